@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"backend/internal/config"
+)
+
+// El binario es también la herramienta de operación: cada tarea es un
+// subcomando que usa la misma configuración que el servidor, así que no
+// hacen falta scripts contra Mongo. Sin subcomando se ejecuta serve.
+
+type cliCommand struct {
+	Name    string
+	Args    string
+	Summary string
+	// Aliases son nombres antiguos que se siguen aceptando.
+	Aliases []string
+	Run     func(cfg config.Config, args []string) error
+}
+
+var cliCommands = []cliCommand{
+	{Name: "serve", Summary: "inicia el servidor (por defecto)", Run: runServeCommand},
+	{Name: "migrate", Summary: "crea los índices y aplica las migraciones pendientes", Run: runMigrateCommand},
+	{Name: "seed", Args: "[-count N] [-force]", Summary: "crea usuarios de prueba", Run: runSeedCommand},
+	{Name: "create-admin", Args: "-email EMAIL [-name NOMBRE] [-last-name APELLIDO]", Summary: "crea un administrador o da el rol a un usuario existente", Run: runCreateAdminCommand},
+	{Name: "cleanup-uploads", Args: "[-dry-run]", Summary: "borra los archivos subidos que ningún usuario usa", Aliases: []string{"cleanup"}, Run: runCleanupCommand},
+}
+
+func findCommand(name string) (cliCommand, bool) {
+	for _, command := range cliCommands {
+		if command.Name == name {
+			return command, true
+		}
+		for _, alias := range command.Aliases {
+			if alias == name {
+				return command, true
+			}
+		}
+	}
+	return cliCommand{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Uso: backend [subcomando] [opciones]")
+	fmt.Fprintln(w)
+	for _, command := range cliCommands {
+		fmt.Fprintf(w, "  %-16s %s\n", command.Name, command.Summary)
+		if command.Args != "" {
+			fmt.Fprintf(w, "  %-16s %s\n", "", command.Args)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Cada subcomando muestra sus opciones con -h.")
+}
+
+// runMigrateCommand atiende `backend migrate`. El servidor también migra al
+// arrancar; el subcomando permite hacerlo antes de desplegar.
+func runMigrateCommand(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New("uso: backend migrate")
+	}
+	_, closeBackend := openBackend(cfg)
+	defer closeBackend()
+
+	fmt.Println("✅ Base de datos al día")
+	return nil
+}
+
+// runSeedCommand atiende `backend seed`: crea usuarios verificados
+// seed1@example.com, seed2@example.com... con la etiqueta "seed" e imprime
+// sus códigos. Los que ya existen se saltan, así que se puede repetir. Solo
+// se ejecuta con DEV_MODE=true salvo que se pase -force.
+func runSeedCommand(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := flags.Int("count", 10, "número de usuarios")
+	force := flags.Bool("force", false, "ejecutar aunque DEV_MODE no esté activado")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || *count < 1 || *count > 1000 {
+		return errors.New("uso: backend seed [-count N] [-force], con N entre 1 y 1000")
+	}
+	if !cfg.Server.DevMode && !*force {
+		return errors.New("seed solo se ejecuta con DEV_MODE=true (o con -force)")
+	}
+	srv, closeBackend := openBackend(cfg)
+	defer closeBackend()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return srv.SeedUsers(ctx, *count)
+}
+
+// runCreateAdminCommand atiende `backend create-admin`. Si el email ya está
+// registrado le da el rol de administrador; si no, crea la cuenta ya
+// verificada. El código de acceso se imprime en vez de enviarse por email.
+func runCreateAdminCommand(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email del administrador (obligatorio)")
+	name := flags.String("name", "", "nombre, si se crea la cuenta")
+	lastName := flags.String("last-name", "", "apellido, si se crea la cuenta")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	*email = strings.TrimSpace(*email)
+	if flags.NArg() > 0 || *email == "" {
+		return errors.New("uso: backend create-admin -email EMAIL [-name NOMBRE] [-last-name APELLIDO]")
+	}
+	if address, err := mail.ParseAddress(*email); err != nil || address.Address != *email {
+		return fmt.Errorf("email inválido: %s", *email)
+	}
+	srv, closeBackend := openBackend(cfg)
+	defer closeBackend()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.CreateAdmin(ctx, *email, *name, *lastName)
+}
+
+// runCleanupCommand atiende `backend cleanup-uploads [-dry-run]`.
+func runCleanupCommand(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("cleanup-uploads", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "solo informa de los archivos huérfanos, sin borrarlos")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New("uso: backend cleanup-uploads [-dry-run]")
+	}
+	srv, closeBackend := openBackend(cfg)
+	defer closeBackend()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	return srv.CleanupUploads(ctx, *dryRun)
+}
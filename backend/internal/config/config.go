@@ -0,0 +1,785 @@
+// Package config lee de las variables de entorno toda la configuración del
+// backend, una sola vez al arrancar. Load aplica los valores por defecto y
+// valida cada variable (requeridas, URLs, rangos numéricos); si algo falla,
+// el error lista todos los problemas, no solo el primero. Los componentes
+// reciben su sección ya validada y no vuelven a leer el entorno.
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config es la configuración completa. GRPCPort (GRPC_PORT) es el puerto del
+// servicio gRPC interno; si está vacío no se sirve.
+type Config struct {
+	Port     string
+	GRPCPort string
+	Server   Server
+	API      API
+	CORS     CORS
+	TLS      TLS
+	Log      Log
+	DB       DB
+	Mongo    Mongo
+	Email    Email
+	Auth     Auth
+	OAuth    OAuth
+	SAML     SAML
+	WebAuthn WebAuthn
+	Admin    Admin
+	Codes    Codes
+	Users    Users
+	Uploads  Uploads
+	Webhooks Webhooks
+	Events   Events
+}
+
+// Server son las URLs con las que se construyen los enlaces: PublicBaseURL
+// (PUBLIC_BASE_URL) es desde donde los clientes alcanzan este servidor y
+// FrontendURL (FRONTEND_URL) la del frontend. TrustProxy (TRUST_PROXY) toma
+// la IP del cliente de X-Forwarded-For, saltando desde la derecha las
+// TrustedProxies (TRUSTED_PROXIES) entradas que añaden los proxies propios, y
+// DevMode (DEV_MODE) habilita las
+// herramientas de desarrollo y, sin proveedor de email, que las respuestas
+// incluyan los códigos y enlaces.
+type Server struct {
+	PublicBaseURL  string
+	FrontendURL    string
+	TrustProxy     bool
+	TrustedProxies int
+	DevMode        bool
+	Timeouts       Timeouts
+}
+
+// Timeouts son los plazos del servidor HTTP (HTTP_READ_HEADER_TIMEOUT,
+// HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT, HTTP_IDLE_TIMEOUT), contados desde
+// que llega la petición. Upload (HTTP_UPLOAD_TIMEOUT) sustituye a Read y
+// Write en las subidas de imágenes, que pueden tardar más en llegar.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Upload     time.Duration
+}
+
+// API configura el versionado. LegacySunset (API_LEGACY_SUNSET) es la fecha
+// anunciada para retirar las rutas sin versión de /api; cero si no hay fecha.
+// RateLimit (API_RATE_LIMIT) es cuántas peticiones acepta cada IP por
+// RateWindow (API_RATE_WINDOW); 0 lo desactiva. RateLimitBackend
+// (RATE_LIMIT_BACKEND) es dónde se cuentan este y los demás límites: memory,
+// por instancia, o mongo, compartido entre todas (solo con DB_DRIVER=mongo).
+// Format (API_FORMAT) es el formato de las respuestas si el cliente no pide
+// uno: json o jsonapi.
+type API struct {
+	LegacySunset     time.Time
+	RateLimit        int
+	RateWindow       time.Duration
+	RateLimitBackend string
+	Format           string
+}
+
+// CORS configura los orígenes de los frontends (CORS_ALLOWED_ORIGINS,
+// separados por comas), que también se comprueban al abrir un WebSocket. Un
+// origen puede tener un comodín en el primer nivel del subdominio
+// (https://*.example.com) o ser "*" para aceptar cualquiera.
+// AllowCredentials (CORS_ALLOW_CREDENTIALS) deja que el navegador envíe la
+// cookie de sesión; por defecto solo se activa con SESSION_MODE=cookie.
+type CORS struct {
+	AllowedOrigins   []string
+	AllowCredentials bool
+}
+
+// TLS sirve HTTPS sin un proxy delante, con un certificado propio (TLS_CERT
+// y TLS_KEY) o pidiéndolo a Let's Encrypt para AutocertDomains
+// (TLS_AUTOCERT_DOMAINS, separados por comas). Los certificados obtenidos se
+// guardan en AutocertCache (TLS_AUTOCERT_CACHE, "certs" por defecto) y
+// AutocertEmail (TLS_AUTOCERT_EMAIL) recibe los avisos de caducidad.
+// HTTPPort (TLS_HTTP_PORT) sirve HTTP solo para redirigir a HTTPS y, con
+// autocert, responder los retos de Let's Encrypt; por defecto 80 con
+// autocert y ninguno con certificado propio.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+
+	AutocertDomains []string
+	AutocertEmail   string
+	AutocertCache   string
+
+	HTTPPort string
+}
+
+// Enabled indica si el servidor HTTP sirve HTTPS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || len(t.AutocertDomains) > 0
+}
+
+// Log configura los logs: Format es "console" (texto, por defecto) o "json".
+type Log struct {
+	Format string
+	Level  slog.Level
+}
+
+// DB elige dónde se guardan los datos (DB_DRIVER): mongo, postgres
+// (DATABASE_URL), bolt (el archivo BOLT_PATH) o memory. MONGODB_URI solo
+// hace falta con mongo.
+type DB struct {
+	Driver      string
+	PostgresURL string
+	BoltPath    string
+}
+
+type Mongo struct {
+	URI      string
+	Database string
+}
+
+// Email configura el proveedor de email. Provider vacío significa que no hay
+// proveedor y los emails solo se muestran en consola.
+type Email struct {
+	Provider string
+	From     string
+	DryRun   bool
+
+	// DKIMSelectors son los selectores que comprueba el diagnóstico del
+	// dominio (DKIM_SELECTORS, separados por comas).
+	DKIMSelectors []string
+
+	// TemplatesDir (EMAIL_TEMPLATES_DIR) sustituye o añade plantillas a las
+	// embebidas, y hace que la vista previa las recargue en cada petición.
+	TemplatesDir string
+	// MaxAttempts (EMAIL_MAX_ATTEMPTS) son los envíos antes de pasar un
+	// email a la cola de fallidos.
+	MaxAttempts int
+
+	ResendAPIKey        string
+	ResendWebhookSecret string
+	SendGridAPIKey      string
+	SendGridSandbox     bool
+	SMTP                SMTP
+}
+
+// SMTP configura el envío por SMTP. TLS es "starttls", "implicit" (SMTPS) o "none".
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+}
+
+// Auth configura las sesiones. JWTSecret (JWT_SECRET) vacío hace que se
+// genere uno temporal al arrancar. SessionMode (SESSION_MODE) es "token" o
+// "cookie" y LoginMode (LOGIN_MODE) "code", "otp" o "both".
+// SessionCookieSecure (SESSION_COOKIE_SECURE) marca la cookie de sesión como
+// Secure; por defecto solo se desactiva con DEV_MODE, ya que detrás de un
+// proxy que termina TLS el servidor no ve la conexión HTTPS. Las duraciones
+// se leen de <NOMBRE>_TTL en el formato de time.ParseDuration ("15m");
+// SigningKeyRotation (SIGNING_KEY_ROTATION) es cada cuánto se genera una
+// clave de firma nueva y SigningKeySecret (SIGNING_KEY_SECRET) el secreto con
+// el que se cifran en la base; vacío se deriva de JWT_SECRET.
+type Auth struct {
+	JWTSecret        string
+	SessionMode      string
+	SessionCookieKey string
+	SigningKeySecret string
+	LoginMode        string
+
+	SessionCookieSecure bool
+
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	SigningKeyRotation time.Duration
+
+	OTPTTL           time.Duration
+	MagicLinkTTL     time.Duration
+	VerifyEmailTTL   time.Duration
+	RecoveryTTL      time.Duration
+	ReactivationTTL  time.Duration
+	ImpersonationTTL time.Duration
+}
+
+// MaxImpersonationTTL es la duración máxima de un token de suplantación.
+const MaxImpersonationTTL = time.Hour
+
+// OAuth configura el login con Google (GOOGLE_*) y GitHub (GITHUB_*).
+// SuccessRedirectURL (OAUTH_SUCCESS_REDIRECT_URL) recibe los tokens en el
+// fragmento tras el login; sin ella se responden en JSON.
+type OAuth struct {
+	Google             OAuthClient
+	GitHub             OAuthClient
+	SuccessRedirectURL string
+}
+
+// OAuthClient se lee de <PREFIX>_CLIENT_ID, <PREFIX>_CLIENT_SECRET y
+// <PREFIX>_REDIRECT_URL. RedirectURL vacía usa el callback del backend en
+// PUBLIC_BASE_URL.
+type OAuthClient struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Enabled indica si el proveedor está configurado.
+func (c OAuthClient) Enabled() bool {
+	return c.ClientID != ""
+}
+
+// SAML configura el SSO con el certificado del SP (SAML_CERT_FILE y
+// SAML_KEY_FILE) y los metadatos del IdP (SAML_IDP_METADATA_URL o
+// SAML_IDP_METADATA_FILE). EntityID (SAML_ENTITY_ID) vacío usa la URL de
+// los metadatos del SP. TrustedDomains (SAML_TRUSTED_DOMAINS, separados por
+// comas) son los dominios de email que el IdP administra: solo esos emails se
+// dan por verificados y pueden entrar en la cuenta que ya los use.
+type SAML struct {
+	CertFile        string
+	KeyFile         string
+	EntityID        string
+	IdPMetadataFile string
+	IdPMetadataURL  string
+	TrustedDomains  []string
+}
+
+// Enabled indica si el SSO SAML está configurado.
+func (s SAML) Enabled() bool {
+	return s.CertFile != ""
+}
+
+// WebAuthn configura las passkeys: RPID (WEBAUTHN_RP_ID) es el dominio y
+// RPOrigins (WEBAUTHN_RP_ORIGINS, separados por comas) los orígenes de los
+// frontends que las usan.
+type WebAuthn struct {
+	RPID      string
+	RPOrigins []string
+}
+
+// Admin restringe /api/admin. Token (ADMIN_TOKEN) es un bearer estático de
+// administrador; AllowedCIDRs (ADMIN_ALLOWED_CIDRS, separadas por comas) y
+// AllowedCIDRsFile (ADMIN_ALLOWED_CIDRS_FILE) las redes desde las que se
+// permite el acceso.
+type Admin struct {
+	Token            string
+	AllowedCIDRs     []string
+	AllowedCIDRsFile string
+}
+
+// Codes configura los códigos de acceso: cuánto duran (CODE_TTL), con qué
+// caracteres (CODE_ALPHABET) y longitud (CODE_LENGTH) se generan, el pepper
+// con el que se guardan (CODE_PEPPER, obligatorio salvo con DEV_MODE) y
+// cuántos emails con código nuevo se envían por dirección (CODE_RESEND_LIMIT
+// cada CODE_RESEND_WINDOW). QRURL (ACCESS_CODE_QR_URL) hace que el QR sea un
+// enlace al frontend.
+type Codes struct {
+	TTL          time.Duration
+	Alphabet     string
+	Length       int
+	Pepper       string
+	ResendLimit  int
+	ResendWindow time.Duration
+	QRURL        string
+}
+
+// DefaultCodeAlphabet evita caracteres ambiguos (0/O, 1/I) porque el código
+// se copia a mano desde el email.
+const DefaultCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Users configura las cuentas: cuánto se conservan las eliminadas
+// (USER_RETENTION), cuántas versiones del perfil (PROFILE_VERSIONS) e
+// imágenes (MAX_PROFILE_IMAGES) se guardan, y el bloqueo de emails
+// desechables (BLOCK_DISPOSABLE_EMAILS, con DISPOSABLE_EMAIL_DOMAINS y
+// DISPOSABLE_EMAIL_DOMAINS_FILE).
+type Users struct {
+	Retention        time.Duration
+	ProfileVersions  int
+	MaxProfileImages int
+
+	BlockDisposableEmails bool
+	DisposableDomains     []string
+	DisposableDomainsFile string
+
+	Reminders Reminders
+}
+
+// Reminders configura los recordatorios para completar el perfil
+// (PROFILE_REMINDERS): se envían PROFILE_REMINDER_AFTER tras el registro y
+// se buscan cada PROFILE_REMINDER_INTERVAL.
+type Reminders struct {
+	Enabled  bool
+	After    time.Duration
+	Interval time.Duration
+}
+
+// Webhooks configura los webhooks salientes: cuánto se espera la respuesta
+// de cada endpoint (WEBHOOK_TIMEOUT), cuántos intentos se hacen por entrega
+// (WEBHOOK_MAX_ATTEMPTS), la espera tras el primer fallo
+// (WEBHOOK_RETRY_INTERVAL), que se duplica en cada reintento, y cuánto se
+// conservan las entregas terminadas (WEBHOOK_RETENTION).
+type Webhooks struct {
+	Timeout       time.Duration
+	MaxAttempts   int
+	RetryInterval time.Duration
+	Retention     time.Duration
+}
+
+// Events configura el bus de eventos internos. Backend (EVENT_BUS) es
+// memory, dentro del proceso, nats (NATS_URL) o kafka (KAFKA_BROKERS,
+// separados por comas). Topic (EVENT_TOPIC) es el topic de Kafka y el prefijo
+// de los subjects de NATS.
+type Events struct {
+	Backend      string
+	NATSURL      string
+	KafkaBrokers []string
+	Topic        string
+}
+
+// Uploads configura los archivos subidos. Backend (STORAGE_BACKEND) es
+// "local" (carpeta Dir, UPLOADS_DIR), "s3" o "azure". CDNURL
+// (UPLOADS_CDN_URL) sustituye a la URL del backend al servir las imágenes y
+// MaxAge (UPLOADS_MAX_AGE) es la caché de los archivos locales.
+type Uploads struct {
+	Backend   string
+	Dir       string
+	PublicURL string
+	CDNURL    string
+	MaxAge    time.Duration
+	// MaxSize (MAX_UPLOAD_SIZE) admite bytes o sufijo KB/MB ("5MB").
+	MaxSize int64
+
+	GC    UploadsGC
+	S3    S3
+	Azure Azure
+}
+
+// UploadsGC configura la limpieza de archivos huérfanos (UPLOADS_GC): cada
+// UPLOADS_GC_INTERVAL se borran los que tengan más de UPLOADS_GC_GRACE.
+type UploadsGC struct {
+	Enabled  bool
+	Interval time.Duration
+	Grace    time.Duration
+}
+
+// S3 se lee de S3_*. Endpoint vacío es AWS; ForcePathStyle
+// (S3_FORCE_PATH_STYLE) es nil si no se indica.
+type S3 struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PublicURL       string
+	ForcePathStyle  *bool
+}
+
+// Azure se lee de AZURE_STORAGE_*. Sin ConnectionString se usa la identidad
+// administrada de Account: ClientID (AZURE_CLIENT_ID) elige una asignada por
+// el usuario, e IdentityEndpoint e IdentityHeader (IDENTITY_ENDPOINT e
+// IDENTITY_HEADER) los define App Service.
+type Azure struct {
+	Container        string
+	PublicURL        string
+	ConnectionString string
+	Account          string
+	ClientID         string
+	IdentityEndpoint string
+	IdentityHeader   string
+}
+
+// Load lee la configuración del entorno y la valida.
+func Load() (Config, error) {
+	e := &env{}
+	cfg := Config{
+		Port:     e.str("PORT", "8080"),
+		GRPCPort: e.str("GRPC_PORT", ""),
+		Server: Server{
+			PublicBaseURL:  e.url("PUBLIC_BASE_URL", "http://localhost:8080"),
+			FrontendURL:    e.url("FRONTEND_URL", "http://localhost:5173"),
+			TrustProxy:     e.boolean("TRUST_PROXY", false),
+			TrustedProxies: e.integer("TRUSTED_PROXIES", 1, 1, 10),
+			DevMode:        e.boolean("DEV_MODE", false),
+			Timeouts: Timeouts{
+				ReadHeader: e.duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second, time.Second),
+				Read:       e.duration("HTTP_READ_TIMEOUT", time.Minute, time.Second),
+				Write:      e.duration("HTTP_WRITE_TIMEOUT", time.Minute, time.Second),
+				Idle:       e.duration("HTTP_IDLE_TIMEOUT", 2*time.Minute, time.Second),
+				Upload:     e.duration("HTTP_UPLOAD_TIMEOUT", 5*time.Minute, time.Second),
+			},
+		},
+		Mongo: Mongo{
+			URI:      e.str("MONGODB_URI", ""),
+			Database: "userapp",
+		},
+	}
+	e.integer("PORT", 0, 1, 65535)
+	if cfg.GRPCPort != "" {
+		e.integer("GRPC_PORT", 0, 1, 65535)
+	}
+	if timeouts := cfg.Server.Timeouts; timeouts.Read < timeouts.ReadHeader {
+		e.fail("HTTP_READ_TIMEOUT", "no puede ser menor que HTTP_READ_HEADER_TIMEOUT (%s): %s", timeouts.ReadHeader, timeouts.Read)
+	}
+
+	if value := e.str("API_LEGACY_SUNSET", ""); value != "" {
+		sunset, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			e.fail("API_LEGACY_SUNSET", "debe ser una fecha 2006-01-02: %s", value)
+		}
+		cfg.API.LegacySunset = sunset
+	}
+	cfg.API.RateLimit = e.integer("API_RATE_LIMIT", 0, 0, 1000000)
+	cfg.API.RateWindow = e.duration("API_RATE_WINDOW", time.Minute, time.Second)
+	cfg.API.RateLimitBackend = e.oneOf("RATE_LIMIT_BACKEND", "memory", "memory", "mongo")
+	cfg.API.Format = e.oneOf("API_FORMAT", "json", "json", "jsonapi")
+
+	cfg.DB = DB{
+		Driver:      e.oneOf("DB_DRIVER", "mongo", "mongo", "memory", "postgres", "bolt"),
+		PostgresURL: e.str("DATABASE_URL", ""),
+		BoltPath:    e.str("BOLT_PATH", "userapp.db"),
+	}
+	if cfg.DB.Driver == "postgres" {
+		e.require("DATABASE_URL", cfg.DB.PostgresURL, "con DB_DRIVER=postgres")
+	}
+	if cfg.DB.Driver == "mongo" {
+		e.require("MONGODB_URI", cfg.Mongo.URI, "")
+	} else if cfg.API.RateLimitBackend == "mongo" {
+		e.fail("RATE_LIMIT_BACKEND", "mongo solo está disponible con DB_DRIVER=mongo")
+	}
+
+	cfg.Auth = loadAuth(e, cfg.Server.DevMode)
+	cfg.CORS = loadCORS(e, cfg.Auth.SessionMode)
+	cfg.TLS = loadTLS(e, cfg.Port)
+	cfg.Log = loadLog(e)
+	cfg.Email = loadEmail(e)
+	cfg.OAuth = OAuth{
+		Google:             loadOAuthClient(e, "GOOGLE"),
+		GitHub:             loadOAuthClient(e, "GITHUB"),
+		SuccessRedirectURL: e.url("OAUTH_SUCCESS_REDIRECT_URL", ""),
+	}
+	cfg.SAML = loadSAML(e)
+	cfg.WebAuthn = WebAuthn{
+		RPID:      e.str("WEBAUTHN_RP_ID", "localhost"),
+		RPOrigins: e.list("WEBAUTHN_RP_ORIGINS", nil),
+	}
+	if len(cfg.WebAuthn.RPOrigins) == 0 {
+		cfg.WebAuthn.RPOrigins = defaultCORSOrigins
+	}
+	cfg.Admin = Admin{
+		Token:            e.str("ADMIN_TOKEN", ""),
+		AllowedCIDRs:     e.list("ADMIN_ALLOWED_CIDRS", nil),
+		AllowedCIDRsFile: e.str("ADMIN_ALLOWED_CIDRS_FILE", ""),
+	}
+	cfg.Codes = loadCodes(e, cfg.Server.DevMode)
+	cfg.Users = Users{
+		Retention:             e.duration("USER_RETENTION", 30*24*time.Hour, time.Hour),
+		ProfileVersions:       e.integer("PROFILE_VERSIONS", 10, 1, 1000),
+		MaxProfileImages:      e.integer("MAX_PROFILE_IMAGES", 10, 1, 100),
+		BlockDisposableEmails: e.boolean("BLOCK_DISPOSABLE_EMAILS", true),
+		DisposableDomains:     e.list("DISPOSABLE_EMAIL_DOMAINS", nil),
+		DisposableDomainsFile: e.str("DISPOSABLE_EMAIL_DOMAINS_FILE", ""),
+		Reminders: Reminders{
+			Enabled:  e.boolean("PROFILE_REMINDERS", true),
+			After:    e.duration("PROFILE_REMINDER_AFTER", 72*time.Hour, time.Minute),
+			Interval: e.duration("PROFILE_REMINDER_INTERVAL", time.Hour, time.Minute),
+		},
+	}
+	cfg.Uploads = loadUploads(e)
+	cfg.Webhooks = Webhooks{
+		Timeout:       e.duration("WEBHOOK_TIMEOUT", 10*time.Second, time.Second),
+		MaxAttempts:   e.integer("WEBHOOK_MAX_ATTEMPTS", 8, 1, 20),
+		RetryInterval: e.duration("WEBHOOK_RETRY_INTERVAL", time.Minute, time.Second),
+		Retention:     e.duration("WEBHOOK_RETENTION", 30*24*time.Hour, time.Hour),
+	}
+	cfg.Events = Events{
+		Backend:      e.oneOf("EVENT_BUS", "memory", "memory", "nats", "kafka"),
+		NATSURL:      e.str("NATS_URL", ""),
+		KafkaBrokers: e.list("KAFKA_BROKERS", nil),
+		Topic:        e.str("EVENT_TOPIC", "userapp.events"),
+	}
+	switch cfg.Events.Backend {
+	case "nats":
+		e.require("NATS_URL", cfg.Events.NATSURL, "con EVENT_BUS=nats")
+	case "kafka":
+		e.require("KAFKA_BROKERS", strings.Join(cfg.Events.KafkaBrokers, ","), "con EVENT_BUS=kafka")
+	}
+
+	if err := e.err(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func loadAuth(e *env, devMode bool) Auth {
+	cfg := Auth{
+		JWTSecret:          e.str("JWT_SECRET", ""),
+		SessionMode:        e.oneOf("SESSION_MODE", "token", "token", "cookie"),
+		SessionCookieKey:   e.str("SESSION_COOKIE_KEY", ""),
+		SigningKeySecret:   e.str("SIGNING_KEY_SECRET", ""),
+		LoginMode:          e.oneOf("LOGIN_MODE", "code", "code", "otp", "both"),
+		AccessTokenTTL:     e.duration("ACCESS_TOKEN_TTL", 15*time.Minute, time.Minute),
+		RefreshTokenTTL:    e.duration("REFRESH_TOKEN_TTL", 30*24*time.Hour, time.Hour),
+		SigningKeyRotation: e.duration("SIGNING_KEY_ROTATION", 24*time.Hour, time.Minute),
+		OTPTTL:             e.duration("OTP_TTL", 10*time.Minute, time.Minute),
+		MagicLinkTTL:       e.duration("MAGIC_LINK_TTL", 15*time.Minute, time.Minute),
+		VerifyEmailTTL:     e.duration("VERIFY_EMAIL_TTL", 48*time.Hour, time.Minute),
+		RecoveryTTL:        e.duration("RECOVERY_TTL", 30*time.Minute, time.Minute),
+		ReactivationTTL:    e.duration("REACTIVATION_TTL", time.Hour, time.Minute),
+		ImpersonationTTL:   e.duration("IMPERSONATION_TTL", 10*time.Minute, time.Minute),
+	}
+	cfg.SessionCookieSecure = e.boolean("SESSION_COOKIE_SECURE", !devMode)
+	if cfg.ImpersonationTTL > MaxImpersonationTTL {
+		e.fail("IMPERSONATION_TTL", "no puede superar %s", MaxImpersonationTTL)
+	}
+	return cfg
+}
+
+var defaultCORSOrigins = []string{"http://localhost:5173", "http://localhost:3000"}
+
+func loadCORS(e *env, sessionMode string) CORS {
+	cfg := CORS{AllowCredentials: e.boolean("CORS_ALLOW_CREDENTIALS", sessionMode == "cookie")}
+
+	for _, origin := range e.list("CORS_ALLOWED_ORIGINS", defaultCORSOrigins) {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		if err := validateOrigin(origin); err != nil {
+			e.fail("CORS_ALLOWED_ORIGINS", "%s: %v", origin, err)
+			continue
+		}
+		if origin == "*" && cfg.AllowCredentials {
+			e.fail("CORS_ALLOWED_ORIGINS", "no puede ser * con credenciales (CORS_ALLOW_CREDENTIALS o SESSION_MODE=cookie)")
+			continue
+		}
+		cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+	}
+	return cfg
+}
+
+// validateOrigin acepta "*" o esquema y host (con puerto opcional), sin ruta.
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("debe ser esquema://host[:puerto]")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("el esquema debe ser http o https")
+	}
+	domain, wildcard := strings.CutPrefix(u.Hostname(), "*.")
+	if strings.Contains(domain, "*") {
+		return errors.New("el comodín solo se admite como primer nivel del subdominio (https://*.example.com)")
+	}
+	if wildcard && !strings.Contains(domain, ".") {
+		return errors.New("el comodín necesita un dominio de al menos dos niveles (https://*.example.com)")
+	}
+	return nil
+}
+
+func loadTLS(e *env, port string) TLS {
+	cfg := TLS{
+		CertFile:      e.str("TLS_CERT", ""),
+		KeyFile:       e.str("TLS_KEY", ""),
+		AutocertEmail: e.str("TLS_AUTOCERT_EMAIL", ""),
+		AutocertCache: e.str("TLS_AUTOCERT_CACHE", "certs"),
+		HTTPPort:      e.str("TLS_HTTP_PORT", ""),
+	}
+	for _, domain := range e.list("TLS_AUTOCERT_DOMAINS", nil) {
+		domain = strings.ToLower(domain)
+		if strings.ContainsAny(domain, "*:/") {
+			e.fail("TLS_AUTOCERT_DOMAINS", "admite solo nombres de dominio, sin comodines ni esquema: %s", domain)
+			continue
+		}
+		cfg.AutocertDomains = append(cfg.AutocertDomains, domain)
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		e.fail("TLS_CERT", "TLS_CERT y TLS_KEY deben indicarse juntas")
+	}
+	if cfg.CertFile != "" && len(cfg.AutocertDomains) > 0 {
+		e.fail("TLS_CERT", "no se puede usar a la vez que TLS_AUTOCERT_DOMAINS")
+	}
+	if !cfg.Enabled() {
+		if cfg.HTTPPort != "" {
+			e.fail("TLS_HTTP_PORT", "requiere TLS_CERT o TLS_AUTOCERT_DOMAINS")
+		}
+		return cfg
+	}
+
+	if len(cfg.AutocertDomains) > 0 && cfg.HTTPPort == "" {
+		cfg.HTTPPort = "80"
+	}
+	if cfg.HTTPPort == port {
+		e.fail("TLS_HTTP_PORT", "no puede ser el mismo puerto que PORT (%s)", port)
+	}
+	return cfg
+}
+
+// loadLog lee LOG_FORMAT (console o json) y LOG_LEVEL (debug, info, warn o error).
+func loadLog(e *env) Log {
+	cfg := Log{Format: e.oneOf("LOG_FORMAT", "console", "console", "json")}
+	if value := e.str("LOG_LEVEL", ""); value != "" {
+		if err := cfg.Level.UnmarshalText([]byte(value)); err != nil {
+			e.fail("LOG_LEVEL", "debe ser debug, info, warn o error: %s", value)
+		}
+	}
+	return cfg
+}
+
+// loadEmail lee EMAIL_PROVIDER (resend, sendgrid o smtp). Si no se indica, se
+// elige según la variable configurada (RESEND_API_KEY, SENDGRID_API_KEY o
+// SMTP_HOST).
+func loadEmail(e *env) Email {
+	cfg := Email{
+		Provider:            e.oneOf("EMAIL_PROVIDER", "", "resend", "sendgrid", "smtp"),
+		From:                e.str("EMAIL_FROM", "UserApp <onboarding@resend.dev>"),
+		DryRun:              e.boolean("EMAIL_DRY_RUN", false),
+		DKIMSelectors:       e.list("DKIM_SELECTORS", nil),
+		TemplatesDir:        e.str("EMAIL_TEMPLATES_DIR", ""),
+		MaxAttempts:         e.integer("EMAIL_MAX_ATTEMPTS", 3, 1, 20),
+		ResendAPIKey:        e.str("RESEND_API_KEY", ""),
+		ResendWebhookSecret: e.str("RESEND_WEBHOOK_SECRET", ""),
+		SendGridAPIKey:      e.str("SENDGRID_API_KEY", ""),
+		SendGridSandbox:     e.boolean("SENDGRID_SANDBOX", false),
+		SMTP: SMTP{
+			Host:     e.str("SMTP_HOST", ""),
+			Port:     e.integer("SMTP_PORT", 587, 1, 65535),
+			Username: e.str("SMTP_USERNAME", ""),
+			Password: e.str("SMTP_PASSWORD", ""),
+			TLS:      e.oneOf("SMTP_TLS", "", "starttls", "implicit", "none"),
+		},
+	}
+
+	if cfg.Provider == "" {
+		switch {
+		case cfg.ResendAPIKey != "":
+			cfg.Provider = "resend"
+		case cfg.SendGridAPIKey != "":
+			cfg.Provider = "sendgrid"
+		case cfg.SMTP.Host != "":
+			cfg.Provider = "smtp"
+		}
+	}
+
+	switch cfg.Provider {
+	case "resend":
+		e.require("RESEND_API_KEY", cfg.ResendAPIKey, "con EMAIL_PROVIDER=resend")
+	case "sendgrid":
+		e.require("SENDGRID_API_KEY", cfg.SendGridAPIKey, "con EMAIL_PROVIDER=sendgrid")
+	case "smtp":
+		e.require("SMTP_HOST", cfg.SMTP.Host, "con EMAIL_PROVIDER=smtp")
+		if cfg.SMTP.TLS == "" {
+			cfg.SMTP.TLS = "starttls"
+			if cfg.SMTP.Port == 465 {
+				cfg.SMTP.TLS = "implicit"
+			}
+		}
+	}
+	return cfg
+}
+
+func loadOAuthClient(e *env, prefix string) OAuthClient {
+	cfg := OAuthClient{
+		ClientID:     e.str(prefix+"_CLIENT_ID", ""),
+		ClientSecret: e.str(prefix+"_CLIENT_SECRET", ""),
+		RedirectURL:  e.url(prefix+"_REDIRECT_URL", ""),
+	}
+	if (cfg.ClientID == "") != (cfg.ClientSecret == "") {
+		e.fail(prefix+"_CLIENT_ID", "%s_CLIENT_ID y %s_CLIENT_SECRET deben indicarse juntas", prefix, prefix)
+	}
+	return cfg
+}
+
+func loadSAML(e *env) SAML {
+	cfg := SAML{
+		CertFile:        e.str("SAML_CERT_FILE", ""),
+		KeyFile:         e.str("SAML_KEY_FILE", ""),
+		EntityID:        e.str("SAML_ENTITY_ID", ""),
+		IdPMetadataFile: e.str("SAML_IDP_METADATA_FILE", ""),
+		IdPMetadataURL:  e.url("SAML_IDP_METADATA_URL", ""),
+		TrustedDomains:  e.list("SAML_TRUSTED_DOMAINS", nil),
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		e.fail("SAML_CERT_FILE", "SAML_CERT_FILE y SAML_KEY_FILE deben indicarse juntas")
+	}
+	if cfg.Enabled() && cfg.IdPMetadataFile == "" && cfg.IdPMetadataURL == "" {
+		e.fail("SAML_IDP_METADATA_URL", "es requerida (o SAML_IDP_METADATA_FILE) con SAML_CERT_FILE")
+	}
+	if cfg.Enabled() && len(cfg.TrustedDomains) == 0 {
+		e.fail("SAML_TRUSTED_DOMAINS", "es requerida con SAML_CERT_FILE")
+	}
+	return cfg
+}
+
+func loadCodes(e *env, devMode bool) Codes {
+	cfg := Codes{
+		TTL:          e.duration("CODE_TTL", 7*24*time.Hour, time.Minute),
+		Alphabet:     e.str("CODE_ALPHABET", DefaultCodeAlphabet),
+		Length:       e.integer("CODE_LENGTH", 10, 6, 64),
+		Pepper:       e.str("CODE_PEPPER", ""),
+		ResendLimit:  e.integer("CODE_RESEND_LIMIT", 3, 1, 100),
+		ResendWindow: e.duration("CODE_RESEND_WINDOW", time.Hour, time.Minute),
+		QRURL:        e.url("ACCESS_CODE_QR_URL", ""),
+	}
+	// Sin pepper el hash es SHA-256 del código y quien tenga un volcado de la
+	// base puede probar códigos offline.
+	if cfg.Pepper == "" && !devMode {
+		e.fail("CODE_PEPPER", "es requerida salvo con DEV_MODE=true")
+	}
+	// generateCode elige bytes del alfabeto, así que debe ser ASCII.
+	if len(cfg.Alphabet) < 10 || strings.ContainsFunc(cfg.Alphabet, func(r rune) bool { return r < '!' || r > '~' }) {
+		e.fail("CODE_ALPHABET", "debe tener al menos 10 caracteres ASCII imprimibles")
+		cfg.Alphabet = DefaultCodeAlphabet
+	}
+	return cfg
+}
+
+func loadUploads(e *env) Uploads {
+	cfg := Uploads{
+		Backend:   e.oneOf("STORAGE_BACKEND", "local", "local", "s3", "azure"),
+		Dir:       e.str("UPLOADS_DIR", "uploads"),
+		PublicURL: e.url("UPLOADS_PUBLIC_URL", ""),
+		CDNURL:    e.url("UPLOADS_CDN_URL", ""),
+		MaxAge:    e.duration("UPLOADS_MAX_AGE", time.Hour, 0),
+		MaxSize:   e.size("MAX_UPLOAD_SIZE", 10<<20),
+		GC: UploadsGC{
+			Enabled:  e.boolean("UPLOADS_GC", true),
+			Interval: e.duration("UPLOADS_GC_INTERVAL", 24*time.Hour, time.Minute),
+			Grace:    e.duration("UPLOADS_GC_GRACE", 24*time.Hour, time.Hour),
+		},
+		S3: S3{
+			Endpoint:        e.url("S3_ENDPOINT", ""),
+			Region:          e.str("S3_REGION", "us-east-1"),
+			Bucket:          e.str("S3_BUCKET", ""),
+			AccessKeyID:     e.str("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: e.str("S3_SECRET_ACCESS_KEY", ""),
+			PublicURL:       e.url("S3_PUBLIC_URL", ""),
+		},
+		Azure: Azure{
+			Container:        e.str("AZURE_STORAGE_CONTAINER", ""),
+			PublicURL:        e.url("AZURE_STORAGE_PUBLIC_URL", ""),
+			ConnectionString: e.str("AZURE_STORAGE_CONNECTION_STRING", ""),
+			Account:          e.str("AZURE_STORAGE_ACCOUNT", ""),
+			ClientID:         e.str("AZURE_CLIENT_ID", ""),
+			IdentityEndpoint: e.url("IDENTITY_ENDPOINT", ""),
+			IdentityHeader:   e.str("IDENTITY_HEADER", ""),
+		},
+	}
+	if e.str("S3_FORCE_PATH_STYLE", "") != "" {
+		forcePathStyle := e.boolean("S3_FORCE_PATH_STYLE", false)
+		cfg.S3.ForcePathStyle = &forcePathStyle
+	}
+
+	switch cfg.Backend {
+	case "s3":
+		e.require("S3_BUCKET", cfg.S3.Bucket, "con STORAGE_BACKEND=s3")
+		e.require("S3_ACCESS_KEY_ID", cfg.S3.AccessKeyID, "con STORAGE_BACKEND=s3")
+		e.require("S3_SECRET_ACCESS_KEY", cfg.S3.SecretAccessKey, "con STORAGE_BACKEND=s3")
+	case "azure":
+		e.require("AZURE_STORAGE_CONTAINER", cfg.Azure.Container, "con STORAGE_BACKEND=azure")
+		if cfg.Azure.ConnectionString == "" && cfg.Azure.Account == "" {
+			e.fail("AZURE_STORAGE_ACCOUNT", "es requerida (o AZURE_STORAGE_CONNECTION_STRING) con STORAGE_BACKEND=azure")
+		}
+	}
+	return cfg
+}
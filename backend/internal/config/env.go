@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// env lee variables de entorno apuntando cada problema en vez de parar en el
+// primero, para que Load pueda informar de todos a la vez. Los métodos
+// devuelven el valor por defecto si la variable no está o no es válida.
+type env struct {
+	problems []string
+}
+
+func (e *env) fail(name, format string, args ...interface{}) {
+	e.problems = append(e.problems, name+": "+fmt.Sprintf(format, args...))
+}
+
+// err junta los problemas en un único error, o nil si no hay ninguno.
+func (e *env) err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+	if len(e.problems) == 1 {
+		return fmt.Errorf("%s", e.problems[0])
+	}
+	return fmt.Errorf("%d problemas:\n  - %s", len(e.problems), strings.Join(e.problems, "\n  - "))
+}
+
+func (e *env) str(name, def string) string {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value
+	}
+	return def
+}
+
+// require apunta la variable como obligatoria si value está vacío. when
+// explica en qué caso lo es ("con STORAGE_BACKEND=s3"); vacío si siempre.
+func (e *env) require(name, value, when string) {
+	if value != "" {
+		return
+	}
+	if when == "" {
+		e.fail(name, "es requerida")
+		return
+	}
+	e.fail(name, "es requerida %s", when)
+}
+
+// list lee valores separados por comas, ignorando los vacíos.
+func (e *env) list(name string, def []string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func (e *env) boolean(name string, def bool) bool {
+	value := e.str(name, "")
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail(name, "debe ser true o false: %s", value)
+		return def
+	}
+	return parsed
+}
+
+// integer lee un entero en [min, max].
+func (e *env) integer(name string, def, min, max int) int {
+	value := e.str(name, "")
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min || parsed > max {
+		e.fail(name, "debe ser un entero entre %d y %d: %s", min, max, value)
+		return def
+	}
+	return parsed
+}
+
+// duration lee una duración de time.ParseDuration ("15m", "24h") no menor que min.
+func (e *env) duration(name string, def, min time.Duration) time.Duration {
+	value := e.str(name, "")
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		e.fail(name, "debe ser una duración como 15m o 24h: %s", value)
+		return def
+	}
+	if parsed < min {
+		e.fail(name, "debe ser al menos %s: %s", min, value)
+		return def
+	}
+	return parsed
+}
+
+// url lee una URL absoluta http o https, sin la barra final.
+func (e *env) url(name, def string) string {
+	value := e.str(name, "")
+	if value == "" {
+		return def
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		e.fail(name, "debe ser una URL http o https: %s", value)
+		return def
+	}
+	return strings.TrimRight(value, "/")
+}
+
+// oneOf lee uno de los valores permitidos, sin distinguir mayúsculas.
+func (e *env) oneOf(name, def string, allowed ...string) string {
+	value := strings.ToLower(e.str(name, ""))
+	if value == "" {
+		return def
+	}
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	last := len(allowed) - 1
+	e.fail(name, "debe ser %s o %s: %s", strings.Join(allowed[:last], ", "), allowed[last], value)
+	return def
+}
+
+// size lee un tamaño en bytes o con sufijo KB/MB ("5MB").
+func (e *env) size(name string, def int64) int64 {
+	raw := e.str(name, "")
+	if raw == "" {
+		return def
+	}
+	value := strings.ToUpper(raw)
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "MB"):
+		multiplier, value = 1<<20, strings.TrimSuffix(value, "MB")
+	case strings.HasSuffix(value, "KB"):
+		multiplier, value = 1<<10, strings.TrimSuffix(value, "KB")
+	}
+
+	parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || parsed <= 0 {
+		e.fail(name, "debe ser un tamaño positivo en bytes, KB o MB: %s", raw)
+		return def
+	}
+	return parsed * multiplier
+}
@@ -0,0 +1,61 @@
+// Package email envía los emails ya renderizados con el proveedor
+// configurado (Resend, SendGrid o SMTP).
+package email
+
+import (
+	"errors"
+	"fmt"
+
+	"backend/internal/config"
+)
+
+// Message es un email ya renderizado, con la versión HTML y la de texto plano.
+type Message struct {
+	To          string
+	Template    string
+	Subject     string
+	HTML        string
+	Text        string
+	Attachments []Attachment
+}
+
+// Attachment es un archivo adjunto. Con ContentID se muestra embebido y el
+// HTML puede referenciarlo como cid:<ContentID>.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+	ContentID   string
+}
+
+// Sender envía un email y devuelve el ID que le asignó el proveedor.
+type Sender interface {
+	Name() string
+	Send(message Message) (string, error)
+}
+
+// Errores genéricos de envío. Los proveedores que distinguen la causa los
+// envuelven para que quien reintenta sepa si merece la pena.
+var (
+	ErrAuth        = errors.New("credenciales del proveedor de email inválidas")
+	ErrRejected    = errors.New("el proveedor de email rechazó el mensaje")
+	ErrRateLimited = errors.New("límite de envíos del proveedor de email alcanzado")
+	ErrUnavailable = errors.New("proveedor de email no disponible")
+)
+
+// New construye el Sender del proveedor configurado. Sin proveedor devuelve
+// nil: los emails solo se muestran en consola.
+func New(cfg config.Email) (Sender, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "resend":
+		return NewResend(cfg.ResendAPIKey, cfg.From)
+	case "sendgrid":
+		return NewSendGrid(cfg.SendGridAPIKey, cfg.From, cfg.SendGridSandbox)
+	case "smtp":
+		return NewSMTP(cfg.SMTP, cfg.From)
+	default:
+		return nil, fmt.Errorf("EMAIL_PROVIDER desconocido: %s", cfg.Provider)
+	}
+}
@@ -0,0 +1,154 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type ResendEmail struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	HTML    string   `json:"html"`
+	Text    string   `json:"text,omitempty"`
+
+	Attachments []ResendAttachment `json:"attachments,omitempty"`
+}
+
+type ResendAttachment struct {
+	Filename    string `json:"filename"`
+	Content     string `json:"content"`
+	ContentType string `json:"content_type,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// Resend usa la API de Resend.
+type Resend struct {
+	apiKey string
+	from   string
+}
+
+func NewResend(apiKey, from string) (*Resend, error) {
+	if apiKey == "" {
+		return nil, errors.New("RESEND_API_KEY es requerida con EMAIL_PROVIDER=resend")
+	}
+	return &Resend{apiKey: apiKey, from: from}, nil
+}
+
+func (s *Resend) Name() string {
+	return "Resend"
+}
+
+func (s *Resend) Send(email Message) (string, error) {
+	message := ResendEmail{
+		From:    s.from,
+		To:      []string{email.To},
+		Subject: email.Subject,
+		HTML:    email.HTML,
+		Text:    email.Text,
+	}
+	for _, attachment := range email.Attachments {
+		message.Attachments = append(message.Attachments, ResendAttachment{
+			Filename:    attachment.Filename,
+			Content:     base64.StdEncoding.EncodeToString(attachment.Content),
+			ContentType: attachment.ContentType,
+			ContentID:   attachment.ContentID,
+		})
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("error creando JSON: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "https://api.resend.com/emails", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("error creando petición: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error enviando petición: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error de Resend API: status %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(body, &result)
+	return result.ID, nil
+}
+
+// ResendDomainRecord es un registro DNS que Resend exige para el dominio.
+type ResendDomainRecord struct {
+	Record string `json:"record"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	Status string `json:"status"`
+}
+
+type ResendDomain struct {
+	ID      string               `json:"id"`
+	Name    string               `json:"name"`
+	Status  string               `json:"status"`
+	Records []ResendDomainRecord `json:"records,omitempty"`
+}
+
+func (s *Resend) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.resend.com"+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creando petición: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error enviando petición: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error de Resend API: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// Domain busca el dominio en la cuenta de Resend. Devuelve nil si no está dado de alta.
+func (s *Resend) Domain(ctx context.Context, name string) (*ResendDomain, error) {
+	var list struct {
+		Data []ResendDomain `json:"data"`
+	}
+	if err := s.get(ctx, "/domains", &list); err != nil {
+		return nil, err
+	}
+
+	for _, domain := range list.Data {
+		if strings.EqualFold(domain.Name, name) {
+			var detail ResendDomain
+			if err := s.get(ctx, "/domains/"+domain.ID, &detail); err != nil {
+				return nil, err
+			}
+			return &detail, nil
+		}
+	}
+	return nil, nil
+}
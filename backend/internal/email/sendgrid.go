@@ -0,0 +1,180 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	MailSettings     *sendGridMailSettings     `json:"mail_settings,omitempty"`
+}
+
+type sendGridMailSettings struct {
+	SandboxMode struct {
+		Enable bool `json:"enable"`
+	} `json:"sandbox_mode"`
+}
+
+type sendGridErrorResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+		Field   string `json:"field"`
+	} `json:"errors"`
+}
+
+// SendGrid usa la API v3 de SendGrid. En modo sandbox SendGrid valida el
+// mensaje pero no lo entrega, útil en staging.
+type SendGrid struct {
+	apiKey  string
+	sandbox bool
+	from    *mail.Address
+	client  *http.Client
+}
+
+func NewSendGrid(apiKey, from string, sandbox bool) (*SendGrid, error) {
+	if apiKey == "" {
+		return nil, errors.New("SENDGRID_API_KEY es requerida con EMAIL_PROVIDER=sendgrid")
+	}
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("EMAIL_FROM inválido: %v", err)
+	}
+	return &SendGrid{
+		apiKey:  apiKey,
+		sandbox: sandbox,
+		from:    address,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (s *SendGrid) Name() string {
+	if s.sandbox {
+		return "SendGrid (sandbox)"
+	}
+	return "SendGrid"
+}
+
+func (s *SendGrid) Send(email Message) (string, error) {
+	// SendGrid exige que text/plain vaya antes que text/html.
+	var content []sendGridContent
+	if email.Text != "" {
+		content = append(content, sendGridContent{Type: "text/plain", Value: email.Text})
+	}
+	content = append(content, sendGridContent{Type: "text/html", Value: email.HTML})
+
+	message := sendGridMessage{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: email.To}}}},
+		From:             sendGridAddress{Email: s.from.Address, Name: s.from.Name},
+		Subject:          email.Subject,
+		Content:          content,
+	}
+	for _, attachment := range email.Attachments {
+		disposition := "attachment"
+		if attachment.ContentID != "" {
+			disposition = "inline"
+		}
+		message.Attachments = append(message.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(attachment.Content),
+			Type:        attachment.ContentType,
+			Filename:    attachment.Filename,
+			Disposition: disposition,
+			ContentID:   attachment.ContentID,
+		})
+	}
+	if s.sandbox {
+		message.MailSettings = &sendGridMailSettings{}
+		message.MailSettings.SandboxMode.Enable = true
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("error creando JSON: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "https://api.sendgrid.com/v3/mail/send", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("error creando petición: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// 202 es la respuesta normal; en sandbox SendGrid responde 200.
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		return resp.Header.Get("X-Message-Id"), nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	return "", sendGridError(resp.StatusCode, body)
+}
+
+// sendGridError traduce la respuesta de error de SendGrid a uno de los errores
+// genéricos de email, conservando el detalle de la API.
+func sendGridError(status int, body []byte) error {
+	detail := string(body)
+	var parsed sendGridErrorResponse
+	if json.Unmarshal(body, &parsed) == nil && len(parsed.Errors) > 0 {
+		messages := make([]string, 0, len(parsed.Errors))
+		for _, e := range parsed.Errors {
+			if e.Field != "" {
+				messages = append(messages, e.Field+": "+e.Message)
+			} else {
+				messages = append(messages, e.Message)
+			}
+		}
+		detail = strings.Join(messages, "; ")
+	}
+
+	var kind error
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		kind = ErrAuth
+	case status == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case status >= 500:
+		kind = ErrUnavailable
+	default:
+		kind = ErrRejected
+	}
+	return fmt.Errorf("%w (SendGrid %d): %s", kind, status, detail)
+}
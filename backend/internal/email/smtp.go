@@ -0,0 +1,230 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/config"
+)
+
+// SMTP envía por SMTP para despliegues propios (ver config.SMTP).
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	tlsMode  string
+	from     *mail.Address
+}
+
+func NewSMTP(cfg config.SMTP, from string) (*SMTP, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("SMTP_HOST es requerida con EMAIL_PROVIDER=smtp")
+	}
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("EMAIL_FROM inválido: %v", err)
+	}
+	return &SMTP{
+		host:     cfg.Host,
+		port:     cfg.Port,
+		username: cfg.Username,
+		password: cfg.Password,
+		tlsMode:  cfg.TLS,
+		from:     address,
+	}, nil
+}
+
+func (s *SMTP) Name() string {
+	return "SMTP (" + s.host + ")"
+}
+
+func (s *SMTP) Send(message Message) (string, error) {
+	messageID, data, err := buildSMTPMessage(s.from, message)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := s.dial()
+	if err != nil {
+		return "", fmt.Errorf("error conectando a SMTP: %v", err)
+	}
+	defer client.Close()
+
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return "", fmt.Errorf("error autenticando en SMTP: %v", err)
+		}
+	}
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return "", fmt.Errorf("error en MAIL FROM: %v", err)
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return "", fmt.Errorf("error en RCPT TO: %v", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return "", fmt.Errorf("error en DATA: %v", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return "", fmt.Errorf("error escribiendo mensaje: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("error enviando mensaje: %v", err)
+	}
+	// El servidor ya aceptó el mensaje; un error en QUIT no significa que no se enviara.
+	client.Quit()
+	return messageID, nil
+}
+
+func (s *SMTP) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	tlsConfig := &tls.Config{ServerName: s.host}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	if s.tlsMode == "implicit" {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, s.host)
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if s.tlsMode == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("el servidor no soporta STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// buildSMTPMessage arma un mensaje multipart/alternative con la versión en
+// texto plano primero y la HTML después, como recomienda el RFC 2046. Si hay
+// adjuntos, todo va dentro de un multipart/related para que el HTML pueda
+// referenciar las imágenes embebidas por cid:.
+func buildSMTPMessage(from *mail.Address, message Message) (string, []byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	messageID := hex.EncodeToString(id) + "@" + from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var alternative bytes.Buffer
+	alternativeWriter := multipart.NewWriter(&alternative)
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", message.Text},
+		{"text/html; charset=UTF-8", message.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+		partWriter, err := alternativeWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		body := quotedprintable.NewWriter(partWriter)
+		if _, err := body.Write([]byte(part.body)); err != nil {
+			return "", nil, err
+		}
+		if err := body.Close(); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := alternativeWriter.Close(); err != nil {
+		return "", nil, err
+	}
+
+	contentType := fmt.Sprintf("multipart/alternative; boundary=%q", alternativeWriter.Boundary())
+	content := alternative.Bytes()
+
+	if len(message.Attachments) > 0 {
+		var related bytes.Buffer
+		relatedWriter := multipart.NewWriter(&related)
+
+		partWriter, err := relatedWriter.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return "", nil, err
+		}
+		partWriter.Write(content)
+
+		for _, attachment := range message.Attachments {
+			header := textproto.MIMEHeader{
+				"Content-Type":              {attachment.ContentType},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			}
+			if attachment.ContentID != "" {
+				header.Set("Content-ID", "<"+attachment.ContentID+">")
+				header.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename}))
+			}
+			partWriter, err := relatedWriter.CreatePart(header)
+			if err != nil {
+				return "", nil, err
+			}
+			writeBase64Lines(partWriter, attachment.Content)
+		}
+		if err := relatedWriter.Close(); err != nil {
+			return "", nil, err
+		}
+
+		contentType = fmt.Sprintf("multipart/related; boundary=%q", relatedWriter.Boundary())
+		content = related.Bytes()
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", message.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", message.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s>\r\n", messageID)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n\r\n", contentType)
+	msg.Write(content)
+	return messageID, msg.Bytes(), nil
+}
+
+// writeBase64Lines codifica en base64 con líneas de 76 caracteres (RFC 2045).
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
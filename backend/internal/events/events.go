@@ -0,0 +1,95 @@
+// Package events es el bus de eventos de dominio (user.created,
+// user.updated, ...). Los handlers publican un evento y los consumidores
+// (webhooks, emails, analítica) se suscriben a los tipos que les interesan,
+// en este proceso (memory) o a través de NATS o Kafka para repartirlos entre
+// instancias.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/config"
+)
+
+// handlerTimeout limita lo que puede tardar un consumidor con cada evento.
+const handlerTimeout = 30 * time.Second
+
+// Event es un evento de dominio. Data es JSON para que el evento llegue
+// igual a los consumidores sea cual sea el bus.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	UserID     string          `json:"user_id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// NewEvent crea un evento con ID propio serializando data.
+func NewEvent(eventType, userID string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("error serializando el evento %s: %w", eventType, err)
+	}
+	return Event{
+		ID:         "evt_" + primitive.NewObjectID().Hex(),
+		Type:       eventType,
+		UserID:     userID,
+		Data:       raw,
+		OccurredAt: time.Now(),
+	}, nil
+}
+
+// Handler procesa un evento. Si devuelve un error se registra; el bus no lo
+// reintenta, así que quien necesite reintentos debe gestionarlos él mismo.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus publica eventos y los reparte entre los consumidores.
+type Bus interface {
+	Name() string
+	Publish(ctx context.Context, event Event) error
+	// Subscribe registra handler para los eventos de types. group identifica
+	// al consumidor: con varias instancias, cada evento lo atiende solo una
+	// de las suscripciones con el mismo group.
+	Subscribe(group string, types []string, handler Handler) error
+	Close() error
+}
+
+// New construye el bus configurado con EVENT_BUS.
+func New(cfg config.Events) (Bus, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemory(), nil
+	case "nats":
+		return NewNATS(cfg.NATSURL, cfg.Topic)
+	case "kafka":
+		return NewKafka(cfg.KafkaBrokers, cfg.Topic), nil
+	default:
+		return nil, fmt.Errorf("EVENT_BUS desconocido: %s", cfg.Backend)
+	}
+}
+
+// handle entrega un evento a un consumidor. Un panic o un error del
+// consumidor no afecta a los demás ni al bus.
+func handle(group string, types []string, handler Handler, event Event) {
+	if !slices.Contains(types, event.Type) {
+		return
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("❌ Panic en el consumidor %s con el evento %s: %v", group, event.Type, recovered)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+	if err := handler(ctx, event); err != nil {
+		log.Printf("⚠️  El consumidor %s no pudo procesar el evento %s (%s): %v", group, event.Type, event.ID, err)
+	}
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka publica todos los eventos en un topic, con el usuario como clave
+// para que los de un mismo usuario lleguen en orden. Cada group es un
+// consumer group con el nombre <topic>.<group>. El offset se confirma después
+// de procesar cada evento: si la instancia cae a mitad, el evento se vuelve
+// a entregar, así que los consumidores deben tolerar duplicados.
+type Kafka struct {
+	brokers []string
+	topic   string
+	writer  *kafka.Writer
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	readers []*kafka.Reader
+	wg      sync.WaitGroup
+}
+
+func NewKafka(brokers []string, topic string) *Kafka {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Kafka{
+		brokers: brokers,
+		topic:   topic,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+			// Cada evento se publica desde una petición: no se espera a
+			// juntar un lote.
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (k *Kafka) Name() string {
+	return "Kafka (" + k.topic + ")"
+}
+
+func (k *Kafka) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return k.writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(event.UserID),
+		Value:   data,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(event.Type)}},
+	})
+}
+
+func (k *Kafka) Subscribe(group string, types []string, handler Handler) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: k.brokers,
+		Topic:   k.topic,
+		GroupID: k.topic + "." + group,
+	})
+
+	k.mu.Lock()
+	k.readers = append(k.readers, reader)
+	k.mu.Unlock()
+
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		k.consume(reader, group, types, handler)
+	}()
+	return nil
+}
+
+func (k *Kafka) consume(reader *kafka.Reader, group string, types []string, handler Handler) {
+	for {
+		msg, err := reader.FetchMessage(k.ctx)
+		if errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			log.Printf("⚠️  Error leyendo eventos de Kafka (%s): %v", group, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			log.Printf("⚠️  Evento inválido en %s (offset %d): %v", k.topic, msg.Offset, err)
+		} else {
+			handle(group, types, handler, event)
+		}
+
+		if err := reader.CommitMessages(k.ctx, msg); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("⚠️  Error confirmando el offset en Kafka (%s): %v", group, err)
+		}
+	}
+}
+
+func (k *Kafka) Close() error {
+	k.cancel()
+	k.wg.Wait()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	errs := []error{k.writer.Close()}
+	for _, reader := range k.readers {
+		errs = append(errs, reader.Close())
+	}
+	return errors.Join(errs...)
+}
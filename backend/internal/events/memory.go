@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory reparte los eventos dentro del proceso. Publish llama a los
+// consumidores uno tras otro y no vuelve hasta que terminan, como si la
+// operación los llamara directamente. Cada instancia solo ve los eventos que
+// publica ella misma.
+type Memory struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+}
+
+type subscription struct {
+	group   string
+	types   []string
+	handler Handler
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Name() string {
+	return "memoria"
+}
+
+func (m *Memory) Publish(ctx context.Context, event Event) error {
+	m.mu.RLock()
+	subscriptions := m.subscriptions
+	m.mu.RUnlock()
+
+	for _, s := range subscriptions {
+		handle(s.group, s.types, s.handler, event)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(group string, types []string, handler Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptions = append(m.subscriptions, subscription{group: group, types: types, handler: handler})
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publica cada evento en el subject <prefijo>.<tipo>. Los consumidores
+// se suscriben con un queue group por group, así que cada evento lo procesa
+// una sola instancia. NATS sin JetStream no guarda los mensajes: los eventos
+// publicados mientras no hay ningún consumidor conectado se pierden.
+type NATS struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func NewNATS(url, prefix string) (*NATS, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("userapp"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("⚠️  Desconectado de NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Printf("✅ Reconectado a NATS (%s)", conn.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error conectando a NATS: %w", err)
+	}
+	return &NATS{conn: conn, prefix: prefix}, nil
+}
+
+func (n *NATS) Name() string {
+	return "NATS (" + n.prefix + ".*)"
+}
+
+func (n *NATS) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return n.conn.Publish(n.prefix+"."+event.Type, data)
+}
+
+func (n *NATS) Subscribe(group string, types []string, handler Handler) error {
+	for _, eventType := range types {
+		_, err := n.conn.QueueSubscribe(n.prefix+"."+eventType, group, func(msg *nats.Msg) {
+			var event Event
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				log.Printf("⚠️  Evento inválido en %s: %v", msg.Subject, err)
+				return
+			}
+			handle(group, types, handler, event)
+		})
+		if err != nil {
+			return fmt.Errorf("error suscribiendo %s a %s: %w", group, eventType, err)
+		}
+	}
+	return nil
+}
+
+// Close espera a que los consumidores terminen con los eventos que ya han
+// recibido.
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/events"
+)
+
+// Las cuentas eliminadas solo se marcan con deleted_at y se conservan
+// (con sus imágenes) durante USER_RETENTION, por si hay que restaurarlas.
+// Pasado ese plazo runUserPurge las borra definitivamente.
+
+// Máximo de cuentas borradas definitivamente en cada pasada.
+const maxPurgesPerRun = 100
+
+func (s *Server) userRetention() time.Duration {
+	return s.usersConfig.Retention
+}
+
+// handleDeleteUser elimina la cuenta del usuario autenticado: la marca como
+// borrada, revoca sus sesiones y borra tokens y subidas pendientes. Los
+// access tokens ya emitidos dejan de servir porque las consultas ignoran
+// las cuentas eliminadas.
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if rejectImpersonation(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	err := s.userRepo.Delete(ctx, user.ID)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error eliminando usuario: %v", err)
+		http.Error(w, "Error eliminando usuario", http.StatusInternalServerError)
+		return
+	}
+
+	s.deleteUserData(ctx, user.ID)
+	clearSessionCookie(w)
+	s.publishUserDeleted(ctx, user, "deleted", primitive.NilObjectID)
+
+	log.Printf("🗑️  Cuenta eliminada: %s", user.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Cuenta eliminada correctamente",
+	})
+}
+
+// sendAccountDeletedEmail es el consumidor del bus que confirma la baja al
+// usuario. Solo las bajas pedidas por el propio usuario se confirman.
+func (s *Server) sendAccountDeletedEmail(ctx context.Context, event events.Event) error {
+	var data struct {
+		User   deletedUser `json:"user"`
+		Reason string      `json:"reason"`
+	}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return err
+	}
+	if data.Reason != "deleted" || data.User.Email == "" {
+		return nil
+	}
+
+	retentionDays := int(s.userRetention().Hours() / 24)
+	err := s.sendTemplateEmail(data.User.Email, emailTemplateAccountDeleted, map[string]interface{}{
+		"Email":         data.User.Email,
+		"RetentionDays": retentionDays,
+	}, "🗑️  CUENTA ELIMINADA: "+data.User.Email)
+	if err != nil {
+		return fmt.Errorf("error enviando confirmación de baja a %s: %w", data.User.Email, err)
+	}
+	return nil
+}
+
+// deleteUserData revoca las sesiones del usuario y borra sus datos en el
+// resto de colecciones. Los errores solo se registran: lo que quede expira por TTL.
+func (s *Server) deleteUserData(ctx context.Context, userID primitive.ObjectID) {
+	_, err := s.database.Sessions.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error revocando sesiones: %v", err)
+	}
+
+	cursor, err := s.database.Uploads.Find(ctx, bson.M{"user_id": userID})
+	if err == nil {
+		var uploads []TusUpload
+		if err := cursor.All(ctx, &uploads); err == nil {
+			for _, upload := range uploads {
+				s.deleteTusUpload(ctx, upload.ID)
+			}
+		}
+	}
+
+	if _, err := s.database.OTPs.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		log.Printf("Error borrando códigos de un solo uso: %v", err)
+	}
+	if _, err := s.database.ActionTokens.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		log.Printf("Error borrando tokens: %v", err)
+	}
+	if _, err := s.database.WebAuthnSessions.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		log.Printf("Error borrando sesiones de passkeys: %v", err)
+	}
+}
+
+// purgeUser borra definitivamente una cuenta ya eliminada y sus imágenes.
+func (s *Server) purgeUser(ctx context.Context, user User) error {
+	err := s.userRepo.Purge(ctx, user.ID)
+	if errors.Is(err, errUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.deleteUserData(ctx, user.ID)
+	s.deleteProfileImageObjects(ctx, user.Images...)
+	if _, err := s.database.ProfileVersions.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		log.Printf("Error borrando versiones del perfil: %v", err)
+	}
+	return nil
+}
+
+// purgeDeletedUserByEmail libera el email de una cuenta eliminada para que
+// pueda volver a registrarse sin esperar a que acabe la retención.
+func (s *Server) purgeDeletedUserByEmail(ctx context.Context, email string) error {
+	user, err := s.findUser(ctx, UserQuery{Deleted: true, Email: email})
+	if errors.Is(err, errUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.purgeUser(ctx, user)
+}
+
+// runUserPurge borra cada hora las cuentas eliminadas hace más de USER_RETENTION.
+func (s *Server) runUserPurge() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := s.purgeDeletedUsers()
+		if err != nil {
+			log.Printf("❌ Error purgando cuentas eliminadas: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("🗑️  %d cuentas eliminadas borradas definitivamente", purged)
+		}
+	}
+}
+
+func (s *Server) purgeDeletedUsers() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cutoff := time.Now().Add(-s.userRetention())
+	users, _, err := s.userRepo.List(ctx, UserQuery{Deleted: true, DeletedBefore: &cutoff, Limit: maxPurgesPerRun})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		if err := s.purgeUser(ctx, user); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// handleAdminRestoreUser recupera una cuenta eliminada que aún no se ha
+// purgado. Acepta el ID del usuario o su código.
+func (s *Server) handleAdminRestoreUser(w http.ResponseWriter, r *http.Request) {
+	query := s.adminUserQuery(mux.Vars(r)["id"])
+	query.Deleted = true
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUser(ctx, query)
+	if err == nil {
+		user, err = s.userRepo.Restore(ctx, user.ID)
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Cuenta eliminada no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error restaurando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("♻️  Cuenta restaurada: %s", user.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Cuenta restaurada correctamente",
+		"user":    user,
+	})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actividad de los usuarios: last_login_at y login_count se actualizan al
+// abrir cada sesión; last_seen_at con cada petición autenticada, pero como
+// mucho una vez cada lastSeenResolution para no escribir en cada petición.
+
+const lastSeenResolution = 5 * time.Minute
+
+func (s *Server) recordLogin(ctx context.Context, userID primitive.ObjectID) {
+	ctx, cancel := detach(ctx, 5*time.Second)
+	defer cancel()
+	now := time.Now()
+	err := s.userRepo.RecordActivity(ctx, userID, UserActivity{Logins: 1, LoginAt: &now, SeenAt: &now})
+	if err != nil {
+		log.Printf("⚠️  Error registrando login: %v", err)
+		return
+	}
+	s.lastSeenWrites.Store(userID, now)
+}
+
+// touchLastSeen actualiza last_seen_at si ha pasado lastSeenResolution desde
+// la última vez.
+func (s *Server) touchLastSeen(userID primitive.ObjectID) {
+	now := time.Now()
+	if last, ok := s.lastSeenWrites.Load(userID); ok && now.Sub(last.(time.Time)) < lastSeenResolution {
+		return
+	}
+	s.lastSeenWrites.Store(userID, now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.userRepo.RecordActivity(ctx, userID, UserActivity{SeenAt: &now})
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		log.Printf("⚠️  Error actualizando last_seen_at: %v", err)
+	}
+}
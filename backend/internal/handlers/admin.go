@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"backend/internal/config"
+)
+
+const roleAdmin = "admin"
+
+func (s *Server) loadAdminAllowlist(cfg config.Admin) error {
+	prefixes, err := readAdminAllowlist(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.liveMu.Lock()
+	s.adminToken, s.adminAllowedPrefixes = cfg.Token, prefixes
+	s.liveMu.Unlock()
+
+	if len(prefixes) > 0 {
+		log.Printf("🔒 Rutas de administración restringidas a %d redes", len(prefixes))
+	}
+	return nil
+}
+
+// readAdminAllowlist lee las redes permitidas para /api/admin desde
+// ADMIN_ALLOWED_CIDRS (separadas por comas) y/o ADMIN_ALLOWED_CIDRS_FILE (una
+// por línea, # para comentarios). Sin ninguna, el acceso no se restringe por IP.
+func readAdminAllowlist(cfg config.Admin) ([]netip.Prefix, error) {
+	entries := append([]string{}, cfg.AllowedCIDRs...)
+
+	if path := cfg.AllowedCIDRsFile; path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("leyendo %s: %v", path, err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			entries = append(entries, line)
+		}
+	}
+
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, err := parseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("red inválida %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parseCIDR acepta tanto redes (10.0.0.0/8) como IPs sueltas.
+func parseCIDR(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (s *Server) requireAdminIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.liveMu.RLock()
+		prefixes := s.adminAllowedPrefixes
+		s.liveMu.RUnlock()
+
+		if len(prefixes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, err := netip.ParseAddr(s.clientIP(r))
+		if err == nil {
+			addr = addr.Unmap()
+			for _, prefix := range prefixes {
+				if prefix.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		log.Printf("⚠️  Acceso de administración bloqueado desde %s", s.clientIP(r))
+		http.Error(w, "Acceso no permitido desde esta IP", http.StatusForbidden)
+	})
+}
+
+// requireAdmin acepta tres credenciales: una API key con scope admin, el
+// ADMIN_TOKEN de arranque (para crear las primeras keys) o el token de sesión
+// de un usuario con rol admin.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "" {
+			r, ok := s.authenticateAPIKey(w, r, scopeAdmin)
+			if ok {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" {
+			http.Error(w, "Credenciales de administrador requeridas", http.StatusUnauthorized)
+			return
+		}
+
+		s.liveMu.RLock()
+		staticToken := s.adminToken
+		s.liveMu.RUnlock()
+		if staticToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(staticToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := s.parseAccessToken(token)
+		if err != nil {
+			http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != roleAdmin {
+			http.Error(w, "Se requieren permisos de administrador", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestActor identifica quién hace la petición para los registros:
+// "api_key:<id>", "user:<id>" o "admin_token" (token de arranque). Con un
+// token de suplantación es "<administrador> as user:<id>".
+func requestActor(r *http.Request) string {
+	if apiKey, ok := apiKeyFromContext(r.Context()); ok {
+		return "api_key:" + apiKey.ID.Hex()
+	}
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		if claims.Act != nil {
+			return claims.Act.Subject + " as user:" + claims.Subject
+		}
+		return "user:" + claims.Subject
+	}
+	return "admin_token"
+}
+
+// adminUserQuery busca al usuario por su ID o por su código.
+func (s *Server) adminUserQuery(idOrCode string) UserQuery {
+	if userID, err := primitive.ObjectIDFromHex(idOrCode); err == nil {
+		return UserQuery{ID: userID}
+	}
+	return UserQuery{CodeHash: s.hashCode(idOrCode)}
+}
+
+// findAdminTarget busca al usuario de la ruta por su ID o por su código,
+// incluidas las cuentas eliminadas (pero no las borradas por RGPD).
+func (s *Server) findAdminTarget(ctx context.Context, r *http.Request) (User, error) {
+	query := s.adminUserQuery(mux.Vars(r)["id"])
+	query.IncludeDeleted = true
+	user, err := s.findUser(ctx, query)
+	if err == nil && user.ErasedAt != nil {
+		return User{}, errUserNotFound
+	}
+	return user, err
+}
+
+func (s *Server) handleAdminCreateIndexes(w http.ResponseWriter, r *http.Request) {
+	if err := s.CreateIndexes(); err != nil {
+		log.Printf("Error creando índices: %v", err)
+		http.Error(w, "Error creando índices", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Índices creados correctamente",
+	})
+}
+
+// adminUserList son la paginación y los filtros del listado de usuarios;
+// los filtros los interpreta parseAdminUserQuery.
+var adminUserList = listOptions{
+	Sorts:       userSorts,
+	DefaultSort: "-created_at",
+	Filters: []string{
+		"email", "name", "created_after", "created_before", "has_image",
+		"deleted", "active", "tag", "group", "seen_after", "seen_before",
+	},
+}
+
+// parseAdminTime acepta fechas RFC 3339 o solo el día (2006-01-02).
+func parseAdminTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseAdminUserQuery traduce los filtros de búsqueda: email y name buscan
+// por prefijo (distingue mayúsculas), created_after/created_before acotan
+// created_at, seen_after/seen_before acotan last_seen_at y has_image filtra
+// por image_url. tag (repetible) exige todas las etiquetas y group aplica
+// la definición de un grupo. Las cuentas eliminadas solo aparecen con
+// deleted=true. Devuelve el error de cada parámetro inválido; err solo
+// indica un fallo consultando la base de datos.
+func (s *Server) parseAdminUserQuery(ctx context.Context, query url.Values) (UserQuery, map[string]string, error) {
+	var userQuery UserQuery
+	invalid := map[string]string{}
+	for param := range query {
+		if !listParams[param] && !slices.Contains(adminUserList.Filters, param) {
+			invalid[param] = "parámetro no permitido"
+		}
+	}
+
+	for _, param := range []struct {
+		name  string
+		value **bool
+	}{
+		{"active", &userQuery.Active},
+		{"has_image", &userQuery.HasImage},
+	} {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid[param.name] = "debe ser true o false"
+				continue
+			}
+			*param.value = &parsed
+		}
+	}
+	if value := query.Get("deleted"); value != "" {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			invalid["deleted"] = "debe ser true o false"
+		}
+		userQuery.Deleted = deleted
+	}
+	if values := query["tag"]; len(values) > 0 {
+		tags, err := normalizeTags(values)
+		if err != nil {
+			invalid["tag"] = err.Error()
+		}
+		userQuery.AllTags = tags
+	}
+	if name := query.Get("group"); name != "" {
+		group, err := s.findGroup(ctx, name)
+		if err == mongo.ErrNoDocuments {
+			invalid["group"] = "grupo no encontrado: " + name
+		} else if err != nil {
+			return userQuery, nil, fmt.Errorf("error consultando el grupo %s: %v", name, err)
+		} else if group.Match == groupMatchAll {
+			userQuery.AllTags = append(userQuery.AllTags, group.Tags...)
+		} else {
+			userQuery.AnyTags = group.Tags
+		}
+	}
+	userQuery.EmailPrefix = query.Get("email")
+	userQuery.NamePrefix = query.Get("name")
+
+	for _, param := range []struct {
+		name  string
+		value **time.Time
+	}{
+		{"created_after", &userQuery.CreatedAfter},
+		{"created_before", &userQuery.CreatedBefore},
+		{"seen_after", &userQuery.SeenAfter},
+		{"seen_before", &userQuery.SeenBefore},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseAdminTime(value)
+		if err != nil {
+			invalid[param.name] = "debe ser una fecha RFC 3339 o 2006-01-02"
+			continue
+		}
+		*param.value = &parsed
+	}
+	return userQuery, invalid, nil
+}
+
+// handleAdminListUsers lista los usuarios paginados (ver pagination.go,
+// -created_at por defecto) con los filtros de parseAdminUserQuery.
+func (s *Server) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userQuery, invalid, err := s.parseAdminUserQuery(ctx, query)
+	if err != nil {
+		log.Printf("Error interpretando filtros: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	list, listErrors := parseListQuery(query, adminUserList)
+	for param, message := range listErrors {
+		invalid[param] = message
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+	userQuery.Sort = list.Sort
+	userQuery.Skip = list.Offset
+	userQuery.Limit = list.Limit
+
+	users, total, err := s.userRepo.List(ctx, userQuery)
+	if err != nil {
+		log.Printf("Error listando usuarios: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, users, len(users), total))
+}
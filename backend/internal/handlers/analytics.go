@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/events"
+)
+
+// EventCount cuenta los eventos de dominio de un tipo en un día (UTC). Los
+// mantiene el consumidor de analítica del bus (ver eventbus.go).
+type EventCount struct {
+	Day   string `json:"day" bson:"day"`
+	Type  string `json:"type" bson:"type"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+func (s *Server) createEventCountIndexes(ctx context.Context) error {
+	_, err := s.database.EventCounts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "day", Value: -1}, {Key: "type", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (s *Server) countEvent(ctx context.Context, event events.Event) error {
+	day := event.OccurredAt.UTC().Format("2006-01-02")
+	_, err := s.database.EventCounts.UpdateOne(ctx,
+		bson.M{"day": day, "type": event.Type},
+		bson.M{"$inc": bson.M{"count": 1}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+var eventCountList = listOptions{
+	Sorts:       map[string]bool{"day": true, "count": true},
+	DefaultSort: "-day",
+	Filters:     []string{"type", "day"},
+}
+
+// handleAdminEventCounts lista los eventos por día, filtrando por ?type= y
+// ?day=.
+func (s *Server) handleAdminEventCounts(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), eventCountList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	counts := []EventCount{}
+	total, err := findList(ctx, s.database.EventCounts, bson.M{}, list, &counts)
+	if err != nil {
+		log.Printf("Error listando contadores de eventos: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, counts, len(counts), total))
+}
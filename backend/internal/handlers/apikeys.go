@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	apiKeyPrefix = "uak_"
+
+	scopeUsersRead  = "users:read"
+	scopeUsersWrite = "users:write"
+	scopeAdmin      = "admin"
+
+	apiKeyContextKey contextKey = "api_key"
+)
+
+var apiKeyScopes = []string{scopeUsersRead, scopeUsersWrite, scopeAdmin}
+
+var errInvalidAPIKey = errors.New("API key inválida")
+
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	KeyHash    string             `json:"-" bson:"key_hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (s *Server) createAPIKeyIndexes(ctx context.Context) error {
+	_, err := s.database.APIKeys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, scopeAdmin)
+}
+
+func newAPIKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (s *Server) findAPIKey(ctx context.Context, key string) (APIKey, error) {
+	var apiKey APIKey
+	now := time.Now()
+	err := s.database.APIKeys.FindOneAndUpdate(ctx,
+		bson.M{"key_hash": hashToken(key), "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	return apiKey, nil
+}
+
+func apiKeyFromContext(ctx context.Context) (APIKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyContextKey).(APIKey)
+	return apiKey, ok
+}
+
+// authenticateAPIKey valida X-API-Key y exige el scope indicado. Devuelve
+// false si ya respondió con un error.
+func (s *Server) authenticateAPIKey(w http.ResponseWriter, r *http.Request, scope string) (*http.Request, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	apiKey, err := s.findAPIKey(ctx, r.Header.Get("X-API-Key"))
+	if err == errInvalidAPIKey {
+		http.Error(w, "API key inválida", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error validando API key: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !apiKey.HasScope(scope) {
+		http.Error(w, "La API key no tiene el permiso "+scope, http.StatusForbidden)
+		return nil, false
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, apiKey)), true
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Nombre requerido", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		http.Error(w, "Se requiere al menos un scope", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			http.Error(w, "Scope desconocido: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		log.Printf("Error generando API key: %v", err)
+		http.Error(w, "Error generando API key", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	apiKey := APIKey{
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		KeyHash:   hashToken(key),
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+
+	result, err := s.database.APIKeys.InsertOne(ctx, apiKey)
+	if err != nil {
+		log.Printf("Error guardando API key: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+
+	log.Printf("✅ API key %s creada (%s)", apiKey.Prefix, apiKey.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "API key creada. Guárdala ahora, no se volverá a mostrar.",
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+var apiKeyList = listOptions{
+	Sorts:       map[string]bool{"created_at": true, "last_used_at": true},
+	DefaultSort: "-created_at",
+}
+
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), apiKeyList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	keys := []APIKey{}
+	total, err := findList(ctx, s.database.APIKeys, bson.M{}, list, &keys)
+	if err != nil {
+		log.Printf("Error listando API keys: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, keys, len(keys), total))
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "API key no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.database.APIKeys.UpdateOne(ctx,
+		bson.M{"_id": keyID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error revocando API key: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		http.Error(w, "API key no encontrada", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "API key revocada correctamente",
+	})
+}
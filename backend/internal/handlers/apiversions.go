@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+// Versionado de la API. Cada versión registra sus rutas en su propio
+// subrouter bajo /api/<versión>, así un cambio incompatible (por ejemplo en
+// el formato de los errores) se publica como versión nueva sin romper a los
+// clientes de la anterior. Las rutas sin versión (/api/...) son un alias de
+// legacyAPIVersion para los clientes y enlaces anteriores al versionado.
+
+type apiVersion struct {
+	Name   string
+	Routes func(s *Server, api *mux.Router, cfg config.Config)
+
+	// Deprecated marca la versión como obsoleta: sus respuestas llevan
+	// Deprecation y un Link a la misma ruta en currentAPIVersion.
+	Deprecated bool
+	Sunset     time.Time
+}
+
+var apiVersions = []apiVersion{
+	{Name: "v1", Routes: (*Server).registerV1Routes},
+}
+
+const (
+	currentAPIVersion = "v1"
+	legacyAPIVersion  = "v1"
+)
+
+// apiPath devuelve la ruta de la versión actual, para los enlaces que se
+// envían por email.
+func apiPath(path string) string {
+	return "/api/" + currentAPIVersion + path
+}
+
+// mountAPI registra cada versión y el alias sin versión. Las versiones van
+// primero para que /api/v1/... no se interprete como una ruta del alias.
+func (s *Server) mountAPI(r *mux.Router, cfg config.Config) {
+	var legacy apiVersion
+	for _, version := range apiVersions {
+		prefix := "/api/" + version.Name
+		api := r.PathPrefix(prefix).Subrouter()
+		api.Use(apiVersionHeaders(version.Name))
+		if version.Deprecated {
+			api.Use(deprecatedAPI(prefix, version.Sunset))
+		}
+		version.Routes(s, api, cfg)
+
+		if version.Name == legacyAPIVersion {
+			legacy = version
+		}
+	}
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(apiVersionHeaders(legacy.Name))
+	api.Use(deprecatedAPI("/api", cfg.API.LegacySunset))
+	legacy.Routes(s, api, cfg)
+}
+
+func apiVersionHeaders(name string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("API-Version", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// deprecatedAPI anuncia que las rutas bajo prefix están obsoletas
+// (Deprecation), la fecha de retirada si la hay (Sunset) y la ruta
+// equivalente en la versión actual (Link con rel="successor-version").
+func deprecatedAPI(prefix string, sunset time.Time) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			successor := apiPath(strings.TrimPrefix(r.URL.Path, prefix))
+			w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) registerV1Routes(api *mux.Router, cfg config.Config) {
+	api.HandleFunc("/register", s.handleRegister).Methods("POST")
+	api.HandleFunc("/login", s.handleLogin).Methods("POST")
+	api.HandleFunc("/login/otp", s.handleRequestOTP).Methods("POST")
+	api.HandleFunc("/login/magic", s.handleRequestMagicLink).Methods("POST")
+	api.HandleFunc("/auth/magic/{token}", handleMagicLinkPage).Methods("GET")
+	api.HandleFunc("/auth/magic/{token}", s.handleMagicLinkLogin).Methods("POST")
+	api.HandleFunc("/token/refresh", s.handleRefreshToken).Methods("POST")
+	api.HandleFunc("/logout", s.handleLogout).Methods("POST")
+	api.HandleFunc("/code/regenerate", s.handleRegenerateCode).Methods("POST")
+	api.HandleFunc("/code/resend", s.handleRegenerateCode).Methods("POST")
+	api.HandleFunc("/code/resend/{token}", s.handleConfirmNewCode).Methods("GET")
+	api.HandleFunc("/verify/{token}", s.handleVerifyEmail).Methods("GET")
+	api.HandleFunc("/recover", s.handleRequestRecovery).Methods("POST")
+	api.HandleFunc("/recover/{token}", s.handleConfirmRecovery).Methods("GET")
+	api.HandleFunc("/reactivate", s.handleRequestReactivation).Methods("POST")
+	api.HandleFunc("/reactivate/{token}", s.handleConfirmReactivation).Methods("GET")
+	api.HandleFunc("/reminders/unsubscribe/{token}", s.handleReminderUnsubscribe).Methods("GET")
+	api.HandleFunc("/auth/{provider}", s.handleOAuthLogin).Methods("GET")
+	api.HandleFunc("/auth/{provider}/callback", s.handleOAuthCallback).Methods("GET")
+
+	// Resend entrega los eventos en ráfagas desde pocas IPs.
+	s.middlewares.Route(api.HandleFunc("/webhooks/resend", s.handleResendWebhook).Methods("POST"),
+		routeMiddleware{Skip: []string{"ratelimit"}})
+
+	api.HandleFunc("/saml/metadata", s.handleSAMLMetadata).Methods("GET")
+	api.HandleFunc("/saml/login", s.handleSAMLLogin).Methods("GET")
+	api.HandleFunc("/saml/acs", s.handleSAMLACS).Methods("POST")
+
+	api.HandleFunc("/webauthn/login/begin", s.handleWebAuthnLoginBegin).Methods("POST")
+	api.HandleFunc("/webauthn/login/finish", s.handleWebAuthnLoginFinish).Methods("POST")
+
+	passkeys := api.PathPrefix("/webauthn/register").Subrouter()
+	passkeys.Use(s.requireAuth)
+	passkeys.HandleFunc("/begin", s.handleWebAuthnRegisterBegin).Methods("POST")
+	passkeys.HandleFunc("/finish", s.handleWebAuthnRegisterFinish).Methods("POST")
+
+	dev := api.PathPrefix("/dev").Subrouter()
+	dev.Use(s.requireDevMode)
+	dev.HandleFunc("/email-preview", s.handleListEmailPreviews).Methods("GET")
+	dev.HandleFunc("/email-preview/{template}", s.handleEmailPreview).Methods("GET")
+
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(s.requireAdminIP, s.requireAdmin)
+	admin.HandleFunc("/keys", s.handleCreateAPIKey).Methods("POST")
+	admin.HandleFunc("/keys", s.handleListAPIKeys).Methods("GET")
+	admin.HandleFunc("/keys/{id}", s.handleRevokeAPIKey).Methods("DELETE")
+	admin.HandleFunc("/indexes", s.handleAdminCreateIndexes).Methods("POST")
+	admin.HandleFunc("/stats", s.handleAdminStats).Methods("GET")
+	admin.HandleFunc("/users", s.handleAdminListUsers).Methods("GET")
+	admin.HandleFunc("/users/export", s.handleAdminExportUsers).Methods("GET")
+	admin.HandleFunc("/users/merge", s.handleAdminMergeUsers).Methods("POST")
+	admin.HandleFunc("/users/{id}/restore", s.handleAdminRestoreUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/erase", s.handleAdminEraseUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/deactivate", s.handleAdminDeactivateUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/reactivate", s.handleAdminReactivateUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/audit", s.handleAdminUserAudit).Methods("GET")
+	admin.HandleFunc("/users/{id}/impersonate", s.handleAdminImpersonateUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/tags", s.handleAdminAddTags).Methods("POST")
+	admin.HandleFunc("/users/{id}/notes", s.handleAdminListNotes).Methods("GET")
+	admin.HandleFunc("/users/{id}/notes", s.handleAdminAddNote).Methods("POST")
+	admin.HandleFunc("/users/{id}/notes/{note}", s.handleAdminUpdateNote).Methods("PUT")
+	admin.HandleFunc("/users/{id}/notes/{note}", s.handleAdminDeleteNote).Methods("DELETE")
+	admin.HandleFunc("/users/{id}/tags/{tag}", s.handleAdminRemoveTag).Methods("DELETE")
+	admin.HandleFunc("/groups", s.handleAdminListGroups).Methods("GET")
+	admin.HandleFunc("/groups/{name}", s.handleAdminGetGroup).Methods("GET")
+	admin.HandleFunc("/groups/{name}", s.handleAdminPutGroup).Methods("PUT")
+	admin.HandleFunc("/groups/{name}", s.handleAdminDeleteGroup).Methods("DELETE")
+	admin.HandleFunc("/users/{id}/avatar/original", s.handleAdminAvatarOriginal).Methods("GET")
+	admin.HandleFunc("/uploads/rewrite-urls", s.handleAdminRewriteUploadURLs).Methods("POST")
+	admin.HandleFunc("/email-events", s.handleAdminListEmailEvents).Methods("GET")
+	admin.HandleFunc("/emails/failed", s.handleAdminListFailedEmails).Methods("GET")
+	admin.HandleFunc("/emails/{id}/retry", s.handleAdminRetryEmail).Methods("POST")
+	admin.HandleFunc("/emails/{id}", s.handleAdminEmailStatus).Methods("GET")
+	admin.HandleFunc("/mail-log", s.handleAdminMailLog).Methods("GET")
+	admin.HandleFunc("/webhooks", s.handleAdminCreateWebhook).Methods("POST")
+	admin.HandleFunc("/webhooks", s.handleAdminListWebhooks).Methods("GET")
+	admin.HandleFunc("/webhooks/{id}", s.handleAdminDeleteWebhook).Methods("DELETE")
+	admin.HandleFunc("/webhooks/{id}/deliveries", s.handleAdminListWebhookDeliveries).Methods("GET")
+	admin.HandleFunc("/webhooks/deliveries/{id}/retry", s.handleAdminRetryWebhookDelivery).Methods("POST")
+	admin.HandleFunc("/event-counts", s.handleAdminEventCounts).Methods("GET")
+	admin.Handle("/email/domain-check", newEmailDomainCheck(s.emailSender, cfg.Email.From, s.EmailProviderName(), cfg.Email.DKIMSelectors)).Methods("GET")
+
+	api.Handle("/batch", batchHandler{server: s, router: api}).Methods("POST")
+	api.HandleFunc("/ws", s.handleWebSocket).Methods("GET")
+	api.HandleFunc("/events", s.handleEventStream).Methods("GET")
+	api.HandleFunc("/avatars/{seed:[0-9a-f]{24}}.svg", handleDefaultAvatar).Methods("GET")
+
+	// Debe registrarse antes que /user/{code} para que "by-username" no se tome por un código.
+	api.HandleFunc("/user/by-username/{name}", s.handleGetUserByUsername).Methods("GET")
+
+	user := api.PathPrefix("/user/{code}").Subrouter()
+	user.Use(s.requireAuth)
+	user.HandleFunc("", s.handleGetUser).Methods("GET")
+	user.HandleFunc("", s.handleUpdateUser).Methods("PUT")
+	user.HandleFunc("", s.handlePatchUser).Methods("PATCH")
+	user.HandleFunc("", s.handleDeleteUser).Methods("DELETE")
+	user.HandleFunc("/erase", s.handleEraseUser).Methods("POST")
+	user.HandleFunc("/deactivate", s.handleDeactivateUser).Methods("POST")
+	user.HandleFunc("/image/presign", s.handlePresignAvatarUpload).Methods("POST")
+	user.HandleFunc("/image/confirm", s.handleConfirmAvatarUpload).Methods("POST")
+	user.HandleFunc("/uploads", s.handleTusOptions).Methods("OPTIONS")
+	user.HandleFunc("/uploads", s.handleTusCreate).Methods("POST")
+	user.HandleFunc("/uploads/{id}", s.handleTusHead).Methods("HEAD")
+	// Una subida reanudable son muchas peticiones seguidas, una por trozo.
+	s.middlewares.Route(user.HandleFunc("/uploads/{id}", s.handleTusPatch).Methods("PATCH"),
+		routeMiddleware{Skip: []string{"ratelimit"}})
+	user.HandleFunc("/uploads/{id}", s.handleTusDelete).Methods("DELETE")
+	user.HandleFunc("/images", s.handleListImages).Methods("GET")
+	user.HandleFunc("/images", s.handleAddImage).Methods("POST")
+	user.HandleFunc("/images/order", s.handleReorderImages).Methods("PUT")
+	user.HandleFunc("/images/{id}", s.handleDeleteImage).Methods("DELETE")
+	user.HandleFunc("/images/{id}/avatar", s.handleSetAvatarImage).Methods("PUT")
+	user.HandleFunc("/completeness", s.handleGetCompleteness).Methods("GET")
+	user.HandleFunc("/visibility", s.handleGetVisibility).Methods("GET")
+	user.HandleFunc("/visibility", s.handleUpdateVisibility).Methods("PUT")
+	user.HandleFunc("/preferences", s.handleGetPreferences).Methods("GET")
+	user.HandleFunc("/preferences", s.handleUpdatePreferences).Methods("PUT")
+	user.HandleFunc("/versions", s.handleListProfileVersions).Methods("GET")
+	user.HandleFunc("/revert/{version}", s.handleRevertProfile).Methods("POST")
+	user.HandleFunc("/sessions", s.handleListSessions).Methods("GET")
+	user.HandleFunc("/sessions/{id}", s.handleRevokeSession).Methods("DELETE")
+}
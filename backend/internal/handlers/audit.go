@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	auditActionProfileUpdate = "profile.update"
+	auditActionDeactivate    = "account.deactivate"
+	auditActionReactivate    = "account.reactivate"
+	auditActionImpersonate   = "impersonation.start"
+	auditActionImpersonated  = "impersonation.request"
+	auditActionMerge         = "account.merge"
+	auditActionRoleGrant     = "role.grant"
+)
+
+// AuditEntry registra un cambio en el perfil de un usuario: quién lo hizo
+// (ver requestActor), desde dónde y qué campos cambiaron.
+type AuditEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Actor     string             `json:"actor" bson:"actor"`
+	Action    string             `json:"action" bson:"action"`
+	Changes   []AuditChange      `json:"changes" bson:"changes"`
+	Details   map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type AuditChange struct {
+	Field string      `json:"field" bson:"field"`
+	Old   interface{} `json:"old" bson:"old"`
+	New   interface{} `json:"new" bson:"new"`
+}
+
+func (s *Server) createAuditIndexes(ctx context.Context) error {
+	_, err := s.database.Audit.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+// auditedProfileFields son los campos del perfil que se comparan, en el
+// orden en que aparecen los cambios.
+var auditedProfileFields = []string{"name", "last_name", "username", "image_url", "phone", "bio", "birthday", "website", "pronouns"}
+
+func profileSnapshot(user User) map[string]string {
+	return map[string]string{
+		"name":      user.Name,
+		"last_name": user.LastName,
+		"username":  user.Username,
+		"image_url": user.ImageURL,
+		"phone":     user.Phone,
+		"bio":       user.Bio,
+		"birthday":  user.Birthday,
+		"website":   user.Website,
+		"pronouns":  user.Pronouns,
+	}
+}
+
+func profileChanges(before, after User) []AuditChange {
+	old, current := profileSnapshot(before), profileSnapshot(after)
+	var changes []AuditChange
+	for _, field := range auditedProfileFields {
+		if old[field] != current[field] {
+			changes = append(changes, AuditChange{Field: field, Old: old[field], New: current[field]})
+		}
+	}
+	return changes
+}
+
+// recordProfileAudit guarda los cambios entre before y after. Un fallo solo
+// se registra en el log: la actualización ya se hizo.
+func (s *Server) recordProfileAudit(ctx context.Context, r *http.Request, before, after User) {
+	changes := profileChanges(before, after)
+	if len(changes) == 0 {
+		return
+	}
+
+	ctx, cancel := detach(ctx, 5*time.Second)
+	defer cancel()
+	_, err := s.database.Audit.InsertOne(ctx, AuditEntry{
+		UserID:    after.ID,
+		Actor:     requestActor(r),
+		Action:    auditActionProfileUpdate,
+		Changes:   changes,
+		IP:        s.clientIP(r),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️  Error registrando auditoría: %v", err)
+	}
+}
+
+// recordAccountAudit registra una acción sobre la cuenta que no modifica
+// campos del perfil. actor sigue el formato de requestActor.
+func (s *Server) recordAccountAudit(ctx context.Context, r *http.Request, userID primitive.ObjectID, actor, action string) {
+	ctx, cancel := detach(ctx, 5*time.Second)
+	defer cancel()
+	_, err := s.database.Audit.InsertOne(ctx, AuditEntry{
+		UserID:    userID,
+		Actor:     actor,
+		Action:    action,
+		Changes:   []AuditChange{},
+		IP:        s.clientIP(r),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️  Error registrando auditoría: %v", err)
+	}
+}
+
+var auditList = listOptions{
+	Sorts:       map[string]bool{"created_at": true},
+	DefaultSort: "-created_at",
+	Filters:     []string{"action", "actor"},
+}
+
+// handleAdminUserAudit lista los cambios de perfil de un usuario, del más
+// reciente al más antiguo, filtrando por ?action= y ?actor=.
+func (s *Server) handleAdminUserAudit(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), auditList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findAdminTarget(ctx, r)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	entries := []AuditEntry{}
+	total, err := findList(ctx, s.database.Audit, bson.M{"user_id": user.ID}, list, &entries)
+	if err != nil {
+		log.Printf("Error listando auditoría: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	response := listResponse(list, entries, len(entries), total)
+	response["user_id"] = user.ID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
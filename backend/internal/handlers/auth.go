@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/config"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "session_claims"
+
+const tokenIssuer = "userapp"
+
+type SessionClaims struct {
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	// Act solo aparece en los tokens de suplantación (ver impersonation.go).
+	Act *ActorClaim `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// loadJWTSecret guarda la configuración de sesiones y carga el secreto del que
+// se derivan claves simétricas, como la de la cookie de sesión. Los access
+// tokens se firman con las claves de keys.go.
+func (s *Server) loadJWTSecret(cfg config.Auth) {
+	s.authConfig = cfg
+	if secret := cfg.JWTSecret; secret != "" {
+		s.jwtSecret = []byte(secret)
+		log.Println("✅ JWT_SECRET configurada correctamente")
+		return
+	}
+
+	s.jwtSecret = make([]byte, 32)
+	if _, err := rand.Read(s.jwtSecret); err != nil {
+		log.Fatal("Error generando secreto JWT:", err)
+	}
+	log.Println("⚠️  JWT_SECRET no configurada - usando un secreto temporal, las cookies de sesión no sobrevivirán a un reinicio")
+}
+
+func (s *Server) accessTokenTTL() time.Duration {
+	return s.authConfig.AccessTokenTTL
+}
+
+func (s *Server) issueAccessToken(user User, sessionID primitive.ObjectID) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.accessTokenTTL())
+
+	claims := SessionClaims{
+		Role:      user.Role,
+		SessionID: sessionID.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := s.signClaims(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+func (s *Server) signClaims(claims SessionClaims) (string, error) {
+	kid, key := s.tokenKeys.current()
+	if key == nil {
+		return "", errUnknownSigningKey
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+func (s *Server) parseAccessToken(tokenString string) (*SessionClaims, error) {
+	claims := &SessionClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.verificationKey(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// sessionResponse abre una nueva sesión para el usuario y devuelve los
+// campos de token que se añaden a la respuesta de login. En modo cookie los
+// tokens se envían en la cookie de sesión en lugar del cuerpo.
+func (s *Server) sessionResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, user User) (map[string]interface{}, error) {
+	session, refreshToken, err := s.createSession(ctx, r, user)
+	if err != nil {
+		return nil, fmt.Errorf("error creando sesión: %v", err)
+	}
+	s.recordLogin(ctx, user.ID)
+	tokens, err := s.tokenResponse(user, session.ID, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.deliverTokens(w, r, tokens)
+}
+
+func (s *Server) tokenResponse(user User, sessionID primitive.ObjectID, refreshToken string) (map[string]interface{}, error) {
+	token, expiresAt, err := s.issueAccessToken(user, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error firmando token: %v", err)
+	}
+
+	return map[string]interface{}{
+		"access_token":  token,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(expiresAt).Seconds()),
+		"refresh_token": refreshToken,
+	}, nil
+}
+
+// clientIP devuelve la IP del cliente. X-Forwarded-For solo se tiene en cuenta
+// con TRUST_PROXY=true, y aun así solo las entradas de la derecha: cada proxy
+// añade al final la IP desde la que le llegó la petición, y lo que hay antes
+// lo puede haber escrito el cliente. Con TRUSTED_PROXIES=n la IP del cliente
+// es la n-ésima empezando por la derecha.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.serverConfig.TrustProxy {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if len(hops) > 0 {
+			return hops[max(len(hops)-s.serverConfig.TrustedProxies, 0)]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// requireAuth valida el token de sesión (bearer o cookie) y, si la ruta incluye {code},
+// comprueba que pertenezca al mismo usuario ("me" siempre es el del token). Los clientes servidor a servidor
+// pueden usar X-API-Key con el scope users:read o users:write.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "" {
+			scope := scopeUsersWrite
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = scopeUsersRead
+			}
+			r, ok := s.authenticateAPIKey(w, r, scope)
+			if ok {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		claims, err := s.requestClaims(w, r)
+		if err == errNoCredentials {
+			http.Error(w, "Token de acceso requerido", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+			return
+		}
+
+		if code, ok := mux.Vars(r)["code"]; ok && code != "me" {
+			owner, err := s.codeOwner(r.Context(), code)
+			if err != nil && !errors.Is(err, errUserNotFound) {
+				log.Printf("Error buscando usuario: %v", err)
+				http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+				return
+			}
+			if err != nil || owner.Hex() != claims.Subject {
+				http.Error(w, "No autorizado para este usuario", http.StatusForbidden)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		r = r.WithContext(ctx)
+		setRequestUser(r, claims.Subject)
+		if claims.Act != nil {
+			s.recordImpersonatedRequest(r, claims)
+		} else if userID, ok := sessionUserID(r); ok {
+			s.touchLastSeen(userID)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// codeOwner devuelve el ID del usuario con ese código de acceso. El token
+// no lleva el hash del código: con él se podría adivinar el código offline.
+func (s *Server) codeOwner(ctx context.Context, code string) (primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	user, err := s.userRepo.FindByCode(ctx, s.hashCode(code))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return user.ID, nil
+}
+
+func claimsFromContext(ctx context.Context) (*SessionClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*SessionClaims)
+	return claims, ok
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(trust bool, proxies int) {
+		testServer.serverConfig.TrustProxy, testServer.serverConfig.TrustedProxies = trust, proxies
+	}(testServer.serverConfig.TrustProxy, testServer.serverConfig.TrustedProxies)
+
+	cases := []struct {
+		trust     bool
+		proxies   int
+		forwarded []string
+		want      string
+	}{
+		{false, 1, []string{"1.1.1.1"}, "10.0.0.1"},
+		{true, 1, nil, "10.0.0.1"},
+		{true, 1, []string{"1.1.1.1"}, "1.1.1.1"},
+		// El cliente añade una IP falsa delante; el proxy añade la real al final.
+		{true, 1, []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{true, 2, []string{"6.6.6.6, 1.1.1.1, 10.0.0.2"}, "1.1.1.1"},
+		{true, 1, []string{"6.6.6.6", "1.1.1.1"}, "1.1.1.1"},
+		{true, 3, []string{"1.1.1.1"}, "1.1.1.1"},
+	}
+	for _, tc := range cases {
+		testServer.serverConfig.TrustProxy, testServer.serverConfig.TrustedProxies = tc.trust, tc.proxies
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:4321"
+		for _, value := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := testServer.clientIP(r); got != tc.want {
+			t.Errorf("TRUST_PROXY=%v TRUSTED_PROXIES=%d X-Forwarded-For %q: %s, se esperaba %s", tc.trust, tc.proxies, tc.forwarded, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gen2brain/webp"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errInvalidCrop = errors.New("recorte inválido: crop_x, crop_y, crop_w y crop_h deben ser enteros dentro de la imagen")
+
+// maxImagePixels limita las dimensiones de las imágenes que se decodifican:
+// un PNG o WebP de pocos KB puede declarar 50000x50000 píxeles y ocupar
+// gigas al decodificarlo. 40 Mpx cubre las fotos de cualquier móvil.
+const maxImagePixels = 40_000_000
+
+var errImageTooLarge = fmt.Errorf("la imagen supera el máximo de %d megapíxeles", maxImagePixels/1_000_000)
+
+// parseCrop lee el recorte opcional del formulario (crop_x, crop_y, crop_w,
+// crop_h, en píxeles de la imagen original). Devuelve nil si no se envió.
+func parseCrop(r *http.Request) (*image.Rectangle, error) {
+	fields := []string{"crop_x", "crop_y", "crop_w", "crop_h"}
+	values := make([]int, len(fields))
+	present := 0
+	for i, field := range fields {
+		value := r.FormValue(field)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, errInvalidCrop
+		}
+		values[i] = n
+		present++
+	}
+
+	if present == 0 {
+		return nil, nil
+	}
+	if present != len(fields) || values[2] == 0 || values[3] == 0 {
+		return nil, errInvalidCrop
+	}
+
+	crop := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+	return &crop, nil
+}
+
+// CropRequest es el recorte en las peticiones JSON, con el mismo
+// significado que los campos crop_* del formulario.
+type CropRequest struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+func (c *CropRequest) Rect() (*image.Rectangle, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.X < 0 || c.Y < 0 || c.W <= 0 || c.H <= 0 {
+		return nil, errInvalidCrop
+	}
+	crop := image.Rect(c.X, c.Y, c.X+c.W, c.Y+c.H)
+	return &crop, nil
+}
+
+// processedAvatar son las versiones que se guardan de un avatar: WebP para
+// los navegadores que lo soportan y JPEG como alternativa. WebP queda vacío
+// si no se pudo codificar.
+type processedAvatar struct {
+	WebP []byte
+	JPEG []byte
+}
+
+// processAvatar decodifica la imagen ya validada con detectImageType, le
+// aplica la orientación EXIF y el recorte, y la vuelve a codificar. Al
+// recodificar se descartan los metadatos EXIF/ICC (ubicación GPS, cámara...).
+// Antes de decodificarla se comprueban sus dimensiones (maxImagePixels).
+func processAvatar(data []byte, contentType string, crop *image.Rectangle) (processedAvatar, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return processedAvatar{}, err
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return processedAvatar{}, errImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return processedAvatar{}, err
+	}
+
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	if crop != nil {
+		if !crop.Add(img.Bounds().Min).In(img.Bounds()) {
+			return processedAvatar{}, errInvalidCrop
+		}
+		img = img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(crop.Add(img.Bounds().Min))
+	}
+
+	var avatar processedAvatar
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: 90}); err != nil {
+		return processedAvatar{}, err
+	}
+	avatar.JPEG = out.Bytes()
+
+	var webpOut bytes.Buffer
+	if err := webp.Encode(&webpOut, img, webp.Options{Quality: 80, Method: 4}); err != nil {
+		log.Printf("⚠️  No se pudo convertir el avatar a WebP, se usa JPEG: %v", err)
+	} else {
+		avatar.WebP = webpOut.Bytes()
+	}
+	return avatar, nil
+}
+
+// jpegOrientation lee la etiqueta Orientation (0x0112) del bloque EXIF de un
+// JPEG. Devuelve 1 (sin rotación) si no la encuentra.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation gira o voltea la imagen según la orientación EXIF, ya que
+// al eliminar los metadatos los visores dejarían de corregirla.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			out.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return out
+}
+
+// newAvatarOriginalKey genera la clave privada y aleatoria con la que se
+// guarda el original tal como se subió (con sus metadatos), accesible solo
+// desde la API de administración.
+func newAvatarOriginalKey(userID primitive.ObjectID, ext string) (string, error) {
+	suffix := make([]byte, 12)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return privateStoragePrefix + userID.Hex() + "-" + hex.EncodeToString(suffix) + ext, nil
+}
+
+// saveProfileImage guarda las versiones procesadas con URL pública, nombradas
+// por el hash de su contenido. El original ya debe estar en originalKey.
+func (s *Server) saveProfileImage(ctx context.Context, originalKey string, avatar processedAvatar) (ProfileImage, error) {
+	stored := ProfileImage{
+		ID:          primitive.NewObjectID(),
+		OriginalKey: originalKey,
+		CreatedAt:   time.Now(),
+	}
+
+	jpegKey := contentKey(avatar.JPEG, ".jpg")
+	if err := s.storage.Put(ctx, jpegKey, avatar.JPEG, "image/jpeg"); err != nil {
+		return ProfileImage{}, err
+	}
+	stored.URL = s.uploadURL(jpegKey)
+	stored.FallbackURL = s.uploadURL(jpegKey)
+	stored.Keys = []string{jpegKey}
+
+	if len(avatar.WebP) > 0 {
+		webpKey := contentKey(avatar.WebP, ".webp")
+		if err := s.storage.Put(ctx, webpKey, avatar.WebP, "image/webp"); err != nil {
+			return ProfileImage{}, err
+		}
+		stored.URL = s.uploadURL(webpKey)
+		stored.Keys = append(stored.Keys, webpKey)
+	}
+	return stored, nil
+}
+
+// storeUploadedImage valida, procesa y guarda una imagen subida. Si el
+// original no está ya en el almacenamiento (originalKey vacío) también lo
+// guarda. Escribe la respuesta de error y devuelve false si algo falla.
+func (s *Server) storeUploadedImage(ctx context.Context, w http.ResponseWriter, userID primitive.ObjectID, data []byte, declaredType string, crop *image.Rectangle, originalKey string) (ProfileImage, bool) {
+	contentType, ext, err := detectImageType(data, declaredType)
+	if err != nil {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_image", err.Error())
+		return ProfileImage{}, false
+	}
+
+	avatar, err := processAvatar(data, contentType, crop)
+	if err == errInvalidCrop {
+		writeJSONError(w, http.StatusBadRequest, "invalid_crop", err.Error())
+		return ProfileImage{}, false
+	}
+	if err == errImageTooLarge {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "image_too_large", err.Error())
+		return ProfileImage{}, false
+	}
+	if err != nil {
+		log.Printf("Error procesando imagen: %v", err)
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_image", "No se pudo leer la imagen")
+		return ProfileImage{}, false
+	}
+
+	if originalKey == "" {
+		originalKey, err = newAvatarOriginalKey(userID, ext)
+		if err == nil {
+			err = s.storage.Put(ctx, originalKey, data, contentType)
+		}
+		if err != nil {
+			log.Printf("Error guardando imagen: %v", err)
+			http.Error(w, "Error guardando imagen", http.StatusInternalServerError)
+			return ProfileImage{}, false
+		}
+	}
+
+	stored, err := s.saveProfileImage(ctx, originalKey, avatar)
+	if err != nil {
+		log.Printf("Error guardando imagen: %v", err)
+		http.Error(w, "Error guardando imagen", http.StatusInternalServerError)
+		return ProfileImage{}, false
+	}
+	return stored, true
+}
+
+// handleAdminAvatarOriginal devuelve la imagen original que subió el usuario
+// para su avatar, o para otra imagen de la galería con ?image=<id>.
+func (s *Server) handleAdminAvatarOriginal(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	imageID := user.AvatarImageID
+	if id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("image")); err == nil {
+		imageID = &id
+	}
+	index := -1
+	if imageID != nil {
+		index = findProfileImage(user.Images, *imageID)
+	}
+	if index < 0 || user.Images[index].OriginalKey == "" {
+		http.Error(w, "El usuario no tiene imagen original", http.StatusNotFound)
+		return
+	}
+
+	data, err := s.storage.Get(ctx, user.Images[index].OriginalKey)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "El usuario no tiene imagen original", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error leyendo imagen original: %v", err)
+		http.Error(w, "Error leyendo imagen", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(data)
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// POST /api/v1/batch ejecuta varias lecturas en una sola petición, para que
+// los paneles no tengan que hacer una ronda por cada usuario que muestran.
+// Cada subpetición pasa por el mismo router con la sesión o la API key de la
+// petición, así que se autentica y autoriza como si llegara sola, y su
+// respuesta vuelve con su propio estado en el mismo orden en que se pidió.
+// Solo se admite GET: las subpeticiones se ejecutan a la vez y unas
+// escrituras sin orden definido no tendrían sentido.
+//
+// Cada subpetición cuenta en el límite de peticiones por IP como una
+// petición más. En modo cookie la sesión se renueva, si hace falta, una sola
+// vez en la petición del batch: las subpeticiones no rotan el refresh token,
+// y ninguna Set-Cookie suya llega al cuerpo de la respuesta, donde la vería
+// JavaScript.
+
+const (
+	batchMaxRequests = 20
+	batchConcurrency = 5
+	// batchMaxBody limita cada respuesta; todas se guardan en memoria hasta
+	// que termina la última.
+	batchMaxBody = 1 << 20
+)
+
+// batchInheritedHeaders son las cabeceras de la petición que reciben las
+// subpeticiones: las credenciales y lo que usa clientIP.
+var batchInheritedHeaders = []string{"Authorization", "Cookie", "X-API-Key", "X-Forwarded-For", "User-Agent", "Accept-Language"}
+
+type BatchRequest struct {
+	Requests []BatchItem `json:"requests"`
+}
+
+// BatchItem es una subpetición. Path es relativo a la versión de la API
+// (/user/me, /user/by-username/ana?fields=name) y Headers se añaden a las
+// heredadas, por ejemplo If-None-Match.
+type BatchItem struct {
+	ID      string            `json:"id,omitempty" validate:"max=64"`
+	Method  string            `json:"method,omitempty" validate:"oneof=GET"`
+	Path    string            `json:"path" validate:"required,max=2048"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// BatchResult es la respuesta de una subpetición. Body es el JSON tal cual
+// si la respuesta lo era y un texto si no.
+type BatchResult struct {
+	ID      string      `json:"id,omitempty"`
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    interface{} `json:"body,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// batchSubrequestKey marca el contexto de las subpeticiones.
+type batchSubrequestKey struct{}
+
+func isBatchSubrequest(r *http.Request) bool {
+	return r.Context().Value(batchSubrequestKey{}) != nil
+}
+
+// batchHandler despacha las subpeticiones por el router de la versión en la
+// que se registró, con sus middlewares (cabeceras de versión, auth).
+type batchHandler struct {
+	server *Server
+	router *mux.Router
+}
+
+func (h batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if invalid := validateBatch(req); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	prefix := strings.TrimSuffix(r.URL.Path, "/batch")
+	parentID := ""
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		parentID = info.ID
+	}
+	cookies := h.server.refreshBatchCookie(w, r)
+
+	results := make([]BatchResult, len(req.Requests))
+	slots := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range req.Requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = h.run(r, cookies, prefix, parentID+"."+strconv.Itoa(i+1), item)
+		}()
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"responses": results,
+	})
+}
+
+func validateBatch(req BatchRequest) map[string]string {
+	invalid := map[string]string{}
+	switch {
+	case len(req.Requests) == 0:
+		invalid["requests"] = "es requerido"
+	case len(req.Requests) > batchMaxRequests:
+		invalid["requests"] = fmt.Sprintf("máximo %d peticiones", batchMaxRequests)
+	}
+	for i, item := range req.Requests {
+		field := fmt.Sprintf("requests[%d].", i)
+		for name, message := range validateStruct(item) {
+			invalid[field+name] = message
+		}
+		if _, ok := invalid[field+"path"]; ok {
+			continue
+		}
+		if !strings.HasPrefix(item.Path, "/") || strings.HasPrefix(item.Path, "//") {
+			invalid[field+"path"] = "debe empezar por / y ser relativa a la API"
+		} else if path, _, _ := strings.Cut(item.Path, "?"); path == "/batch" {
+			invalid[field+"path"] = "no puede ser otro batch"
+		}
+	}
+	return invalid
+}
+
+// refreshBatchCookie renueva la cookie de sesión si el access token ha
+// expirado, con la respuesta del propio batch, y devuelve la cabecera Cookie
+// que deben usar las subpeticiones. Si la cookie no se puede renovar se deja
+// como está y cada subpetición responde 401.
+func (s *Server) refreshBatchCookie(w http.ResponseWriter, r *http.Request) string {
+	header := strings.Join(r.Header.Values("Cookie"), "; ")
+	if s.sessionMode() != sessionModeCookie || bearerToken(r) != "" || r.Header.Get("X-API-Key") != "" {
+		return header
+	}
+	if _, err := r.Cookie(sessionCookieName); err != nil {
+		return header
+	}
+	if _, err := s.cookieSessionClaims(w, r); err != nil {
+		return header
+	}
+
+	var renewed *http.Cookie
+	for _, line := range w.Header().Values("Set-Cookie") {
+		if cookie, err := http.ParseSetCookie(line); err == nil && cookie.Name == sessionCookieName && cookie.MaxAge >= 0 {
+			renewed = cookie
+		}
+	}
+	if renewed == nil {
+		return header
+	}
+
+	pairs := []string{}
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == sessionCookieName {
+			cookie.Value = renewed.Value
+		}
+		pairs = append(pairs, cookie.Name+"="+cookie.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// run ejecuta una subpetición. Va en su propia goroutine, así que recupera
+// sus panics aquí: el recoverPanics de la cadena no los vería.
+func (h batchHandler) run(parent *http.Request, cookies, prefix, id string, item BatchItem) BatchResult {
+	result := BatchResult{ID: item.ID}
+
+	info := &requestInfo{ID: id}
+	ctx := context.WithValue(parent.Context(), requestInfoKey{}, info)
+	ctx = context.WithValue(ctx, batchSubrequestKey{}, true)
+	sub, err := http.NewRequestWithContext(ctx, http.MethodGet, prefix+item.Path, nil)
+	if err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = "ruta inválida"
+		return result
+	}
+	sub.RemoteAddr = parent.RemoteAddr
+	sub.Host = parent.Host
+	sub.TLS = parent.TLS
+	for _, name := range batchInheritedHeaders {
+		if values := parent.Header.Values(name); len(values) > 0 {
+			sub.Header[name] = values
+		}
+	}
+	if cookies != "" {
+		sub.Header.Set("Cookie", cookies)
+	}
+	for name, value := range item.Headers {
+		// La sesión es siempre la del batch.
+		if http.CanonicalHeaderKey(name) == "Cookie" {
+			continue
+		}
+		sub.Header.Set(name, value)
+	}
+
+	if h.server.apiRateLimiter != nil {
+		allowed, retryAfter, err := h.server.apiRateLimiter.Allow(ctx, "ip:"+h.server.clientIP(sub))
+		if err != nil {
+			requestLogger(sub).Warn("⚠️  Error consultando el límite de peticiones", "error", err)
+		} else if !allowed {
+			result.Status = http.StatusTooManyRequests
+			result.Headers = http.Header{"Retry-After": {strconv.Itoa(int(retryAfter.Seconds()) + 1)}}
+			result.Error = "Demasiadas peticiones, inténtalo más tarde"
+			return result
+		}
+	}
+
+	recorder := &batchResponseWriter{header: http.Header{"X-Request-Id": {id}}}
+	h.server.recoverPanics(h.router).ServeHTTP(recorder, sub)
+
+	result.Status = recorder.status
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	result.Headers = recorder.header
+	result.Headers.Del("X-Request-Id")
+	result.Headers.Del("Set-Cookie")
+	if recorder.truncated {
+		result.Error = "respuesta demasiado grande para un batch"
+		return result
+	}
+	result.Body = batchBody(result.Headers.Get("Content-Type"), recorder.body.Bytes())
+	return result
+}
+
+func batchBody(contentType string, body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && json.Valid(body) {
+		return json.RawMessage(bytes.TrimSpace(body))
+	}
+	return string(body)
+}
+
+var errBatchBodyTooLarge = errors.New("respuesta demasiado grande para un batch")
+
+// batchResponseWriter guarda la respuesta de una subpetición. No implementa
+// Flusher ni Hijacker, así que los eventos y el WebSocket la rechazan en vez
+// de quedarse abiertos.
+type batchResponseWriter struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (b *batchResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *batchResponseWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *batchResponseWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.body.Len()+len(p) > batchMaxBody {
+		b.truncated = true
+		return 0, errBatchBodyTooLarge
+	}
+	return b.body.Write(p)
+}
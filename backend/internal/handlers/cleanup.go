@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Limpieza de archivos huérfanos: los que quedan en el almacenamiento sin
+// que ningún usuario los referencie (borrados que fallaron, subidas firmadas
+// que nunca se confirmaron...). Se ejecuta periódicamente y con
+// `backend cleanup-uploads`.
+
+type cleanupReport struct {
+	Scanned        int
+	Orphaned       int
+	Deleted        int
+	ReclaimedBytes int64
+}
+
+// uploadsGCGrace lee UPLOADS_GC_GRACE: antigüedad mínima de un archivo para
+// borrarlo (24h por defecto), así no se tocan subidas aún en curso.
+func (s *Server) uploadsGCGrace() time.Duration {
+	return s.uploadsConfig.GC.Grace
+}
+
+func (s *Server) uploadsGCInterval() time.Duration {
+	return s.uploadsConfig.GC.Interval
+}
+
+// referencedUploadKeys reúne las claves de todos los archivos que usan los
+// usuarios, también los eliminados que aún no se han purgado.
+func (s *Server) referencedUploadKeys(ctx context.Context) (map[string]bool, error) {
+	keys := map[string]bool{}
+	err := s.userRepo.Each(ctx, UserQuery{IncludeDeleted: true}, func(user User) error {
+		for _, url := range []string{user.ImageURL, user.ImageFallbackURL} {
+			if key, ok := s.uploadKeyFromURL(url); ok {
+				keys[key] = true
+			}
+		}
+		for _, image := range user.Images {
+			for _, key := range image.Keys {
+				keys[key] = true
+			}
+			if image.OriginalKey != "" {
+				keys[image.OriginalKey] = true
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// uploadKeyInUse vuelve a consultar la base justo antes de borrar, por si el
+// archivo se empezó a usar después de reunir las referencias (una imagen por
+// contenido que otro usuario sube de nuevo).
+func (s *Server) uploadKeyInUse(ctx context.Context, key string) (bool, error) {
+	_, total, err := s.userRepo.List(ctx, UserQuery{IncludeDeleted: true, ImageKey: key, Limit: 1})
+	return total > 0, err
+}
+
+// cleanupOrphanedUploads borra los archivos sin referencias más antiguos que
+// el periodo de gracia. Con dryRun solo los cuenta.
+func (s *Server) cleanupOrphanedUploads(ctx context.Context, dryRun bool) (cleanupReport, error) {
+	var report cleanupReport
+
+	lister, ok := s.storage.(storageLister)
+	if !ok {
+		return report, fmt.Errorf("el almacenamiento %s no permite listar archivos", s.storage.Name())
+	}
+
+	referenced, err := s.referencedUploadKeys(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-s.uploadsGCGrace())
+	err = lister.List(ctx, func(object StoredObject) error {
+		report.Scanned++
+		if referenced[object.Key] || object.ModTime.IsZero() || object.ModTime.After(cutoff) {
+			return nil
+		}
+
+		inUse, err := s.uploadKeyInUse(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return nil
+		}
+
+		report.Orphaned++
+		if dryRun {
+			report.ReclaimedBytes += object.Size
+			return nil
+		}
+		if err := s.storage.Delete(ctx, object.Key); err != nil {
+			log.Printf("⚠️  No se pudo borrar el archivo huérfano %s: %v", object.Key, err)
+			return nil
+		}
+		report.Deleted++
+		report.ReclaimedBytes += object.Size
+		return nil
+	})
+	return report, err
+}
+
+// runUploadsCleanup ejecuta la limpieza cada UPLOADS_GC_INTERVAL (24h por
+// defecto). UPLOADS_GC=false la desactiva.
+func (s *Server) runUploadsCleanup() {
+	if !s.uploadsConfig.GC.Enabled {
+		return
+	}
+	if _, ok := s.storage.(storageLister); !ok {
+		return
+	}
+
+	ticker := time.NewTicker(s.uploadsGCInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		report, err := s.cleanupOrphanedUploads(ctx, false)
+		cancel()
+		if err != nil {
+			log.Printf("❌ Error limpiando archivos huérfanos: %v", err)
+		}
+		if report.Deleted > 0 {
+			log.Printf("🧹 %d archivos huérfanos borrados (%s liberados)", report.Deleted, formatBytes(report.ReclaimedBytes))
+		}
+	}
+}
+
+// CleanupUploads borra los archivos huérfanos, o solo los cuenta con dryRun,
+// e imprime el resultado. Es `backend cleanup-uploads`.
+func (s *Server) CleanupUploads(ctx context.Context, dryRun bool) error {
+	report, err := s.cleanupOrphanedUploads(ctx, dryRun)
+	fmt.Printf("Archivos revisados: %d\n", report.Scanned)
+	fmt.Printf("Archivos huérfanos: %d\n", report.Orphaned)
+	if dryRun {
+		fmt.Printf("Espacio recuperable: %s\n", formatBytes(report.ReclaimedBytes))
+	} else {
+		fmt.Printf("Archivos borrados: %d\n", report.Deleted)
+		fmt.Printf("Espacio liberado: %s\n", formatBytes(report.ReclaimedBytes))
+	}
+	return err
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/config"
+	"backend/internal/events"
+)
+
+const maxCodeAttempts = 5
+
+// loadCodes guarda la configuración de los códigos de acceso y prepara el
+// pepper y el límite de reenvíos.
+func (s *Server) loadCodes(cfg config.Codes) {
+	s.codesConfig = cfg
+	s.loadCodePepper()
+	s.loadCodeEmailLimiter()
+}
+
+// loadCodeEmailLimiter limita cuántos emails con código nuevo se envían por
+// dirección: CODE_RESEND_LIMIT (3 por defecto) cada CODE_RESEND_WINDOW (1h).
+func (s *Server) loadCodeEmailLimiter() {
+	s.codeEmailLimiter = s.newRateLimiter(s.codesConfig.ResendLimit, s.codesConfig.ResendWindow)
+}
+
+type CodeRequest struct {
+	Email string `json:"email" validate:"required,max=254"`
+}
+
+func (s *Server) codeTTL() time.Duration {
+	return s.codesConfig.TTL
+}
+
+// codeExpired trata como vigentes los códigos de usuarios creados antes de
+// que existiera la expiración (sin code_expires_at).
+func codeExpired(user User) bool {
+	return !user.CodeExpiresAt.IsZero() && time.Now().After(user.CodeExpiresAt)
+}
+
+// generateCode usa CODE_ALPHABET, que por defecto evita caracteres ambiguos
+// (ver config.DefaultCodeAlphabet).
+func (s *Server) generateCode() (string, error) {
+	alphabet := s.codesConfig.Alphabet
+	size := big.NewInt(int64(len(alphabet)))
+
+	code := make([]byte, s.codesConfig.Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func (s *Server) loadCodePepper() {
+	pepper := s.codesConfig.Pepper
+	if pepper == "" {
+		log.Println("⚠️  CODE_PEPPER no configurada (DEV_MODE) - los códigos se guardan con SHA-256 sin pepper")
+		return
+	}
+	s.codePepper = []byte(pepper)
+	log.Println("✅ CODE_PEPPER configurada correctamente")
+}
+
+// hashCode es determinista para poder buscar usuarios por código; el pepper
+// (HMAC) evita que un volcado de la base permita adivinar códigos offline.
+func (s *Server) hashCode(code string) string {
+	code = strings.TrimSpace(code)
+	if len(s.codePepper) == 0 {
+		return hashToken(code)
+	}
+	mac := hmac.New(sha256.New, s.codePepper)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// insertUserWithCode asigna un código aleatorio al usuario y lo inserta,
+// reintentando si el código ya existe. Devuelve el código en claro, que solo
+// debe usarse para enviarlo por email. El evento user.created se guarda en el
+// outbox antes de insertar, con el ID ya asignado, para no perderlo si el
+// proceso cae justo después.
+func (s *Server) insertUserWithCode(ctx context.Context, user *User) (string, error) {
+	userID := primitive.NewObjectID()
+	user.ID = userID
+	event, err := events.NewEvent(eventUserCreated, userID.Hex(), userEventData{User: user})
+	if err != nil {
+		return "", err
+	}
+	entry, err := s.stageEvent(ctx, event)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := s.generateCode()
+		if err != nil {
+			s.discardEvent(ctx, entry)
+			return "", err
+		}
+		user.CodeHash = s.hashCode(code)
+		// Create lo deja a cero si falla.
+		user.ID = userID
+
+		err = s.userRepo.Create(ctx, user)
+		if errors.Is(err, errDuplicateCode) {
+			continue
+		}
+		if err != nil {
+			s.discardEvent(ctx, entry)
+			return "", err
+		}
+		s.confirmEvent(ctx, entry)
+		return code, nil
+	}
+	s.discardEvent(ctx, entry)
+	return "", fmt.Errorf("no se pudo generar un código único tras %d intentos", maxCodeAttempts)
+}
+
+// rotateUserCode asigna un código nuevo al usuario, reinicia su expiración y
+// cierra sus sesiones, que se abrieron con el código anterior.
+func (s *Server) rotateUserCode(ctx context.Context, user User) (string, error) {
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := s.generateCode()
+		if err != nil {
+			return "", err
+		}
+
+		_, err = s.changeUser(ctx, user.ID, func(user *User) error {
+			user.CodeHash = s.hashCode(code)
+			user.CodeExpiresAt = time.Now().Add(s.codeTTL())
+			return nil
+		})
+		if errors.Is(err, errDuplicateCode) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := s.revokeUserSessions(ctx, user.ID); err != nil {
+			return "", err
+		}
+		return code, nil
+	}
+	return "", fmt.Errorf("no se pudo generar un código único tras %d intentos", maxCodeAttempts)
+}
+
+// tokenPurposeNewCode es el enlace que confirma la petición de un código
+// nuevo.
+const tokenPurposeNewCode = "new_code"
+
+func (s *Server) sendNewCodeEmail(toEmail, link string) error {
+	return s.sendTemplateEmail(toEmail, emailTemplateNewCode, map[string]interface{}{
+		"Link":       link,
+		"TTLMinutes": int(s.recoveryTTL().Minutes()),
+	}, "🔁 ENLACE PARA UN CÓDIGO NUEVO: "+link)
+}
+
+// handleRegenerateCode atiende /code/regenerate y /code/resend. Como los
+// códigos se guardan hasheados no es posible reenviar el anterior, y emitir
+// uno nuevo invalida el actual: si bastara con conocer el email, cualquiera
+// podría dejar al usuario sin acceso. Por eso solo se envía un enlace y el
+// código cambia cuando se abre (handleConfirmNewCode). La respuesta es la
+// misma si el email no está registrado.
+func (s *Server) handleRegenerateCode(w http.ResponseWriter, r *http.Request) {
+	var req CodeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	allowed, retryAfter, err := s.codeEmailLimiter.Allow(ctx, "code_email:"+strings.ToLower(req.Email))
+	if err != nil {
+		log.Printf("Error consultando límite de envíos: %v", err)
+		http.Error(w, "Error interno", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Demasiados envíos para este email, inténtalo más tarde", http.StatusTooManyRequests)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Si el email está registrado, te enviamos un enlace para generar un código nuevo. Tu código actual sigue funcionando hasta que lo abras.",
+	}
+
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
+	if errors.Is(err, errUserNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.createActionToken(ctx, user.ID, tokenPurposeNewCode, s.recoveryTTL())
+	if err != nil {
+		log.Printf("Error creando token de código nuevo: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	link := s.publicBaseURL() + apiPath("/code/resend/"+token)
+	if err := s.sendNewCodeEmail(user.Email, link); err != nil {
+		log.Printf("❌ Error enviando enlace de código nuevo: %v", err)
+		http.Error(w, "Error enviando enlace", http.StatusInternalServerError)
+		return
+	}
+
+	if s.showDevCodes() {
+		response["dev_confirm_url"] = link
+		response["dev_note"] = "Sin proveedor de email - enlace mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleConfirmNewCode emite el código nuevo y lo envía por email. A
+// diferencia de la recuperación, las sesiones abiertas se mantienen.
+func (s *Server) handleConfirmNewCode(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeNewCode, mux.Vars(r)["token"])
+	if err == errInvalidActionToken {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando enlace de código nuevo: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userRepo.FindByID(ctx, stored.UserID)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	code, err := s.rotateUserCode(ctx, user)
+	if err != nil {
+		log.Printf("Error regenerando código: %v", err)
+		http.Error(w, "Error generando código", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.sendEmail(user.Email, code); err != nil {
+		log.Printf("❌ Error enviando email: %v", err)
+	} else {
+		log.Printf("✅ Nuevo código enviado a %s", user.Email)
+	}
+
+	response := map[string]string{
+		"message": "Se generó un nuevo código. Revisa tu email para obtenerlo.",
+	}
+
+	if s.showDevCodes() {
+		response["dev_code"] = code
+		response["dev_note"] = "Sin proveedor de email - código mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Operaciones de los subcomandos de la CLI (ver main). Imprimen el
+// resultado en la salida estándar en vez de responder por HTTP.
+
+// Usuarios que crea SeedUsers, repartidos en orden.
+var seedNames = [][2]string{
+	{"Ana", "García"}, {"Luis", "Martínez"}, {"María", "López"}, {"Carlos", "Sánchez"},
+	{"Lucía", "Pérez"}, {"Javier", "Gómez"}, {"Elena", "Fernández"}, {"Pablo", "Ruiz"},
+}
+
+// SeedUsers crea count usuarios verificados seed1@example.com,
+// seed2@example.com... con la etiqueta "seed" e imprime sus códigos. Los que
+// ya existen se saltan, así que se puede repetir.
+func (s *Server) SeedUsers(ctx context.Context, count int) error {
+	created := 0
+	for i := 1; i <= count; i++ {
+		email := fmt.Sprintf("seed%d@example.com", i)
+		_, err := s.userRepo.FindByEmail(ctx, email)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errUserNotFound) {
+			return err
+		}
+
+		name := seedNames[(i-1)%len(seedNames)]
+		now := time.Now()
+		user := User{
+			Email:         email,
+			CodeExpiresAt: now.Add(s.codeTTL()),
+			Name:          name[0],
+			LastName:      name[1],
+			Verified:      true,
+			VerifiedAt:    &now,
+			Tags:          []string{"seed"},
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		code, err := s.insertUserWithCode(ctx, &user)
+		if err != nil {
+			return fmt.Errorf("creando %s: %w", email, err)
+		}
+		fmt.Printf("%s\t%s\n", email, code)
+		created++
+	}
+
+	fmt.Printf("✅ %d usuarios creados (%d ya existían)\n", created, count-created)
+	return nil
+}
+
+// CreateAdmin da el rol de administrador al usuario con ese email o, si no
+// está registrado, crea la cuenta ya verificada. El código de acceso se
+// imprime en vez de enviarse por email.
+func (s *Server) CreateAdmin(ctx context.Context, email, name, lastName string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	switch {
+	case err == nil:
+		if user.Role == roleAdmin {
+			fmt.Printf("%s ya es administrador\n", email)
+			return nil
+		}
+		previous := user.Role
+		user.Role = roleAdmin
+		if err := s.userRepo.Update(ctx, &user); err != nil {
+			return err
+		}
+		s.recordRoleGrant(ctx, user, previous)
+		fmt.Printf("✅ %s es ahora administrador\n", email)
+		return nil
+	case !errors.Is(err, errUserNotFound):
+		return err
+	}
+
+	now := time.Now()
+	user = User{
+		Email:         email,
+		CodeExpiresAt: now.Add(s.codeTTL()),
+		Name:          name,
+		LastName:      lastName,
+		Verified:      true,
+		VerifiedAt:    &now,
+		Role:          roleAdmin,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	code, err := s.insertUserWithCode(ctx, &user)
+	if err != nil {
+		return err
+	}
+	s.recordRoleGrant(ctx, user, "")
+
+	fmt.Printf("✅ Administrador %s creado (ID %s)\n", email, user.ID.Hex())
+	fmt.Printf("Código de acceso: %s\n", code)
+	return nil
+}
+
+// recordRoleGrant deja en la auditoría del usuario que recibió el rol desde
+// la línea de comandos.
+func (s *Server) recordRoleGrant(ctx context.Context, user User, previous string) {
+	_, err := s.database.Audit.InsertOne(ctx, AuditEntry{
+		UserID:    user.ID,
+		Actor:     "cli",
+		Action:    auditActionRoleGrant,
+		Changes:   []AuditChange{{Field: "role", Old: previous, New: user.Role}},
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️  Error registrando auditoría: %v", err)
+	}
+}
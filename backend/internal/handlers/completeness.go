@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// profileCompleteness calcula qué parte del perfil está rellena a partir de
+// los mismos campos que se auditan, de modo que un campo nuevo cuenta en
+// cuanto se añade a auditedProfileFields.
+func profileCompleteness(user User) (int, []string) {
+	snapshot := profileSnapshot(user)
+	missing := []string{}
+	for _, field := range auditedProfileFields {
+		if snapshot[field] == "" {
+			missing = append(missing, field)
+		}
+	}
+	filled := len(auditedProfileFields) - len(missing)
+	return filled * 100 / len(auditedProfileFields), missing
+}
+
+// handleGetCompleteness devuelve el porcentaje de perfil completado y los
+// campos que faltan, para el indicador de progreso del frontend.
+func (s *Server) handleGetCompleteness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	percentage, missing := profileCompleteness(user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"percentage": percentage,
+		"missing":    missing,
+		"total":      len(auditedProfileFields),
+	})
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	sessionModeToken  = "token"
+	sessionModeCookie = "cookie"
+
+	sessionCookieName = "userapp_session"
+)
+
+var (
+	errNoCredentials = errors.New("sin credenciales")
+	errInvalidCookie = errors.New("cookie de sesión inválida")
+)
+
+// sessionCookie es el contenido cifrado de la cookie de sesión.
+type sessionCookie struct {
+	AccessToken  string `json:"a"`
+	RefreshToken string `json:"r"`
+}
+
+// sessionMode lee SESSION_MODE: "token" (bearer en la respuesta, por defecto)
+// o "cookie" (tokens en una cookie HTTP-only que el navegador no puede leer).
+func (s *Server) sessionMode() string {
+	return s.authConfig.SessionMode
+}
+
+// loadSessionCookieKey deriva la clave AES-256 de SESSION_COOKIE_KEY o, si no
+// está configurada, del secreto JWT. Debe llamarse después de loadJWTSecret.
+func (s *Server) loadSessionCookieKey() {
+	secret := []byte(s.authConfig.SessionCookieKey)
+	if len(secret) == 0 {
+		secret = append([]byte("session_cookie:"), s.jwtSecret...)
+	}
+	sum := sha256.Sum256(secret)
+	s.sessionCookieKey = sum[:]
+}
+
+func (s *Server) sessionCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.sessionCookieKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSessionCookie cifra y autentica el contenido con AES-GCM, así que el
+// cliente no puede leerlo ni modificarlo.
+func (s *Server) sealSessionCookie(payload sessionCookie) (string, error) {
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := s.sessionCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(sessionCookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Server) openSessionCookie(value string) (sessionCookie, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return sessionCookie{}, errInvalidCookie
+	}
+
+	aead, err := s.sessionCipher()
+	if err != nil {
+		return sessionCookie{}, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return sessionCookie{}, errInvalidCookie
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(sessionCookieName))
+	if err != nil {
+		return sessionCookie{}, errInvalidCookie
+	}
+
+	var payload sessionCookie
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return sessionCookie{}, errInvalidCookie
+	}
+	return payload, nil
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, payload sessionCookie) error {
+	value, err := s.sealSessionCookie(payload)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(s.refreshTokenTTL().Seconds()),
+		HttpOnly: true,
+		Secure:   s.authConfig.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1})
+}
+
+// deliverTokens entrega los tokens según SESSION_MODE. En modo cookie se
+// guardan en la cookie y se quitan de la respuesta JSON.
+func (s *Server) deliverTokens(w http.ResponseWriter, r *http.Request, tokens map[string]interface{}) (map[string]interface{}, error) {
+	if s.sessionMode() != sessionModeCookie {
+		return tokens, nil
+	}
+
+	accessToken, _ := tokens["access_token"].(string)
+	refreshToken, _ := tokens["refresh_token"].(string)
+	if err := s.setSessionCookie(w, sessionCookie{AccessToken: accessToken, RefreshToken: refreshToken}); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token_type": "Cookie",
+		"expires_in": tokens["expires_in"],
+	}, nil
+}
+
+// cookieSessionClaims valida la cookie de sesión. Si el access token ha
+// expirado se rota el refresh token y se reemite la cookie en la misma
+// respuesta, de modo que el frontend nunca tiene que refrescar a mano. Si
+// otra petición simultánea acaba de rotarlo (errRefreshTokenSuperseded), su
+// respuesta ya trae la cookie nueva: esta se atiende con un access token
+// propio y no toca la cookie.
+func (s *Server) cookieSessionClaims(w http.ResponseWriter, r *http.Request) (*SessionClaims, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, errNoCredentials
+	}
+
+	payload, err := s.openSessionCookie(cookie.Value)
+	if err != nil {
+		clearSessionCookie(w)
+		return nil, err
+	}
+
+	claims, err := s.parseAccessToken(payload.AccessToken)
+	if err == nil || !errors.Is(err, jwt.ErrTokenExpired) {
+		return claims, err
+	}
+	// La Set-Cookie de una subpetición de un batch no llega al navegador: el
+	// batch ya ha renovado la cookie si podía.
+	if isBatchSubrequest(r) {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	session, refreshToken, err := s.rotateSession(ctx, r, payload.RefreshToken)
+	superseded := err == errRefreshTokenSuperseded
+	if err != nil && !superseded {
+		clearSessionCookie(w)
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if superseded {
+		token, _, err := s.issueAccessToken(user, session.ID)
+		if err != nil {
+			return nil, err
+		}
+		return s.parseAccessToken(token)
+	}
+
+	tokens, err := s.tokenResponse(user, session.ID, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.deliverTokens(w, r, tokens); err != nil {
+		return nil, err
+	}
+
+	log.Printf("🔄 Sesión %s renovada desde la cookie", session.ID.Hex())
+	return s.parseAccessToken(tokens["access_token"].(string))
+}
+
+// requestClaims obtiene la sesión del header Authorization o, en modo
+// cookie, de la cookie de sesión.
+func (s *Server) requestClaims(w http.ResponseWriter, r *http.Request) (*SessionClaims, error) {
+	var claims *SessionClaims
+	var err error
+	switch {
+	case bearerToken(r) != "":
+		claims, err = s.parseAccessToken(bearerToken(r))
+	case s.sessionMode() == sessionModeCookie:
+		claims, err = s.cookieSessionClaims(w, r)
+	default:
+		return nil, errNoCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := s.checkSessionActive(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// handleLogout cierra la sesión actual: revoca su refresh token y borra la cookie.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req RefreshRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && req.RefreshToken == "" {
+		if payload, err := s.openSessionCookie(cookie.Value); err == nil {
+			req.RefreshToken = payload.RefreshToken
+		}
+	}
+	clearSessionCookie(w)
+
+	if req.RefreshToken != "" {
+		_, err := s.database.Sessions.UpdateOne(ctx,
+			bson.M{"refresh_hash": hashToken(req.RefreshToken), "revoked_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+		)
+		if err != nil {
+			log.Printf("Error revocando sesión: %v", err)
+			http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Sesión cerrada correctamente",
+	})
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Una cuenta está activa mientras no tenga deactivated_at. A diferencia de
+// la baja, desactivarla no borra nada ni tiene plazo: solo impide iniciar
+// sesión y oculta el perfil (findRouteUser la ignora) hasta que se reactive,
+// por el propio usuario desde el enlace que recibe por email o por un
+// administrador.
+
+const tokenPurposeReactivate = "reactivate"
+
+func (s *Server) reactivationTTL() time.Duration {
+	return s.authConfig.ReactivationTTL
+}
+
+const accountDeactivatedMessage = "Cuenta desactivada, solicita un enlace de reactivación"
+
+// accountActive rechaza el login de una cuenta desactivada. Todos los
+// caminos de login deben comprobarlo antes de abrir la sesión.
+func accountActive(w http.ResponseWriter, user User) bool {
+	if user.DeactivatedAt == nil {
+		return true
+	}
+	http.Error(w, accountDeactivatedMessage, http.StatusForbidden)
+	return false
+}
+
+// deactivateUser marca la cuenta como desactivada y revoca sus sesiones.
+// Devuelve false si la cuenta no existe o ya estaba desactivada.
+func (s *Server) deactivateUser(ctx context.Context, userID primitive.ObjectID) (User, bool, error) {
+	now := time.Now()
+	user, err := s.changeUser(ctx, userID, func(user *User) error {
+		if user.DeactivatedAt != nil {
+			return errUserNotFound
+		}
+		user.DeactivatedAt = &now
+		return nil
+	})
+	if errors.Is(err, errUserNotFound) {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+
+	_, err = s.database.Sessions.UpdateMany(ctx,
+		bson.M{"user_id": user.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	log.Printf("💤 Cuenta desactivada: %s", user.Email)
+	return user, true, nil
+}
+
+func (s *Server) reactivateUser(ctx context.Context, userID primitive.ObjectID) (User, bool, error) {
+	user, err := s.changeUser(ctx, userID, func(user *User) error {
+		if user.DeactivatedAt == nil {
+			return errUserNotFound
+		}
+		user.DeactivatedAt = nil
+		return nil
+	})
+	if errors.Is(err, errUserNotFound) {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+
+	log.Printf("☀️  Cuenta reactivada: %s", user.Email)
+	return user, true, nil
+}
+
+// handleDeactivateUser desactiva la cuenta del usuario autenticado y cierra
+// todas sus sesiones.
+func (s *Server) handleDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	if rejectImpersonation(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	user, ok, err := s.deactivateUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error desactivando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	s.recordAccountAudit(ctx, r, user.ID, requestActor(r), auditActionDeactivate)
+	clearSessionCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Cuenta desactivada. Puedes reactivarla cuando quieras desde /api/reactivate",
+	})
+}
+
+// handleRequestReactivation envía el enlace para reactivar una cuenta
+// desactivada. Abrirlo reactiva la cuenta e inicia sesión.
+func (s *Server) handleRequestReactivation(w http.ResponseWriter, r *http.Request) {
+	var req CodeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	allowed, retryAfter, err := s.codeEmailLimiter.Allow(ctx, "reactivate:"+strings.ToLower(req.Email))
+	if err != nil {
+		log.Printf("Error consultando límite de envíos: %v", err)
+		http.Error(w, "Error interno", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Demasiados envíos para este email, inténtalo más tarde", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
+	if err == nil && user.DeactivatedAt == nil {
+		err = errUserNotFound
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "No hay ninguna cuenta desactivada con ese email", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.createActionToken(ctx, user.ID, tokenPurposeReactivate, s.reactivationTTL())
+	if err != nil {
+		log.Printf("Error creando token de reactivación: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	link := s.publicBaseURL() + apiPath("/reactivate/"+token)
+	err = s.sendTemplateEmail(user.Email, emailTemplateReactivate, map[string]interface{}{
+		"Link":       link,
+		"TTLMinutes": int(s.reactivationTTL().Minutes()),
+	}, "☀️  ENLACE DE REACTIVACIÓN: "+link)
+	if err != nil {
+		log.Printf("❌ Error enviando reactivación: %v", err)
+		http.Error(w, "Error enviando enlace", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Te enviamos un enlace para reactivar tu cuenta. Revisa tu email.",
+	}
+
+	if s.showDevCodes() {
+		response["dev_reactivate_url"] = link
+		response["dev_note"] = "Sin proveedor de email - enlace mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleConfirmReactivation(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeReactivate, mux.Vars(r)["token"])
+	if err == errInvalidActionToken {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando reactivación: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	user, ok, err := s.reactivateUser(ctx, stored.UserID)
+	if err != nil {
+		log.Printf("Error reactivando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+
+	s.recordAccountAudit(ctx, r, user.ID, "user:"+user.ID.Hex(), auditActionReactivate)
+	s.finishBrowserLogin(ctx, w, r, user)
+}
+
+// deactivateAdminTarget aplica deactivateUser o reactivateUser al usuario
+// de la ruta de administración.
+func (s *Server) deactivateAdminTarget(ctx context.Context, r *http.Request, apply func(context.Context, primitive.ObjectID) (User, bool, error)) (User, bool, error) {
+	user, err := s.findUser(ctx, s.adminUserQuery(mux.Vars(r)["id"]))
+	if errors.Is(err, errUserNotFound) {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return apply(ctx, user.ID)
+}
+
+func (s *Server) handleAdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok, err := s.deactivateAdminTarget(ctx, r, s.deactivateUser)
+	if err != nil {
+		log.Printf("Error desactivando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Cuenta activa no encontrada", http.StatusNotFound)
+		return
+	}
+
+	s.recordAccountAudit(ctx, r, user.ID, requestActor(r), auditActionDeactivate)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Cuenta desactivada correctamente",
+		"user":    user,
+	})
+}
+
+func (s *Server) handleAdminReactivateUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok, err := s.deactivateAdminTarget(ctx, r, s.reactivateUser)
+	if err != nil {
+		log.Printf("Error reactivando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Cuenta desactivada no encontrada", http.StatusNotFound)
+		return
+	}
+
+	s.recordAccountAudit(ctx, r, user.ID, requestActor(r), auditActionReactivate)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Cuenta reactivada correctamente",
+		"user":    user,
+	})
+}
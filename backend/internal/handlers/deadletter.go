@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"backend/internal/email"
+)
+
+// deadLetterPayload guarda el email ya renderizado para poder reenviarlo.
+// Solo existe mientras el email está en dead letter.
+type deadLetterPayload struct {
+	Subject     string             `bson:"subject"`
+	HTML        string             `bson:"html"`
+	Text        string             `bson:"text"`
+	Attachments []email.Attachment `bson:"attachments,omitempty"`
+}
+
+// emailMaxAttempts lee EMAIL_MAX_ATTEMPTS (3 por defecto).
+func (s *Server) emailMaxAttempts() int {
+	return s.emailConfig.MaxAttempts
+}
+
+// retryableEmailError distingue los fallos transitorios de los que se
+// repetirían igual (credenciales, mensaje rechazado).
+func retryableEmailError(err error) bool {
+	return !errors.Is(err, email.ErrAuth) && !errors.Is(err, email.ErrRejected)
+}
+
+// deliverEmail intenta el envío hasta emailMaxAttempts veces con espera
+// creciente. Si se agotan los intentos el email queda en dead letter.
+func (s *Server) deliverEmail(entryID primitive.ObjectID, email email.Message) error {
+	var err error
+	for attempt := 1; attempt <= s.emailMaxAttempts(); attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+
+		var messageID string
+		messageID, err = s.emailSender.Send(email)
+		s.recordMailAttemptCount(entryID)
+		if err == nil {
+			s.recordMailResult(entryID, mailStatusSent, messageID, nil)
+			log.Printf("✅ Email enviado exitosamente a %s", email.To)
+			return nil
+		}
+
+		log.Printf("⚠️  Intento %d de envío a %s fallido: %v", attempt, email.To, err)
+		if !retryableEmailError(err) {
+			break
+		}
+	}
+
+	s.moveToDeadLetter(entryID, email, err)
+	return err
+}
+
+func (s *Server) recordMailAttemptCount(id primitive.ObjectID) {
+	if id.IsZero() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.database.MailLog.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"attempts": 1}}); err != nil {
+		log.Printf("⚠️  Error actualizando el mail log: %v", err)
+	}
+}
+
+func (s *Server) moveToDeadLetter(id primitive.ObjectID, email email.Message, sendErr error) {
+	log.Printf("❌ Email a %s movido a dead letter: %v", email.To, sendErr)
+	if id.IsZero() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.database.MailLog.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":     mailStatusDead,
+		"error":      sendErr.Error(),
+		"updated_at": time.Now(),
+		"payload": deadLetterPayload{
+			Subject:     email.Subject,
+			HTML:        email.HTML,
+			Text:        email.Text,
+			Attachments: email.Attachments,
+		},
+	}})
+	if err != nil {
+		log.Printf("⚠️  Error actualizando el mail log: %v", err)
+	}
+}
+
+var failedEmailList = listOptions{
+	Sorts:       map[string]bool{"created_at": true, "updated_at": true},
+	DefaultSort: "-created_at",
+	Filters:     []string{"to", "template"},
+}
+
+func (s *Server) handleAdminListFailedEmails(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), failedEmailList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entries := []MailLogEntry{}
+	total, err := findList(ctx, s.database.MailLog, bson.M{"status": mailStatusDead}, list, &entries)
+	if err != nil {
+		log.Printf("Error listando emails fallidos: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, entries, len(entries), total))
+}
+
+// handleAdminRetryEmail reenvía un email en dead letter, por ejemplo tras
+// corregir la configuración del proveedor.
+func (s *Server) handleAdminRetryEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Email no encontrado", http.StatusNotFound)
+		return
+	}
+
+	if s.emailSender == nil || s.emailDryRun() {
+		http.Error(w, "No hay un proveedor de email activo", http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Se pasa a pending de forma atómica para que dos reintentos simultáneos
+	// no envíen el email dos veces.
+	var entry MailLogEntry
+	err = s.database.MailLog.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": mailStatusDead},
+		bson.M{"$set": bson.M{"status": mailStatusPending, "updated_at": time.Now()}},
+	).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Email no encontrado en dead letter", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo email fallido: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if entry.Payload == nil {
+		http.Error(w, "El email no tiene contenido guardado para reenviar", http.StatusConflict)
+		return
+	}
+
+	email := email.Message{
+		To:          entry.To,
+		Template:    entry.Template,
+		Subject:     entry.Payload.Subject,
+		HTML:        entry.Payload.HTML,
+		Text:        entry.Payload.Text,
+		Attachments: entry.Payload.Attachments,
+	}
+
+	if err := s.deliverEmail(entry.ID, email); err != nil {
+		writeJSONError(w, http.StatusBadGateway, "email_send_failed", err.Error())
+		return
+	}
+
+	// Una vez enviado ya no hace falta conservar el contenido.
+	_, err = s.database.MailLog.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{
+		"$unset": bson.M{"payload": "", "error": ""},
+	})
+	if err != nil {
+		log.Printf("⚠️  Error actualizando el mail log: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email reenviado correctamente",
+	})
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Avatares por defecto: a quien no ha subido imagen se le asigna un SVG
+// generado a partir de su ID (el color) y sus iniciales, o un identicon si
+// aún no tiene nombre. Solo se calcula al responder; image_url sigue vacío
+// en la base de datos, así has_image y la completitud del perfil no cambian.
+
+// defaultAvatarURL es la URL del avatar generado para el usuario.
+func (s *Server) defaultAvatarURL(user User) string {
+	user.avatarBaseURL = s.publicBaseURL()
+	return user.defaultAvatarURL()
+}
+
+func (u User) defaultAvatarURL() string {
+	avatarURL := u.avatarBaseURL + apiPath("/avatars/"+u.ID.Hex()+".svg")
+	if initials := userInitials(u); initials != "" {
+		avatarURL += "?initials=" + url.QueryEscape(initials)
+	}
+	return avatarURL
+}
+
+// avatarUsers anota en los usuarios que pasan por el repositorio la URL
+// pública del servidor, que MarshalJSON necesita para el avatar generado.
+// New envuelve con él el repositorio recibido.
+type avatarUsers struct {
+	UserRepository
+	baseURL string
+}
+
+func (r avatarUsers) found(user User, err error) (User, error) {
+	user.avatarBaseURL = r.baseURL
+	return user, err
+}
+
+func (r avatarUsers) Create(ctx context.Context, user *User) error {
+	user.avatarBaseURL = r.baseURL
+	return r.UserRepository.Create(ctx, user)
+}
+
+func (r avatarUsers) Update(ctx context.Context, user *User) error {
+	user.avatarBaseURL = r.baseURL
+	return r.UserRepository.Update(ctx, user)
+}
+
+func (r avatarUsers) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return r.found(r.UserRepository.FindByID(ctx, id))
+}
+
+func (r avatarUsers) FindByEmail(ctx context.Context, email string) (User, error) {
+	return r.found(r.UserRepository.FindByEmail(ctx, email))
+}
+
+func (r avatarUsers) FindByCode(ctx context.Context, codeHash string) (User, error) {
+	return r.found(r.UserRepository.FindByCode(ctx, codeHash))
+}
+
+func (r avatarUsers) FindByUsername(ctx context.Context, username string) (User, error) {
+	return r.found(r.UserRepository.FindByUsername(ctx, username))
+}
+
+func (r avatarUsers) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	return r.found(r.UserRepository.FindByIdentity(ctx, provider, subject))
+}
+
+func (r avatarUsers) Restore(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return r.found(r.UserRepository.Restore(ctx, id))
+}
+
+func (r avatarUsers) List(ctx context.Context, query UserQuery) ([]User, int64, error) {
+	users, total, err := r.UserRepository.List(ctx, query)
+	for i := range users {
+		users[i].avatarBaseURL = r.baseURL
+	}
+	return users, total, err
+}
+
+func (r avatarUsers) Each(ctx context.Context, query UserQuery, fn func(User) error) error {
+	return r.UserRepository.Each(ctx, query, func(user User) error {
+		user.avatarBaseURL = r.baseURL
+		return fn(user)
+	})
+}
+
+// CreateIndexes prepara el esquema si el repositorio lo necesita (ver
+// userIndexer).
+func (r avatarUsers) CreateIndexes(ctx context.Context, data legacyUserData) error {
+	if indexer, ok := r.UserRepository.(userIndexer); ok {
+		return indexer.CreateIndexes(ctx, data)
+	}
+	return nil
+}
+
+func userInitials(user User) string {
+	initials := ""
+	for _, name := range []string{user.Name, user.LastName} {
+		for _, r := range strings.TrimSpace(name) {
+			initials += string(unicode.ToUpper(r))
+			break
+		}
+	}
+	return sanitizeInitials(initials)
+}
+
+// sanitizeInitials deja como mucho dos letras o dígitos: el valor llega por
+// query y acaba dentro del SVG.
+func sanitizeInitials(initials string) string {
+	clean := []rune{}
+	for _, r := range initials {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			clean = append(clean, unicode.ToUpper(r))
+		}
+		if len(clean) == 2 {
+			break
+		}
+	}
+	return string(clean)
+}
+
+// MarshalJSON rellena image_url con el avatar generado cuando el usuario no
+// tiene imagen, para que los clientes nunca reciban un image_url vacío.
+func (u User) MarshalJSON() ([]byte, error) {
+	type plainUser User
+	generated := u.ImageURL == "" && !u.ID.IsZero()
+	if generated {
+		u.ImageURL = u.defaultAvatarURL()
+	}
+	return json.Marshal(struct {
+		plainUser
+		ImageGenerated bool `json:"image_generated,omitempty"`
+	}{plainUser(u), generated})
+}
+
+// handleDefaultAvatar dibuja el avatar. Depende solo de la URL, así que se
+// puede cachear indefinidamente.
+func handleDefaultAvatar(w http.ResponseWriter, r *http.Request) {
+	seed := sha256.Sum256([]byte(mux.Vars(r)["seed"]))
+	hue := (int(seed[0])<<8 | int(seed[1])) % 360
+	color := fmt.Sprintf("hsl(%d, 55%%, 50%%)", hue)
+
+	var svg strings.Builder
+	svg.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="128" height="128" viewBox="0 0 100 100">`)
+	if initials := sanitizeInitials(r.URL.Query().Get("initials")); initials != "" {
+		fmt.Fprintf(&svg, `<rect width="100" height="100" fill="%s"/>`, color)
+		fmt.Fprintf(&svg, `<text x="50" y="50" dy=".35em" text-anchor="middle" font-family="-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif" font-size="40" font-weight="600" fill="#ffffff">%s</text>`, initials)
+	} else {
+		// Identicon de 5x5 simétrico: las tres primeras columnas salen de los
+		// bits del hash y las dos últimas las reflejan.
+		svg.WriteString(`<rect width="100" height="100" fill="#f0f0f0"/>`)
+		for row := 0; row < 5; row++ {
+			for col := 0; col < 3; col++ {
+				bit := row*3 + col
+				if seed[2+bit/8]&(1<<(bit%8)) == 0 {
+					continue
+				}
+				for _, x := range []int{col, 4 - col} {
+					fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="18" height="18" fill="%s"/>`, 5+x*18, 5+row*18, color)
+					if x == 2 {
+						break
+					}
+				}
+			}
+		}
+	}
+	svg.WriteString(`</svg>`)
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Write([]byte(svg.String()))
+}
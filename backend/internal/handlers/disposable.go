@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bufio"
+	_ "embed"
+	"log"
+	"os"
+	"strings"
+
+	"backend/internal/config"
+)
+
+//go:embed disposable_domains.txt
+var embeddedDisposableDomains string
+
+func (s *Server) loadDisposableDomains(cfg config.Users) error {
+	domains, err := readDisposableDomains(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.liveMu.Lock()
+	s.disposableDomains = domains
+	s.liveMu.Unlock()
+
+	if cfg.BlockDisposableEmails {
+		log.Printf("✅ %d dominios de email desechable bloqueados", len(domains))
+	} else {
+		log.Println("⚠️  Bloqueo de emails desechables desactivado")
+	}
+	return nil
+}
+
+// readDisposableDomains usa la lista embebida o, si se define,
+// DISPOSABLE_EMAIL_DOMAINS_FILE. DISPOSABLE_EMAIL_DOMAINS añade dominios
+// separados por comas y BLOCK_DISPOSABLE_EMAILS=false desactiva el bloqueo.
+func readDisposableDomains(cfg config.Users) (map[string]bool, error) {
+	domains := map[string]bool{}
+	if !cfg.BlockDisposableEmails {
+		return domains, nil
+	}
+
+	list := embeddedDisposableDomains
+	if path := cfg.DisposableDomainsFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		list = string(data)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		addDisposableDomain(domains, scanner.Text())
+	}
+	for _, domain := range cfg.DisposableDomains {
+		addDisposableDomain(domains, domain)
+	}
+	return domains, nil
+}
+
+func addDisposableDomain(domains map[string]bool, line string) {
+	domain := strings.ToLower(strings.TrimSpace(line))
+	if domain == "" || strings.HasPrefix(domain, "#") {
+		return
+	}
+	domains[domain] = true
+}
+
+// isDisposableEmail comprueba el dominio del email y sus dominios padre,
+// para cubrir también subdominios como x.mailinator.com.
+func (s *Server) isDisposableEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	s.liveMu.RLock()
+	domains := s.disposableDomains
+	s.liveMu.RUnlock()
+
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	for domain != "" {
+		if domains[domain] {
+			return true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return false
+}
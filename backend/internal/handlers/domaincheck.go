@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"backend/internal/email"
+)
+
+const (
+	dnsStatusOK      = "ok"
+	dnsStatusMissing = "missing"
+	dnsStatusInvalid = "invalid"
+	dnsStatusError   = "error"
+)
+
+// dnsCheck es el resultado de comprobar un registro de autenticación del dominio.
+type dnsCheck struct {
+	Status  string   `json:"status"`
+	Name    string   `json:"name"`
+	Records []string `json:"records,omitempty"`
+	Detail  string   `json:"detail,omitempty"`
+}
+
+// emailDomainCheck comprueba SPF, DKIM y DMARC del dominio desde el que se
+// envían los emails. Con Resend también consulta el estado del dominio en su API.
+type emailDomainCheck struct {
+	sender   email.Sender
+	from     string
+	provider string
+	dkim     []string
+}
+
+// newEmailDomainCheck recibe el proveedor (nil si no hay), la dirección de
+// EMAIL_FROM, el nombre que se muestra del proveedor y los selectores DKIM a
+// probar. Sin selectores se usan los que configura cada proveedor.
+func newEmailDomainCheck(sender email.Sender, from, provider string, dkimSelectors []string) *emailDomainCheck {
+	if len(dkimSelectors) == 0 {
+		switch sender.(type) {
+		case *email.Resend:
+			dkimSelectors = []string{"resend"}
+		case *email.SendGrid:
+			dkimSelectors = []string{"s1", "s2"}
+		default:
+			dkimSelectors = []string{"default"}
+		}
+	}
+	return &emailDomainCheck{sender: sender, from: from, provider: provider, dkim: dkimSelectors}
+}
+
+// domain extrae el dominio de EMAIL_FROM ("Nombre <user@dominio>").
+func (h *emailDomainCheck) domain() (string, error) {
+	address, err := mail.ParseAddress(h.from)
+	if err != nil {
+		return "", err
+	}
+	at := strings.LastIndex(address.Address, "@")
+	if at < 0 {
+		return "", errors.New("EMAIL_FROM sin dominio")
+	}
+	return strings.ToLower(address.Address[at+1:]), nil
+}
+
+// lookupTXTCheck busca los registros TXT de name que empiezan por prefix.
+func lookupTXTCheck(ctx context.Context, name, prefix string) dnsCheck {
+	check := dnsCheck{Name: name}
+
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		check.Status = dnsStatusMissing
+		return check
+	}
+	if err != nil {
+		check.Status = dnsStatusError
+		check.Detail = err.Error()
+		return check
+	}
+
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(record), strings.ToLower(prefix)) {
+			check.Records = append(check.Records, record)
+		}
+	}
+
+	switch len(check.Records) {
+	case 0:
+		check.Status = dnsStatusMissing
+	case 1:
+		check.Status = dnsStatusOK
+	default:
+		// Varios registros SPF o DMARC invalidan la política entera.
+		check.Status = dnsStatusInvalid
+		check.Detail = "hay más de un registro " + prefix
+	}
+	return check
+}
+
+func (h *emailDomainCheck) checkDKIM(ctx context.Context, domain string) dnsCheck {
+	var last dnsCheck
+	for _, selector := range h.dkim {
+		last = lookupTXTCheck(ctx, selector+"._domainkey."+domain, "v=DKIM1")
+		if last.Status == dnsStatusOK {
+			return last
+		}
+	}
+	return last
+}
+
+func (h *emailDomainCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	domain, err := h.domain()
+	if err != nil {
+		http.Error(w, "EMAIL_FROM inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	spf := lookupTXTCheck(ctx, domain, "v=spf1")
+	dkim := h.checkDKIM(ctx, domain)
+	dmarc := lookupTXTCheck(ctx, "_dmarc."+domain, "v=DMARC1")
+
+	response := map[string]interface{}{
+		"domain":   domain,
+		"provider": h.provider,
+		"spf":      spf,
+		"dkim":     dkim,
+		"dmarc":    dmarc,
+		"ok":       spf.Status == dnsStatusOK && dkim.Status == dnsStatusOK && dmarc.Status == dnsStatusOK,
+	}
+
+	if sender, ok := h.sender.(*email.Resend); ok {
+		resendDomain, err := sender.Domain(ctx, domain)
+		switch {
+		case err != nil:
+			log.Printf("Error consultando dominio en Resend: %v", err)
+			response["resend"] = map[string]string{"status": dnsStatusError, "detail": err.Error()}
+		case resendDomain == nil:
+			response["resend"] = map[string]string{"status": "not_registered"}
+		default:
+			response["resend"] = resendDomain
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"backend/internal/config"
+	"backend/internal/email"
+)
+
+// loadEmailSender guarda el proveedor construido con email.New a partir de
+// EMAIL_PROVIDER (ver config.Email). Sin ninguno los emails solo se muestran
+// en consola.
+func (s *Server) loadEmailSender(cfg config.Email, sender email.Sender) {
+	s.emailConfig = cfg
+	s.emailSender = sender
+	if s.emailDryRun() {
+		log.Println("📭 EMAIL_DRY_RUN activo - los emails se registran pero no se envían")
+	}
+
+	if s.emailSender == nil {
+		log.Println("⚠️  Sin proveedor de email configurado - emails se mostrarán en consola")
+		return
+	}
+	log.Printf("✅ Proveedor de email: %s", s.emailSender.Name())
+}
+
+// emailDryRun (EMAIL_DRY_RUN=true) renderiza y registra los emails completos
+// sin llamar al proveedor, para entornos de staging.
+func (s *Server) emailDryRun() bool {
+	return s.emailConfig.DryRun
+}
+
+// EmailProviderName describe cómo se envían los emails: el proveedor,
+// "dry-run" o "consola".
+func (s *Server) EmailProviderName() string {
+	if s.emailDryRun() {
+		return "dry-run"
+	}
+	if s.emailSender == nil {
+		return "consola"
+	}
+	return s.emailSender.Name()
+}
+
+// showDevCodes indica si las respuestas incluyen los códigos y enlaces que
+// normalmente solo van por email: hace falta DEV_MODE=true y no tener
+// proveedor. Sin proveedor en producción cualquiera podría pedir el código
+// de otra cuenta, así que ahí solo se muestran en consola.
+func (s *Server) showDevCodes() bool {
+	return s.emailSender == nil && s.devMode()
+}
+
+func (s *Server) emailFrom() string {
+	return s.emailConfig.From
+}
+
+// sendMail envía un email con el proveedor configurado y lo registra en el
+// mail log. Sin proveedor solo lo muestra en consola (consoleText), para desarrollo.
+func (s *Server) sendMail(email email.Message, consoleText string) error {
+	entryID := s.recordMailAttempt(email)
+
+	if s.emailDryRun() {
+		log.Printf("📭 EMAIL_DRY_RUN - email no enviado\nPara: %s\nPlantilla: %s\nAsunto: %s\n\n%s\n%s",
+			email.To, email.Template, email.Subject, email.Text, email.HTML)
+		s.recordMailBody(entryID, email)
+		s.recordMailResult(entryID, mailStatusDryRun, "", nil)
+		return nil
+	}
+
+	if s.emailSender == nil {
+		fmt.Print("\n" + strings.Repeat("=", 60) + "\n")
+		fmt.Printf("📧 EMAIL SIMULADO (sin proveedor de email)\n")
+		fmt.Print(strings.Repeat("=", 60) + "\n")
+		fmt.Printf("Para: %s\n", email.To)
+		fmt.Printf("Asunto: %s\n", email.Subject)
+		fmt.Print(strings.Repeat("-", 60) + "\n")
+		fmt.Println(consoleText)
+		fmt.Print(strings.Repeat("=", 60) + "\n\n")
+		s.recordMailResult(entryID, mailStatusConsole, "", nil)
+		return nil
+	}
+
+	return s.deliverEmail(entryID, email)
+}
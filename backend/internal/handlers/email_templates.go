@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+
+	"backend/internal/email"
+)
+
+//go:embed templates/email/*.html templates/email/*.txt
+var embeddedEmailTemplates embed.FS
+
+const (
+	emailTemplateAccessCode  = "access_code"
+	emailTemplateMagicLink   = "magic_link"
+	emailTemplateVerifyEmail = "verify_email"
+	emailTemplateRecovery    = "recovery"
+	emailTemplateNewCode     = "new_code"
+
+	emailTemplateProfileReminder = "profile_reminder"
+	emailTemplateAccountDeleted  = "account_deleted"
+	emailTemplateReactivate      = "reactivate"
+)
+
+// loadEmailTemplates carga las plantillas embebidas. Con EMAIL_TEMPLATES_DIR
+// los archivos de ese directorio reemplazan a los embebidos del mismo nombre
+// o añaden tipos de email nuevos, sin recompilar.
+func (s *Server) loadEmailTemplates() error {
+	embedded, err := fs.Sub(embeddedEmailTemplates, "templates/email")
+	if err != nil {
+		return err
+	}
+	if err := s.registerEmailTemplates(embedded); err != nil {
+		return err
+	}
+
+	if dir := s.emailConfig.TemplatesDir; dir != "" {
+		if err := s.registerEmailTemplates(os.DirFS(dir)); err != nil {
+			return err
+		}
+		log.Printf("✅ Plantillas de email cargadas desde %s", dir)
+	}
+	return nil
+}
+
+func (s *Server) registerEmailTemplates(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		tmpl, err := htmltemplate.ParseFS(fsys, file)
+		if err != nil {
+			return fmt.Errorf("plantilla %s: %v", file, err)
+		}
+		if tmpl.Lookup("subject") == nil {
+			return fmt.Errorf("plantilla %s: falta el bloque \"subject\"", file)
+		}
+		s.emailTemplates[strings.TrimSuffix(path.Base(file), ".html")] = tmpl
+	}
+
+	textFiles, err := fs.Glob(fsys, "*.txt")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range textFiles {
+		tmpl, err := texttemplate.ParseFS(fsys, file)
+		if err != nil {
+			return fmt.Errorf("plantilla %s: %v", file, err)
+		}
+		s.emailTextTemplates[strings.TrimSuffix(path.Base(file), ".txt")] = tmpl
+	}
+	return nil
+}
+
+// renderEmail construye el email de la plantilla con los datos dados. Si la
+// plantilla no tiene versión .txt, el texto plano se obtiene del HTML.
+func (s *Server) renderEmail(name string, data interface{}) (email.Message, error) {
+	tmpl, ok := s.emailTemplates[name]
+	if !ok {
+		return email.Message{}, fmt.Errorf("plantilla de email desconocida: %s", name)
+	}
+
+	var subject bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return email.Message{}, err
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return email.Message{}, err
+	}
+
+	message := email.Message{
+		// El asunto se renderiza como HTML; se deshace el escapado para la cabecera.
+		Subject: strings.TrimSpace(html.UnescapeString(subject.String())),
+		HTML:    body.String(),
+	}
+
+	if textTmpl, ok := s.emailTextTemplates[name]; ok {
+		var text bytes.Buffer
+		if err := textTmpl.Execute(&text, data); err != nil {
+			return email.Message{}, err
+		}
+		message.Text = text.String()
+	} else {
+		message.Text = htmlToText(message.HTML)
+	}
+	return message, nil
+}
+
+var (
+	htmlHeadPattern   = regexp.MustCompile(`(?is)<head.*?</head>`)
+	htmlLinkPattern   = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlBreakPattern  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr)>`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesPattern = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// htmlToText es una conversión sencilla para plantillas sin versión .txt:
+// conserva los saltos de línea y las URLs de los enlaces.
+func htmlToText(source string) string {
+	text := htmlHeadPattern.ReplaceAllString(source, "")
+	text = htmlLinkPattern.ReplaceAllString(text, "$2: $1")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
+
+func (s *Server) sendTemplateEmail(toEmail, name string, data interface{}, consoleText string, attachments ...email.Attachment) error {
+	email, err := s.renderEmail(name, data)
+	if err != nil {
+		return fmt.Errorf("error renderizando email %s: %v", name, err)
+	}
+	email.To = toEmail
+	email.Template = name
+	email.Attachments = attachments
+	return s.sendMail(email, consoleText)
+}
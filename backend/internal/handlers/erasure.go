@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Derecho al olvido (RGPD): a diferencia de la baja, el usuario no se borra
+// sino que se anonimiza, para que los contadores (usuarios registrados,
+// sesiones, emails enviados) sigan cuadrando. Se eliminan sus datos
+// personales de users, mail_log, email_events, sessions, audit,
+// profile_versions, webhook_deliveries y outbox, y queda un justificante en
+// erasures.
+
+// Erasure es el justificante de un borrado. No contiene datos personales:
+// el email solo se guarda como hash para poder demostrar que se atendió
+// la solicitud de una dirección concreta.
+type Erasure struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	EmailHash   string             `json:"email_hash" bson:"email_hash"`
+	RequestedBy string             `json:"requested_by" bson:"requested_by"`
+	Items       map[string]int64   `json:"items" bson:"items"`
+	ErasedAt    time.Time          `json:"erased_at" bson:"erased_at"`
+}
+
+func erasedEmail(userID primitive.ObjectID) string {
+	return "erased+" + userID.Hex() + "@invalid"
+}
+
+// anonymizedUser quita del usuario todo lo que lo identifica. Se conservan
+// las fechas, los contadores y las etiquetas.
+func anonymizedUser(user User, anonymous string, now time.Time) User {
+	user.Email = anonymous
+	user.CodeHash = hashToken("erased:" + user.ID.Hex())
+	user.Name, user.LastName, user.Username = "", "", ""
+	user.Phone, user.Bio, user.Birthday, user.Website, user.Pronouns = "", "", "", "", ""
+	user.Images = nil
+	setAvatar(&user, nil)
+	user.AdminNotes = nil
+	user.Identities = nil
+	user.Passkeys = nil
+	user.Preferences = nil
+	user.DeletedAt = nil
+	user.ErasedAt = &now
+	user.UpdatedAt = now
+	return user
+}
+
+// eraseUser anonimiza al usuario y todo lo que lo identifica. requestedBy
+// indica quién lo pidió ("user" o el administrador, ver requestActor).
+func (s *Server) eraseUser(ctx context.Context, user User, requestedBy string) (Erasure, error) {
+	// A medias dejaría datos sin anonimizar: sigue aunque el cliente se vaya.
+	ctx, cancel := detach(ctx, 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	anonymous := erasedEmail(user.ID)
+	erasure := Erasure{
+		UserID:      user.ID,
+		EmailHash:   hashToken(strings.ToLower(user.Email)),
+		RequestedBy: requestedBy,
+		Items:       map[string]int64{},
+		ErasedAt:    now,
+	}
+
+	err := s.userRepo.Erase(ctx, anonymizedUser(user, anonymous, now))
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["images"] = int64(len(user.Images))
+	s.deleteProfileImageObjects(ctx, user.Images...)
+
+	s.deleteUserData(ctx, user.ID)
+	sessions, err := s.database.Sessions.UpdateMany(ctx, bson.M{"user_id": user.ID},
+		bson.M{"$set": bson.M{"ip": "", "user_agent": ""}})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["sessions"] = sessions.ModifiedCount
+
+	mails, err := s.database.MailLog.UpdateMany(ctx, bson.M{"to": user.Email}, bson.M{
+		"$set":   bson.M{"to": anonymous},
+		"$unset": bson.M{"text": "", "html": "", "payload": ""},
+	})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["mail_log"] = mails.ModifiedCount
+
+	events, err := s.database.EmailEvents.UpdateMany(ctx, bson.M{"to": user.Email},
+		bson.M{"$set": bson.M{"to.$[recipient]": anonymous}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"recipient": user.Email}},
+		}),
+	)
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["email_events"] = events.ModifiedCount
+
+	// La auditoría conserva qué campos cambiaron y cuándo, pero no los valores.
+	audit, err := s.database.Audit.UpdateMany(ctx, bson.M{"user_id": user.ID},
+		bson.M{
+			"$set":   bson.M{"changes.$[].old": nil, "changes.$[].new": nil, "ip": ""},
+			"$unset": bson.M{"details": ""},
+		})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["audit"] = audit.ModifiedCount
+
+	versions, err := s.database.ProfileVersions.DeleteMany(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["profile_versions"] = versions.DeletedCount
+
+	// Las entregas de webhooks y los eventos del outbox llevan una copia del
+	// usuario: se borran, incluidos los pendientes, que ya no deben salir.
+	// El evento user.deleted se publica después y no contiene datos.
+	deliveries, err := s.database.WebhookDeliveries.DeleteMany(ctx, bson.M{"user_id": user.ID.Hex()})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["webhook_deliveries"] = deliveries.DeletedCount
+
+	outbox, err := s.database.Outbox.DeleteMany(ctx, bson.M{"user_id": user.ID.Hex()})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Items["outbox"] = outbox.DeletedCount
+
+	result, err := s.database.Erasures.InsertOne(ctx, erasure)
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.ID = result.InsertedID.(primitive.ObjectID)
+	s.publishUserDeleted(ctx, user, "erased", primitive.NilObjectID)
+
+	log.Printf("🧽 Datos personales borrados del usuario %s", user.ID.Hex())
+	return erasure, nil
+}
+
+func writeErasure(w http.ResponseWriter, erasure Erasure) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Datos personales eliminados correctamente",
+		"receipt": erasure,
+	})
+}
+
+// handleEraseUser atiende la solicitud de derecho al olvido del propio usuario.
+func (s *Server) handleEraseUser(w http.ResponseWriter, r *http.Request) {
+	if rejectImpersonation(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	erasure, err := s.eraseUser(ctx, user, "user")
+	if err != nil {
+		log.Printf("Error anonimizando usuario: %v", err)
+		http.Error(w, "Error eliminando datos personales", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	writeErasure(w, erasure)
+}
+
+// handleAdminEraseUser permite atender solicitudes recibidas por otros
+// canales, incluso de cuentas ya dadas de baja.
+func (s *Server) handleAdminEraseUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.findUser(ctx, UserQuery{ID: userID, IncludeDeleted: true})
+	if err == nil && user.ErasedAt != nil {
+		err = errUserNotFound
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	erasure, err := s.eraseUser(ctx, user, requestActor(r))
+	if err != nil {
+		log.Printf("Error anonimizando usuario: %v", err)
+		http.Error(w, "Error eliminando datos personales", http.StatusInternalServerError)
+		return
+	}
+
+	writeErasure(w, erasure)
+}
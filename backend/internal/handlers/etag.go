@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// userETag identifica la versión del usuario por su updated_at, el mismo
+// valor con el que userRepo.Update detecta conflictos. Los campos de
+// actividad (last_seen_at, last_login_at, login_count) no cambian
+// updated_at, así que tampoco el ETag: no merece la pena volver a descargar
+// el perfil ni rechazar una edición solo porque el usuario ha hecho otra
+// petición.
+func userETag(user User) string {
+	sum := sha256.Sum256([]byte(user.ID.Hex() + ":" + strconv.FormatInt(user.UpdatedAt.UnixNano(), 10)))
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// etagMatches compara etag con una lista de If-Match o If-None-Match. La
+// comparación débil (If-None-Match) ignora el prefijo W/.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeUserNotModified envía el ETag del usuario y, si coincide con
+// If-None-Match, responde 304.
+func writeUserNotModified(w http.ResponseWriter, r *http.Request, user User) bool {
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// checkUserIfMatch responde 412 si la petición trae If-Match y el usuario ya
+// no está en esa versión, para no pisar cambios que el cliente no ha visto.
+func checkUserIfMatch(w http.ResponseWriter, r *http.Request, user User) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, userETag(user), false) {
+		return true
+	}
+	w.Header().Set("ETag", userETag(user))
+	http.Error(w, "El usuario ha cambiado desde que se leyó", http.StatusPreconditionFailed)
+	return false
+}
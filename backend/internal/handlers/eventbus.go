@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/events"
+)
+
+// Eventos de dominio: los handlers publican en el bus (EVENT_BUS, ver
+// internal/events) lo que ha pasado y los consumidores hacen el trabajo
+// secundario: webhooks salientes, el email de confirmación de baja y la
+// analítica. Los emails con códigos o enlaces de acceso se siguen enviando
+// desde el handler, porque la respuesta depende de ellos y esos secretos no
+// deben pasar por el broker. Los eventos en tiempo real de las sesiones del
+// usuario (events.go) no pasan por el bus.
+//
+// Solo `backend serve` registra consumidores y publica; los eventos de los
+// comandos de la CLI quedan en el outbox hasta que los publica el servidor.
+
+const (
+	eventUserCreated = "user.created"
+	eventUserUpdated = "user.updated"
+	eventUserDeleted = "user.deleted"
+)
+
+// userEventData es el contenido de los eventos user.*. En user.deleted, User
+// es un deletedUser.
+type userEventData struct {
+	User       interface{} `json:"user"`
+	Fields     []string    `json:"fields,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	MergedInto string      `json:"merged_into,omitempty"`
+}
+
+// deletedUser identifica una cuenta que ya no existe. Email va vacío si la
+// cuenta se anonimizó.
+type deletedUser struct {
+	ID    string `json:"id"`
+	Email string `json:"email,omitempty"`
+}
+
+// subscribeEvents registra los consumidores del bus.
+func (s *Server) subscribeEvents() error {
+	subscribers := []struct {
+		group   string
+		types   []string
+		handler events.Handler
+	}{
+		{"webhooks", webhookEvents, s.queueWebhookEvent},
+		{"email", []string{eventUserDeleted}, s.sendAccountDeletedEmail},
+		{"analytics", []string{eventUserCreated, eventUserUpdated, eventUserDeleted}, s.countEvent},
+	}
+	for _, sub := range subscribers {
+		if err := s.eventBus.Subscribe(sub.group, sub.types, sub.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishEvent guarda en el outbox un evento de una operación ya completada
+// y lo publica (ver outbox.go). Un fallo solo se registra: el evento es
+// secundario a la operación que lo origina.
+func (s *Server) publishEvent(ctx context.Context, eventType string, userID primitive.ObjectID, data interface{}) {
+	event, err := events.NewEvent(eventType, userID.Hex(), data)
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+
+	ctx, cancel := detach(ctx, 10*time.Second)
+	defer cancel()
+
+	lock := time.Duration(0)
+	if s.relayEvents {
+		lock = outboxLease
+	}
+	entry, err := s.writeOutbox(ctx, event, outboxStatusReady, lock)
+	if err != nil {
+		log.Printf("⚠️  Error guardando el evento %s en el outbox: %v", eventType, err)
+		if s.relayEvents {
+			if err := s.eventBus.Publish(ctx, event); err != nil {
+				log.Printf("⚠️  Error publicando el evento %s: %v", eventType, err)
+			}
+		}
+		return
+	}
+	if s.relayEvents {
+		s.relayOutboxEntry(ctx, entry)
+	}
+}
+
+// publishUserDeleted avisa de que una cuenta ha dejado de existir. reason es
+// "deleted" (baja), "erased" (anonimizada) o "merged" (fusionada con otra).
+func (s *Server) publishUserDeleted(ctx context.Context, user User, reason string, mergedInto primitive.ObjectID) {
+	deleted := deletedUser{ID: user.ID.Hex()}
+	if reason != "erased" {
+		deleted.Email = user.Email
+	}
+	data := userEventData{User: deleted, Reason: reason}
+	if !mergedInto.IsZero() {
+		data.MergedInto = mergedInto.Hex()
+	}
+	s.publishEvent(ctx, eventUserDeleted, user.ID, data)
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Eventos que se notifican en tiempo real al propio usuario (ver ws.go y
+// sse.go). El hub vive en memoria: con varias instancias, cada una solo
+// notifica a las conexiones que tiene abiertas y solo guarda el historial de
+// los eventos que ha publicado.
+
+const (
+	userEventProfileUpdated = "profile.updated"
+	userEventLogin          = "session.login"
+)
+
+// Un suscriptor lento no debe frenar al resto: si su buffer está lleno, los
+// eventos nuevos se descartan para él.
+const userEventBuffer = 16
+
+// userEventHistory es cuántos eventos recientes se guardan por usuario para
+// que un cliente que se reconecta reciba los que se perdió (Last-Event-ID).
+const userEventHistory = 50
+
+type UserEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+
+	// SessionID es la sesión que originó el evento; no se le notifica a
+	// ella misma.
+	SessionID string `json:"session_id,omitempty"`
+}
+
+type eventSubscriber struct {
+	sessionID string
+	events    chan UserEvent
+}
+
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[*eventSubscriber]bool
+	history     map[primitive.ObjectID][]UserEvent
+}
+
+// Subscribe devuelve los eventos del usuario para la sesión indicada y la
+// función que cancela la suscripción y cierra el canal.
+func (h *eventHub) Subscribe(userID primitive.ObjectID, sessionID string) (<-chan UserEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subscribe(userID, sessionID)
+}
+
+// Resume suscribe como Subscribe y devuelve además los eventos publicados
+// después de lastEventID, sin huecos ni duplicados con los del canal. found
+// es false si lastEventID ya no está en el historial: el cliente puede
+// haberse perdido eventos y debe volver a pedir lo que necesite.
+func (h *eventHub) Resume(userID primitive.ObjectID, sessionID, lastEventID string) (missed []UserEvent, found bool, events <-chan UserEvent, unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	history := h.history[userID]
+	for i, event := range history {
+		if event.ID != lastEventID {
+			continue
+		}
+		found = true
+		for _, event := range history[i+1:] {
+			if event.SessionID == "" || event.SessionID != sessionID {
+				missed = append(missed, event)
+			}
+		}
+		break
+	}
+
+	events, unsubscribe = h.subscribe(userID, sessionID)
+	return missed, found, events, unsubscribe
+}
+
+// subscribe requiere h.mu.
+func (h *eventHub) subscribe(userID primitive.ObjectID, sessionID string) (<-chan UserEvent, func()) {
+	subscriber := &eventSubscriber{sessionID: sessionID, events: make(chan UserEvent, userEventBuffer)}
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = map[*eventSubscriber]bool{}
+	}
+	h.subscribers[userID][subscriber] = true
+
+	var once sync.Once
+	return subscriber.events, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[userID], subscriber)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			h.mu.Unlock()
+			close(subscriber.events)
+		})
+	}
+}
+
+func (h *eventHub) Publish(userID primitive.ObjectID, event UserEvent) {
+	if event.ID == "" {
+		event.ID = primitive.NewObjectID().Hex()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	history := append(h.history[userID], event)
+	if len(history) > userEventHistory {
+		history = history[len(history)-userEventHistory:]
+	}
+	h.history[userID] = history
+
+	for subscriber := range h.subscribers[userID] {
+		if event.SessionID != "" && subscriber.sessionID == event.SessionID {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			log.Printf("⚠️  Evento %s descartado para %s: suscriptor lento", event.Type, userID.Hex())
+		}
+	}
+}
+
+// publishProfileUpdated avisa a las demás sesiones del usuario de qué campos
+// del perfil han cambiado; el cliente vuelve a pedir el perfil si le interesa.
+func (s *Server) publishProfileUpdated(r *http.Request, user User, changes []AuditChange) {
+	event := UserEvent{
+		Type: userEventProfileUpdated,
+		Data: map[string]interface{}{"fields": changedFields(changes)},
+	}
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		event.SessionID = claims.SessionID
+	}
+	s.userEvents.Publish(user.ID, event)
+}
+
+func changedFields(changes []AuditChange) []string {
+	fields := make([]string, len(changes))
+	for i, change := range changes {
+		fields[i] = change.Field
+	}
+	return fields
+}
+
+func (s *Server) publishLogin(session Session) {
+	s.userEvents.Publish(session.UserID, UserEvent{
+		Type: userEventLogin,
+		Data: map[string]interface{}{
+			"session_id": session.ID.Hex(),
+			"user_agent": session.UserAgent,
+			"ip":         session.IP,
+		},
+	})
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Filas escritas entre cada envío al cliente durante la exportación.
+const exportFlushEvery = 500
+
+// exportFields son las columnas de la exportación y lo que se escribe en cada una.
+var exportFields = map[string]func(User) interface{}{
+	"id":             func(u User) interface{} { return u.ID.Hex() },
+	"email":          func(u User) interface{} { return u.Email },
+	"name":           func(u User) interface{} { return u.Name },
+	"last_name":      func(u User) interface{} { return u.LastName },
+	"username":       func(u User) interface{} { return u.Username },
+	"image_url":      func(u User) interface{} { return u.ImageURL },
+	"phone":          func(u User) interface{} { return u.Phone },
+	"bio":            func(u User) interface{} { return u.Bio },
+	"birthday":       func(u User) interface{} { return u.Birthday },
+	"website":        func(u User) interface{} { return u.Website },
+	"pronouns":       func(u User) interface{} { return u.Pronouns },
+	"role":           func(u User) interface{} { return u.Role },
+	"tags":           func(u User) interface{} { return strings.Join(u.Tags, " ") },
+	"verified":       func(u User) interface{} { return u.Verified },
+	"created_at":     func(u User) interface{} { return exportTime(&u.CreatedAt) },
+	"updated_at":     func(u User) interface{} { return exportTime(&u.UpdatedAt) },
+	"last_login_at":  func(u User) interface{} { return exportTime(u.LastLoginAt) },
+	"login_count":    func(u User) interface{} { return u.LoginCount },
+	"last_seen_at":   func(u User) interface{} { return exportTime(u.LastSeenAt) },
+	"deactivated_at": func(u User) interface{} { return exportTime(u.DeactivatedAt) },
+	"deleted_at":     func(u User) interface{} { return exportTime(u.DeletedAt) },
+}
+
+var defaultExportFields = []string{"id", "email", "name", "last_name", "username", "verified", "created_at"}
+
+func exportTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvValue evita que las hojas de cálculo interpreten como fórmula un valor
+// introducido por el usuario (=, +, -, @).
+func csvValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	text := fmt.Sprint(value)
+	if text != "" && strings.ContainsRune("=+-@", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// handleAdminExportUsers exporta los usuarios en CSV o NDJSON (?format=),
+// con las columnas de ?fields= (separadas por comas) y los mismos filtros
+// que el listado. Se escribe a medida que el repositorio entrega los
+// usuarios (ver UserRepository.Each).
+func (s *Server) handleAdminExportUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	invalid := map[string]string{}
+	if format != "csv" && format != "ndjson" {
+		invalid["format"] = "debe ser csv o ndjson"
+	}
+
+	names := defaultExportFields
+	if value := query.Get("fields"); value != "" {
+		names = strings.Split(value, ",")
+	}
+	for _, name := range names {
+		if _, ok := exportFields[name]; !ok {
+			invalid["fields"] = "campo no exportable: " + name
+		}
+	}
+
+	// Sin timeout fijo: la exportación dura lo que tarde el cliente en
+	// leerla y se cancela si se desconecta.
+	extendDeadlines(w, 0)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	filters := r.URL.Query()
+	filters.Del("format")
+	filters.Del("fields")
+	userQuery, filterErrors, err := s.parseAdminUserQuery(ctx, filters)
+	if err != nil {
+		log.Printf("Error interpretando filtros: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	for param, message := range filterErrors {
+		invalid[param] = message
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	userQuery.Sort = "created_at"
+
+	// Las cabeceras se envían con la primera fila, así un error al empezar
+	// la consulta aún puede responderse con un 500.
+	flusher, _ := w.(http.Flusher)
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	rows := 0
+	started := false
+	start := func() {
+		started = true
+		filename := "users-" + time.Now().Format("20060102") + "." + format
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if format == "csv" {
+			csvWriter.Write(names)
+		}
+	}
+
+	err = s.userRepo.Each(ctx, userQuery, func(user User) error {
+		if !started {
+			start()
+		}
+		if format == "csv" {
+			record := make([]string, len(names))
+			for i, name := range names {
+				record[i] = csvValue(exportFields[name](user))
+			}
+			csvWriter.Write(record)
+		} else {
+			row := make(map[string]interface{}, len(names))
+			for _, name := range names {
+				row[name] = exportFields[name](user)
+			}
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			csvWriter.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && !started {
+		log.Printf("Error exportando usuarios: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if !started {
+		start()
+	}
+	csvWriter.Flush()
+
+	// Las cabeceras ya se enviaron: un error a mitad solo puede registrarse,
+	// el cliente recibe el archivo truncado.
+	if err != nil {
+		log.Printf("❌ Exportación de usuarios interrumpida tras %d filas: %v", rows, err)
+		return
+	}
+	log.Printf("📤 Exportados %d usuarios (%s)", rows, format)
+}
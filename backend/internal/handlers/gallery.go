@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProfileImage es una imagen de la galería del usuario. Una de ellas es el
+// avatar, que además se copia en image_url para los clientes que solo usan ese campo.
+type ProfileImage struct {
+	ID          primitive.ObjectID `json:"id" bson:"id"`
+	URL         string             `json:"url" bson:"url"`
+	FallbackURL string             `json:"fallback_url,omitempty" bson:"fallback_url,omitempty"`
+	OriginalKey string             `json:"-" bson:"original_key,omitempty"`
+	Keys        []string           `json:"-" bson:"keys,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type ReorderImagesRequest struct {
+	IDs []string `json:"ids"`
+}
+
+func (s *Server) maxProfileImages() int {
+	return s.usersConfig.MaxProfileImages
+}
+
+func findProfileImage(images []ProfileImage, id primitive.ObjectID) int {
+	for i, image := range images {
+		if image.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// setAvatar copia en el usuario las URLs del avatar elegido.
+func setAvatar(user *User, image *ProfileImage) {
+	if image == nil {
+		user.ImageURL, user.ImageFallbackURL, user.AvatarImageID = "", "", nil
+		return
+	}
+	id := image.ID
+	user.ImageURL, user.ImageFallbackURL, user.AvatarImageID = image.URL, image.FallbackURL, &id
+}
+
+// replaceAvatarImage pone image en el lugar del avatar actual (o al final si
+// no hay) y devuelve la galería resultante y la imagen reemplazada.
+func replaceAvatarImage(user User, image ProfileImage) ([]ProfileImage, []ProfileImage) {
+	images := append([]ProfileImage{}, user.Images...)
+	if user.AvatarImageID != nil {
+		if i := findProfileImage(images, *user.AvatarImageID); i >= 0 {
+			removed := images[i]
+			images[i] = image
+			return images, []ProfileImage{removed}
+		}
+	}
+	return append(images, image), nil
+}
+
+// deleteProfileImageObjects borra del almacenamiento los archivos de imágenes
+// ya quitadas del usuario. Se llama después de guardar el usuario, así un
+// fallo nunca deja al usuario sin imagen; como mucho queda un archivo huérfano.
+// Los archivos por contenido pueden compartirse (misma imagen subida dos
+// veces), así que solo se borran si ningún usuario los sigue usando.
+func (s *Server) deleteProfileImageObjects(ctx context.Context, images ...ProfileImage) {
+	for _, image := range images {
+		keys := append([]string{}, image.Keys...)
+		if image.OriginalKey != "" {
+			keys = append(keys, image.OriginalKey)
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, contentAddressedPrefix) {
+				inUse, err := s.uploadKeyInUse(ctx, key)
+				if err != nil || inUse {
+					continue
+				}
+			}
+			if err := s.storage.Delete(ctx, key); err != nil {
+				log.Printf("⚠️  No se pudo borrar la imagen %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// updateGallery guarda la galería y el avatar y responde con el usuario actualizado.
+func (s *Server) updateGallery(ctx context.Context, w http.ResponseWriter, user User, images []ProfileImage, avatar *ProfileImage, message string) (User, bool) {
+	user.Images = images
+	setAvatar(&user, avatar)
+	if !s.saveUser(ctx, w, &user) {
+		return User{}, false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"user":    user,
+	})
+	return user, true
+}
+
+// handleListImages lista las imágenes en el orden que eligió el usuario
+// (ver handleReorderImages), así que no acepta ?sort=.
+func (s *Server) handleListImages(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), listOptions{})
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	images := user.Images
+	if images == nil {
+		images = []ProfileImage{}
+	}
+	start, end := pageBounds(list, len(images))
+
+	response := listResponse(list, images[start:end], end-start, int64(len(images)))
+	response["avatar_image_id"] = user.AvatarImageID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAddImage añade una imagen a la galería. Con avatar=true (o si es la
+// primera) pasa a ser el avatar.
+func (s *Server) handleAddImage(w http.ResponseWriter, r *http.Request) {
+	extendDeadlines(w, s.uploadTimeout())
+	limit := s.maxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+
+	err := r.ParseMultipartForm(limit)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeUploadTooLarge(w, limit)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error parseando formulario", http.StatusBadRequest)
+		return
+	}
+
+	crop, err := parseCrop(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_crop", err.Error())
+		return
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Imagen requerida", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > limit {
+		writeUploadTooLarge(w, limit)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Error leyendo imagen", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if len(user.Images) >= s.maxProfileImages() {
+		writeJSONError(w, http.StatusConflict, "too_many_images",
+			"Has alcanzado el máximo de "+strconv.Itoa(s.maxProfileImages())+" imágenes")
+		return
+	}
+
+	image, ok := s.storeUploadedImage(ctx, w, user.ID, data, header.Header.Get("Content-Type"), crop, "")
+	if !ok {
+		return
+	}
+
+	images := append(user.Images, image)
+	avatar := &image
+	if user.AvatarImageID != nil && r.FormValue("avatar") != "true" {
+		if i := findProfileImage(images, *user.AvatarImageID); i >= 0 {
+			avatar = &images[i]
+		}
+	}
+
+	s.updateGallery(ctx, w, user, images, avatar, "Imagen añadida correctamente")
+}
+
+func (s *Server) handleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	imageID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Imagen no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	index := findProfileImage(user.Images, imageID)
+	if index < 0 {
+		http.Error(w, "Imagen no encontrada", http.StatusNotFound)
+		return
+	}
+	removed := user.Images[index]
+
+	images := append(append([]ProfileImage{}, user.Images[:index]...), user.Images[index+1:]...)
+
+	// Si se borra el avatar, pasa a serlo la primera imagen que quede.
+	var avatar *ProfileImage
+	if user.AvatarImageID != nil && *user.AvatarImageID != imageID {
+		if i := findProfileImage(images, *user.AvatarImageID); i >= 0 {
+			avatar = &images[i]
+		}
+	}
+	if avatar == nil && len(images) > 0 {
+		avatar = &images[0]
+	}
+
+	if _, ok := s.updateGallery(ctx, w, user, images, avatar, "Imagen eliminada correctamente"); ok {
+		s.deleteProfileImageObjects(ctx, removed)
+	}
+}
+
+// handleReorderImages recibe los IDs de todas las imágenes en el orden deseado.
+func (s *Server) handleReorderImages(w http.ResponseWriter, r *http.Request) {
+	var req ReorderImagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if len(req.IDs) != len(user.Images) {
+		http.Error(w, "Hay que indicar todas las imágenes de la galería", http.StatusBadRequest)
+		return
+	}
+
+	images := make([]ProfileImage, 0, len(req.IDs))
+	seen := map[primitive.ObjectID]bool{}
+	for _, hexID := range req.IDs {
+		id, err := primitive.ObjectIDFromHex(hexID)
+		index := -1
+		if err == nil && !seen[id] {
+			index = findProfileImage(user.Images, id)
+		}
+		if index < 0 {
+			http.Error(w, "Imagen no encontrada: "+hexID, http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+		images = append(images, user.Images[index])
+	}
+
+	var avatar *ProfileImage
+	if user.AvatarImageID != nil {
+		if i := findProfileImage(images, *user.AvatarImageID); i >= 0 {
+			avatar = &images[i]
+		}
+	}
+
+	s.updateGallery(ctx, w, user, images, avatar, "Galería reordenada correctamente")
+}
+
+func (s *Server) handleSetAvatarImage(w http.ResponseWriter, r *http.Request) {
+	imageID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Imagen no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	index := findProfileImage(user.Images, imageID)
+	if index < 0 {
+		http.Error(w, "Imagen no encontrada", http.StatusNotFound)
+		return
+	}
+
+	s.updateGallery(ctx, w, user, user.Images, &user.Images[index], "Avatar actualizado correctamente")
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Las etiquetas (tags) las ponen los administradores en cada usuario. Un
+// grupo es una definición con nombre sobre las etiquetas ("usuarios con
+// beta y vip"), que el listado y la exportación aceptan con ?group= y que
+// sirve de destinatario para operaciones masivas.
+
+// Máximo de etiquetas por usuario.
+const maxUserTags = 50
+
+const (
+	groupMatchAny = "any"
+	groupMatchAll = "all"
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+
+type Group struct {
+	Name        string    `json:"name" bson:"_id"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Tags        []string  `json:"tags" bson:"tags"`
+	Match       string    `json:"match" bson:"match"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// normalizeTags pasa las etiquetas a minúsculas y quita repetidas.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("etiqueta inválida: %q", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// groupQuery es la condición que cumplen los miembros del grupo.
+func groupQuery(group Group) UserQuery {
+	if group.Match == groupMatchAll {
+		return UserQuery{AllTags: group.Tags}
+	}
+	return UserQuery{AnyTags: group.Tags}
+}
+
+var errTooManyTags = fmt.Errorf("un usuario no puede tener más de %d etiquetas", maxUserTags)
+
+func (s *Server) findGroup(ctx context.Context, name string) (Group, error) {
+	var group Group
+	err := s.database.Groups.FindOne(ctx, bson.M{"_id": strings.ToLower(name)}).Decode(&group)
+	return group, err
+}
+
+// handleAdminAddTags añade etiquetas al usuario sin tocar las que ya tenía.
+func (s *Server) handleAdminAddTags(w http.ResponseWriter, r *http.Request) {
+	var req TagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(tags) == 0 {
+		http.Error(w, "Etiquetas requeridas", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUser(ctx, s.adminUserQuery(mux.Vars(r)["id"]))
+	if err == nil {
+		// El límite se comprueba al guardar: changeUser vuelve a leer las
+		// etiquetas si otra petición las cambió entretanto.
+		user, err = s.changeUser(ctx, user.ID, func(user *User) error {
+			for _, tag := range tags {
+				if !slices.Contains(user.Tags, tag) {
+					user.Tags = append(user.Tags, tag)
+				}
+			}
+			if len(user.Tags) > maxUserTags {
+				return errTooManyTags
+			}
+			return nil
+		})
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errTooManyTags) {
+		http.Error(w, fmt.Sprintf("El usuario no puede tener más de %d etiquetas", maxUserTags), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error añadiendo etiquetas: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": user.ID,
+		"tags":    user.Tags,
+	})
+}
+
+func (s *Server) handleAdminRemoveTag(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	tag := strings.ToLower(mux.Vars(r)["tag"])
+	user, err := s.findUser(ctx, s.adminUserQuery(mux.Vars(r)["id"]))
+	if err == nil {
+		user, err = s.changeUser(ctx, user.ID, func(user *User) error {
+			user.Tags = slices.DeleteFunc(user.Tags, func(t string) bool { return t == tag })
+			return nil
+		})
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error quitando etiqueta: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	tags := user.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": user.ID,
+		"tags":    tags,
+	})
+}
+
+// Sin ?sort= los grupos se listan por nombre (su _id).
+var groupList = listOptions{
+	Sorts:   map[string]bool{"created_at": true, "updated_at": true},
+	Filters: []string{"match"},
+}
+
+func (s *Server) handleAdminListGroups(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), groupList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	groups := []Group{}
+	total, err := findList(ctx, s.database.Groups, bson.M{}, list, &groups)
+	if err != nil {
+		log.Printf("Error listando grupos: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, groups, len(groups), total))
+}
+
+// handleAdminGetGroup devuelve la definición del grupo y cuántos usuarios
+// la cumplen ahora mismo.
+func (s *Server) handleAdminGetGroup(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	group, err := s.findGroup(ctx, mux.Vars(r)["name"])
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Grupo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo grupo: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	query := groupQuery(group)
+	query.Limit = 1
+	_, members, err := s.userRepo.List(ctx, query)
+	if err != nil {
+		log.Printf("Error contando miembros: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group":   group,
+		"members": members,
+	})
+}
+
+// handleAdminPutGroup crea o reemplaza un grupo. match es "any" (alguna de
+// las etiquetas, por defecto) o "all" (todas).
+func (s *Server) handleAdminPutGroup(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(mux.Vars(r)["name"])
+	if !tagPattern.MatchString(name) {
+		http.Error(w, "Nombre de grupo inválido", http.StatusBadRequest)
+		return
+	}
+
+	var group Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := normalizeTags(group.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(tags) == 0 {
+		http.Error(w, "Etiquetas requeridas", http.StatusBadRequest)
+		return
+	}
+	if group.Match == "" {
+		group.Match = groupMatchAny
+	}
+	if group.Match != groupMatchAny && group.Match != groupMatchAll {
+		http.Error(w, "match debe ser any o all", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	err = s.database.Groups.FindOneAndUpdate(ctx, bson.M{"_id": name},
+		bson.M{
+			"$set": bson.M{
+				"description": strings.TrimSpace(group.Description),
+				"tags":        tags,
+				"match":       group.Match,
+				"updated_at":  now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&group)
+	if err != nil {
+		log.Printf("Error guardando grupo: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+func (s *Server) handleAdminDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.database.Groups.DeleteOne(ctx, bson.M{"_id": strings.ToLower(mux.Vars(r)["name"])})
+	if err != nil {
+		log.Printf("Error eliminando grupo: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Grupo no encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Grupo eliminado correctamente",
+	})
+}
@@ -0,0 +1,337 @@
+package handlers
+
+//go:generate protoc -I proto --go_out=. --go_opt=module=backend --go-grpc_out=. --go-grpc_opt=module=backend userapp/v1/user.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"backend/internal/userpb"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Servicio gRPC para llamadas internas (ver proto/userapp/v1/user.proto).
+// Usa el mismo repositorio de usuarios, envío de emails y sesiones que la
+// API HTTP, y se autentica con las mismas API keys.
+
+var grpcMethodScopes = map[string]string{
+	userpb.UserService_Register_FullMethodName:   scopeUsersWrite,
+	userpb.UserService_Login_FullMethodName:      scopeUsersWrite,
+	userpb.UserService_GetUser_FullMethodName:    scopeUsersRead,
+	userpb.UserService_UpdateUser_FullMethodName: scopeUsersWrite,
+}
+
+// ServeGRPC atiende el servicio gRPC en port. Solo vuelve si no puede
+// escuchar o el servidor falla, y entonces termina el proceso.
+func (s *Server) ServeGRPC(port string) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("❌ Error abriendo el puerto gRPC: ", err)
+	}
+
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(s.logGRPC, s.authenticateGRPC))
+	userpb.RegisterUserServiceServer(server, &userService{server: s})
+
+	fmt.Printf("🛰️  gRPC iniciado en puerto %s\n", port)
+	log.Fatal(server.Serve(listener))
+}
+
+// logGRPC escribe una línea por llamada, como logRequests en HTTP. El ID se
+// toma de la metadata x-request-id si el cliente lo envía.
+func (s *Server) logGRPC(ctx context.Context, req interface{}, call *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	var received string
+	if values := metadata.ValueFromIncomingContext(ctx, "x-request-id"); len(values) > 0 {
+		received = values[0]
+	}
+	info := &requestInfo{ID: requestID(received), Route: call.FullMethod}
+	ctx = context.WithValue(ctx, requestInfoKey{}, info)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", info.ID))
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	level := slog.LevelInfo
+	if code == codes.Internal || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("request_id", info.ID),
+		slog.String("method", call.FullMethod),
+		slog.String("code", code.String()),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("ip", s.clientIP(grpcHTTPRequest(ctx))),
+	}
+	if info.UserID != "" {
+		attrs = append(attrs, slog.String("user_id", info.UserID))
+	}
+	slog.LogAttrs(ctx, level, "llamada gRPC", attrs...)
+	return resp, err
+}
+
+// authenticateGRPC exige en la metadata x-api-key una API key con el scope
+// del método.
+func (s *Server) authenticateGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	values := metadata.ValueFromIncomingContext(ctx, "x-api-key")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "API key requerida")
+	}
+
+	apiKey, err := s.findAPIKey(ctx, values[0])
+	if err == errInvalidAPIKey {
+		return nil, status.Error(codes.Unauthenticated, "API key inválida")
+	}
+	if err != nil {
+		log.Printf("Error validando API key: %v", err)
+		return nil, status.Error(codes.Internal, "Error de base de datos")
+	}
+
+	scope, ok := grpcMethodScopes[info.FullMethod]
+	if !ok || !apiKey.HasScope(scope) {
+		return nil, status.Error(codes.PermissionDenied, "La API key no tiene el permiso "+scope)
+	}
+	return handler(context.WithValue(ctx, apiKeyContextKey, apiKey), req)
+}
+
+// grpcHTTPRequest construye una petición HTTP equivalente a la llamada para
+// los helpers compartidos con la API HTTP (sesiones, auditoría), que leen de
+// ella el user agent, la IP y el actor.
+func grpcHTTPRequest(ctx context.Context) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{"user-agent", "x-forwarded-for"} {
+			if values := md.Get(key); len(values) > 0 {
+				r.Header.Set(key, values[0])
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r
+}
+
+// grpcInvalidFields es el equivalente de writeInvalidFields: InvalidArgument
+// con un BadRequest que detalla cada campo.
+func grpcInvalidFields(invalid map[string]string) error {
+	details := &errdetails.BadRequest{}
+	for field, message := range invalid {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: message,
+		})
+	}
+	st, err := status.New(codes.InvalidArgument, "Hay campos inválidos").WithDetails(details)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Hay campos inválidos")
+	}
+	return st.Err()
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	}
+	return codes.Internal
+}
+
+func grpcTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func userToProto(user User) *userpb.User {
+	return &userpb.User{
+		Id:            user.ID.Hex(),
+		Email:         user.Email,
+		Name:          user.Name,
+		LastName:      user.LastName,
+		Username:      user.Username,
+		ImageUrl:      user.ImageURL,
+		Verified:      user.Verified,
+		Tags:          user.Tags,
+		Phone:         user.Phone,
+		Bio:           user.Bio,
+		Birthday:      user.Birthday,
+		Website:       user.Website,
+		Pronouns:      user.Pronouns,
+		CreatedAt:     grpcTimestamp(&user.CreatedAt),
+		UpdatedAt:     grpcTimestamp(&user.UpdatedAt),
+		LastLoginAt:   grpcTimestamp(user.LastLoginAt),
+		DeactivatedAt: grpcTimestamp(user.DeactivatedAt),
+	}
+}
+
+type userService struct {
+	userpb.UnimplementedUserServiceServer
+	server *Server
+}
+
+func (s *userService) Register(ctx context.Context, req *userpb.RegisterRequest) (*userpb.RegisterResponse, error) {
+	if invalid := validateStruct(RegisterRequest{Email: req.Email}); len(invalid) > 0 {
+		return nil, grpcInvalidFields(invalid)
+	}
+
+	user, code, _, err := s.server.registerUser(ctx, req.Email)
+	if err == errDisposableEmail {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, errDuplicateEmail) {
+		return nil, status.Error(codes.AlreadyExists, "El email ya está registrado")
+	}
+	if err != nil {
+		log.Printf("Error registrando usuario: %v", err)
+		return nil, status.Error(codes.Internal, "Error guardando usuario")
+	}
+
+	response := &userpb.RegisterResponse{User: userToProto(user)}
+	if s.server.showDevCodes() {
+		response.DevCode = code
+	}
+	return response, nil
+}
+
+func (s *userService) Login(ctx context.Context, req *userpb.LoginRequest) (*userpb.LoginResponse, error) {
+	login := LoginRequest{Code: req.Code, Email: req.Email, OTP: req.Otp}
+	if invalid := validateStruct(login); len(invalid) > 0 {
+		return nil, grpcInvalidFields(invalid)
+	}
+
+	user, err := s.server.authenticateLogin(ctx, login)
+	var rejected *loginError
+	if errors.As(err, &rejected) {
+		if rejected.Field != "" {
+			return nil, grpcInvalidFields(map[string]string{rejected.Field: rejected.Message})
+		}
+		return nil, status.Error(grpcCode(rejected.Status), rejected.Message)
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		return nil, status.Error(codes.Internal, "Error de base de datos")
+	}
+
+	session, refreshToken, err := s.server.createSession(ctx, grpcHTTPRequest(ctx), user)
+	if err != nil {
+		log.Printf("Error creando sesión: %v", err)
+		return nil, status.Error(codes.Internal, "Error creando sesión")
+	}
+	s.server.recordLogin(ctx, user.ID)
+	token, expiresAt, err := s.server.issueAccessToken(user, session.ID)
+	if err != nil {
+		log.Printf("Error firmando token: %v", err)
+		return nil, status.Error(codes.Internal, "Error creando sesión")
+	}
+
+	return &userpb.LoginResponse{
+		User:         userToProto(user),
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(expiresAt).Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *userService) findUser(ctx context.Context, id string) (User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return User{}, grpcInvalidFields(map[string]string{"id": "ID inválido"})
+	}
+
+	user, err := s.server.userRepo.FindByID(ctx, objectID)
+	if errors.Is(err, errUserNotFound) {
+		return User{}, status.Error(codes.NotFound, "Usuario no encontrado")
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		return User{}, status.Error(codes.Internal, "Error de base de datos")
+	}
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.UserID = user.ID.Hex()
+	}
+	return user, nil
+}
+
+func (s *userService) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	user, err := s.findUser(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return userToProto(user), nil
+}
+
+func (s *userService) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	before, err := s.findUser(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Como en PATCH: solo cuentan los campos presentes y vacío los borra.
+	values := map[string]*string{}
+	for name, value := range map[string]*string{
+		"name":      req.Name,
+		"last_name": req.LastName,
+		"username":  req.Username,
+		"phone":     req.Phone,
+		"bio":       req.Bio,
+		"birthday":  req.Birthday,
+		"website":   req.Website,
+		"pronouns":  req.Pronouns,
+	} {
+		if value == nil {
+			continue
+		}
+		if *value == "" {
+			value = nil
+		}
+		values[name] = value
+	}
+
+	user := before
+	if invalid := setProfileFields(&user, values); len(invalid) > 0 {
+		return nil, grpcInvalidFields(invalid)
+	}
+	user.ProfileVersion++
+
+	err = s.server.userRepo.Update(ctx, &user)
+	switch {
+	case err == nil:
+	case errors.Is(err, errUserNotFound):
+		return nil, status.Error(codes.NotFound, "Usuario no encontrado")
+	case errors.Is(err, errUserConflict):
+		return nil, status.Error(codes.Aborted, "El usuario ha cambiado, inténtalo de nuevo")
+	case isDuplicateUsername(err):
+		return nil, status.Error(codes.AlreadyExists, "Ese nombre de usuario ya está en uso")
+	default:
+		log.Printf("Error actualizando usuario: %v", err)
+		return nil, status.Error(codes.Internal, "Error actualizando usuario")
+	}
+
+	s.server.recordProfileChange(ctx, grpcHTTPRequest(ctx), before, user)
+	return userToProto(user), nil
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// Suplantación: un administrador obtiene un access token de otro usuario
+// para reproducir un problema. El token lleva el claim "act" (RFC 8693) con
+// el administrador, no tiene refresh token ni rol, y cada petición que se
+// hace con él queda en la auditoría del usuario.
+
+type ActorClaim struct {
+	Subject string `json:"sub"`
+}
+
+func (s *Server) impersonationTTL() time.Duration {
+	return s.authConfig.ImpersonationTTL
+}
+
+type ImpersonationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// rejectImpersonation impide con un token de suplantación las acciones
+// irreversibles sobre la cuenta (baja, borrado de datos, desactivación) y
+// registrar passkeys, que darían al administrador un acceso propio.
+func rejectImpersonation(w http.ResponseWriter, r *http.Request) bool {
+	if claims, ok := claimsFromContext(r.Context()); ok && claims.Act != nil {
+		http.Error(w, "No permitido durante una suplantación", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// recordImpersonatedRequest deja constancia de cada petición hecha con un
+// token de suplantación. Se guarda la plantilla de la ruta y no la ruta
+// real, que puede contener el código de acceso del usuario.
+func (s *Server) recordImpersonatedRequest(r *http.Request, claims *SessionClaims) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		return
+	}
+
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+
+	ctx, cancel := detach(r.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.database.Audit.InsertOne(ctx, AuditEntry{
+		UserID:  userID,
+		Actor:   requestActor(r),
+		Action:  auditActionImpersonated,
+		Changes: []AuditChange{},
+		Details: map[string]string{
+			"method": r.Method,
+			"route":  path,
+		},
+		IP:        s.clientIP(r),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️  Error registrando petición suplantada: %v", err)
+	}
+}
+
+// handleAdminImpersonateUser emite un token de suplantación para el usuario.
+// El motivo es obligatorio y queda en la auditoría.
+func (s *Server) handleAdminImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	var req ImpersonationRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "Motivo requerido", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUser(ctx, s.adminUserQuery(mux.Vars(r)["id"]))
+	if err == nil && user.DeactivatedAt != nil {
+		err = errUserNotFound
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	actor := requestActor(r)
+	now := time.Now()
+	expiresAt := now.Add(s.impersonationTTL())
+	token, err := s.signClaims(SessionClaims{
+		Act: &ActorClaim{Subject: actor},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		log.Printf("Error firmando token de suplantación: %v", err)
+		http.Error(w, "Error firmando token", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = s.database.Audit.InsertOne(ctx, AuditEntry{
+		UserID:  user.ID,
+		Actor:   actor,
+		Action:  auditActionImpersonate,
+		Changes: []AuditChange{},
+		Details: map[string]string{
+			"reason":     req.Reason,
+			"expires_at": expiresAt.UTC().Format(time.RFC3339),
+		},
+		IP:        s.clientIP(r),
+		CreatedAt: now,
+	})
+	if err != nil {
+		// Sin registro no se entrega el token.
+		log.Printf("Error registrando suplantación: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🎭 %s suplanta a %s: %s", actor, user.Email, req.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  token,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(expiresAt).Seconds()),
+		"impersonating": user.ID,
+	})
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formato JSON:API (https://jsonapi.org) para los clientes que lo usan. Se
+// pide con Accept: application/vnd.api+json o se activa para todos con
+// API_FORMAT=jsonapi. Los handlers no cambian: formatJSONAPI convierte su
+// respuesta JSON al terminar.
+//
+//   - Los recursos que apiDocs declara como Response (User, Session...) van
+//     en data como objetos {type, id, attributes, relationships}; los
+//     listados, con meta.total y links.next para la página siguiente.
+//   - Los campos que referencian otro recurso (jsonAPIRelationships) pasan a
+//     relationships, y las imágenes de un usuario además a included.
+//   - Los errores, en JSON o en texto, van en errors; los de validación con
+//     source.pointer al atributo.
+//   - El resto de respuestas (mensajes, tokens) va entero en meta.
+//
+// Los cuerpos con Content-Type application/vnd.api+json se aceptan con el
+// documento {data: {type, attributes}}; los handlers reciben los atributos.
+
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// jsonAPIRelationships son los campos con el ID de otro recurso y su tipo.
+var jsonAPIRelationships = map[string]string{
+	"user_id":         "users",
+	"avatar_image_id": "profile-images",
+}
+
+// jsonAPIIncluded son los campos con una lista de recursos incrustados.
+var jsonAPIIncluded = map[string]string{
+	"images": "profile-images",
+}
+
+// jsonAPIEnvelopes son las claves con las que algunos handlers devuelven un
+// recurso junto a un mensaje ({"message": ..., "user": {...}}).
+var jsonAPIEnvelopes = map[string]string{
+	"user": "users",
+}
+
+// wantsJSONAPI indica si la respuesta debe ir en JSON:API. Con
+// API_FORMAT=jsonapi un cliente puede seguir pidiendo JSON normal con
+// Accept: application/json.
+func (s *Server) wantsJSONAPI(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, jsonAPIMediaType) {
+		return true
+	}
+	return s.apiConfig.Format == "jsonapi" && !strings.Contains(accept, "application/json")
+}
+
+// formatJSONAPI es el middleware de la etapa de formato (ver middleware.go).
+func (s *Server) formatJSONAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == jsonAPIMediaType {
+			if !unwrapJSONAPIRequest(w, r) {
+				return
+			}
+		}
+		if !s.wantsJSONAPI(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &jsonAPIWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r)
+		if writer.buffering {
+			writer.finish(r)
+		}
+	})
+}
+
+// unwrapJSONAPIRequest sustituye el cuerpo por los atributos del recurso.
+func unwrapJSONAPIRequest(w http.ResponseWriter, r *http.Request) bool {
+	var document struct {
+		Data *struct {
+			Attributes json.RawMessage `json:"attributes"`
+		} `json:"data"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, multipartOverhead)).Decode(&document)
+	if err != nil || document.Data == nil || len(document.Data.Attributes) == 0 {
+		writeJSONAPIErrors(w, http.StatusBadRequest, []map[string]interface{}{{
+			"status": "400",
+			"code":   "invalid_document",
+			"title":  "El cuerpo debe ser un documento JSON:API con data.attributes",
+		}})
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(document.Data.Attributes))
+	r.ContentLength = int64(len(document.Data.Attributes))
+	r.Header.Set("Content-Type", "application/json")
+	return true
+}
+
+// jsonAPIWriter guarda las respuestas JSON y de texto para convertirlas al
+// terminar; las demás (imágenes, CSV, eventos, WebSocket) pasan tal cual.
+type jsonAPIWriter struct {
+	http.ResponseWriter
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (j *jsonAPIWriter) WriteHeader(status int) {
+	if j.status != 0 {
+		return
+	}
+	j.status = status
+	mediaType, _, _ := mime.ParseMediaType(j.Header().Get("Content-Type"))
+	j.buffering = status != http.StatusNoContent && status != http.StatusNotModified &&
+		(mediaType == "application/json" || mediaType == "text/plain")
+	if !j.buffering {
+		j.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (j *jsonAPIWriter) Write(p []byte) (int, error) {
+	if j.status == 0 {
+		j.WriteHeader(http.StatusOK)
+	}
+	if j.buffering {
+		return j.body.Write(p)
+	}
+	return j.ResponseWriter.Write(p)
+}
+
+func (j *jsonAPIWriter) Flush() {
+	if flusher, ok := j.ResponseWriter.(http.Flusher); ok && !j.buffering {
+		flusher.Flush()
+	}
+}
+
+func (j *jsonAPIWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := j.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("la respuesta no admite Hijack")
+	}
+	return hijacker.Hijack()
+}
+
+func (j *jsonAPIWriter) Unwrap() http.ResponseWriter {
+	return j.ResponseWriter
+}
+
+func (j *jsonAPIWriter) finish(r *http.Request) {
+	header := j.Header()
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	header.Del("Content-Length")
+	header.Del("X-Content-Type-Options")
+
+	if j.status >= 400 {
+		writeJSONAPIErrors(j.ResponseWriter, j.status, jsonAPIErrors(j.status, mediaType, j.body.Bytes()))
+		return
+	}
+
+	var body interface{}
+	if mediaType != "application/json" || json.Unmarshal(j.body.Bytes(), &body) != nil {
+		// Un texto con estado correcto no tiene equivalente en JSON:API.
+		header.Set("Content-Type", mediaType)
+		j.ResponseWriter.WriteHeader(j.status)
+		j.ResponseWriter.Write(j.body.Bytes())
+		return
+	}
+
+	header.Set("Content-Type", jsonAPIMediaType)
+	j.ResponseWriter.WriteHeader(j.status)
+	json.NewEncoder(j.ResponseWriter).Encode(jsonAPIDocument(r, body))
+}
+
+// jsonAPIDocument convierte la respuesta de un handler en un documento.
+func jsonAPIDocument(r *http.Request, body interface{}) map[string]interface{} {
+	doc := apiDoc{}
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		doc = apiDocs[info.Handler]
+	}
+	object, _ := body.(map[string]interface{})
+
+	if doc.Response != nil {
+		resourceType := jsonAPITypeName(reflect.TypeOf(doc.Response))
+		if doc.List != nil && object != nil {
+			items, _ := object["items"].([]interface{})
+			var included []interface{}
+			data := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				resource, embedded := jsonAPIResource(resourceType, item)
+				data = append(data, resource)
+				included = append(included, embedded...)
+			}
+			document := map[string]interface{}{"data": data, "meta": map[string]interface{}{"total": object["total"]}}
+			if next, ok := object["next_cursor"].(string); ok {
+				query := r.URL.Query()
+				query.Del("offset")
+				query.Set("cursor", next)
+				document["links"] = map[string]interface{}{"next": r.URL.Path + "?" + query.Encode()}
+			}
+			if len(included) > 0 {
+				document["included"] = included
+			}
+			return document
+		}
+		if object != nil && object["id"] != nil {
+			return jsonAPIData(jsonAPIResource(resourceType, object))
+		}
+	}
+
+	// Un recurso junto a un mensaje: el recurso va en data y el resto en meta.
+	for key, resourceType := range jsonAPIEnvelopes {
+		nested, ok := object[key].(map[string]interface{})
+		if !ok || nested["id"] == nil {
+			continue
+		}
+		document := jsonAPIData(jsonAPIResource(resourceType, nested))
+		meta := map[string]interface{}{}
+		for name, value := range object {
+			if name != key {
+				meta[name] = value
+			}
+		}
+		if len(meta) > 0 {
+			document["meta"] = meta
+		}
+		return document
+	}
+
+	if object == nil {
+		object = map[string]interface{}{"data": body}
+	}
+	return map[string]interface{}{"meta": object}
+}
+
+func jsonAPIData(resource map[string]interface{}, included []interface{}) map[string]interface{} {
+	document := map[string]interface{}{"data": resource}
+	if len(included) > 0 {
+		document["included"] = included
+	}
+	return document
+}
+
+// jsonAPIResource convierte un objeto con id en un recurso y devuelve
+// también los recursos incrustados que pasan a included.
+func jsonAPIResource(resourceType string, value interface{}) (map[string]interface{}, []interface{}) {
+	object, _ := value.(map[string]interface{})
+	attributes := map[string]interface{}{}
+	relationships := map[string]interface{}{}
+	var included []interface{}
+
+	for name, field := range object {
+		if name == "id" {
+			continue
+		}
+		if related, ok := jsonAPIRelationships[name]; ok {
+			var data interface{}
+			if id, ok := field.(string); ok && id != "" {
+				data = map[string]interface{}{"type": related, "id": id}
+			}
+			relationships[strings.TrimSuffix(name, "_id")] = map[string]interface{}{"data": data}
+			continue
+		}
+		if related, ok := jsonAPIIncluded[name]; ok {
+			items, _ := field.([]interface{})
+			data := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				resource, _ := jsonAPIResource(related, item)
+				data = append(data, map[string]interface{}{"type": related, "id": resource["id"]})
+				included = append(included, resource)
+			}
+			relationships[name] = map[string]interface{}{"data": data}
+			continue
+		}
+		attributes[name] = field
+	}
+
+	resource := map[string]interface{}{
+		"type":       resourceType,
+		"id":         jsonAPIID(object["id"]),
+		"attributes": attributes,
+	}
+	if len(relationships) > 0 {
+		resource["relationships"] = relationships
+	}
+	return resource, included
+}
+
+// jsonAPIID devuelve el id como texto, que es lo que exige la especificación.
+func jsonAPIID(id interface{}) string {
+	switch id := id.(type) {
+	case string:
+		return id
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// jsonAPITypeName es el tipo del recurso: el nombre del struct en plural y
+// separado por guiones (ProfileImage → profile-images, APIKey → api-keys).
+func jsonAPITypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := []rune(t.Name())
+	var out strings.Builder
+	for i, c := range name {
+		if i > 0 && unicode.IsUpper(c) && (unicode.IsLower(name[i-1]) || (i+1 < len(name) && unicode.IsLower(name[i+1]))) {
+			out.WriteByte('-')
+		}
+		out.WriteRune(unicode.ToLower(c))
+	}
+	return out.String() + "s"
+}
+
+// jsonAPIErrors convierte un error de un handler: el formato de
+// writeJSONError y writeInvalidFields, o el texto de http.Error.
+func jsonAPIErrors(status int, mediaType string, body []byte) []map[string]interface{} {
+	statusText := strconv.Itoa(status)
+	var envelope struct {
+		Error   string            `json:"error"`
+		Message string            `json:"message"`
+		Fields  map[string]string `json:"fields"`
+	}
+	if mediaType != "application/json" || json.Unmarshal(body, &envelope) != nil || envelope.Error == "" {
+		detail := strings.TrimSpace(string(body))
+		if detail == "" {
+			detail = http.StatusText(status)
+		}
+		return []map[string]interface{}{{"status": statusText, "title": detail}}
+	}
+
+	if len(envelope.Fields) == 0 {
+		return []map[string]interface{}{{"status": statusText, "code": envelope.Error, "title": envelope.Message}}
+	}
+	fields := slices.Sorted(maps.Keys(envelope.Fields))
+	errs := make([]map[string]interface{}, 0, len(fields))
+	for _, field := range fields {
+		errs = append(errs, map[string]interface{}{
+			"status": statusText,
+			"code":   envelope.Error,
+			"title":  envelope.Fields[field],
+			"source": map[string]string{"pointer": "/data/attributes/" + strings.ReplaceAll(field, ".", "/")},
+		})
+	}
+	return errs
+}
+
+func writeJSONAPIErrors(w http.ResponseWriter, status int, errs []map[string]interface{}) {
+	w.Header().Set("Content-Type", jsonAPIMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": errs,
+	})
+}
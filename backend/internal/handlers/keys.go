@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cada cuánto se recargan las claves desde la base, para ver las que hayan
+// rotado otras instancias.
+const signingKeyReloadInterval = time.Minute
+
+var errUnknownSigningKey = errors.New("clave de firma desconocida")
+
+// SigningKey es una clave ES256 para firmar access tokens. Se guarda en la
+// base para que todas las instancias firmen y verifiquen con el mismo juego.
+// PrivateKey es el PKCS8 cifrado con signingKeyCipher; las claves anteriores
+// al cifrado (Encrypted false) solo se usan para verificar hasta que caducan.
+type SigningKey struct {
+	ID         string    `bson:"_id"`
+	PrivateKey []byte    `bson:"private_key"`
+	Encrypted  bool      `bson:"encrypted"`
+	CreatedAt  time.Time `bson:"created_at"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+}
+
+type keyring struct {
+	mu         sync.RWMutex
+	currentID  string
+	keys       map[string]*ecdsa.PrivateKey
+	lastReload time.Time
+}
+
+// loadSigningKeySecret deriva la clave AES-256 con la que se cifran las
+// claves de firma de SIGNING_KEY_SECRET o, si no está configurada, del
+// secreto JWT, igual que la de la cookie de sesión. Debe llamarse después de
+// loadJWTSecret.
+func (s *Server) loadSigningKeySecret() {
+	secret := []byte(s.authConfig.SigningKeySecret)
+	if len(secret) == 0 {
+		secret = append([]byte("signing_key:"), s.jwtSecret...)
+	}
+	sum := sha256.Sum256(secret)
+	s.signingKeySecret = sum[:]
+}
+
+func (s *Server) signingKeyCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.signingKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSigningKey cifra la clave con AES-GCM. El kid va como dato asociado,
+// así una clave cifrada no sirve copiada en otro documento.
+func (s *Server) sealSigningKey(kid string, der []byte) ([]byte, error) {
+	aead, err := s.signingKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, der, []byte(kid)), nil
+}
+
+func (s *Server) openSigningKey(stored SigningKey) (*ecdsa.PrivateKey, error) {
+	der := stored.PrivateKey
+	if stored.Encrypted {
+		aead, err := s.signingKeyCipher()
+		if err != nil {
+			return nil, err
+		}
+		if len(der) < aead.NonceSize() {
+			return nil, errUnknownSigningKey
+		}
+		nonce, ciphertext := der[:aead.NonceSize()], der[aead.NonceSize():]
+		if der, err = aead.Open(nil, nonce, ciphertext, []byte(stored.ID)); err != nil {
+			return nil, err
+		}
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("la clave de firma no es ECDSA")
+	}
+	return key, nil
+}
+
+// signingKeyRotation lee SIGNING_KEY_ROTATION: cada cuánto se genera una clave
+// nueva para firmar (24h por defecto).
+func (s *Server) signingKeyRotation() time.Duration {
+	return s.authConfig.SigningKeyRotation
+}
+
+func (s *Server) createSigningKeyIndexes(ctx context.Context) error {
+	_, err := s.database.SigningKeys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// newSigningKey genera y guarda una clave. Sigue publicada en el JWKS hasta
+// que expiran todos los tokens que pudo firmar.
+func (s *Server) newSigningKey(ctx context.Context) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(kid)
+
+	sealed, err := s.sealSigningKey(id, der)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = s.database.SigningKeys.InsertOne(ctx, SigningKey{
+		ID:         id,
+		PrivateKey: sealed,
+		Encrypted:  true,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.signingKeyRotation() + s.accessTokenTTL() + time.Hour),
+	})
+	if err == nil {
+		log.Printf("🔑 Nueva clave de firma %s", id)
+	}
+	return err
+}
+
+// reloadSigningKeys carga las claves vigentes y genera una nueva si la más
+// reciente ya superó el intervalo de rotación. Solo firman las claves
+// cifradas que se pueden descifrar; las demás (anteriores al cifrado, o de
+// otro SIGNING_KEY_SECRET) solo verifican.
+func (s *Server) reloadSigningKeys(ctx context.Context) error {
+	cursor, err := s.database.SigningKeys.Find(ctx,
+		bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return err
+	}
+
+	var stored []SigningKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*ecdsa.PrivateKey, len(stored))
+	var current *SigningKey
+	for i, sk := range stored {
+		key, err := s.openSigningKey(sk)
+		if err != nil {
+			log.Printf("⚠️  Clave de firma %s ilegible: %v", sk.ID, err)
+			continue
+		}
+		keys[sk.ID] = key
+		if current == nil && sk.Encrypted {
+			current = &stored[i]
+		}
+	}
+
+	if current == nil || time.Since(current.CreatedAt) >= s.signingKeyRotation() {
+		if err := s.newSigningKey(ctx); err != nil {
+			return err
+		}
+		return s.reloadSigningKeys(ctx)
+	}
+
+	s.tokenKeys.mu.Lock()
+	s.tokenKeys.currentID = current.ID
+	s.tokenKeys.keys = keys
+	s.tokenKeys.lastReload = time.Now()
+	s.tokenKeys.mu.Unlock()
+	return nil
+}
+
+// runSigningKeyRotation mantiene el juego de claves al día en segundo plano.
+func (s *Server) runSigningKeyRotation() {
+	ticker := time.NewTicker(signingKeyReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := s.reloadSigningKeys(ctx); err != nil {
+			log.Printf("❌ Error rotando claves de firma: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (k *keyring) current() (string, *ecdsa.PrivateKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.currentID, k.keys[k.currentID]
+}
+
+// verificationKey busca la clave pública de un kid. Si no se conoce puede ser
+// que otra instancia acabe de rotar, así que se recarga (como mucho cada 10s).
+func (s *Server) verificationKey(kid string) (*ecdsa.PublicKey, error) {
+	s.tokenKeys.mu.RLock()
+	key, ok := s.tokenKeys.keys[kid]
+	stale := time.Since(s.tokenKeys.lastReload) > 10*time.Second
+	s.tokenKeys.mu.RUnlock()
+	if ok {
+		return &key.PublicKey, nil
+	}
+	if !stale {
+		return nil, errUnknownSigningKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.reloadSigningKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	s.tokenKeys.mu.RLock()
+	defer s.tokenKeys.mu.RUnlock()
+	if key, ok := s.tokenKeys.keys[kid]; ok {
+		return &key.PublicKey, nil
+	}
+	return nil, errUnknownSigningKey
+}
+
+// handleJWKS publica las claves públicas vigentes para que otros servicios
+// verifiquen los access tokens sin compartir secretos.
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	s.tokenKeys.mu.RLock()
+	keys := make([]map[string]string, 0, len(s.tokenKeys.keys))
+	for kid, key := range s.tokenKeys.keys {
+		public, err := key.PublicKey.ECDH()
+		if err != nil {
+			continue
+		}
+		point := public.Bytes() // 0x04 || X || Y
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"use": "sig",
+			"alg": "ES256",
+			"kid": kid,
+			"x":   base64.RawURLEncoding.EncodeToString(point[1:33]),
+			"y":   base64.RawURLEncoding.EncodeToString(point[33:]),
+		})
+	}
+	s.tokenKeys.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keys,
+	})
+}
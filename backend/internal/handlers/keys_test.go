@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSigningKeysEncrypted(t *testing.T) {
+	ctx := context.Background()
+
+	// Una clave guardada en claro, como antes del cifrado, se sustituye por
+	// una cifrada aunque no toque rotar.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := SigningKey{ID: "legacy", PrivateKey: der, CreatedAt: time.Now().Add(time.Minute), ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := testServer.database.SigningKeys.InsertOne(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	defer testServer.database.SigningKeys.DeleteOne(ctx, bson.M{"_id": "legacy"})
+
+	if err := testServer.reloadSigningKeys(ctx); err != nil {
+		t.Fatalf("reloadSigningKeys: %v", err)
+	}
+	kid, _ := testServer.tokenKeys.current()
+	if kid == "legacy" {
+		t.Fatal("la clave en claro sigue firmando")
+	}
+	if _, err := testServer.verificationKey("legacy"); err != nil {
+		t.Errorf("la clave en claro ya no verifica sus tokens: %v", err)
+	}
+
+	var stored SigningKey
+	err = testServer.database.SigningKeys.FindOne(ctx, bson.M{"_id": kid}).Decode(&stored)
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if !stored.Encrypted {
+		t.Error("la clave nueva no está marcada como cifrada")
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(stored.PrivateKey); err == nil {
+		t.Error("la clave nueva se guardó en claro")
+	}
+}
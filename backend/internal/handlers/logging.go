@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Los log.Printf empiezan con un emoji del que main deduce el nivel en slog
+// (❌ error, ⚠️ aviso). Cada petición escribe además su línea de acceso.
+
+type requestInfoKey struct{}
+
+// requestInfo se rellena a lo largo de la petición (ruta y handler en el
+// router, usuario en requireAuth) y se escribe al terminar.
+type requestInfo struct {
+	ID      string
+	Route   string
+	Handler string
+	UserID  string
+	Code    string
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestLogger es el logger con el request_id de la petición, para que
+// los logs de un handler se puedan relacionar con su línea de acceso.
+func requestLogger(r *http.Request) *slog.Logger {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		return slog.Default().With("request_id", info.ID)
+	}
+	return slog.Default()
+}
+
+// setRequestUser anota el usuario autenticado de la petición.
+func setRequestUser(r *http.Request, userID string) {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		info.UserID = userID
+	}
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusRecorder guarda el código y los bytes de la respuesta. Flush y
+// Unwrap mantienen el streaming (exportación) a través del wrapper.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack permite las conexiones WebSocket; se registran con estado 101.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("la respuesta no admite Hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// requestID acepta el ID que envía el cliente si es razonable y si no
+// genera uno nuevo.
+func requestID(received string) string {
+	if requestIDPattern.MatchString(received) {
+		return received
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// assignRequestID asigna un ID a cada petición (o usa el X-Request-ID que
+// llega si es válido) y lo devuelve en la respuesta. Si recoverPanics ya ha
+// creado el requestInfo, lo completa.
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := requestInfoFromContext(r.Context())
+		if info == nil {
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		}
+		info.ID = requestID(r.Header.Get("X-Request-ID"))
+		w.Header().Set("X-Request-ID", info.ID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequests escribe una línea por petición con la ruta, el estado y la
+// latencia. Se registra la plantilla de la ruta y no la URL, que puede
+// llevar códigos o tokens. Va después de assignRequestID.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := requestInfoFromContext(r.Context())
+		if info == nil {
+			info = &requestInfo{}
+		}
+
+		// La línea se escribe también si el handler entra en pánico; recoverPanics,
+		// más arriba en la cadena, responde con un 500.
+		recorder := &statusRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if !completed && recorder.status == 0 {
+				recorder.status = http.StatusInternalServerError
+			}
+			s.logRequest(r, info, recorder, start)
+		}()
+		next.ServeHTTP(recorder, r)
+		completed = true
+	})
+}
+
+func (s *Server) logRequest(r *http.Request, info *requestInfo, recorder *statusRecorder, start time.Time) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	level := slog.LevelInfo
+	if recorder.status >= 500 {
+		level = slog.LevelError
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", info.ID),
+		slog.String("method", r.Method),
+		slog.String("route", info.Route),
+		slog.Int("status", recorder.status),
+		slog.Int("bytes", recorder.bytes),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("ip", s.clientIP(r)),
+	}
+	if info.UserID != "" {
+		attrs = append(attrs, slog.String("user_id", info.UserID))
+	}
+	if info.Code != "" {
+		attrs = append(attrs, slog.String("user_code", info.Code))
+	}
+	slog.LogAttrs(r.Context(), level, "petición", attrs...)
+}
+
+// recordRoute es el middleware del router que anota la plantilla de la ruta
+// y el {code} redactado: el código de acceso es una credencial, así que solo
+// se distingue "me"; el usuario queda identificado por user_id.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.Route, _ = route.GetPathTemplate()
+				info.Handler = handlerName(route.GetHandler())
+			}
+			if code, ok := mux.Vars(r)["code"]; ok {
+				info.Code = redactCode(code)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func redactCode(code string) string {
+	if code == "me" {
+		return code
+	}
+	return "[redactado]"
+}
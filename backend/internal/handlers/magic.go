@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) magicLinkTTL() time.Duration {
+	return s.authConfig.MagicLinkTTL
+}
+
+func (s *Server) sendMagicLinkEmail(toEmail, link string) error {
+	return s.sendTemplateEmail(toEmail, emailTemplateMagicLink, map[string]interface{}{
+		"Link":       link,
+		"TTLMinutes": int(s.magicLinkTTL().Minutes()),
+	}, "🔗 ENLACE DE ACCESO: "+link)
+}
+
+// handleRequestMagicLink envía un enlace de acceso. Como los demás envíos
+// por email está limitado con codeEmailLimiter, y la respuesta es la misma si
+// el email no está registrado, para no revelar qué cuentas existen.
+func (s *Server) handleRequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req CodeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	allowed, retryAfter, err := s.codeEmailLimiter.Allow(ctx, "magic:"+strings.ToLower(req.Email))
+	if err != nil {
+		log.Printf("Error consultando límite de envíos: %v", err)
+		http.Error(w, "Error interno", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Demasiados envíos para este email, inténtalo más tarde", http.StatusTooManyRequests)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Si el email está registrado, te enviamos un enlace de acceso. Revisa tu email.",
+	}
+
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
+	if errors.Is(err, errUserNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.createActionToken(ctx, user.ID, tokenPurposeMagicLogin, s.magicLinkTTL())
+	if err != nil {
+		log.Printf("Error creando magic link: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	link := s.publicBaseURL() + apiPath("/auth/magic/"+token)
+	if err := s.sendMagicLinkEmail(req.Email, link); err != nil {
+		log.Printf("❌ Error enviando magic link: %v", err)
+		http.Error(w, "Error enviando enlace", http.StatusInternalServerError)
+		return
+	}
+
+	if s.showDevCodes() {
+		response["dev_magic_url"] = link
+		response["dev_note"] = "Sin proveedor de email - enlace mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// magicLinkConfirmPage es lo que abre el enlace del email. Solo el botón
+// (un POST) consume el token: los escáneres de enlaces de los clientes de
+// correo hacen GET y lo gastarían antes que el usuario.
+const magicLinkConfirmPage = `<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Iniciar sesión</title></head>
+<body>
+<form method="post">
+<p>Pulsa el botón para iniciar sesión.</p>
+<button type="submit">Iniciar sesión</button>
+</form>
+</body>
+</html>
+`
+
+func handleMagicLinkPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write([]byte(magicLinkConfirmPage))
+}
+
+func (s *Server) handleMagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeMagicLogin, mux.Vars(r)["token"])
+	if err == errInvalidActionToken {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando magic link: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userRepo.FindByID(ctx, stored.UserID)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	// Recibir el enlace en el buzón también demuestra que el email es suyo.
+	if !user.Verified {
+		if err := s.markEmailVerified(ctx, user.ID); err != nil {
+			log.Printf("Error verificando usuario: %v", err)
+			http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+			return
+		}
+		user.Verified = true
+	}
+
+	s.finishBrowserLogin(ctx, w, r, user)
+}
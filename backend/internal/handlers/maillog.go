@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"backend/internal/email"
+)
+
+const (
+	mailStatusPending = "pending"
+	mailStatusSent    = "sent"
+	mailStatusDead    = "dead_letter"
+	mailStatusConsole = "console"
+	mailStatusDryRun  = "dry_run"
+)
+
+// MailLogEntry registra un email saliente. El contenido solo se guarda en
+// modo EMAIL_DRY_RUN, porque puede incluir códigos de acceso.
+type MailLogEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	To        string             `json:"to" bson:"to"`
+	Template  string             `json:"template" bson:"template"`
+	Subject   string             `json:"subject" bson:"subject"`
+	Provider  string             `json:"provider" bson:"provider"`
+	MessageID string             `json:"message_id,omitempty" bson:"message_id,omitempty"`
+	Status    string             `json:"status" bson:"status"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	Text      string             `json:"text,omitempty" bson:"text,omitempty"`
+	HTML      string             `json:"html,omitempty" bson:"html,omitempty"`
+	Payload   *deadLetterPayload `json:"-" bson:"payload,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	SentAt    *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+func (s *Server) createMailLogIndexes(ctx context.Context) error {
+	_, err := s.database.MailLog.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "to", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "message_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// recordMailAttempt guarda el email como pendiente antes de enviarlo. Un
+// fallo del log no impide el envío.
+func (s *Server) recordMailAttempt(email email.Message) primitive.ObjectID {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := s.database.MailLog.InsertOne(ctx, MailLogEntry{
+		To:        email.To,
+		Template:  email.Template,
+		Subject:   email.Subject,
+		Provider:  s.EmailProviderName(),
+		Status:    mailStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		log.Printf("⚠️  Error registrando email en el mail log: %v", err)
+		return primitive.NilObjectID
+	}
+	return result.InsertedID.(primitive.ObjectID)
+}
+
+func (s *Server) recordMailResult(id primitive.ObjectID, status, messageID string, sendErr error) {
+	if id.IsZero() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"status": status, "updated_at": now}
+	if messageID != "" {
+		set["message_id"] = messageID
+	}
+	if sendErr != nil {
+		set["error"] = sendErr.Error()
+	} else {
+		set["sent_at"] = now
+	}
+
+	if _, err := s.database.MailLog.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set}); err != nil {
+		log.Printf("⚠️  Error actualizando el mail log: %v", err)
+	}
+}
+
+func (s *Server) recordMailBody(id primitive.ObjectID, email email.Message) {
+	if id.IsZero() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.database.MailLog.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"text": email.Text, "html": email.HTML},
+	})
+	if err != nil {
+		log.Printf("⚠️  Error actualizando el mail log: %v", err)
+	}
+}
+
+var mailLogList = listOptions{
+	Sorts:       map[string]bool{"created_at": true, "updated_at": true},
+	DefaultSort: "-created_at",
+	Filters:     []string{"to", "template", "status"},
+}
+
+// handleAdminMailLog lista los emails salientes, filtrando por ?to=,
+// ?template= y ?status=.
+func (s *Server) handleAdminMailLog(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), mailLogList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entries := []MailLogEntry{}
+	total, err := findList(ctx, s.database.MailLog, bson.M{}, list, &entries)
+	if err != nil {
+		log.Printf("Error listando mail log: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, entries, len(entries), total))
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"backend/internal/config"
+	"backend/internal/events"
+	"backend/internal/store"
+)
+
+// testServer es el servidor completo en modo demo (DB_DRIVER=memory), sin
+// MongoDB ni proveedor de email, igual que al arrancar con `backend serve`.
+// DEV_MODE=true hace que las respuestas incluyan los códigos de los emails.
+var testServer *Server
+
+func TestMain(m *testing.M) {
+	uploads, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, name := range []string{"MONGODB_URI", "EMAIL_PROVIDER", "RESEND_API_KEY", "SENDGRID_API_KEY", "SMTP_HOST", "DATABASE_URL", "EVENT_BUS"} {
+		os.Unsetenv(name)
+	}
+	os.Setenv("DB_DRIVER", "memory")
+	os.Setenv("DEV_MODE", "true")
+	os.Setenv("UPLOADS_DIR", uploads)
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("❌ Configuración inválida: ", err)
+	}
+	testServer, err = New(cfg, store.NewMemory(), NewMemoryUserRepository(), nil, events.NewMemory())
+	if err != nil {
+		log.Fatal("❌ ", err)
+	}
+	if err := testServer.CreateIndexes(); err != nil {
+		log.Fatal("Error creando índices:", err)
+	}
+	if err := testServer.reloadSigningKeys(context.Background()); err != nil {
+		log.Fatal("Error cargando claves de firma:", err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(uploads)
+	os.Exit(code)
+}
+
+// doRequest envía la petición al servidor de pruebas y decodifica la
+// respuesta JSON en out si no es nil.
+func doRequest(t *testing.T, method, path, body, token string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+
+	if out != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: respuesta inválida %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+// register da de alta email y devuelve el código y el enlace de
+// verificación que el modo desarrollo incluye en la respuesta.
+func register(t *testing.T, email string) (code, verifyPath string) {
+	t.Helper()
+	var registered map[string]string
+	if status := doRequest(t, "POST", "/api/v1/register", `{"email":"`+email+`"}`, "", &registered); status != http.StatusOK {
+		t.Fatalf("registro de %s: estado %d", email, status)
+	}
+	link, err := url.Parse(registered["dev_verify_url"])
+	if err != nil || registered["dev_code"] == "" {
+		t.Fatalf("el registro no devolvió código ni enlace: %v", registered)
+	}
+	return registered["dev_code"], link.Path
+}
+
+func TestRegisterVerifyLogin(t *testing.T) {
+	code, verifyPath := register(t, "ana@example.com")
+	login := `{"code":"` + code + `"}`
+
+	if status := doRequest(t, "POST", "/api/v1/login", login, "", nil); status != http.StatusForbidden {
+		t.Errorf("login sin verificar: estado %d, se esperaba %d", status, http.StatusForbidden)
+	}
+
+	if status := doRequest(t, "GET", verifyPath, "", "", nil); status != http.StatusOK {
+		t.Fatalf("verificación: estado %d", status)
+	}
+	if status := doRequest(t, "GET", verifyPath, "", "", nil); status != http.StatusBadRequest {
+		t.Errorf("segunda verificación con el mismo enlace: estado %d, se esperaba %d", status, http.StatusBadRequest)
+	}
+
+	var session struct {
+		AccessToken string `json:"access_token"`
+		User        User   `json:"user"`
+	}
+	if status := doRequest(t, "POST", "/api/v1/login", login, "", &session); status != http.StatusOK {
+		t.Fatalf("login: estado %d", status)
+	}
+	if session.AccessToken == "" || session.User.Email != "ana@example.com" {
+		t.Fatalf("login devolvió token %q y usuario %q", session.AccessToken, session.User.Email)
+	}
+
+	var profile User
+	if status := doRequest(t, "GET", "/api/v1/user/"+code, "", session.AccessToken, &profile); status != http.StatusOK {
+		t.Fatalf("perfil: estado %d", status)
+	}
+	if !profile.Verified || profile.LoginCount != 1 {
+		t.Errorf("perfil verificado %v con %d logins, se esperaba verificado con 1", profile.Verified, profile.LoginCount)
+	}
+}
+
+func TestRegisterDuplicateEmail(t *testing.T) {
+	register(t, "luis@example.com")
+	if status := doRequest(t, "POST", "/api/v1/register", `{"email":"luis@example.com"}`, "", nil); status != http.StatusBadRequest {
+		t.Errorf("registro repetido: estado %d, se esperaba %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestLoginInvalidCode(t *testing.T) {
+	if status := doRequest(t, "POST", "/api/v1/login", `{"code":"NOEXISTE"}`, "", nil); status != http.StatusUnauthorized {
+		t.Errorf("login con código desconocido: estado %d, se esperaba %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRecoveryUnknownEmail(t *testing.T) {
+	register(t, "marta@example.com")
+
+	var known, unknown map[string]string
+	if status := doRequest(t, "POST", "/api/v1/recover", `{"email":"marta@example.com"}`, "", &known); status != http.StatusOK {
+		t.Fatalf("recuperación de una cuenta existente: estado %d", status)
+	}
+	if status := doRequest(t, "POST", "/api/v1/recover", `{"email":"nadie@example.com"}`, "", &unknown); status != http.StatusOK {
+		t.Fatalf("recuperación de un email no registrado: estado %d, se esperaba %d", status, http.StatusOK)
+	}
+	if known["message"] != unknown["message"] || unknown["dev_recover_url"] != "" {
+		t.Errorf("las respuestas distinguen si el email está registrado: %v y %v", known, unknown)
+	}
+}
+
+func TestMagicLinkNeedsPost(t *testing.T) {
+	_, verifyPath := register(t, "sofia@example.com")
+	doRequest(t, "GET", verifyPath, "", "", nil)
+
+	var requested map[string]string
+	if status := doRequest(t, "POST", "/api/v1/login/magic", `{"email":"sofia@example.com"}`, "", &requested); status != http.StatusOK {
+		t.Fatalf("pedir enlace: estado %d", status)
+	}
+	link, err := url.Parse(requested["dev_magic_url"])
+	if err != nil || link.Path == "" {
+		t.Fatalf("la respuesta no incluye el enlace: %v", requested)
+	}
+
+	var unknown map[string]string
+	if status := doRequest(t, "POST", "/api/v1/login/magic", `{"email":"nadie@example.com"}`, "", &unknown); status != http.StatusOK || unknown["message"] != requested["message"] {
+		t.Errorf("enlace para un email no registrado: estado %d, %v", status, unknown)
+	}
+
+	// Abrir el enlace (lo que haría un escáner de correo) no lo consume.
+	for range 2 {
+		if status := doRequest(t, "GET", link.Path, "", "", nil); status != http.StatusOK {
+			t.Fatalf("abrir el enlace: estado %d", status)
+		}
+	}
+
+	var session struct {
+		AccessToken string `json:"access_token"`
+	}
+	if status := doRequest(t, "POST", link.Path, "", "", &session); status != http.StatusOK || session.AccessToken == "" {
+		t.Fatalf("login con el enlace: estado %d", status)
+	}
+	if status := doRequest(t, "POST", link.Path, "", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("segundo login con el mismo enlace: estado %d, se esperaba %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRecoveryRevokesAccessTokens(t *testing.T) {
+	code, verifyPath := register(t, "pablo@example.com")
+	doRequest(t, "GET", verifyPath, "", "", nil)
+
+	var session struct {
+		AccessToken string `json:"access_token"`
+	}
+	if status := doRequest(t, "POST", "/api/v1/login", `{"code":"`+code+`"}`, "", &session); status != http.StatusOK {
+		t.Fatalf("login: estado %d", status)
+	}
+	if strings.Contains(decodeTokenPayload(t, session.AccessToken), "code_hash") {
+		t.Error("el access token incluye el hash del código")
+	}
+
+	var recovery map[string]string
+	doRequest(t, "POST", "/api/v1/recover", `{"email":"pablo@example.com"}`, "", &recovery)
+	link, err := url.Parse(recovery["dev_recover_url"])
+	if err != nil {
+		t.Fatalf("enlace de recuperación: %v", recovery)
+	}
+	if status := doRequest(t, "GET", link.Path, "", "", nil); status != http.StatusOK {
+		t.Fatalf("confirmar recuperación: estado %d", status)
+	}
+
+	if status := doRequest(t, "GET", "/api/v1/user/me", "", session.AccessToken, nil); status != http.StatusUnauthorized {
+		t.Errorf("access token tras rotar el código: estado %d, se esperaba %d", status, http.StatusUnauthorized)
+	}
+}
+
+func decodeTokenPayload(t *testing.T, token string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token mal formado: %q", token)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("payload del token: %v", err)
+	}
+	return string(payload)
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fusión de cuentas duplicadas: la cuenta "keep" conserva su email, su
+// código y su rol, y recibe de la otra las imágenes, identidades OAuth,
+// passkeys, etiquetas, notas y el historial de auditoría. Los campos del
+// perfil que keep tenga vacíos se rellenan con los de la otra. La cuenta
+// fusionada se borra, pero no sus imágenes, que pasan a keep aunque se
+// supere MAX_PROFILE_IMAGES.
+
+var errMergeConflict = errors.New("la cuenta ha cambiado durante la fusión")
+
+type MergeUsersRequest struct {
+	Keep   string `json:"keep"`
+	Merge  string `json:"merge"`
+	DryRun bool   `json:"dry_run"`
+}
+
+func earliest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.Before(*a)) {
+		return b
+	}
+	return a
+}
+
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+// mergeUsers calcula el documento resultante sin tocar la base de datos.
+func mergeUsers(keep, merged User) User {
+	result := keep
+
+	for _, field := range []struct{ into, from *string }{
+		{&result.Name, &merged.Name},
+		{&result.LastName, &merged.LastName},
+		{&result.Username, &merged.Username},
+		{&result.Phone, &merged.Phone},
+		{&result.Bio, &merged.Bio},
+		{&result.Birthday, &merged.Birthday},
+		{&result.Website, &merged.Website},
+		{&result.Pronouns, &merged.Pronouns},
+	} {
+		if *field.into == "" {
+			*field.into = *field.from
+		}
+	}
+
+	result.Images = append(append([]ProfileImage{}, keep.Images...), merged.Images...)
+	if keep.AvatarImageID == nil && merged.AvatarImageID != nil {
+		result.AvatarImageID = merged.AvatarImageID
+		result.ImageURL = merged.ImageURL
+		result.ImageFallbackURL = merged.ImageFallbackURL
+	}
+
+	result.Identities = append(append([]ExternalIdentity{}, keep.Identities...), merged.Identities...)
+	result.Passkeys = append(append([]webauthn.Credential{}, keep.Passkeys...), merged.Passkeys...)
+	result.AdminNotes = append(append([]AdminNote{}, keep.AdminNotes...), merged.AdminNotes...)
+	result.Tags, _ = normalizeTags(append(append([]string{}, keep.Tags...), merged.Tags...))
+	if len(result.Tags) == 0 {
+		result.Tags = nil
+	}
+
+	if result.Preferences == nil {
+		result.Preferences = merged.Preferences
+	}
+	if result.Visibility == nil {
+		result.Visibility = merged.Visibility
+	}
+
+	result.Verified = keep.Verified || merged.Verified
+	result.VerifiedAt = earliest(keep.VerifiedAt, merged.VerifiedAt)
+	if merged.CreatedAt.Before(keep.CreatedAt) {
+		result.CreatedAt = merged.CreatedAt
+	}
+	result.LoginCount = keep.LoginCount + merged.LoginCount
+	result.LastLoginAt = latest(keep.LastLoginAt, merged.LastLoginAt)
+	result.LastSeenAt = latest(keep.LastSeenAt, merged.LastSeenAt)
+	result.UpdatedAt = time.Now()
+	return result
+}
+
+func (s *Server) findMergeUser(ctx context.Context, w http.ResponseWriter, idOrCode, param string) (User, bool) {
+	if idOrCode == "" {
+		http.Error(w, param+" requerido", http.StatusBadRequest)
+		return User{}, false
+	}
+
+	user, err := s.findUser(ctx, s.adminUserQuery(idOrCode))
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado: "+param, http.StatusNotFound)
+		return User{}, false
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return User{}, false
+	}
+	return user, true
+}
+
+// restoreMergedUser deshace la preparación de una fusión fallida: recupera
+// la cuenta y le devuelve el username, las identidades y las passkeys.
+func (s *Server) restoreMergedUser(ctx context.Context, merged User) error {
+	restored, err := s.userRepo.Restore(ctx, merged.ID)
+	if err != nil {
+		return err
+	}
+	restored.Username = merged.Username
+	restored.Identities = merged.Identities
+	restored.Passkeys = merged.Passkeys
+	return s.userRepo.Update(ctx, &restored)
+}
+
+// handleAdminMergeUsers fusiona merge en keep (ID o código). Con dry_run
+// solo devuelve el documento resultante.
+func (s *Server) handleAdminMergeUsers(w http.ResponseWriter, r *http.Request) {
+	var req MergeUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	keep, ok := s.findMergeUser(ctx, w, req.Keep, "keep")
+	if !ok {
+		return
+	}
+	merged, ok := s.findMergeUser(ctx, w, req.Merge, "merge")
+	if !ok {
+		return
+	}
+	if keep.ID == merged.ID {
+		http.Error(w, "keep y merge son la misma cuenta", http.StatusBadRequest)
+		return
+	}
+
+	result := mergeUsers(keep, merged)
+	auditEntries, err := s.database.Audit.CountDocuments(ctx, bson.M{"user_id": merged.ID})
+	if err != nil {
+		log.Printf("Error contando auditoría: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	summary := map[string]interface{}{
+		"dry_run":       req.DryRun,
+		"merged_id":     merged.ID,
+		"merged_email":  merged.Email,
+		"images":        len(merged.Images),
+		"identities":    len(merged.Identities),
+		"passkeys":      len(merged.Passkeys),
+		"audit_entries": auditEntries,
+		"user":          result,
+	}
+
+	if req.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+		return
+	}
+
+	// Primero se liberan en la cuenta fusionada los valores con índice único
+	// (username, identidades) y se marca como eliminada; si algo falla al
+	// guardar keep, se restaura tal como estaba. Desde aquí la fusión sigue
+	// aunque el cliente se desconecte.
+	ctx, cancelMerge := detach(ctx, 30*time.Second)
+	defer cancelMerge()
+	now := time.Now()
+	released := merged
+	released.Username = ""
+	released.Identities = nil
+	released.Passkeys = nil
+	released.DeletedAt = &now
+	err = s.userRepo.Update(ctx, &released)
+	if errors.Is(err, errUserConflict) || errors.Is(err, errUserNotFound) {
+		http.Error(w, "La cuenta a fusionar ha cambiado, inténtalo de nuevo", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error preparando fusión: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	// Update no escribe la actividad: la de la cuenta fusionada se suma a
+	// keep cuando la fusión ya no puede deshacerse.
+	result.UpdatedAt = keep.UpdatedAt
+	err = s.userRepo.Update(ctx, &result)
+	if errors.Is(err, errUserConflict) || errors.Is(err, errUserNotFound) {
+		err = errMergeConflict
+	}
+	if err != nil {
+		if restoreErr := s.restoreMergedUser(ctx, merged); restoreErr != nil {
+			log.Printf("❌ Error restaurando la cuenta %s tras una fusión fallida: %v", merged.ID.Hex(), restoreErr)
+		}
+		if err == errMergeConflict {
+			http.Error(w, "La cuenta a conservar ha cambiado, inténtalo de nuevo", http.StatusConflict)
+			return
+		}
+		if isDuplicateUsername(err) {
+			writeUsernameTaken(w)
+			return
+		}
+		log.Printf("Error guardando cuenta fusionada: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := s.database.Audit.UpdateMany(ctx, bson.M{"user_id": merged.ID}, bson.M{"$set": bson.M{"user_id": keep.ID}}); err != nil {
+		log.Printf("Error moviendo auditoría: %v", err)
+	}
+	if _, err := s.database.ProfileVersions.DeleteMany(ctx, bson.M{"user_id": merged.ID}); err != nil {
+		log.Printf("Error borrando versiones del perfil: %v", err)
+	}
+	s.deleteUserData(ctx, merged.ID)
+	if err := s.userRepo.Purge(ctx, merged.ID); err != nil {
+		log.Printf("Error borrando cuenta fusionada: %v", err)
+	}
+	err = s.userRepo.RecordActivity(ctx, keep.ID, UserActivity{
+		Logins:  merged.LoginCount,
+		LoginAt: merged.LastLoginAt,
+		SeenAt:  merged.LastSeenAt,
+	})
+	if err != nil {
+		log.Printf("Error sumando la actividad de la cuenta fusionada: %v", err)
+	}
+	summary["user"] = result
+
+	changes := profileChanges(keep, result)
+	if changes == nil {
+		changes = []AuditChange{}
+	}
+	_, err = s.database.Audit.InsertOne(ctx, AuditEntry{
+		UserID:  keep.ID,
+		Actor:   requestActor(r),
+		Action:  auditActionMerge,
+		Changes: changes,
+		Details: map[string]string{
+			"merged_id":    merged.ID.Hex(),
+			"merged_email": merged.Email,
+		},
+		IP:        s.clientIP(r),
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Printf("⚠️  Error registrando auditoría: %v", err)
+	}
+
+	s.publishUserDeleted(ctx, merged, "merged", keep.ID)
+	if len(changes) > 0 {
+		s.publishEvent(ctx, eventUserUpdated, keep.ID, userEventData{User: result, Fields: changedFields(changes)})
+	}
+
+	log.Printf("🔀 Cuenta %s fusionada en %s", merged.Email, keep.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+
+	"backend/internal/config"
+)
+
+// Todas las peticiones pasan por la misma cadena de middlewares, en este
+// orden: recover → request ID → logging → formato → CORS → rate limit →
+// auth. Cada middleware declara su etapa y la cadena los ordena por ella,
+// así que el orden no depende de dónde ni cuándo se registren. Una ruta
+// puede saltarse middlewares o cambiarlos (ver middlewareChain.Route). La
+// autenticación depende del grupo de rutas y se sigue aplicando con Use en
+// cada subrouter, que se ejecuta dentro del router y por tanto después de
+// toda la cadena.
+
+type middlewareStage int
+
+const (
+	stageRecover middlewareStage = iota
+	stageRequestID
+	stageLogging
+	stageFormat
+	stageCORS
+	stageRateLimit
+	stageAuth
+)
+
+// middleware es un eslabón de la cadena. Name lo identifica en los ajustes
+// por ruta.
+type middleware struct {
+	Name  string
+	Stage middlewareStage
+	Wrap  func(http.Handler) http.Handler
+}
+
+// routeMiddleware ajusta la cadena de una ruta: Skip quita middlewares por
+// su nombre y Use añade otros o sustituye a los que tienen el mismo nombre.
+type routeMiddleware struct {
+	Skip []string
+	Use  []middleware
+}
+
+type middlewareChain struct {
+	global []middleware
+	routes map[*mux.Route]routeMiddleware
+}
+
+// Use añade un middleware a todas las peticiones. Los nombres no se pueden
+// repetir, porque los ajustes por ruta los buscan por nombre.
+func (c *middlewareChain) Use(m middleware) {
+	for _, existing := range c.global {
+		if existing.Name == m.Name {
+			panic("middleware registrado dos veces: " + m.Name)
+		}
+	}
+	c.global = append(c.global, m)
+}
+
+// Route ajusta la cadena para route y la devuelve, para poder usarla al
+// registrar la ruta. Cada versión de la API registra sus propias rutas, así
+// que el ajuste se aplica en todas si se hace dentro de Routes.
+func (c *middlewareChain) Route(route *mux.Route, override routeMiddleware) *mux.Route {
+	if c.routes == nil {
+		c.routes = map[*mux.Route]routeMiddleware{}
+	}
+	c.routes[route] = override
+	return route
+}
+
+// forRoute son los middlewares que se aplican a route ordenados por etapa;
+// dentro de una etapa, en el orden en que se registraron.
+func (c *middlewareChain) forRoute(route *mux.Route) []middleware {
+	override := c.routes[route]
+	var chain []middleware
+	for _, m := range c.global {
+		if slices.Contains(override.Skip, m.Name) {
+			continue
+		}
+		if i := slices.IndexFunc(override.Use, func(o middleware) bool { return o.Name == m.Name }); i >= 0 {
+			m = override.Use[i]
+		}
+		chain = append(chain, m)
+	}
+	for _, m := range override.Use {
+		if !slices.ContainsFunc(chain, func(existing middleware) bool { return existing.Name == m.Name }) {
+			chain = append(chain, m)
+		}
+	}
+	slices.SortStableFunc(chain, func(a, b middleware) int { return cmp.Compare(a.Stage, b.Stage) })
+	return chain
+}
+
+// Handler monta la cadena delante de router. Las cadenas se construyen aquí
+// una vez, así que todos los middlewares y ajustes deben estar registrados
+// antes. La ruta se busca antes de entrar para aplicar sus ajustes; lo que no
+// encaja con ninguna (404, preflight de CORS) usa la cadena global.
+func (c *middlewareChain) Handler(router *mux.Router) http.Handler {
+	build := func(route *mux.Route) http.Handler {
+		var handler http.Handler = router
+		chain := c.forRoute(route)
+		for i := len(chain) - 1; i >= 0; i-- {
+			handler = chain[i].Wrap(handler)
+		}
+		return handler
+	}
+
+	global := build(nil)
+	if len(c.routes) == 0 {
+		return global
+	}
+	byRoute := make(map[*mux.Route]http.Handler, len(c.routes))
+	for route := range c.routes {
+		byRoute[route] = build(route)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+		if router.Match(r, &match) {
+			if handler, ok := byRoute[match.Route]; ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		global.ServeHTTP(w, r)
+	})
+}
+
+// useDefaultMiddlewares registra la cadena global del servidor.
+func (s *Server) useDefaultMiddlewares(cfg config.Config) {
+	s.middlewares.Use(middleware{Name: "recover", Stage: stageRecover, Wrap: s.recoverPanics})
+	s.middlewares.Use(middleware{Name: "request-id", Stage: stageRequestID, Wrap: assignRequestID})
+	s.middlewares.Use(middleware{Name: "logging", Stage: stageLogging, Wrap: s.logRequests})
+	s.middlewares.Use(middleware{Name: "jsonapi", Stage: stageFormat, Wrap: s.formatJSONAPI})
+	s.middlewares.Use(middleware{Name: "cors", Stage: stageCORS, Wrap: s.applyCORS})
+	if cfg.API.RateLimit > 0 {
+		s.apiRateLimiter = s.newRateLimiter(cfg.API.RateLimit, cfg.API.RateWindow)
+		s.middlewares.Use(middleware{Name: "ratelimit", Stage: stageRateLimit, Wrap: s.limitRequests(s.apiRateLimiter)})
+	}
+}
+
+// applyCORS aplica la política vigente, que cambia al recargar la
+// configuración (ver reload.go).
+func (s *Server) applyCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.corsPolicy.Load().ServeHTTP(w, r, next.ServeHTTP)
+	})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxAdminNoteLength = 2000
+
+// AdminNote es una nota de soporte sobre la cuenta. Se guarda en el propio
+// usuario (admin_notes) pero nunca se incluye en su JSON: solo se lee y se
+// edita desde /api/admin.
+type AdminNote struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Author    string             `json:"author" bson:"author"`
+	Text      string             `json:"text" bson:"text"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt *time.Time         `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+type AdminNoteRequest struct {
+	Text string `json:"text"`
+}
+
+// readAdminNote valida el texto de la nota; si no es válido ya ha respondido.
+func readAdminNote(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req AdminNoteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, multipartOverhead)).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return "", false
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		http.Error(w, "Texto requerido", http.StatusBadRequest)
+		return "", false
+	}
+	if utf8.RuneCountInString(text) > maxAdminNoteLength {
+		http.Error(w, fmt.Sprintf("La nota admite como máximo %d caracteres", maxAdminNoteLength), http.StatusBadRequest)
+		return "", false
+	}
+	return text, true
+}
+
+var errNoteNotFound = errors.New("nota no encontrada")
+
+// changeAdminNotes aplica change a las notas del usuario de la ruta y lo
+// guarda.
+func (s *Server) changeAdminNotes(ctx context.Context, r *http.Request, change func([]AdminNote) ([]AdminNote, error)) (User, error) {
+	user, err := s.findUser(ctx, s.adminUserQuery(mux.Vars(r)["id"]))
+	if err != nil {
+		return User{}, err
+	}
+	return s.changeUser(ctx, user.ID, func(user *User) error {
+		notes, err := change(user.AdminNotes)
+		if err != nil {
+			return err
+		}
+		user.AdminNotes = notes
+		return nil
+	})
+}
+
+// adminNoteIndex devuelve la posición de la nota o errNoteNotFound.
+func adminNoteIndex(notes []AdminNote, id primitive.ObjectID) (int, error) {
+	for i, note := range notes {
+		if note.ID == id {
+			return i, nil
+		}
+	}
+	return -1, errNoteNotFound
+}
+
+func writeAdminNotes(w http.ResponseWriter, user User) {
+	notes := user.AdminNotes
+	if notes == nil {
+		notes = []AdminNote{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": user.ID,
+		"notes":   notes,
+	})
+}
+
+// handleAdminListNotes lista las notas en el orden en que se añadieron.
+func (s *Server) handleAdminListNotes(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), listOptions{})
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findAdminTarget(ctx, r)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo notas: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	notes := user.AdminNotes
+	if notes == nil {
+		notes = []AdminNote{}
+	}
+	start, end := pageBounds(list, len(notes))
+
+	response := listResponse(list, notes[start:end], end-start, int64(len(notes)))
+	response["user_id"] = user.ID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleAdminAddNote(w http.ResponseWriter, r *http.Request) {
+	text, ok := readAdminNote(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	note := AdminNote{
+		ID:        primitive.NewObjectID(),
+		Author:    requestActor(r),
+		Text:      text,
+		CreatedAt: time.Now(),
+	}
+
+	user, err := s.changeAdminNotes(ctx, r, func(notes []AdminNote) ([]AdminNote, error) {
+		return append(notes, note), nil
+	})
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error guardando nota: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeAdminNotes(w, user)
+}
+
+// handleAdminUpdateNote reemplaza el texto de una nota; el autor original se
+// conserva y updated_at indica que se editó.
+func (s *Server) handleAdminUpdateNote(w http.ResponseWriter, r *http.Request) {
+	noteID, err := primitive.ObjectIDFromHex(mux.Vars(r)["note"])
+	if err != nil {
+		http.Error(w, "Nota no encontrada", http.StatusNotFound)
+		return
+	}
+
+	text, ok := readAdminNote(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	user, err := s.changeAdminNotes(ctx, r, func(notes []AdminNote) ([]AdminNote, error) {
+		i, err := adminNoteIndex(notes, noteID)
+		if err != nil {
+			return nil, err
+		}
+		notes[i].Text = text
+		notes[i].UpdatedAt = &now
+		return notes, nil
+	})
+	if errors.Is(err, errUserNotFound) || errors.Is(err, errNoteNotFound) {
+		http.Error(w, "Nota no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error editando nota: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminNotes(w, user)
+}
+
+func (s *Server) handleAdminDeleteNote(w http.ResponseWriter, r *http.Request) {
+	noteID, err := primitive.ObjectIDFromHex(mux.Vars(r)["note"])
+	if err != nil {
+		http.Error(w, "Nota no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.changeAdminNotes(ctx, r, func(notes []AdminNote) ([]AdminNote, error) {
+		i, err := adminNoteIndex(notes, noteID)
+		if err != nil {
+			return nil, err
+		}
+		return slices.Delete(notes, i, i+1), nil
+	})
+	if errors.Is(err, errUserNotFound) || errors.Is(err, errNoteNotFound) {
+		http.Error(w, "Nota no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error eliminando nota: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminNotes(w, user)
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+
+	"backend/internal/config"
+)
+
+const oauthStateCookie = "oauth_state"
+
+// ExternalIdentity vincula un usuario con su cuenta en un proveedor externo.
+type ExternalIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// oauthProfile es la información mínima que necesitamos del proveedor.
+type oauthProfile struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	LastName      string
+	ImageURL      string
+}
+
+// AuthProvider es un proveedor de identidad OAuth2. Para añadir uno nuevo
+// basta con implementarlo y registrarlo en loadAuthProviders.
+type AuthProvider interface {
+	Name() string
+	AuthCodeURL(state string) string
+	FetchProfile(ctx context.Context, code string) (oauthProfile, error)
+}
+
+func (s *Server) registerAuthProvider(provider AuthProvider) {
+	s.authProviders[provider.Name()] = provider
+	log.Printf("✅ Proveedor de login %s habilitado", provider.Name())
+}
+
+func (s *Server) loadAuthProviders(cfg config.OAuth) {
+	s.oauthSuccessRedirectURL = cfg.SuccessRedirectURL
+	if config := s.oauthClientConfig("google", cfg.Google, endpoints.Google, "openid", "email", "profile"); config != nil {
+		s.registerAuthProvider(&googleProvider{config: config})
+	}
+	if config := s.oauthClientConfig("github", cfg.GitHub, endpoints.GitHub, "read:user", "user:email"); config != nil {
+		s.registerAuthProvider(&githubProvider{config: config})
+	}
+}
+
+// oauthClientConfig devuelve nil si el proveedor no está configurado.
+func (s *Server) oauthClientConfig(name string, client config.OAuthClient, endpoint oauth2.Endpoint, scopes ...string) *oauth2.Config {
+	if !client.Enabled() {
+		return nil
+	}
+
+	redirectURL := client.RedirectURL
+	if redirectURL == "" {
+		redirectURL = s.publicBaseURL() + apiPath("/auth/"+name+"/callback")
+	}
+
+	return &oauth2.Config{
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint:     endpoint,
+		Scopes:       scopes,
+	}
+}
+
+func newOAuthState() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (s *Server) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.authProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Proveedor de login no configurado", http.StatusNotFound)
+		return
+	}
+
+	state, err := newOAuthState()
+	if err != nil {
+		log.Printf("Error generando state OAuth: %v", err)
+		http.Error(w, "Error iniciando login", http.StatusInternalServerError)
+		return
+	}
+
+	// Path /api para que llegue al callback con y sin versión: los
+	// <PREFIX>_REDIRECT_URL ya registrados pueden apuntar a /api/auth.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/api",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.AuthCodeURL(state), http.StatusFound)
+}
+
+func (s *Server) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.authProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Proveedor de login no configurado", http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "State OAuth inválido", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/api", MaxAge: -1})
+
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		http.Error(w, "Login cancelado: "+errParam, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	profile, err := provider.FetchProfile(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("Error completando login con %s: %v", provider.Name(), err)
+		http.Error(w, "No se pudo completar el login con "+provider.Name(), http.StatusUnauthorized)
+		return
+	}
+
+	s.completeOAuthLogin(ctx, w, r, profile)
+}
+
+// completeOAuthLogin busca (o crea) el usuario del perfil externo y abre una
+// sesión igual que el login con código.
+func (s *Server) completeOAuthLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, profile oauthProfile) {
+	if profile.Email == "" || !profile.EmailVerified {
+		http.Error(w, "El proveedor no devolvió un email verificado", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.findOrCreateOAuthUser(ctx, profile)
+	if err != nil {
+		log.Printf("Error vinculando usuario %s: %v", profile.Provider, err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	s.finishBrowserLogin(ctx, w, r, user)
+}
+
+// finishBrowserLogin abre la sesión de un login iniciado desde el navegador
+// (OAuth, magic link). Si OAUTH_SUCCESS_REDIRECT_URL está configurada se
+// redirige al frontend con los tokens en el fragmento; si no, se responde JSON.
+func (s *Server) finishBrowserLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, user User) {
+	if !accountActive(w, user) {
+		return
+	}
+
+	session, err := s.sessionResponse(ctx, w, r, user)
+	if err != nil {
+		log.Printf("Error creando sesión: %v", err)
+		http.Error(w, "Error creando sesión", http.StatusInternalServerError)
+		return
+	}
+
+	if redirectURL := s.oauthSuccessRedirectURL; redirectURL != "" {
+		fragment := url.Values{}
+		for key, value := range session {
+			fragment.Set(key, fmt.Sprint(value))
+		}
+		http.Redirect(w, r, redirectURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Login exitoso",
+		"user":    user,
+	}
+	for key, value := range session {
+		response[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) findOrCreateOAuthUser(ctx context.Context, profile oauthProfile) (User, error) {
+	user, err := s.userRepo.FindByIdentity(ctx, profile.Provider, profile.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, errUserNotFound) {
+		return User{}, err
+	}
+
+	identity := ExternalIdentity{
+		Provider: profile.Provider,
+		Subject:  profile.Subject,
+		LinkedAt: time.Now(),
+	}
+
+	user, err = s.userRepo.FindByEmail(ctx, profile.Email)
+	if err == nil {
+		user, err = s.changeUser(ctx, user.ID, func(user *User) error {
+			user.Identities = append(user.Identities, identity)
+			user.Verified = true
+			return nil
+		})
+	}
+	if err == nil {
+		log.Printf("✅ Cuenta %s vinculada al usuario %s", profile.Provider, user.ID.Hex())
+		return user, nil
+	}
+	if !errors.Is(err, errUserNotFound) {
+		return User{}, err
+	}
+
+	user = User{
+		Email:         profile.Email,
+		CodeExpiresAt: time.Now().Add(s.codeTTL()),
+		Name:          profile.Name,
+		LastName:      profile.LastName,
+		ImageURL:      profile.ImageURL,
+		Verified:      true,
+		Identities:    []ExternalIdentity{identity},
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	if err := s.purgeDeletedUserByEmail(ctx, user.Email); err != nil {
+		return User{}, err
+	}
+	if _, err := s.insertUserWithCode(ctx, &user); err != nil {
+		return User{}, err
+	}
+
+	log.Printf("✅ Usuario creado desde %s con ID: %v", profile.Provider, user.ID.Hex())
+	return user, nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+type githubProvider struct {
+	config *oauth2.Config
+}
+
+type githubUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func (p *githubProvider) Name() string {
+	return "github"
+}
+
+func (p *githubProvider) AuthCodeURL(state string) string {
+	return p.config.AuthCodeURL(state)
+}
+
+func (p *githubProvider) FetchProfile(ctx context.Context, code string) (oauthProfile, error) {
+	token, err := p.config.Exchange(ctx, code)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	client := p.config.Client(ctx, token)
+
+	var user githubUser
+	if err := githubGet(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return oauthProfile{}, err
+	}
+
+	// El email del perfil puede ser privado; /user/emails indica cuál está verificado.
+	var emails []githubEmail
+	if err := githubGet(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return oauthProfile{}, err
+	}
+
+	profile := oauthProfile{
+		Provider: p.Name(),
+		Subject:  strconv.FormatInt(user.ID, 10),
+		ImageURL: user.AvatarURL,
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			profile.Email = email.Email
+			profile.EmailVerified = true
+			break
+		}
+	}
+
+	name, lastName, _ := strings.Cut(strings.TrimSpace(user.Name), " ")
+	if name == "" {
+		name = user.Login
+	}
+	profile.Name = name
+	profile.LastName = lastName
+
+	return profile, nil
+}
+
+func githubGet(ctx context.Context, client *http.Client, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s respondió status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
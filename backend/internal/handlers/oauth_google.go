@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+type googleProvider struct {
+	config *oauth2.Config
+}
+
+type googleUserInfo struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+}
+
+func (p *googleProvider) Name() string {
+	return "google"
+}
+
+func (p *googleProvider) AuthCodeURL(state string) string {
+	return p.config.AuthCodeURL(state)
+}
+
+func (p *googleProvider) FetchProfile(ctx context.Context, code string) (oauthProfile, error) {
+	token, err := p.config.Exchange(ctx, code)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://openidconnect.googleapis.com/v1/userinfo", nil)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+
+	resp, err := p.config.Client(ctx, token).Do(req)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return oauthProfile{}, fmt.Errorf("userinfo respondió status %d", resp.StatusCode)
+	}
+
+	var info googleUserInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return oauthProfile{}, err
+	}
+
+	return oauthProfile{
+		Provider:      p.Name(),
+		Subject:       info.Sub,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Name:          info.GivenName,
+		LastName:      info.FamilyName,
+		ImageURL:      info.Picture,
+	}, nil
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/swaggest/swgui/v5emb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Documentación OpenAPI 3 de la versión actual de la API. Rutas, métodos y
+// parámetros de ruta se leen del router, así que no se desincronizan con lo
+// que se sirve; apiDocs añade a cada handler un resumen y el tipo de su
+// cuerpo JSON, cuyo esquema se genera con las etiquetas json y validate.
+
+type apiDoc struct {
+	Summary string
+	// Request es el cuerpo JSON que acepta el handler; nil si no tiene.
+	Request interface{}
+	// Response es el cuerpo de la respuesta correcta si es un tipo conocido.
+	Response interface{}
+	// List indica que el handler es un listado (ver pagination.go); Response
+	// es entonces el tipo de cada elemento.
+	List *listOptions
+}
+
+var apiDocs = map[string]apiDoc{
+	"handleRegister":               {Summary: "Registrar un usuario y enviarle su código", Request: RegisterRequest{}},
+	"handleLogin":                  {Summary: "Iniciar sesión con el código de acceso o un código de un solo uso", Request: LoginRequest{}},
+	"handleRequestOTP":             {Summary: "Enviar un código de un solo uso por email", Request: CodeRequest{}},
+	"handleRequestMagicLink":       {Summary: "Enviar un enlace de acceso por email", Request: CodeRequest{}},
+	"handleMagicLinkLogin":         {Summary: "Iniciar sesión con un enlace de acceso"},
+	"handleRefreshToken":           {Summary: "Renovar la sesión con el refresh token", Request: RefreshRequest{}},
+	"handleLogout":                 {Summary: "Cerrar la sesión", Request: RefreshRequest{}},
+	"handleRegenerateCode":         {Summary: "Solicitar un código de acceso nuevo (envía un enlace de confirmación)", Request: CodeRequest{}},
+	"handleConfirmNewCode":         {Summary: "Confirmar el código nuevo y recibirlo por email"},
+	"handleVerifyEmail":            {Summary: "Verificar el email con el enlace enviado al registrarse"},
+	"handleRequestRecovery":        {Summary: "Iniciar la recuperación de la cuenta", Request: CodeRequest{}},
+	"handleConfirmRecovery":        {Summary: "Confirmar la recuperación y recibir un código nuevo"},
+	"handleRequestReactivation":    {Summary: "Solicitar la reactivación de una cuenta desactivada", Request: CodeRequest{}},
+	"handleConfirmReactivation":    {Summary: "Confirmar la reactivación de la cuenta"},
+	"handleReminderUnsubscribe":    {Summary: "Dejar de recibir recordatorios de perfil"},
+	"handleOAuthLogin":             {Summary: "Iniciar sesión con un proveedor OAuth"},
+	"handleOAuthCallback":          {Summary: "Callback del proveedor OAuth"},
+	"handleResendWebhook":          {Summary: "Recibir eventos de entrega de Resend"},
+	"handleSAMLMetadata":           {Summary: "Metadatos SAML del proveedor de servicio"},
+	"handleSAMLLogin":              {Summary: "Iniciar sesión con SAML"},
+	"handleSAMLACS":                {Summary: "Assertion Consumer Service de SAML"},
+	"handleWebAuthnLoginBegin":     {Summary: "Empezar el login con passkey", Request: WebAuthnLoginRequest{}},
+	"handleWebAuthnLoginFinish":    {Summary: "Completar el login con passkey"},
+	"handleWebAuthnRegisterBegin":  {Summary: "Empezar el registro de una passkey"},
+	"handleWebAuthnRegisterFinish": {Summary: "Completar el registro de una passkey"},
+	"handleListEmailPreviews":      {Summary: "Listar las plantillas de email (solo en desarrollo)", Response: "", List: &listOptions{}},
+	"handleEmailPreview":           {Summary: "Previsualizar una plantilla de email (solo en desarrollo)"},
+
+	"handleCreateAPIKey":           {Summary: "Crear una API key", Request: CreateAPIKeyRequest{}},
+	"handleListAPIKeys":            {Summary: "Listar las API keys", Response: APIKey{}, List: &apiKeyList},
+	"handleRevokeAPIKey":           {Summary: "Revocar una API key"},
+	"handleAdminCreateIndexes":     {Summary: "Crear los índices de la base de datos"},
+	"handleAdminStats":             {Summary: "Estadísticas de usuarios, emails y almacenamiento", Response: AdminStats{}},
+	"handleAdminListUsers":         {Summary: "Listar usuarios con filtros y paginación", Response: User{}, List: &adminUserList},
+	"handleAdminExportUsers":       {Summary: "Exportar usuarios en CSV o NDJSON"},
+	"handleAdminMergeUsers":        {Summary: "Fusionar dos cuentas duplicadas", Request: MergeUsersRequest{}},
+	"handleAdminRestoreUser":       {Summary: "Restaurar una cuenta eliminada"},
+	"handleAdminEraseUser":         {Summary: "Anonimizar una cuenta"},
+	"handleAdminDeactivateUser":    {Summary: "Desactivar una cuenta"},
+	"handleAdminReactivateUser":    {Summary: "Reactivar una cuenta"},
+	"handleAdminUserAudit":         {Summary: "Historial de auditoría de un usuario", Response: AuditEntry{}, List: &auditList},
+	"handleAdminImpersonateUser":   {Summary: "Emitir un token de suplantación", Request: ImpersonationRequest{}},
+	"handleAdminAddTags":           {Summary: "Añadir etiquetas a un usuario", Request: TagsRequest{}},
+	"handleAdminRemoveTag":         {Summary: "Quitar una etiqueta a un usuario"},
+	"handleAdminListNotes":         {Summary: "Listar las notas internas de un usuario", Response: AdminNote{}, List: &listOptions{}},
+	"handleAdminAddNote":           {Summary: "Añadir una nota interna", Request: AdminNoteRequest{}},
+	"handleAdminUpdateNote":        {Summary: "Editar una nota interna", Request: AdminNoteRequest{}},
+	"handleAdminDeleteNote":        {Summary: "Borrar una nota interna"},
+	"handleAdminListGroups":        {Summary: "Listar los grupos de usuarios", Response: Group{}, List: &groupList},
+	"handleAdminGetGroup":          {Summary: "Obtener un grupo", Response: Group{}},
+	"handleAdminPutGroup":          {Summary: "Crear o reemplazar un grupo", Request: Group{}, Response: Group{}},
+	"handleAdminDeleteGroup":       {Summary: "Borrar un grupo"},
+	"handleAdminAvatarOriginal":    {Summary: "Descargar el avatar original de un usuario"},
+	"handleAdminRewriteUploadURLs": {Summary: "Reescribir las URLs de las imágenes subidas", Request: RewriteURLsRequest{}},
+	"handleAdminListEmailEvents":   {Summary: "Listar eventos de entrega de emails", Response: EmailEvent{}, List: &emailEventList},
+	"handleAdminListFailedEmails":  {Summary: "Listar emails fallidos", Response: MailLogEntry{}, List: &failedEmailList},
+	"handleAdminRetryEmail":        {Summary: "Reintentar el envío de un email fallido"},
+	"handleAdminEmailStatus":       {Summary: "Estado de entrega de un email"},
+	"handleAdminMailLog":           {Summary: "Registro de emails enviados", Response: MailLogEntry{}, List: &mailLogList},
+	"EmailDomainCheck":             {Summary: "Comprobar SPF, DKIM y DMARC del dominio de envío"},
+
+	"handleAdminCreateWebhook":         {Summary: "Registrar un webhook saliente", Request: CreateWebhookRequest{}},
+	"handleAdminListWebhooks":          {Summary: "Listar los webhooks salientes", Response: WebhookEndpoint{}, List: &webhookList},
+	"handleAdminDeleteWebhook":         {Summary: "Eliminar un webhook saliente"},
+	"handleAdminListWebhookDeliveries": {Summary: "Registro de entregas de un webhook", Response: WebhookDelivery{}, List: &webhookDeliveryList},
+	"handleAdminRetryWebhookDelivery":  {Summary: "Reintentar una entrega de webhook fallida"},
+	"handleAdminEventCounts":           {Summary: "Eventos de dominio por día", Response: EventCount{}, List: &eventCountList},
+
+	"handleWebSocket":           {Summary: "Eventos del usuario en tiempo real (WebSocket)"},
+	"handleEventStream":         {Summary: "Eventos del usuario en tiempo real (server-sent events)"},
+	"batchHandler":              {Summary: "Ejecutar varias peticiones GET en una", Request: BatchRequest{}},
+	"handleDefaultAvatar":       {Summary: "Avatar por defecto en SVG"},
+	"handleGetUserByUsername":   {Summary: "Perfil público por nombre de usuario"},
+	"handleGetUser":             {Summary: "Obtener el usuario", Response: User{}},
+	"handleUpdateUser":          {Summary: "Actualizar el perfil y la imagen (multipart o JSON)"},
+	"handlePatchUser":           {Summary: "Actualizar parcialmente el perfil (merge patch)"},
+	"handleDeleteUser":          {Summary: "Eliminar la cuenta"},
+	"handleEraseUser":           {Summary: "Anonimizar la cuenta"},
+	"handleDeactivateUser":      {Summary: "Desactivar la cuenta"},
+	"handlePresignAvatarUpload": {Summary: "Obtener una URL firmada para subir el avatar", Request: PresignRequest{}},
+	"handleConfirmAvatarUpload": {Summary: "Confirmar la subida firmada del avatar", Request: ConfirmUploadRequest{}},
+	"handleTusOptions":          {Summary: "Capacidades del servidor tus"},
+	"handleTusCreate":           {Summary: "Crear una subida reanudable (tus)"},
+	"handleTusHead":             {Summary: "Consultar el progreso de una subida"},
+	"handleTusPatch":            {Summary: "Enviar un fragmento de la subida"},
+	"handleTusDelete":           {Summary: "Cancelar una subida"},
+	"handleListImages":          {Summary: "Listar las imágenes del perfil", Response: ProfileImage{}, List: &listOptions{}},
+	"handleAddImage":            {Summary: "Añadir una imagen al perfil"},
+	"handleReorderImages":       {Summary: "Reordenar las imágenes del perfil", Request: ReorderImagesRequest{}},
+	"handleDeleteImage":         {Summary: "Borrar una imagen del perfil"},
+	"handleSetAvatarImage":      {Summary: "Usar una imagen como avatar"},
+	"handleGetCompleteness":     {Summary: "Porcentaje de perfil completado"},
+	"handleGetVisibility":       {Summary: "Visibilidad de los campos del perfil"},
+	"handleUpdateVisibility":    {Summary: "Cambiar la visibilidad de los campos del perfil"},
+	"handleGetPreferences":      {Summary: "Obtener las preferencias", Response: UserPreferences{}},
+	"handleUpdatePreferences":   {Summary: "Reemplazar las preferencias", Request: UserPreferences{}, Response: UserPreferences{}},
+	"handleListProfileVersions": {Summary: "Listar las versiones anteriores del perfil", Response: ProfileVersion{}, List: &profileVersionList},
+	"handleRevertProfile":       {Summary: "Restaurar una versión anterior del perfil"},
+	"handleListSessions":        {Summary: "Listar las sesiones abiertas", Response: Session{}, List: &sessionList},
+	"handleRevokeSession":       {Summary: "Cerrar una sesión"},
+}
+
+// mountAPIDocs sirve el documento en /api/openapi.json y Swagger UI en
+// /api/docs. Debe registrarse antes que mountAPI, cuyo alias /api
+// capturaría estas rutas; el documento se genera en la primera petición,
+// cuando el router ya tiene todas las rutas.
+func (s *Server) mountAPIDocs(r *mux.Router) {
+	var (
+		once sync.Once
+		spec []byte
+	)
+	r.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			var err error
+			spec, err = json.Marshal(s.buildOpenAPI(r))
+			if err != nil {
+				log.Printf("❌ Error generando OpenAPI: %v", err)
+			}
+		})
+		if spec == nil {
+			http.Error(w, "Error generando la documentación", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}).Methods("GET")
+
+	r.PathPrefix("/api/docs").Handler(v5emb.New("UserApp API", "/api/openapi.json", "/api/docs/")).Methods("GET")
+}
+
+// openAPIBuilder acumula los esquemas de los tipos con nombre, que se
+// publican en components y se referencian con $ref.
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+func (s *Server) buildOpenAPI(r *mux.Router) map[string]interface{} {
+	prefix := apiPath("")
+	builder := &openAPIBuilder{schemas: map[string]interface{}{
+		"InvalidFields": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"error":   map[string]interface{}{"type": "string", "example": "invalid_fields"},
+				"message": map[string]interface{}{"type": "string"},
+				"fields": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+	}}
+	paths := map[string]map[string]interface{}{}
+	operationIDs := map[string]bool{}
+
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil || !strings.HasPrefix(template, prefix) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path, params := openAPIPath(strings.TrimPrefix(template, prefix))
+		name := handlerName(route.GetHandler())
+		doc := apiDocs[name]
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+
+		for _, method := range methods {
+			base := lowerFirst(strings.TrimPrefix(name, "handle"))
+			operationID := base
+			for i := 2; operationIDs[operationID]; i++ {
+				operationID = base + strconv.Itoa(i)
+			}
+			operationIDs[operationID] = true
+
+			operation := map[string]interface{}{
+				"operationId": operationID,
+				"summary":     doc.Summary,
+				"tags":        []string{openAPITag(path)},
+				"responses":   builder.responses(doc, path),
+			}
+			parameters := params
+			if doc.List != nil {
+				parameters = append(slices.Clone(params), openAPIListParameters(*doc.List)...)
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			if security := openAPISecurity(path); security != nil {
+				operation["security"] = security
+			}
+			if doc.Request != nil {
+				operation["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": builder.schema(reflect.TypeOf(doc.Request))},
+					},
+				}
+			}
+			paths[path][strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "UserApp API",
+			"version": currentAPIVersion,
+		},
+		"servers": []map[string]string{{"url": s.publicBaseURL() + prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": builder.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+// handlerName es el nombre de la función registrada (handleRegister) o el
+// del tipo si el handler es un struct (EmailDomainCheck). Los métodos de
+// Server registrados como s.handleRegister se llaman handleRegister-fm.
+func handlerName(handler http.Handler) string {
+	if fn, ok := handler.(http.HandlerFunc); ok {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		return strings.TrimSuffix(name[strings.LastIndex(name, ".")+1:], "-fm")
+	}
+	return reflect.Indirect(reflect.ValueOf(handler)).Type().Name()
+}
+
+// openAPIPath convierte una plantilla de mux (/avatars/{seed:[0-9a-f]{24}}.svg)
+// en una ruta OpenAPI (/avatars/{seed}.svg) y sus parámetros. La expresión
+// regular de mux, si la hay, pasa a ser el pattern del parámetro.
+func openAPIPath(template string) (string, []map[string]interface{}) {
+	var (
+		path   strings.Builder
+		params []map[string]interface{}
+	)
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			path.WriteByte(template[i])
+			continue
+		}
+		depth, end := 0, i
+		for ; end < len(template); end++ {
+			if template[end] == '{' {
+				depth++
+			} else if template[end] == '}' {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+		}
+		name, pattern, _ := strings.Cut(template[i+1:end], ":")
+		schema := map[string]interface{}{"type": "string"}
+		if pattern != "" {
+			schema["pattern"] = "^" + pattern + "$"
+		}
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+		path.WriteString("{" + name + "}")
+		i = end
+	}
+	return path.String(), params
+}
+
+func openAPITag(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return segment
+}
+
+// openAPISecurity refleja los middlewares de autenticación de cada grupo de
+// rutas (ver registerV1Routes).
+func openAPISecurity(path string) []map[string][]string {
+	switch {
+	case strings.HasPrefix(path, "/admin/"):
+		return []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+	case strings.HasPrefix(path, "/user/{code}"), strings.HasPrefix(path, "/webauthn/register/"):
+		return []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+	case path == "/ws", path == "/events":
+		return []map[string][]string{{"bearerAuth": {}}}
+	}
+	return nil
+}
+
+// openAPIListParameters documenta los parámetros de parseListQuery.
+func openAPIListParameters(opts listOptions) []map[string]interface{} {
+	query := func(name, description string, schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "in": "query", "description": description, "schema": schema}
+	}
+	params := []map[string]interface{}{
+		query("limit", "Elementos por página", map[string]interface{}{"type": "integer", "minimum": 1, "maximum": maxListLimit}),
+		query("offset", "Elementos a saltar", map[string]interface{}{"type": "integer", "minimum": 0}),
+		query("cursor", "next_cursor de la página anterior", map[string]interface{}{"type": "string"}),
+	}
+	if len(opts.Sorts) > 0 {
+		var sorts []string
+		for field := range opts.Sorts {
+			sorts = append(sorts, field, "-"+field)
+		}
+		sort.Strings(sorts)
+		params = append(params, query("sort", "Orden; con \"-\" es descendente", map[string]interface{}{"type": "string", "enum": sorts}))
+	}
+	for _, filter := range opts.Filters {
+		params = append(params, query(filter, "Filtro", map[string]interface{}{"type": "string"}))
+	}
+	return params
+}
+
+func (b *openAPIBuilder) responses(doc apiDoc, path string) map[string]interface{} {
+	success := map[string]interface{}{"description": "Correcto"}
+	if doc.List != nil {
+		items := map[string]interface{}{}
+		if doc.Response != nil {
+			items = b.schema(reflect.TypeOf(doc.Response))
+		}
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"items":       map[string]interface{}{"type": "array", "items": items},
+					"next_cursor": map[string]interface{}{"type": "string", "nullable": true},
+					"total":       map[string]interface{}{"type": "integer"},
+				},
+			}},
+		}
+	} else if doc.Response != nil {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(doc.Response))},
+		}
+	}
+	responses := map[string]interface{}{"200": success}
+	if doc.Request != nil {
+		responses["400"] = map[string]interface{}{"description": "JSON inválido"}
+	}
+	if doc.Request != nil || doc.List != nil {
+		responses["422"] = map[string]interface{}{
+			"description": "Hay campos inválidos",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/InvalidFields"}},
+			},
+		}
+	}
+	if openAPISecurity(path) != nil {
+		responses["401"] = map[string]interface{}{"description": "No autenticado"}
+	}
+	return responses
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// schema genera el esquema JSON de t. Los structs con nombre se guardan en
+// components y se devuelve su $ref.
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == objectIDType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// Se reserva el nombre antes de recorrer los campos por si el
+			// tipo se referencia a sí mismo.
+			b.schemas[t.Name()] = nil
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schema(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			rule, param, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				required = append(required, name)
+			case "email":
+				property["format"] = "email"
+			case "min":
+				property["minLength"], _ = strconv.Atoi(param)
+			case "max":
+				property["maxLength"], _ = strconv.Atoi(param)
+			case "digits":
+				property["pattern"] = "^[0-9]*$"
+			case "oneof":
+				property["enum"] = strings.Fields(param)
+			}
+		}
+		properties[name] = property
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// lowerFirst se usa para los operationId: handleRegister → register.
+func lowerFirst(s string) string {
+	for i, r := range s {
+		return string(unicode.ToLower(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	loginModeCode = "code"
+	loginModeOTP  = "otp"
+	loginModeBoth = "both"
+
+	maxOTPAttempts = 5
+)
+
+var errInvalidOTP = errors.New("código de un solo uso inválido o expirado")
+
+type LoginOTP struct {
+	UserID    primitive.ObjectID `bson:"user_id"`
+	CodeHash  string             `bson:"code_hash"`
+	Attempts  int                `bson:"attempts"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// loginMode lee LOGIN_MODE: "code" (código permanente, por defecto), "otp" o "both".
+func (s *Server) loginMode() string {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	return s.authConfig.LoginMode
+}
+
+func (s *Server) loginModeEnabled(mode string) bool {
+	current := s.loginMode()
+	return current == loginModeBoth || current == mode
+}
+
+func (s *Server) otpTTL() time.Duration {
+	return s.authConfig.OTPTTL
+}
+
+func (s *Server) createOTPIndexes(ctx context.Context) error {
+	_, err := s.database.OTPs.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func generateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// consumeLoginOTP valida el OTP del usuario y lo elimina para que no pueda
+// reutilizarse. Cada intento fallido cuenta contra maxOTPAttempts.
+func (s *Server) consumeLoginOTP(ctx context.Context, email, otp string) (User, error) {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if errors.Is(err, errUserNotFound) {
+		return User{}, errInvalidOTP
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	var stored LoginOTP
+	err = s.database.OTPs.FindOneAndUpdate(ctx,
+		bson.M{
+			"user_id":    user.ID,
+			"expires_at": bson.M{"$gt": time.Now()},
+			"attempts":   bson.M{"$lt": maxOTPAttempts},
+		},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+	).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return User{}, errInvalidOTP
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(hashToken(otp))) != 1 {
+		return User{}, errInvalidOTP
+	}
+
+	if _, err := s.database.OTPs.DeleteOne(ctx, bson.M{"user_id": user.ID}); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// handleRequestOTP envía un código de un solo uso. Cada código admite
+// maxOTPAttempts intentos y los envíos por email están limitados con
+// codeEmailLimiter, así que los intentos por hora también. La respuesta es la
+// misma si el email no está registrado, para no revelar qué cuentas existen.
+func (s *Server) handleRequestOTP(w http.ResponseWriter, r *http.Request) {
+	if !s.loginModeEnabled(loginModeOTP) {
+		http.Error(w, "Login con código de un solo uso deshabilitado", http.StatusNotFound)
+		return
+	}
+
+	var req CodeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	allowed, retryAfter, err := s.codeEmailLimiter.Allow(ctx, "otp:"+strings.ToLower(req.Email))
+	if err != nil {
+		log.Printf("Error consultando límite de envíos: %v", err)
+		http.Error(w, "Error interno", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Demasiados envíos para este email, inténtalo más tarde", http.StatusTooManyRequests)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":    "Si el email está registrado, recibirás un código de un solo uso.",
+		"expires_in": int(s.otpTTL().Seconds()),
+	}
+
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
+	if errors.Is(err, errUserNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	otp, err := generateOTP()
+	if err != nil {
+		log.Printf("Error generando OTP: %v", err)
+		http.Error(w, "Error generando código", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	_, err = s.database.OTPs.ReplaceOne(ctx,
+		bson.M{"user_id": user.ID},
+		LoginOTP{
+			UserID:    user.ID,
+			CodeHash:  hashToken(otp),
+			Attempts:  0,
+			CreatedAt: now,
+			ExpiresAt: now.Add(s.otpTTL()),
+		},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Error guardando OTP: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.sendEmail(req.Email, otp); err != nil {
+		log.Printf("❌ Error enviando OTP: %v", err)
+		http.Error(w, "Error enviando código", http.StatusInternalServerError)
+		return
+	}
+
+	if s.showDevCodes() {
+		response["dev_code"] = otp
+		response["dev_note"] = "Sin proveedor de email - código mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/events"
+)
+
+// Webhooks salientes: los integradores registran un endpoint con los eventos
+// que les interesan y cada evento del bus (ver eventbus.go) se les envía firmado como los webhooks de
+// Svix (cabeceras webhook-id, webhook-timestamp y webhook-signature). Cada
+// envío a un endpoint es una entrega que se guarda en webhook_deliveries con
+// su resultado; si falla se reintenta con espera creciente hasta
+// WEBHOOK_MAX_ATTEMPTS. Los webhooks que recibimos de Resend están en
+// webhooks.go.
+
+const (
+	webhookStatusPending   = "pending"
+	webhookStatusDelivered = "delivered"
+	webhookStatusFailed    = "failed"
+
+	webhookSecretPrefix = "whsec_"
+
+	// Cada cuánto se buscan entregas pendientes de reintento y cuántas se
+	// reintentan como mucho en cada pasada.
+	webhookPollInterval     = 15 * time.Second
+	maxWebhookRetriesPerRun = 100
+
+	// webhookMaxBackoff limita la espera entre reintentos.
+	webhookMaxBackoff = 12 * time.Hour
+
+	// Lo que se guarda de la respuesta del endpoint para el registro.
+	webhookResponseSnippet = 1 << 10
+)
+
+var webhookEvents = []string{eventUserCreated, eventUserUpdated, eventUserDeleted}
+
+type WebhookEndpoint struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL         string             `json:"url" bson:"url"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Events      []string           `json:"events" bson:"events"`
+	Secret      string             `json:"-" bson:"secret"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// CreateWebhookRequest registra un endpoint. Si no se envía Secret se genera
+// uno; debe tener el formato de Svix (whsec_ y una clave en base64).
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,max=2048"`
+	Description string   `json:"description,omitempty" validate:"max=200"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret,omitempty" validate:"max=200"`
+}
+
+// WebhookDelivery es el envío de un evento a un endpoint. Payload es el
+// cuerpo tal cual se firmó, igual en todos los intentos.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	EndpointID     primitive.ObjectID `json:"endpoint_id" bson:"endpoint_id"`
+	EventID        string             `json:"event_id" bson:"event_id"`
+	Event          string             `json:"event" bson:"event"`
+	UserID         string             `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	ResponseStatus int                `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	ResponseBody   string             `json:"response_body,omitempty" bson:"response_body,omitempty"`
+	Error          string             `json:"error,omitempty" bson:"error,omitempty"`
+	NextAttemptAt  *time.Time         `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at"`
+	DeliveredAt    *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	ExpiresAt      *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+func (s *Server) createWebhookIndexes(ctx context.Context) error {
+	_, err := s.database.WebhookEndpoints.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "events", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.database.WebhookDeliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "endpoint_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "endpoint_id", Value: 1}, {Key: "event_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		// Las entregas terminadas se borran pasado WEBHOOK_RETENTION: el
+		// payload lleva los datos del usuario.
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func newWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// webhookKey es la clave HMAC de un secreto whsec_.
+func webhookKey(secret string) ([]byte, error) {
+	if !strings.HasPrefix(secret, webhookSecretPrefix) {
+		return nil, errors.New("debe empezar por " + webhookSecretPrefix)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, webhookSecretPrefix))
+	if err != nil {
+		return nil, errors.New("la clave debe estar en base64")
+	}
+	if len(key) < 24 {
+		return nil, errors.New("la clave debe tener al menos 24 bytes")
+	}
+	return key, nil
+}
+
+// webhookLease es cuánto se reserva una entrega mientras se envía, para que
+// el reintento periódico no la envíe a la vez. Si la instancia cae a mitad
+// del envío, la entrega se reintenta al acabar la reserva.
+func (s *Server) webhookLease() time.Duration {
+	return 2 * s.webhooksConfig.Timeout
+}
+
+// webhookBackoff es la espera tras el intento número attempts:
+// WEBHOOK_RETRY_INTERVAL, el doble, el cuádruple...
+func (s *Server) webhookBackoff(attempts int) time.Duration {
+	backoff := s.webhooksConfig.RetryInterval
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+// queueWebhookEvent es el consumidor del bus para los webhooks: crea una
+// entrega del evento para cada endpoint suscrito y hace el primer intento en
+// segundo plano. Si el bus entrega el mismo evento dos veces, el índice
+// único de event_id evita la segunda entrega.
+func (s *Server) queueWebhookEvent(ctx context.Context, event events.Event) error {
+	cursor, err := s.database.WebhookEndpoints.Find(ctx, bson.M{"events": event.Type})
+	if err != nil {
+		return err
+	}
+	var endpoints []WebhookEndpoint
+	if err := cursor.All(ctx, &endpoints); err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         event.ID,
+		"type":       event.Type,
+		"created_at": event.OccurredAt,
+		"data":       event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	nextAttempt := now.Add(s.webhookLease())
+	var errs []error
+	for _, endpoint := range endpoints {
+		delivery := WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			EventID:       event.ID,
+			Event:         event.Type,
+			UserID:        event.UserID,
+			Payload:       string(payload),
+			Status:        webhookStatusPending,
+			NextAttemptAt: &nextAttempt,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		result, err := s.database.WebhookDeliveries.InsertOne(ctx, delivery)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error guardando la entrega a %s: %w", endpoint.URL, err))
+			continue
+		}
+		delivery.ID = result.InsertedID.(primitive.ObjectID)
+		go s.deliverWebhook(delivery, endpoint)
+	}
+	return errors.Join(errs...)
+}
+
+// deliverWebhook hace un intento de entrega y guarda su resultado.
+func (s *Server) deliverWebhook(delivery WebhookDelivery, endpoint WebhookEndpoint) {
+	status, body, err := s.sendWebhook(delivery, endpoint)
+	s.recordWebhookAttempt(delivery, endpoint, status, body, err)
+}
+
+func (s *Server) sendWebhook(delivery WebhookDelivery, endpoint WebhookEndpoint) (int, string, error) {
+	key, err := webhookKey(endpoint.Secret)
+	if err != nil {
+		return 0, "", fmt.Errorf("secreto inválido: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.webhooksConfig.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := webhookSignature(key, delivery.EventID, timestamp, []byte(delivery.Payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "userapp-webhooks/1")
+	req.Header.Set("webhook-id", delivery.EventID)
+	req.Header.Set("webhook-timestamp", timestamp)
+	req.Header.Set("webhook-signature", "v1,"+base64.StdEncoding.EncodeToString(signature))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseSnippet))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(snippet), fmt.Errorf("el endpoint respondió %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(snippet), nil
+}
+
+func (s *Server) recordWebhookAttempt(delivery WebhookDelivery, endpoint WebhookEndpoint, status int, body string, sendErr error) {
+	now := time.Now()
+	attempts := delivery.Attempts + 1
+	set := bson.M{
+		"attempts":        attempts,
+		"response_status": status,
+		"response_body":   body,
+		"updated_at":      now,
+	}
+	unset := bson.M{}
+
+	switch {
+	case sendErr == nil:
+		set["status"] = webhookStatusDelivered
+		set["delivered_at"] = now
+		set["expires_at"] = now.Add(s.webhooksConfig.Retention)
+		unset["error"] = ""
+		unset["next_attempt_at"] = ""
+		log.Printf("✅ Webhook %s entregado a %s", delivery.Event, endpoint.URL)
+	case attempts >= s.webhooksConfig.MaxAttempts:
+		set["status"] = webhookStatusFailed
+		set["error"] = sendErr.Error()
+		set["expires_at"] = now.Add(s.webhooksConfig.Retention)
+		unset["next_attempt_at"] = ""
+		log.Printf("❌ Webhook %s a %s abandonado tras %d intentos: %v", delivery.Event, endpoint.URL, attempts, sendErr)
+	default:
+		set["status"] = webhookStatusPending
+		set["error"] = sendErr.Error()
+		set["next_attempt_at"] = now.Add(s.webhookBackoff(attempts))
+		log.Printf("⚠️  Intento %d del webhook %s a %s fallido: %v", attempts, delivery.Event, endpoint.URL, sendErr)
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.database.WebhookDeliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, update); err != nil {
+		log.Printf("⚠️  Error actualizando la entrega del webhook: %v", err)
+	}
+}
+
+// runWebhookRetries reintenta periódicamente las entregas fallidas cuya
+// espera ha terminado.
+func (s *Server) runWebhookRetries() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		retried, err := s.retryWebhookDeliveries()
+		if err != nil {
+			log.Printf("❌ Error reintentando webhooks: %v", err)
+		}
+		if retried > 0 {
+			log.Printf("🔁 %d entregas de webhooks reintentadas", retried)
+		}
+	}
+}
+
+// retryWebhookDeliveries reserva cada entrega antes de enviarla, así varias
+// instancias no reintentan la misma.
+func (s *Server) retryWebhookDeliveries() (int, error) {
+	retried := 0
+	for retried < maxWebhookRetriesPerRun {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		now := time.Now()
+		var delivery WebhookDelivery
+		err := s.database.WebhookDeliveries.FindOneAndUpdate(ctx,
+			bson.M{"status": webhookStatusPending, "next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"next_attempt_at": now.Add(s.webhookLease())}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}),
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			cancel()
+			return retried, nil
+		}
+		if err != nil {
+			cancel()
+			return retried, err
+		}
+
+		var endpoint WebhookEndpoint
+		err = s.database.WebhookEndpoints.FindOne(ctx, bson.M{"_id": delivery.EndpointID}).Decode(&endpoint)
+		cancel()
+		if err == mongo.ErrNoDocuments {
+			s.abandonWebhookDeliveries(bson.M{"_id": delivery.ID})
+			continue
+		}
+		if err != nil {
+			return retried, err
+		}
+
+		s.deliverWebhook(delivery, endpoint)
+		retried++
+	}
+	return retried, nil
+}
+
+// abandonWebhookDeliveries da por fallidas las entregas pendientes de un
+// endpoint eliminado.
+func (s *Server) abandonWebhookDeliveries(filter bson.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter["status"] = webhookStatusPending
+	now := time.Now()
+	_, err := s.database.WebhookDeliveries.UpdateMany(ctx, filter, bson.M{
+		"$set":   bson.M{"status": webhookStatusFailed, "error": "endpoint eliminado", "updated_at": now, "expires_at": now.Add(s.webhooksConfig.Retention)},
+		"$unset": bson.M{"next_attempt_at": ""},
+	})
+	if err != nil {
+		log.Printf("⚠️  Error cancelando entregas de webhooks: %v", err)
+	}
+}
+
+// validateWebhook comprueba la URL y los eventos de un endpoint nuevo. Fuera
+// de DEV_MODE solo se aceptan URLs https.
+func (s *Server) validateWebhook(req CreateWebhookRequest) map[string]string {
+	invalid := validateStruct(req)
+	if _, ok := invalid["url"]; !ok {
+		parsed, err := url.Parse(req.URL)
+		switch {
+		case err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http"):
+			invalid["url"] = "debe ser una URL http(s) absoluta"
+		case parsed.Scheme == "http" && !s.devMode():
+			invalid["url"] = "debe usar https"
+		}
+	}
+
+	if len(req.Events) == 0 {
+		invalid["events"] = "se requiere al menos un evento"
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhookEvents, event) {
+			invalid["events"] = "evento desconocido: " + event
+			break
+		}
+	}
+
+	if _, ok := invalid["secret"]; !ok && req.Secret != "" {
+		if _, err := webhookKey(req.Secret); err != nil {
+			invalid["secret"] = err.Error()
+		}
+	}
+	return invalid
+}
+
+func (s *Server) handleAdminCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if invalid := s.validateWebhook(req); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		secret, err = newWebhookSecret()
+		if err != nil {
+			log.Printf("Error generando secreto de webhook: %v", err)
+			http.Error(w, "Error generando secreto", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	endpoint := WebhookEndpoint{
+		URL:         req.URL,
+		Description: req.Description,
+		Events:      slices.Compact(slices.Sorted(slices.Values(req.Events))),
+		Secret:      secret,
+		CreatedAt:   time.Now(),
+	}
+	result, err := s.database.WebhookEndpoints.InsertOne(ctx, endpoint)
+	if err != nil {
+		log.Printf("Error guardando webhook: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	endpoint.ID = result.InsertedID.(primitive.ObjectID)
+
+	log.Printf("✅ Webhook registrado: %s (%s)", endpoint.URL, strings.Join(endpoint.Events, ", "))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Webhook registrado. Guarda el secreto, no se volverá a mostrar.",
+		"secret":  secret,
+		"webhook": endpoint,
+	})
+}
+
+var webhookList = listOptions{
+	Sorts:       map[string]bool{"created_at": true},
+	DefaultSort: "-created_at",
+}
+
+func (s *Server) handleAdminListWebhooks(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), webhookList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	endpoints := []WebhookEndpoint{}
+	total, err := findList(ctx, s.database.WebhookEndpoints, bson.M{}, list, &endpoints)
+	if err != nil {
+		log.Printf("Error listando webhooks: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, endpoints, len(endpoints), total))
+}
+
+// handleAdminDeleteWebhook da de baja un endpoint. Sus entregas se conservan
+// en el registro y las pendientes se dan por fallidas.
+func (s *Server) handleAdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Webhook no encontrado", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.database.WebhookEndpoints.DeleteOne(ctx, bson.M{"_id": endpointID})
+	if err != nil {
+		log.Printf("Error eliminando webhook: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Webhook no encontrado", http.StatusNotFound)
+		return
+	}
+
+	s.abandonWebhookDeliveries(bson.M{"endpoint_id": endpointID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Webhook eliminado correctamente",
+	})
+}
+
+var webhookDeliveryList = listOptions{
+	Sorts:       map[string]bool{"created_at": true, "updated_at": true},
+	DefaultSort: "-created_at",
+	Filters:     []string{"status", "event", "event_id"},
+}
+
+// handleAdminListWebhookDeliveries es el registro de entregas de un
+// endpoint, filtrable por ?status=, ?event= y ?event_id=.
+func (s *Server) handleAdminListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Webhook no encontrado", http.StatusNotFound)
+		return
+	}
+
+	list, invalid := parseListQuery(r.URL.Query(), webhookDeliveryList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	deliveries := []WebhookDelivery{}
+	total, err := findList(ctx, s.database.WebhookDeliveries, bson.M{"endpoint_id": endpointID}, list, &deliveries)
+	if err != nil {
+		log.Printf("Error listando entregas de webhooks: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, deliveries, len(deliveries), total))
+}
+
+// handleAdminRetryWebhookDelivery vuelve a poner en cola una entrega
+// fallida, por ejemplo cuando el integrador ha arreglado su endpoint. Cuenta
+// como una entrega nueva: tiene otra vez todos los intentos.
+func (s *Server) handleAdminRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Entrega no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var delivery WebhookDelivery
+	err = s.database.WebhookDeliveries.FindOne(ctx, bson.M{"_id": deliveryID}).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Entrega no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo entrega de webhook: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	var endpoint WebhookEndpoint
+	err = s.database.WebhookEndpoints.FindOne(ctx, bson.M{"_id": delivery.EndpointID}).Decode(&endpoint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "El webhook de esta entrega ya no existe", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo webhook: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	// Se pasa a pending de forma atómica para que dos reintentos simultáneos
+	// no la envíen dos veces.
+	now := time.Now()
+	err = s.database.WebhookDeliveries.FindOneAndUpdate(ctx,
+		bson.M{"_id": deliveryID, "status": webhookStatusFailed},
+		bson.M{
+			"$set":   bson.M{"status": webhookStatusPending, "attempts": 0, "next_attempt_at": now.Add(s.webhookLease()), "updated_at": now},
+			"$unset": bson.M{"error": "", "expires_at": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Solo se pueden reintentar las entregas fallidas", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reintentando entrega de webhook: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	go s.deliverWebhook(delivery, endpoint)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Entrega reintentada",
+		"delivery": delivery,
+	})
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/events"
+)
+
+// Outbox de eventos: todo evento se guarda en la colección outbox antes de
+// publicarlo y solo se marca como publicado cuando el bus lo acepta, así que
+// una caída del bus o del proceso no lo pierde; runOutboxRelay publica lo que
+// quede pendiente. La entrega es al menos una vez: un evento publicado justo
+// antes de una caída se vuelve a publicar.
+//
+// user.created además se guarda antes de crear el usuario (staged) y se
+// confirma después (ready). Los usuarios pueden vivir en otra base de datos
+// (DB_DRIVER), así que no hay una transacción común: si el proceso cae entre
+// las dos escrituras, el relay comprueba si el usuario llegó a crearse y
+// publica o descarta el evento. El resto de eventos se guardan justo después
+// de la operación.
+
+const (
+	outboxStatusStaged    = "staged"
+	outboxStatusReady     = "ready"
+	outboxStatusPublished = "published"
+
+	// outboxLease es cuánto se reserva un evento mientras se publica, y
+	// outboxStagedGrace cuánto se espera a que la operación confirme un evento
+	// staged antes de comprobarlo.
+	outboxLease       = 30 * time.Second
+	outboxStagedGrace = time.Minute
+	outboxRetryDelay  = 30 * time.Second
+
+	// Los eventos publicados se conservan un tiempo para poder consultarlos.
+	outboxRetention = 7 * 24 * time.Hour
+
+	outboxPollInterval    = 5 * time.Second
+	maxOutboxEventsPerRun = 500
+)
+
+type OutboxEntry struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	EventID     string             `bson:"event_id"`
+	Type        string             `bson:"type"`
+	UserID      string             `bson:"user_id,omitempty"`
+	Data        string             `bson:"data"`
+	OccurredAt  time.Time          `bson:"occurred_at"`
+	Status      string             `bson:"status"`
+	Attempts    int                `bson:"attempts"`
+	Error       string             `bson:"error,omitempty"`
+	LockedUntil time.Time          `bson:"locked_until"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	PublishedAt *time.Time         `bson:"published_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty"`
+}
+
+func (e OutboxEntry) event() events.Event {
+	return events.Event{
+		ID:         e.EventID,
+		Type:       e.Type,
+		UserID:     e.UserID,
+		Data:       []byte(e.Data),
+		OccurredAt: e.OccurredAt,
+	}
+}
+
+// outboxCheck devuelve la comprobación de si la operación de un evento
+// staged del tipo eventType llegó a completarse, o nil si no tiene.
+func (s *Server) outboxCheck(eventType string) func(ctx context.Context, entry OutboxEntry) (bool, error) {
+	switch eventType {
+	case eventUserCreated:
+		return s.userCreatedCompleted
+	}
+	return nil
+}
+
+func (s *Server) userCreatedCompleted(ctx context.Context, entry OutboxEntry) (bool, error) {
+	userID, err := primitive.ObjectIDFromHex(entry.UserID)
+	if err != nil {
+		return false, nil
+	}
+	_, err = s.userRepo.FindByID(ctx, userID)
+	if errors.Is(err, errUserNotFound) || err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Server) createOutboxIndexes(ctx context.Context) error {
+	_, err := s.database.Outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "locked_until", Value: 1}}},
+		{Keys: bson.D{{Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// writeOutbox guarda el evento reservado durante lock, para que el relay no
+// lo tome mientras lo publica quien lo ha guardado.
+func (s *Server) writeOutbox(ctx context.Context, event events.Event, status string, lock time.Duration) (OutboxEntry, error) {
+	now := time.Now()
+	entry := OutboxEntry{
+		EventID:     event.ID,
+		Type:        event.Type,
+		UserID:      event.UserID,
+		Data:        string(event.Data),
+		OccurredAt:  event.OccurredAt,
+		Status:      status,
+		LockedUntil: now.Add(lock),
+		CreatedAt:   now,
+	}
+	result, err := s.database.Outbox.InsertOne(ctx, entry)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+	return entry, nil
+}
+
+// stageEvent guarda un evento antes de la operación que lo produce. Si no se
+// puede guardar, la operación no debe hacerse: el evento se perdería.
+func (s *Server) stageEvent(ctx context.Context, event events.Event) (OutboxEntry, error) {
+	entry, err := s.writeOutbox(ctx, event, outboxStatusStaged, outboxStagedGrace)
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("error guardando el evento %s en el outbox: %w", event.Type, err)
+	}
+	return entry, nil
+}
+
+// confirmEvent marca un evento staged como listo tras completar la operación
+// y lo publica. Si falla, el relay lo confirmará al comprobar la operación.
+func (s *Server) confirmEvent(ctx context.Context, entry OutboxEntry) {
+	ctx, cancel := detach(ctx, 10*time.Second)
+	defer cancel()
+
+	lockedUntil := time.Now().Add(outboxLease)
+	_, err := s.database.Outbox.UpdateOne(ctx,
+		bson.M{"_id": entry.ID, "status": outboxStatusStaged},
+		bson.M{"$set": bson.M{"status": outboxStatusReady, "locked_until": lockedUntil}},
+	)
+	if err != nil {
+		log.Printf("⚠️  Error confirmando el evento %s en el outbox: %v", entry.Type, err)
+		return
+	}
+	entry.Status = outboxStatusReady
+	if s.relayEvents {
+		s.relayOutboxEntry(ctx, entry)
+	}
+}
+
+// discardEvent borra un evento staged cuya operación ha fallado.
+func (s *Server) discardEvent(ctx context.Context, entry OutboxEntry) {
+	ctx, cancel := detach(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := s.database.Outbox.DeleteOne(ctx, bson.M{"_id": entry.ID, "status": outboxStatusStaged}); err != nil {
+		log.Printf("⚠️  Error descartando el evento %s del outbox: %v", entry.Type, err)
+	}
+}
+
+// relayOutboxEntry publica un evento reservado y guarda el resultado.
+func (s *Server) relayOutboxEntry(ctx context.Context, entry OutboxEntry) {
+	now := time.Now()
+	update := bson.M{"$inc": bson.M{"attempts": 1}}
+	if err := s.eventBus.Publish(ctx, entry.event()); err != nil {
+		log.Printf("⚠️  Error publicando el evento %s (%s): %v", entry.Type, entry.EventID, err)
+		update["$set"] = bson.M{"error": err.Error(), "locked_until": now.Add(outboxRetryDelay)}
+	} else {
+		update["$set"] = bson.M{
+			"status":       outboxStatusPublished,
+			"published_at": now,
+			"expires_at":   now.Add(outboxRetention),
+		}
+		update["$unset"] = bson.M{"error": ""}
+	}
+
+	// Se guarda aunque el contexto de la petición haya terminado: si no, el
+	// evento se volvería a publicar.
+	ctx, cancel := detach(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := s.database.Outbox.UpdateOne(ctx, bson.M{"_id": entry.ID}, update); err != nil {
+		log.Printf("⚠️  Error actualizando el outbox: %v", err)
+	}
+}
+
+// runOutboxRelay publica periódicamente los eventos que no se pudieron
+// publicar al guardarlos y resuelve los staged abandonados.
+func (s *Server) runOutboxRelay() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		relayed, err := s.relayOutbox()
+		if err != nil {
+			log.Printf("❌ Error publicando eventos del outbox: %v", err)
+		}
+		if relayed > 0 {
+			log.Printf("📤 %d eventos del outbox publicados", relayed)
+		}
+	}
+}
+
+// relayOutbox reserva cada evento antes de publicarlo, así varias instancias
+// no publican el mismo.
+func (s *Server) relayOutbox() (int, error) {
+	relayed := 0
+	for relayed < maxOutboxEventsPerRun {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		now := time.Now()
+		var entry OutboxEntry
+		err := s.database.Outbox.FindOneAndUpdate(ctx,
+			bson.M{
+				"status":       bson.M{"$in": []string{outboxStatusStaged, outboxStatusReady}},
+				"locked_until": bson.M{"$lte": now},
+			},
+			bson.M{"$set": bson.M{"locked_until": now.Add(outboxLease)}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "_id", Value: 1}}),
+		).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			cancel()
+			return relayed, nil
+		}
+		if err != nil {
+			cancel()
+			return relayed, err
+		}
+
+		if entry.Status == outboxStatusStaged {
+			if !s.resolveStagedEvent(ctx, entry) {
+				cancel()
+				continue
+			}
+		}
+		s.relayOutboxEntry(ctx, entry)
+		cancel()
+		relayed++
+	}
+	return relayed, nil
+}
+
+// resolveStagedEvent comprueba si la operación de un evento staged se
+// completó: si es así lo marca como listo para publicarlo y si no lo borra.
+// Devuelve true si hay que publicarlo.
+func (s *Server) resolveStagedEvent(ctx context.Context, entry OutboxEntry) bool {
+	check := s.outboxCheck(entry.Type)
+	completed := false
+	if check != nil {
+		var err error
+		completed, err = check(ctx, entry)
+		if err != nil {
+			// Se vuelve a comprobar cuando termine la reserva.
+			log.Printf("⚠️  Error comprobando el evento %s del outbox: %v", entry.Type, err)
+			return false
+		}
+	}
+
+	if !completed {
+		log.Printf("🗑️  Evento %s (%s) descartado: la operación no llegó a completarse", entry.Type, entry.EventID)
+		if _, err := s.database.Outbox.DeleteOne(ctx, bson.M{"_id": entry.ID, "status": outboxStatusStaged}); err != nil {
+			log.Printf("⚠️  Error descartando el evento %s del outbox: %v", entry.Type, err)
+		}
+		return false
+	}
+
+	if _, err := s.database.Outbox.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{"$set": bson.M{"status": outboxStatusReady}}); err != nil {
+		log.Printf("⚠️  Error confirmando el evento %s del outbox: %v", entry.Type, err)
+		return false
+	}
+	log.Printf("📤 Evento %s (%s) recuperado del outbox", entry.Type, entry.EventID)
+	return true
+}
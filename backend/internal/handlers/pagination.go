@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/store"
+)
+
+// Paginación, orden y filtros comunes a todos los listados:
+//
+//	?limit=N     elementos por página (1-100, 100 por defecto)
+//	?offset=N    elementos a saltar
+//	?cursor=X    next_cursor de la página anterior (no se combina con offset)
+//	?sort=campo  uno de los permitidos; con "-" delante es descendente
+//
+// más los filtros de cada listado. La respuesta es siempre
+// {"items": [...], "next_cursor": "..." o null, "total": N}.
+
+const maxListLimit = 100
+
+// listParams son los parámetros de paginación, que nunca se toman por filtros.
+var listParams = map[string]bool{"limit": true, "offset": true, "cursor": true, "sort": true}
+
+// listOptions describe lo que acepta un listado. Los campos de Sorts y
+// Filters se llaman igual en la query y en la base de datos; los filtros
+// buscan por igualdad.
+type listOptions struct {
+	Sorts map[string]bool
+	// DefaultSort vacío ordena por _id.
+	DefaultSort string
+	Filters     []string
+}
+
+type listQuery struct {
+	Limit   int
+	Offset  int
+	Sort    string
+	Filters map[string]string
+}
+
+// parseListQuery devuelve la consulta y el error de cada parámetro inválido,
+// incluidos los que el listado no acepta.
+func parseListQuery(query url.Values, opts listOptions) (listQuery, map[string]string) {
+	list := listQuery{Limit: maxListLimit, Sort: opts.DefaultSort, Filters: map[string]string{}}
+	invalid := map[string]string{}
+
+	for param := range query {
+		if !listParams[param] && !slices.Contains(opts.Filters, param) {
+			invalid[param] = "parámetro no permitido"
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			invalid["limit"] = "debe ser un entero entre 1 y 100"
+		}
+		list.Limit = limit
+	}
+
+	switch offset, cursor := query.Get("offset"), query.Get("cursor"); {
+	case offset != "" && cursor != "":
+		invalid["cursor"] = "no se puede combinar con offset"
+	case offset != "":
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 {
+			invalid["offset"] = "debe ser un entero mayor o igual que 0"
+		}
+		list.Offset = value
+	case cursor != "":
+		value, ok := decodeListCursor(cursor)
+		if !ok {
+			invalid["cursor"] = "cursor inválido"
+		}
+		list.Offset = value
+	}
+
+	if value := query.Get("sort"); value != "" {
+		if !opts.Sorts[strings.TrimPrefix(value, "-")] {
+			invalid["sort"] = "orden no permitido"
+		}
+		list.Sort = value
+	}
+
+	for _, name := range opts.Filters {
+		if value := query.Get(name); value != "" {
+			list.Filters[name] = value
+		}
+	}
+	return list, invalid
+}
+
+// El cursor es opaco para el cliente aunque por ahora solo guarde el offset:
+// así se puede cambiar a paginación por clave sin romper la API.
+func encodeListCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+func decodeListCursor(cursor string) (int, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	value, ok := strings.CutPrefix(string(data), "o:")
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(value)
+	return offset, err == nil && offset >= 0
+}
+
+// listResponse es el cuerpo de un listado; los handlers pueden añadirle
+// campos propios.
+func listResponse(list listQuery, items interface{}, count int, total int64) map[string]interface{} {
+	var next interface{}
+	if end := list.Offset + count; count > 0 && int64(end) < total {
+		next = encodeListCursor(end)
+	}
+	return map[string]interface{}{
+		"items":       items,
+		"next_cursor": next,
+		"total":       total,
+	}
+}
+
+// pageBounds devuelve el tramo de una lista en memoria de n elementos.
+func pageBounds(list listQuery, n int) (int, int) {
+	start := min(list.Offset, n)
+	return start, min(start+list.Limit, n)
+}
+
+// mongoListSort desempata por _id para que la paginación sea estable.
+func mongoListSort(sort string) bson.D {
+	if sort == "" {
+		return bson.D{{Key: "_id", Value: 1}}
+	}
+	direction := 1
+	if strings.HasPrefix(sort, "-") {
+		sort, direction = sort[1:], -1
+	}
+	return bson.D{{Key: sort, Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// findList añade a filter los filtros de la consulta, cuenta los documentos
+// y decodifica en items la página pedida.
+func findList(ctx context.Context, collection store.Collection, filter bson.M, list listQuery, items interface{}) (int64, error) {
+	for name, value := range list.Filters {
+		filter[name] = value
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(mongoListSort(list.Sort)).
+		SetSkip(int64(list.Offset)).
+		SetLimit(int64(list.Limit)))
+	if err != nil {
+		return 0, err
+	}
+	return total, cursor.All(ctx, items)
+}
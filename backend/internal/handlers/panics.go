@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics convierte un panic en un handler en un 500 con el formato de
+// error habitual, en vez de cortar la conexión sin respuesta. El stack se
+// registra con el request_id para poder encontrarlo desde la línea de acceso.
+// Es el primer eslabón de la cadena (ver middleware.go): crea el requestInfo
+// que luego rellenan assignRequestID y el router, para leer de él el
+// request_id y la plantilla de la ruta; las subpeticiones de un batch ya
+// llegan con el suyo. Como en logRequests, no se registra
+// la URL, que puede llevar códigos o tokens.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := requestInfoFromContext(r.Context())
+		if info == nil {
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// ErrAbortHandler es la forma de cortar una respuesta a propósito
+			// (httputil.ReverseProxy); net/http ya lo trata sin ruido.
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+
+			s.recoveredPanics.Add(1)
+			slog.Error("❌ Panic atendiendo la petición",
+				"request_id", info.ID,
+				"panic", fmt.Sprint(recovered),
+				"method", r.Method,
+				"route", info.Route,
+				"stack", string(debug.Stack()),
+			)
+
+			// Si la respuesta ya había empezado no se puede cambiar el estado;
+			// solo queda cortarla para que el cliente no la tome por completa.
+			if recorder.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Error interno")
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	preferenceThemes    = map[string]bool{"light": true, "dark": true, "system": true}
+	preferenceLanguages = map[string]bool{"es": true, "en": true}
+)
+
+// UserPreferences se guarda como subdocumento del usuario (preferences).
+type UserPreferences struct {
+	Email    EmailPreferences `json:"email" bson:"email"`
+	Theme    string           `json:"theme" bson:"theme,omitempty"`
+	Language string           `json:"language" bson:"language,omitempty"`
+}
+
+// EmailPreferences son los emails opcionales que el usuario acepta recibir.
+// Los de acceso y seguridad se envían siempre.
+type EmailPreferences struct {
+	Reminders      bool `json:"reminders" bson:"reminders"`
+	ProductUpdates bool `json:"product_updates" bson:"product_updates"`
+}
+
+// userPreferences completa con los valores por defecto lo que el usuario no
+// ha configurado. Sin preferencias guardadas, los recordatorios siguen a
+// reminders_opt_out.
+func userPreferences(user User) UserPreferences {
+	if user.Preferences == nil {
+		return UserPreferences{
+			Email:    EmailPreferences{Reminders: !user.RemindersOptOut},
+			Theme:    "system",
+			Language: "es",
+		}
+	}
+	prefs := *user.Preferences
+	if prefs.Theme == "" {
+		prefs.Theme = "system"
+	}
+	if prefs.Language == "" {
+		prefs.Language = "es"
+	}
+	return prefs
+}
+
+func (p UserPreferences) validate() map[string]string {
+	invalid := map[string]string{}
+	if !preferenceThemes[p.Theme] {
+		invalid["theme"] = "debe ser light, dark o system"
+	}
+	if !preferenceLanguages[p.Language] {
+		invalid["language"] = "debe ser es o en"
+	}
+	return invalid
+}
+
+func (s *Server) handleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userPreferences(user))
+}
+
+// handleUpdatePreferences reemplaza las preferencias completas; los campos
+// que falten toman su valor por defecto.
+func (s *Server) handleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	prefs := UserPreferences{Theme: "system", Language: "es"}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, multipartOverhead))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&prefs)
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("JSON inválido: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if invalid := prefs.validate(); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.changeRouteUser(ctx, r, func(user *User) error {
+		user.Preferences = &prefs
+		user.RemindersOptOut = !prefs.Email.Reminders
+		return nil
+	})
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error guardando preferencias: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Preferencias actualizadas correctamente",
+		"preferences": userPreferences(user),
+	})
+}
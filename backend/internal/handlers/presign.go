@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	tokenPurposeAvatarUpload = "avatar_upload"
+
+	presignedUploadTTL = 15 * time.Minute
+)
+
+type PresignRequest struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+type ConfirmUploadRequest struct {
+	UploadID string       `json:"upload_id"`
+	Crop     *CropRequest `json:"crop,omitempty"`
+}
+
+// presignedUploadKey deriva la clave del objeto del upload_id, así no hace
+// falta guardarla: solo quien tiene el token conoce dónde se subió.
+func presignedUploadKey(userID primitive.ObjectID, uploadID string) string {
+	return privateStoragePrefix + userID.Hex() + "-" + hashToken(uploadID)[:24]
+}
+
+// handlePresignAvatarUpload devuelve una URL firmada para subir el avatar
+// directamente al almacenamiento. Después hay que llamar a /image/confirm.
+func (s *Server) handlePresignAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	presigner, ok := s.storage.(storagePresigner)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "presign_unsupported",
+			"El almacenamiento configurado no admite subidas directas, usa PUT /api/user/{code}")
+		return
+	}
+
+	var req PresignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := allowedImageTypes[req.ContentType]; !ok {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_image", errUnsupportedImage.Error())
+		return
+	}
+	if limit := s.maxUploadSize(); req.Size <= 0 || req.Size > limit {
+		writeUploadTooLarge(w, limit)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findRouteUser(ctx, r)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	uploadID, err := s.createActionToken(ctx, user.ID, tokenPurposeAvatarUpload, presignedUploadTTL)
+	if err != nil {
+		log.Printf("Error creando upload: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	uploadURL, headers, err := presigner.PresignPut(presignedUploadKey(user.ID, uploadID), req.ContentType, req.Size, presignedUploadTTL)
+	if err != nil {
+		log.Printf("Error firmando URL de subida: %v", err)
+		http.Error(w, "Error generando URL de subida", http.StatusInternalServerError)
+		return
+	}
+
+	responseHeaders := map[string]string{}
+	for name := range headers {
+		responseHeaders[name] = headers.Get(name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upload_id":  uploadID,
+		"upload_url": uploadURL,
+		"method":     "PUT",
+		"headers":    responseHeaders,
+		"expires_in": int(presignedUploadTTL.Seconds()),
+	})
+}
+
+// handleConfirmAvatarUpload procesa la imagen subida con la URL firmada igual
+// que una subida normal (validación, EXIF, WebP) y la asigna como avatar.
+func (s *Server) handleConfirmAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if req.UploadID == "" {
+		http.Error(w, "upload_id requerido", http.StatusBadRequest)
+		return
+	}
+
+	crop, err := req.Crop.Rect()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_crop", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.findRouteUser(ctx, r)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	// Se comprueba que el archivo exista antes de consumir el token, para
+	// que el cliente pueda reintentar si confirma antes de terminar la subida.
+	key := presignedUploadKey(user.ID, req.UploadID)
+	data, err := s.storage.Get(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "La imagen aún no se ha subido", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error leyendo imagen subida: %v", err)
+		http.Error(w, "Error leyendo imagen", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeAvatarUpload, req.UploadID)
+	if err == errInvalidActionToken || (err == nil && stored.UserID != user.ID) {
+		http.Error(w, "Subida inválida o expirada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if limit := s.maxUploadSize(); int64(len(data)) > limit {
+		writeUploadTooLarge(w, limit)
+		return
+	}
+
+	uploaded, ok := s.storeUploadedImage(ctx, w, user.ID, data, "", crop, key)
+	if !ok {
+		return
+	}
+
+	images, replaced := replaceAvatarImage(user, uploaded)
+	if _, ok := s.updateGallery(ctx, w, user, images, &uploaded, "Imagen actualizada correctamente"); ok {
+		s.deleteProfileImageObjects(ctx, replaced...)
+	}
+}
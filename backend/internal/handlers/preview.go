@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// devMode habilita herramientas solo para desarrollo (DEV_MODE=true).
+func (s *Server) devMode() bool {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	return s.serverConfig.DevMode
+}
+
+func (s *Server) requireDevMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.devMode() {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// previewData rellena las plantillas con datos de ejemplo; cualquier campo
+// puede sobrescribirse con un parámetro de query (?code=, ?link=, ...).
+func (s *Server) previewData(r *http.Request) map[string]interface{} {
+	data := map[string]interface{}{
+		"Code":          "A01-1",
+		"Link":          s.publicBaseURL() + apiPath("/dev/email-preview"),
+		"TTLMinutes":    15,
+		"RetentionDays": 30,
+		"Email":         "usuario@example.com",
+	}
+	for param, field := range map[string]string{"code": "Code", "link": "Link", "email": "Email"} {
+		if value := r.URL.Query().Get(param); value != "" {
+			data[field] = value
+		}
+	}
+	if qr, err := s.accessCodeQRDataURL(data["Code"].(string)); err == nil {
+		data["QRImage"] = qr
+	}
+	return data
+}
+
+func (s *Server) handleListEmailPreviews(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), listOptions{})
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	names := make([]string, 0, len(s.emailTemplates))
+	for name := range s.emailTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	start, end := pageBounds(list, len(names))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, names[start:end], end-start, int64(len(names))))
+}
+
+// handleEmailPreview renderiza una plantilla en el navegador sin enviarla
+// (?format=text muestra la versión de texto plano).
+// Con EMAIL_TEMPLATES_DIR se recargan en cada petición para iterar rápido.
+func (s *Server) handleEmailPreview(w http.ResponseWriter, r *http.Request) {
+	if s.emailConfig.TemplatesDir != "" {
+		if err := s.loadEmailTemplates(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	name := mux.Vars(r)["template"]
+	if _, ok := s.emailTemplates[name]; !ok {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+
+	email, err := s.renderEmail(name, s.previewData(r))
+	if err != nil {
+		log.Printf("Error renderizando vista previa: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(email.Text))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(email.HTML))
+}
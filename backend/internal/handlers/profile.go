@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLength     = 100
+	maxBioLength      = 500
+	maxPronounsLength = 40
+	maxWebsiteLength  = 2048
+)
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// profileField describe un campo editable del perfil. parse valida el texto
+// recibido y devuelve lo que se guarda; null (o "" en formularios) vacía
+// el campo.
+type profileField struct {
+	parse func(value string) (interface{}, error)
+}
+
+var profileFields = map[string]profileField{
+	"name":      {parse: parseNameField},
+	"last_name": {parse: parseNameField},
+	"phone":     {parse: parsePhoneField},
+	"bio":       {parse: parseTextField(maxBioLength, true)},
+	"birthday":  {parse: parseBirthdayField},
+	"website":   {parse: parseWebsiteField},
+	"pronouns":  {parse: parseTextField(maxPronounsLength, false)},
+	"username":  {parse: parseUsernameField},
+}
+
+// parseTextField recorta espacios y limita la longitud; multiline permite
+// saltos de línea (bio) pero ningún otro carácter de control.
+func parseTextField(maxLength int, multiline bool) func(string) (interface{}, error) {
+	return func(value string) (interface{}, error) {
+		value = strings.TrimSpace(value)
+		if utf8.RuneCountInString(value) > maxLength {
+			return nil, fmt.Errorf("máximo %d caracteres", maxLength)
+		}
+		invalid := strings.IndexFunc(value, func(r rune) bool {
+			return unicode.IsControl(r) && !(multiline && (r == '\n' || r == '\r'))
+		})
+		if invalid >= 0 {
+			return nil, errors.New("contiene caracteres no permitidos")
+		}
+		return value, nil
+	}
+}
+
+var parseNameField = parseTextField(maxNameLength, false)
+
+// parsePhoneField acepta el teléfono en formato E.164 (+34600111222); se
+// ignoran espacios y guiones para facilitar la entrada.
+func parsePhoneField(value string) (interface{}, error) {
+	phone := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(value))
+	if !e164Pattern.MatchString(phone) {
+		return nil, errors.New("debe estar en formato internacional E.164, por ejemplo +34600111222")
+	}
+	return phone, nil
+}
+
+// parseBirthdayField acepta la fecha como 2006-01-02 y se guarda tal cual,
+// sin hora ni zona horaria.
+func parseBirthdayField(value string) (interface{}, error) {
+	birthday, err := time.Parse("2006-01-02", strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.New("debe tener el formato AAAA-MM-DD")
+	}
+	if birthday.After(time.Now()) || birthday.Year() < 1900 {
+		return nil, errors.New("fecha fuera de rango")
+	}
+	return birthday.Format("2006-01-02"), nil
+}
+
+func parseWebsiteField(value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > maxWebsiteLength {
+		return nil, fmt.Errorf("máximo %d caracteres", maxWebsiteLength)
+	}
+	website, err := url.Parse(value)
+	if err != nil || (website.Scheme != "http" && website.Scheme != "https") || website.Host == "" {
+		return nil, errors.New("debe ser una URL http o https válida")
+	}
+	return website.String(), nil
+}
+
+// profileFieldValues apunta a los campos de user que corresponden a cada
+// entrada de profileFields.
+func profileFieldValues(user *User) map[string]*string {
+	return map[string]*string{
+		"name":      &user.Name,
+		"last_name": &user.LastName,
+		"phone":     &user.Phone,
+		"bio":       &user.Bio,
+		"birthday":  &user.Birthday,
+		"website":   &user.Website,
+		"pronouns":  &user.Pronouns,
+		"username":  &user.Username,
+	}
+}
+
+// setProfileFields copia en el usuario los campos recibidos, para guardarlo
+// con UserRepository.Update: nil vacía el campo. Devuelve un error por campo
+// inválido o desconocido.
+func setProfileFields(user *User, values map[string]*string) map[string]string {
+	targets := profileFieldValues(user)
+	invalid := map[string]string{}
+
+	for name, value := range values {
+		field, ok := profileFields[name]
+		if !ok {
+			invalid[name] = "campo desconocido o no editable"
+			continue
+		}
+		if value == nil {
+			*targets[name] = ""
+			continue
+		}
+		parsed, err := field.parse(*value)
+		if err != nil {
+			invalid[name] = err.Error()
+			continue
+		}
+		*targets[name] = parsed.(string)
+	}
+	return invalid
+}
+
+// profileValuesFromJSON interpreta un JSON Merge Patch (RFC 7396): solo
+// cuentan los campos presentes y null los borra.
+func profileValuesFromJSON(patch map[string]json.RawMessage) (map[string]*string, map[string]string) {
+	values := map[string]*string{}
+	invalid := map[string]string{}
+	for name, raw := range patch {
+		if string(raw) == "null" {
+			values[name] = nil
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			invalid[name] = "debe ser un texto"
+			continue
+		}
+		values[name] = &value
+	}
+	return values, invalid
+}
+
+func writeInvalidFields(w http.ResponseWriter, invalid map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "invalid_fields",
+		"message": "Hay campos inválidos",
+		"fields":  invalid,
+	})
+}
+
+// handlePatchUser actualiza parcialmente el perfil. A diferencia de PUT, los
+// campos que no se envían no se tocan.
+func (s *Server) handlePatchUser(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		http.Error(w, "Content-Type debe ser application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, multipartOverhead)).Decode(&patch); err != nil || patch == nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	before, ok := s.findRequestUser(ctx, w, r)
+	if !ok || !checkUserIfMatch(w, r, before) {
+		return
+	}
+
+	values, invalid := profileValuesFromJSON(patch)
+	user := before
+	for name, message := range setProfileFields(&user, values) {
+		invalid[name] = message
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+	user.ProfileVersion++
+
+	if !s.saveUser(ctx, w, &user) {
+		return
+	}
+
+	s.recordProfileChange(ctx, r, before, user)
+
+	w.Header().Set("ETag", userETag(user))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Usuario actualizado correctamente",
+		"user":    user,
+	})
+}
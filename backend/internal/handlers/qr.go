@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/url"
+
+	qrcode "github.com/skip2/go-qrcode"
+
+	"backend/internal/email"
+)
+
+const accessCodeQRContentID = "access-code-qr"
+
+// accessCodeQRContent es lo que codifica el QR: el código tal cual o, con
+// ACCESS_CODE_QR_URL, un enlace al frontend con el código en ?code= para
+// iniciar sesión directamente desde el móvil.
+func (s *Server) accessCodeQRContent(code string) string {
+	base := s.codesConfig.QRURL
+	if base == "" {
+		return code
+	}
+	link, err := url.Parse(base)
+	if err != nil {
+		return code
+	}
+	query := link.Query()
+	query.Set("code", code)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+func (s *Server) accessCodeQRPNG(code string) ([]byte, error) {
+	return qrcode.Encode(s.accessCodeQRContent(code), qrcode.Medium, 256)
+}
+
+// accessCodeQRAttachment devuelve el QR como imagen embebida en el email; la
+// plantilla la referencia con cid:.
+func (s *Server) accessCodeQRAttachment(code string) (email.Attachment, template.URL, error) {
+	png, err := s.accessCodeQRPNG(code)
+	if err != nil {
+		return email.Attachment{}, "", err
+	}
+	return email.Attachment{
+		Filename:    "codigo-acceso.png",
+		ContentType: "image/png",
+		Content:     png,
+		ContentID:   accessCodeQRContentID,
+	}, template.URL("cid:" + accessCodeQRContentID), nil
+}
+
+// accessCodeQRDataURL sirve para la vista previa en el navegador, que no
+// resuelve referencias cid:.
+func (s *Server) accessCodeQRDataURL(code string) (template.URL, error) {
+	png, err := s.accessCodeQRPNG(code)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
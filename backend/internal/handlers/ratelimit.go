@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/store"
+)
+
+// RateLimiter decide si una acción identificada por key puede ejecutarse.
+// Cuando no, devuelve cuánto falta para que vuelva a estar permitida.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// adjustableLimiter es un RateLimiter cuyo límite se puede cambiar en
+// caliente (ver reload.go). Los intentos ya registrados se conservan y
+// cuentan con la nueva ventana.
+type adjustableLimiter interface {
+	RateLimiter
+	SetLimit(limit int, window time.Duration)
+}
+
+// newRateLimiter crea un límite de limit acciones por window en el
+// almacenamiento de RATE_LIMIT_BACKEND. Con varias instancias hay que usar
+// mongo: en memoria cada instancia cuenta solo lo que le llega a ella.
+func (s *Server) newRateLimiter(limit int, window time.Duration) adjustableLimiter {
+	if s.apiConfig.RateLimitBackend == "mongo" {
+		return &mongoLimiter{coll: s.database.RateLimits, limit: limit, window: window}
+	}
+	return newMemoryLimiter(limit, window)
+}
+
+// memoryLimiter es una ventana deslizante en memoria. Solo es correcta con una
+// única instancia del servidor.
+type memoryLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+	ticker *time.Ticker
+}
+
+func newMemoryLimiter(limit int, window time.Duration) *memoryLimiter {
+	limiter := &memoryLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+		ticker: time.NewTicker(window),
+	}
+	go limiter.janitor()
+	return limiter
+}
+
+func (l *memoryLimiter) SetLimit(limit int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.window = limit, window
+	l.ticker.Reset(window)
+}
+
+func (l *memoryLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	hits := l.prune(key, now)
+	if len(hits) >= l.limit {
+		return false, hits[0].Add(l.window).Sub(now), nil
+	}
+
+	l.hits[key] = append(hits, now)
+	return true, 0, nil
+}
+
+func (l *memoryLimiter) prune(key string, now time.Time) []time.Time {
+	hits := l.hits[key]
+	cutoff := now.Add(-l.window)
+	first := 0
+	for first < len(hits) && !hits[first].After(cutoff) {
+		first++
+	}
+	hits = hits[first:]
+	if len(hits) == 0 {
+		delete(l.hits, key)
+	} else {
+		l.hits[key] = hits
+	}
+	return hits
+}
+
+func (l *memoryLimiter) janitor() {
+	for now := range l.ticker.C {
+		l.mu.Lock()
+		for key := range l.hits {
+			l.prune(key, now)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// mongoLimiter es la misma ventana deslizante que memoryLimiter, compartida
+// entre instancias: cada key es un documento de rate_limits con sus intentos
+// dentro de la ventana. La comprobación y el registro del intento son una
+// única actualización, así que dos instancias no pueden pasarse del límite
+// a la vez. Los documentos sin intentos recientes expiran por TTL.
+type mongoLimiter struct {
+	coll   store.Collection
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+}
+
+type rateLimitDocument struct {
+	Hits    []time.Time `bson:"hits"`
+	Allowed bool        `bson:"allowed"`
+}
+
+func (s *Server) createRateLimitIndexes(ctx context.Context) error {
+	_, err := s.database.RateLimits.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (l *mongoLimiter) SetLimit(limit int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.window = limit, window
+}
+
+func (l *mongoLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	limit, window := l.limit, l.window
+	l.mu.Unlock()
+
+	// Mongo guarda las fechas en milisegundos.
+	now := time.Now().Truncate(time.Millisecond)
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"hits": bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$hits", bson.A{}}},
+				"cond":  bson.M{"$gt": bson.A{"$$this", now.Add(-window)}},
+			}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$lt": bson.A{bson.M{"$size": "$hits"}, limit}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"hits": bson.M{"$cond": bson.A{
+				"$allowed",
+				bson.M{"$concatArrays": bson.A{"$hits", bson.A{now}}},
+				"$hits",
+			}},
+			"expires_at": now.Add(window),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var doc rateLimitDocument
+	err := l.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&doc)
+	if mongo.IsDuplicateKeyError(err) {
+		// Otra instancia creó el documento a la vez; ahora ya existe.
+		err = l.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&doc)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if doc.Allowed {
+		return true, 0, nil
+	}
+	if len(doc.Hits) == 0 {
+		// Solo pasa con un límite de 0.
+		return false, window, nil
+	}
+	return false, doc.Hits[0].Add(window).Sub(now), nil
+}
+
+// limitRequests limita las peticiones por IP (API_RATE_LIMIT). Los límites
+// propios de cada acción, como codeEmailLimiter, se aplican además de este.
+func (s *Server) limitRequests(limiter RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter, err := limiter.Allow(r.Context(), "ip:"+s.clientIP(r))
+			if err != nil {
+				// Sin poder consultar el límite se deja pasar: es preferible a
+				// tumbar toda la API.
+				requestLogger(r).Warn("⚠️  Error consultando el límite de peticiones", "error", err)
+			} else if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				http.Error(w, "Demasiadas peticiones, inténtalo más tarde", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
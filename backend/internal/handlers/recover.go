@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const tokenPurposeRecover = "recover"
+
+func (s *Server) recoveryTTL() time.Duration {
+	return s.authConfig.RecoveryTTL
+}
+
+func (s *Server) sendRecoveryEmail(toEmail, link string) error {
+	return s.sendTemplateEmail(toEmail, emailTemplateRecovery, map[string]interface{}{
+		"Link":       link,
+		"TTLMinutes": int(s.recoveryTTL().Minutes()),
+	}, "🛟 ENLACE DE RECUPERACIÓN: "+link)
+}
+
+// handleRequestRecovery inicia la recuperación de cuenta. El código no cambia
+// hasta que se confirma desde el enlace enviado al email.
+func (s *Server) handleRequestRecovery(w http.ResponseWriter, r *http.Request) {
+	var req CodeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	allowed, retryAfter, err := s.codeEmailLimiter.Allow(ctx, "recover:"+strings.ToLower(req.Email))
+	if err != nil {
+		log.Printf("Error consultando límite de envíos: %v", err)
+		http.Error(w, "Error interno", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Demasiados envíos para este email, inténtalo más tarde", http.StatusTooManyRequests)
+		return
+	}
+
+	// La respuesta es la misma exista o no la cuenta, para no revelar qué
+	// emails están registrados.
+	response := map[string]string{
+		"message": "Si el email está registrado, te enviamos un enlace para recuperar tu cuenta. Revisa tu email.",
+	}
+
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
+	if errors.Is(err, errUserNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.createActionToken(ctx, user.ID, tokenPurposeRecover, s.recoveryTTL())
+	if err != nil {
+		log.Printf("Error creando token de recuperación: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	link := s.publicBaseURL() + apiPath("/recover/"+token)
+	if err := s.sendRecoveryEmail(req.Email, link); err != nil {
+		log.Printf("❌ Error enviando recuperación: %v", err)
+		http.Error(w, "Error enviando enlace", http.StatusInternalServerError)
+		return
+	}
+
+	if s.showDevCodes() {
+		response["dev_recover_url"] = link
+		response["dev_note"] = "Sin proveedor de email - enlace mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleConfirmRecovery rota el código de acceso y revoca todas las sesiones
+// abiertas, por si la cuenta estaba comprometida. El código nuevo se envía por email.
+func (s *Server) handleConfirmRecovery(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeRecover, mux.Vars(r)["token"])
+	if err == errInvalidActionToken {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando recuperación: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userRepo.FindByID(ctx, stored.UserID)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	code, err := s.rotateUserCode(ctx, user)
+	if err != nil {
+		log.Printf("Error regenerando código: %v", err)
+		http.Error(w, "Error generando código", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.Verified {
+		if err := s.markEmailVerified(ctx, user.ID); err != nil {
+			log.Printf("Error verificando usuario: %v", err)
+		}
+	}
+
+	if err := s.sendEmail(user.Email, code); err != nil {
+		log.Printf("❌ Error enviando email: %v", err)
+	} else {
+		log.Printf("✅ Cuenta %s recuperada, nuevo código enviado", user.ID.Hex())
+	}
+
+	response := map[string]string{
+		"message": "Cuenta recuperada. Te enviamos un código de acceso nuevo y se cerraron todas las sesiones.",
+	}
+
+	if s.showDevCodes() {
+		response["dev_code"] = code
+		response["dev_note"] = "Sin proveedor de email - código mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
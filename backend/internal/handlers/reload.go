@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"log"
+	"reflect"
+	"strings"
+
+	"backend/internal/config"
+)
+
+// Recarga en caliente: con SIGHUP (`kill -HUP <pid>`) main vuelve a leer el
+// .env y el entorno y Reload aplica, sin reiniciar ni cortar subidas o
+// conexiones en curso, los ajustes que lo admiten:
+//
+//   - CODE_RESEND_LIMIT y CODE_RESEND_WINDOW
+//   - CORS_ALLOWED_ORIGINS y CORS_ALLOW_CREDENTIALS
+//   - DEV_MODE, LOGIN_MODE, MAX_UPLOAD_SIZE y PROFILE_REMINDERS
+//   - BLOCK_DISPOSABLE_EMAILS y las listas de dominios desechables
+//   - ADMIN_TOKEN y ADMIN_ALLOWED_CIDRS(_FILE)
+//
+// Si la configuración nueva no es válida se mantiene la anterior entera. El
+// resto de variables solo cambia al reiniciar; la recarga avisa de cuáles.
+
+// Reload aplica de next los ajustes que admiten recarga en caliente. Quien
+// llama ya ha leído y validado la configuración nueva.
+func (s *Server) Reload(next config.Config) {
+	// Todo lo que puede fallar se prepara antes de aplicar nada.
+	prefixes, err := readAdminAllowlist(next.Admin)
+	if err != nil {
+		log.Printf("❌ Recarga cancelada, allowlist de administración inválida: %v", err)
+		return
+	}
+	domains, err := readDisposableDomains(next.Users)
+	if err != nil {
+		log.Printf("❌ Recarga cancelada, lista de dominios desechables inválida: %v", err)
+		return
+	}
+
+	applied := s.activeConfig
+	applied.Codes.ResendLimit, applied.Codes.ResendWindow = next.Codes.ResendLimit, next.Codes.ResendWindow
+	applied.CORS = next.CORS
+	applied.Server.DevMode = next.Server.DevMode
+	applied.Auth.LoginMode = next.Auth.LoginMode
+	applied.Uploads.MaxSize = next.Uploads.MaxSize
+	applied.Users.Reminders.Enabled = next.Users.Reminders.Enabled
+	applied.Users.BlockDisposableEmails = next.Users.BlockDisposableEmails
+	applied.Users.DisposableDomains = next.Users.DisposableDomains
+	applied.Users.DisposableDomainsFile = next.Users.DisposableDomainsFile
+	applied.Admin = next.Admin
+
+	s.liveMu.Lock()
+	s.serverConfig.DevMode = applied.Server.DevMode
+	s.authConfig.LoginMode = applied.Auth.LoginMode
+	s.uploadsConfig.MaxSize = applied.Uploads.MaxSize
+	s.usersConfig.Reminders.Enabled = applied.Users.Reminders.Enabled
+	s.disposableDomains = domains
+	s.adminToken, s.adminAllowedPrefixes = applied.Admin.Token, prefixes
+	s.liveMu.Unlock()
+
+	if limiter, ok := s.codeEmailLimiter.(adjustableLimiter); ok {
+		limiter.SetLimit(applied.Codes.ResendLimit, applied.Codes.ResendWindow)
+	}
+	s.corsPolicy.Store(newCORSPolicy(applied.CORS))
+	s.activeConfig = applied
+
+	log.Printf("✅ Configuración recargada (login: %s, orígenes CORS: %s)", s.loginMode(), strings.Join(applied.CORS.AllowedOrigins, ", "))
+	if pending := changedSections(applied, next); len(pending) > 0 {
+		log.Printf("⚠️  Cambios que requieren reiniciar: %s", strings.Join(pending, ", "))
+	}
+}
+
+// changedSections nombra las secciones de la configuración que difieren.
+func changedSections(a, b config.Config) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
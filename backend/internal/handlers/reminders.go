@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	tokenPurposeReminderOptOut = "reminder_opt_out"
+
+	// Máximo de recordatorios enviados en cada pasada del scheduler.
+	maxRemindersPerRun = 100
+)
+
+// profileReminderAfter lee PROFILE_REMINDER_AFTER: cuánto se espera desde el
+// registro antes de recordar al usuario que complete su perfil (72h por defecto).
+func (s *Server) profileReminderAfter() time.Duration {
+	return s.usersConfig.Reminders.After
+}
+
+func (s *Server) profileReminderInterval() time.Duration {
+	return s.usersConfig.Reminders.Interval
+}
+
+func (s *Server) frontendURL() string {
+	return s.serverConfig.FrontendURL
+}
+
+// runProfileReminders revisa periódicamente los perfiles incompletos.
+// PROFILE_REMINDERS=false lo desactiva.
+func (s *Server) runProfileReminders() {
+	ticker := time.NewTicker(s.profileReminderInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Se comprueba en cada pasada porque se puede cambiar en caliente.
+		s.liveMu.RLock()
+		enabled := s.usersConfig.Reminders.Enabled
+		s.liveMu.RUnlock()
+		if !enabled {
+			continue
+		}
+
+		sent, err := s.sendProfileReminders()
+		if err != nil {
+			log.Printf("❌ Error enviando recordatorios de perfil: %v", err)
+		}
+		if sent > 0 {
+			log.Printf("📬 %d recordatorios de perfil enviados", sent)
+		}
+	}
+}
+
+var errReminderClaimed = errors.New("otra instancia ya envió el recordatorio")
+
+// claimProfileReminder marca el usuario más antiguo que debe recibir el
+// recordatorio. Devuelve errReminderClaimed si otra instancia lo marcó
+// entre la búsqueda y la escritura.
+func (s *Server) claimProfileReminder(ctx context.Context) (User, error) {
+	cutoff := time.Now().Add(-s.profileReminderAfter())
+	user, err := s.findUser(ctx, UserQuery{ReminderDue: true, CreatedBefore: &cutoff, Sort: "created_at"})
+	if err != nil {
+		return User{}, err
+	}
+	return s.changeUser(ctx, user.ID, func(user *User) error {
+		if user.ProfileReminderSentAt != nil {
+			return errReminderClaimed
+		}
+		now := time.Now()
+		user.ProfileReminderSentAt = &now
+		return nil
+	})
+}
+
+// sendProfileReminders reclama cada usuario marcando profile_reminder_sent_at
+// antes de enviar, así varias instancias no mandan el mismo recordatorio. Cada
+// usuario recibe como mucho uno.
+func (s *Server) sendProfileReminders() (int, error) {
+	sent := 0
+	for sent < maxRemindersPerRun {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		user, err := s.claimProfileReminder(ctx)
+		if errors.Is(err, errUserNotFound) {
+			cancel()
+			return sent, nil
+		}
+		if errors.Is(err, errReminderClaimed) {
+			cancel()
+			continue
+		}
+		if err != nil {
+			cancel()
+			return sent, err
+		}
+
+		token, err := s.createActionToken(ctx, user.ID, tokenPurposeReminderOptOut, 30*24*time.Hour)
+		cancel()
+		if err != nil {
+			return sent, err
+		}
+
+		unsubscribeURL := s.publicBaseURL() + apiPath("/reminders/unsubscribe/"+token)
+		err = s.sendTemplateEmail(user.Email, emailTemplateProfileReminder, map[string]interface{}{
+			"AppURL":         s.frontendURL(),
+			"UnsubscribeURL": unsubscribeURL,
+		}, "📝 RECORDATORIO DE PERFIL - baja: "+unsubscribeURL)
+		if err != nil {
+			log.Printf("❌ Error enviando recordatorio a %s: %v", user.Email, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// handleReminderUnsubscribe desactiva los recordatorios desde el enlace del email.
+func (s *Server) handleReminderUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeReminderOptOut, mux.Vars(r)["token"])
+	if err == errInvalidActionToken {
+		http.Error(w, "Enlace inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando baja de recordatorios: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = s.changeUser(ctx, stored.UserID, func(user *User) error {
+		user.RemindersOptOut = true
+		if user.Preferences != nil {
+			user.Preferences.Email.Reminders = false
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		log.Printf("Error guardando baja de recordatorios: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "No volverás a recibir recordatorios",
+	})
+}
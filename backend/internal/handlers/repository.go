@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository guarda los usuarios sin atar los handlers a una base de
+// datos concreta. Las búsquedas ignoran las cuentas eliminadas o borradas
+// por RGPD, pero no las desactivadas: cada handler decide qué hacer con ellas.
+type UserRepository interface {
+	// Create inserta el usuario y le asigna el ID. Devuelve errDuplicateEmail,
+	// errDuplicateCode o errDuplicateUsername si choca con otro usuario.
+	Create(ctx context.Context, user *User) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (User, error)
+	FindByEmail(ctx context.Context, email string) (User, error)
+	// FindByCode busca por el hash del código de acceso (ver hashCode).
+	FindByCode(ctx context.Context, codeHash string) (User, error)
+	FindByUsername(ctx context.Context, username string) (User, error)
+	// FindByIdentity busca la cuenta vinculada a una cuenta externa (ver
+	// oauth.go).
+	FindByIdentity(ctx context.Context, provider, subject string) (User, error)
+	// Update guarda el usuario si nadie lo ha modificado desde que se leyó
+	// (mismo updated_at); si no, devuelve errUserConflict. Actualiza
+	// user.UpdatedAt. La actividad (last_login_at, last_seen_at y
+	// login_count) no se escribe: solo cambia con RecordActivity.
+	Update(ctx context.Context, user *User) error
+	// RecordActivity suma los logins y adelanta las fechas de actividad sin
+	// tocar updated_at, para no invalidar el ETag (ver etag.go) en cada
+	// petición.
+	RecordActivity(ctx context.Context, id primitive.ObjectID, activity UserActivity) error
+	// Delete marca la cuenta como eliminada; purgeDeletedUsers la borra al
+	// acabar la retención.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// Restore recupera una cuenta eliminada y la devuelve.
+	Restore(ctx context.Context, id primitive.ObjectID) (User, error)
+	// Erase guarda el usuario ya anonimizado (ver eraseUser) aunque la
+	// cuenta esté eliminada. Devuelve errUserNotFound si ya estaba borrada
+	// por RGPD.
+	Erase(ctx context.Context, user User) error
+	// Purge borra definitivamente una cuenta eliminada. Si la cuenta no
+	// está eliminada no hace nada y devuelve errUserNotFound.
+	Purge(ctx context.Context, id primitive.ObjectID) error
+	// List devuelve la página pedida y el total de usuarios que cumplen la consulta.
+	List(ctx context.Context, query UserQuery) ([]User, int64, error)
+	// Each llama a fn con cada usuario de la consulta, en el mismo orden que
+	// List, sin cargarlos todos a la vez cuando la base lo permite. fn puede
+	// modificar los usuarios que recibe.
+	Each(ctx context.Context, query UserQuery, fn func(User) error) error
+	// Stats cuenta las cuentas por estado y los registros por día desde
+	// firstDay y por semana ISO desde firstWeek (ver stats.go).
+	Stats(ctx context.Context, firstDay, firstWeek time.Time) (UserStats, error)
+}
+
+// userIndexer lo implementan los repositorios que preparan su esquema junto
+// con el resto de colecciones (CreateIndexes) en vez de al conectar.
+type userIndexer interface {
+	CreateIndexes(ctx context.Context, data legacyUserData) error
+}
+
+// legacyUserData convierte los datos de versiones anteriores que dependen de
+// la configuración: el pepper de los códigos y las URLs del almacenamiento.
+// Lo implementa *Server.
+type legacyUserData interface {
+	hashCode(code string) string
+	uploadKeyFromURL(url string, extraPrefixes ...string) (string, bool)
+}
+
+// UserActivity es lo que RecordActivity suma a la actividad del usuario. Las
+// fechas solo se guardan si son posteriores a las que ya tiene.
+type UserActivity struct {
+	Logins  int
+	LoginAt *time.Time
+	SeenAt  *time.Time
+}
+
+// apply suma la actividad a user.
+func (a UserActivity) apply(user *User) {
+	user.LoginCount += a.Logins
+	user.LastLoginAt = latest(user.LastLoginAt, a.LoginAt)
+	user.LastSeenAt = latest(user.LastSeenAt, a.SeenAt)
+}
+
+// keepActivity copia en user la actividad guardada en stored, para los
+// repositorios que reescriben el usuario entero en Update.
+func keepActivity(user *User, stored User) {
+	user.LoginCount = stored.LoginCount
+	user.LastLoginAt = stored.LastLoginAt
+	user.LastSeenAt = stored.LastSeenAt
+}
+
+var (
+	errUserNotFound      = errors.New("usuario no encontrado")
+	errUserConflict      = errors.New("el usuario ha cambiado desde que se leyó")
+	errDuplicateEmail    = errors.New("el email ya está registrado")
+	errDuplicateCode     = errors.New("el código de acceso ya existe")
+	errDuplicateUsername = errors.New("el nombre de usuario ya está en uso")
+)
+
+// UserQuery son los filtros, el orden y la paginación del listado de
+// usuarios. Los campos vacíos no filtran.
+type UserQuery struct {
+	ID          primitive.ObjectID
+	Email       string
+	CodeHash    string
+	EmailPrefix string
+	// NamePrefix busca en el nombre y en el apellido.
+	NamePrefix string
+	// Deleted lista solo las cuentas eliminadas (pendientes de purga) e
+	// IncludeDeleted todas, también las eliminadas y las borradas por RGPD.
+	Deleted        bool
+	IncludeDeleted bool
+	DeletedBefore  *time.Time
+	Active         *bool
+	// AllTags exige todas las etiquetas y AnyTags al menos una.
+	AllTags  []string
+	AnyTags  []string
+	HasImage *bool
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	SeenAfter     *time.Time
+	SeenBefore    *time.Time
+
+	// ImageKey busca los usuarios con alguna imagen que use ese archivo.
+	ImageKey string
+	// ReminderDue son las cuentas verificadas y activas con el perfil
+	// incompleto que aún no han recibido recordatorio ni lo han rechazado
+	// (ver reminders.go).
+	ReminderDue bool
+
+	// Sort es uno de userSorts, con prefijo "-" para orden descendente.
+	Sort  string
+	Skip  int
+	Limit int
+}
+
+// userSorts son los campos por los que se puede ordenar el listado. Todos
+// desempatan por ID (salvo email, que es único) para que la paginación sea
+// estable.
+var userSorts = map[string]bool{
+	"created_at": true, "updated_at": true, "email": true,
+	"last_login_at": true, "last_seen_at": true, "login_count": true,
+}
+
+// documentTime trunca a milisegundos, la precisión con la que BSON guarda
+// las fechas, para que el updated_at en memoria coincida con el guardado.
+func documentTime(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond)
+}
+
+// matchesUserQuery aplica los filtros de la consulta en memoria, con la
+// misma semántica que mongoUserFilter, para los repositorios que no tienen
+// un motor de consultas.
+func matchesUserQuery(user User, query UserQuery) bool {
+	if !query.IncludeDeleted && (user.ErasedAt != nil || (user.DeletedAt != nil) != query.Deleted) {
+		return false
+	}
+	if !query.ID.IsZero() && user.ID != query.ID {
+		return false
+	}
+	if (query.Email != "" && user.Email != query.Email) || (query.CodeHash != "" && user.CodeHash != query.CodeHash) {
+		return false
+	}
+	if query.DeletedBefore != nil && (user.DeletedAt == nil || user.DeletedAt.After(*query.DeletedBefore)) {
+		return false
+	}
+	if query.Active != nil && (user.DeactivatedAt == nil) != *query.Active {
+		return false
+	}
+	if query.EmailPrefix != "" && !strings.HasPrefix(user.Email, query.EmailPrefix) {
+		return false
+	}
+	if query.NamePrefix != "" && !strings.HasPrefix(user.Name, query.NamePrefix) && !strings.HasPrefix(user.LastName, query.NamePrefix) {
+		return false
+	}
+
+	tags := map[string]bool{}
+	for _, tag := range user.Tags {
+		tags[tag] = true
+	}
+	for _, tag := range query.AllTags {
+		if !tags[tag] {
+			return false
+		}
+	}
+	if len(query.AnyTags) > 0 {
+		found := false
+		for _, tag := range query.AnyTags {
+			found = found || tags[tag]
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if query.HasImage != nil && (user.ImageURL != "") != *query.HasImage {
+		return false
+	}
+	if !inTimeRange(&user.CreatedAt, query.CreatedAfter, query.CreatedBefore) ||
+		!inTimeRange(user.LastSeenAt, query.SeenAfter, query.SeenBefore) {
+		return false
+	}
+	if query.ImageKey != "" && !usesImageKey(user, query.ImageKey) {
+		return false
+	}
+	if query.ReminderDue && !reminderDue(user) {
+		return false
+	}
+	return true
+}
+
+func usesImageKey(user User, key string) bool {
+	for _, image := range user.Images {
+		if image.OriginalKey == key || slices.Contains(image.Keys, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIdentity(user User, provider, subject string) bool {
+	for _, identity := range user.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func reminderDue(user User) bool {
+	return user.Verified && user.DeactivatedAt == nil && !user.RemindersOptOut &&
+		user.ProfileReminderSentAt == nil && (user.Name == "" || user.LastName == "")
+}
+
+// eachUser recorre una página ya cargada, para los repositorios que no
+// pueden leer los usuarios poco a poco.
+func eachUser(users []User, fn func(User) error) error {
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTimeRange indica si t está en [after, before). Sin límites siempre se
+// cumple; con alguno, un t nulo nunca.
+func inTimeRange(t, after, before *time.Time) bool {
+	if after == nil && before == nil {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	return (after == nil || !t.Before(*after)) && (before == nil || t.Before(*before))
+}
+
+// sortUsers ordena como mongoUserSort: los nulos primero en orden
+// ascendente y desempate por ID.
+func sortUsers(users []User, sortKey string) {
+	descending := strings.HasPrefix(sortKey, "-")
+	field := strings.TrimPrefix(sortKey, "-")
+
+	optionalTime := func(t *time.Time) time.Time {
+		if t == nil {
+			return time.Time{}
+		}
+		return *t
+	}
+	compare := func(a, b User) int {
+		switch field {
+		case "email":
+			return strings.Compare(a.Email, b.Email)
+		case "updated_at":
+			return a.UpdatedAt.Compare(b.UpdatedAt)
+		case "last_login_at":
+			return optionalTime(a.LastLoginAt).Compare(optionalTime(b.LastLoginAt))
+		case "last_seen_at":
+			return optionalTime(a.LastSeenAt).Compare(optionalTime(b.LastSeenAt))
+		case "login_count":
+			return a.LoginCount - b.LoginCount
+		default:
+			return a.CreatedAt.Compare(b.CreatedAt)
+		}
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		c := compare(users[i], users[j])
+		if c == 0 {
+			c = strings.Compare(users[i].ID.Hex(), users[j].ID.Hex())
+		}
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// pageUsers aplica Skip y Limit (0 es sin límite) a los usuarios ya ordenados.
+func pageUsers(users []User, query UserQuery) []User {
+	if query.Skip >= len(users) {
+		return []User{}
+	}
+	users = users[query.Skip:]
+	if query.Limit > 0 && query.Limit < len(users) {
+		users = users[:query.Limit]
+	}
+	return users
+}
+
+// findRouteUser resuelve el {code} de la ruta: "me" es el usuario de la
+// sesión y las cuentas desactivadas no se encuentran aunque su access token
+// siga vigente.
+func (s *Server) findRouteUser(ctx context.Context, r *http.Request) (User, error) {
+	code := mux.Vars(r)["code"]
+
+	var user User
+	var err error
+	if userID, ok := sessionUserID(r); ok && code == "me" {
+		user, err = s.userRepo.FindByID(ctx, userID)
+	} else {
+		user, err = s.userRepo.FindByCode(ctx, s.hashCode(code))
+	}
+	if err == nil && user.DeactivatedAt != nil {
+		return User{}, errUserNotFound
+	}
+	return user, err
+}
+
+// findRequestUser busca el usuario de la ruta y responde 404 si no existe.
+func (s *Server) findRequestUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (User, bool) {
+	user, err := s.findRouteUser(ctx, r)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return User{}, false
+	}
+	if err != nil {
+		requestLogger(r).Error("Error obteniendo usuario", "error", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return User{}, false
+	}
+	return user, true
+}
+
+// saveUser guarda el usuario con userRepo.Update y, si falla, responde con
+// el error adecuado.
+func (s *Server) saveUser(ctx context.Context, w http.ResponseWriter, user *User) bool {
+	err := s.userRepo.Update(ctx, user)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errUserNotFound):
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+	case errors.Is(err, errUserConflict):
+		http.Error(w, "El usuario ha cambiado, inténtalo de nuevo", http.StatusConflict)
+	case isDuplicateUsername(err):
+		writeUsernameTaken(w)
+	default:
+		log.Printf("Error actualizando usuario: %v", err)
+		http.Error(w, "Error actualizando usuario", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// changeRouteUser es changeUser sobre el usuario de la ruta (ver
+// findRouteUser).
+func (s *Server) changeRouteUser(ctx context.Context, r *http.Request, change func(*User) error) (User, error) {
+	user, err := s.findRouteUser(ctx, r)
+	if err != nil {
+		return User{}, err
+	}
+	return s.changeUser(ctx, user.ID, change)
+}
+
+// findUser devuelve el primer usuario de la consulta o errUserNotFound.
+func (s *Server) findUser(ctx context.Context, query UserQuery) (User, error) {
+	query.Limit = 1
+	users, _, err := s.userRepo.List(ctx, query)
+	if err != nil {
+		return User{}, err
+	}
+	if len(users) == 0 {
+		return User{}, errUserNotFound
+	}
+	return users[0], nil
+}
+
+const maxUserUpdateAttempts = 3
+
+// changeUser relee el usuario y le aplica change hasta que Update no choque
+// con otra escritura. Si change devuelve un error no se guarda nada y se
+// devuelve ese error.
+func (s *Server) changeUser(ctx context.Context, id primitive.ObjectID, change func(*User) error) (User, error) {
+	for attempt := 1; ; attempt++ {
+		user, err := s.userRepo.FindByID(ctx, id)
+		if err != nil {
+			return User{}, err
+		}
+		if err := change(&user); err != nil {
+			return User{}, err
+		}
+		err = s.userRepo.Update(ctx, &user)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errUserConflict) || attempt == maxUserUpdateAttempts {
+			return User{}, err
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// boltUserRepository implementa UserRepository en un archivo local con
+// BoltDB (DB_DRIVER=bolt), para desarrollar sin servidor de base de datos.
+// Los usuarios se guardan en BSON por ID y los índices únicos son buckets
+// que apuntan al ID. List recorre todos los usuarios: pensado para unos
+// pocos miles, no para producción.
+type boltUserRepository struct {
+	db *bbolt.DB
+}
+
+var (
+	boltUsersBucket = []byte("users")
+	// Índices únicos: valor del campo -> ID.
+	boltEmailBucket    = []byte("users_by_email")
+	boltCodeBucket     = []byte("users_by_code_hash")
+	boltUsernameBucket = []byte("users_by_username")
+)
+
+// NewBoltUserRepository guarda los usuarios en db, el archivo que abre
+// store.OpenBolt para el resto de colecciones; cerrarlo es cosa del store.
+func NewBoltUserRepository(db *bbolt.DB) (*boltUserRepository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltUsersBucket, boltEmailBucket, boltCodeBucket, boltUsernameBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltUserRepository{db: db}, nil
+}
+
+// boltUniqueKey es una entrada de un índice único, con el error que se
+// devuelve si otra cuenta ya la tiene.
+type boltUniqueKey struct {
+	bucket []byte
+	key    string
+	err    error
+}
+
+func boltUniqueKeys(user User) []boltUniqueKey {
+	keys := []boltUniqueKey{
+		{boltEmailBucket, user.Email, errDuplicateEmail},
+		{boltCodeBucket, user.CodeHash, errDuplicateCode},
+	}
+	if user.Username != "" {
+		keys = append(keys, boltUniqueKey{boltUsernameBucket, user.Username, errDuplicateUsername})
+	}
+	return keys
+}
+
+func boltGet(tx *bbolt.Tx, id []byte) (User, error) {
+	data := tx.Bucket(boltUsersBucket).Get(id)
+	if data == nil {
+		return User{}, errUserNotFound
+	}
+	var user User
+	if err := bson.Unmarshal(data, &user); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// boltPut guarda user sustituyendo a previous (nil si es nuevo) y mantiene
+// los índices únicos.
+func boltPut(tx *bbolt.Tx, user User, previous *User) error {
+	id := []byte(user.ID.Hex())
+	for _, unique := range boltUniqueKeys(user) {
+		if owner := tx.Bucket(unique.bucket).Get([]byte(unique.key)); owner != nil && string(owner) != string(id) {
+			return unique.err
+		}
+	}
+
+	if previous != nil {
+		for _, unique := range boltUniqueKeys(*previous) {
+			if err := tx.Bucket(unique.bucket).Delete([]byte(unique.key)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, unique := range boltUniqueKeys(user) {
+		if err := tx.Bucket(unique.bucket).Put([]byte(unique.key), id); err != nil {
+			return err
+		}
+	}
+
+	data, err := bson.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltUsersBucket).Put(id, data)
+}
+
+func boltVisible(user User) bool {
+	return user.DeletedAt == nil && user.ErasedAt == nil
+}
+
+func (b *boltUserRepository) Create(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.CreatedAt = documentTime(user.CreatedAt)
+	user.UpdatedAt = documentTime(user.UpdatedAt)
+
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(boltUsersBucket).Get([]byte(user.ID.Hex())) != nil {
+			return errors.New("ya existe un usuario con ese ID")
+		}
+		return boltPut(tx, *user, nil)
+	})
+	if err != nil {
+		user.ID = primitive.NilObjectID
+	}
+	return err
+}
+
+// findBy busca por un índice único (bucket nil busca por ID).
+func (b *boltUserRepository) findBy(bucket []byte, key string) (User, error) {
+	var user User
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		id := []byte(key)
+		if bucket != nil {
+			if id = tx.Bucket(bucket).Get([]byte(key)); id == nil {
+				return errUserNotFound
+			}
+		}
+		found, err := boltGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if !boltVisible(found) {
+			return errUserNotFound
+		}
+		user = found
+		return nil
+	})
+	return user, err
+}
+
+func (b *boltUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return b.findBy(nil, id.Hex())
+}
+
+func (b *boltUserRepository) FindByEmail(ctx context.Context, email string) (User, error) {
+	return b.findBy(boltEmailBucket, email)
+}
+
+func (b *boltUserRepository) FindByCode(ctx context.Context, codeHash string) (User, error) {
+	return b.findBy(boltCodeBucket, codeHash)
+}
+
+func (b *boltUserRepository) FindByUsername(ctx context.Context, username string) (User, error) {
+	return b.findBy(boltUsernameBucket, username)
+}
+
+// FindByIdentity no tiene índice: las cuentas vinculadas son pocas y solo
+// se buscan al iniciar sesión con un proveedor externo.
+func (b *boltUserRepository) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	var user User
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, data []byte) error {
+			var found User
+			if err := bson.Unmarshal(data, &found); err != nil {
+				return err
+			}
+			if boltVisible(found) && hasIdentity(found, provider, subject) {
+				user = found
+			}
+			return nil
+		})
+	})
+	if err == nil && user.ID.IsZero() {
+		err = errUserNotFound
+	}
+	return user, err
+}
+
+func (b *boltUserRepository) Update(ctx context.Context, user *User) error {
+	updated := *user
+	updated.UpdatedAt = documentTime(time.Now())
+
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltGet(tx, []byte(user.ID.Hex()))
+		if err != nil {
+			return err
+		}
+		if !boltVisible(stored) {
+			return errUserNotFound
+		}
+		if !stored.UpdatedAt.Equal(user.UpdatedAt) {
+			return errUserConflict
+		}
+		keepActivity(&updated, stored)
+		return boltPut(tx, updated, &stored)
+	})
+	if err != nil {
+		return err
+	}
+	user.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (b *boltUserRepository) RecordActivity(ctx context.Context, id primitive.ObjectID, activity UserActivity) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltGet(tx, []byte(id.Hex()))
+		if err != nil {
+			return err
+		}
+		updated := stored
+		activity.apply(&updated)
+		return boltPut(tx, updated, &stored)
+	})
+}
+
+func (b *boltUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltGet(tx, []byte(id.Hex()))
+		if err != nil {
+			return err
+		}
+		if !boltVisible(stored) {
+			return errUserNotFound
+		}
+		deleted := stored
+		now := documentTime(time.Now())
+		deleted.DeletedAt = &now
+		deleted.UpdatedAt = now
+		return boltPut(tx, deleted, &stored)
+	})
+}
+
+func (b *boltUserRepository) Restore(ctx context.Context, id primitive.ObjectID) (User, error) {
+	var restored User
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltGet(tx, []byte(id.Hex()))
+		if err != nil {
+			return err
+		}
+		if stored.DeletedAt == nil || stored.ErasedAt != nil {
+			return errUserNotFound
+		}
+		restored = stored
+		restored.DeletedAt = nil
+		restored.UpdatedAt = documentTime(time.Now())
+		return boltPut(tx, restored, &stored)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return restored, nil
+}
+
+func (b *boltUserRepository) Erase(ctx context.Context, user User) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltGet(tx, []byte(user.ID.Hex()))
+		if err != nil {
+			return err
+		}
+		if stored.ErasedAt != nil {
+			return errUserNotFound
+		}
+		keepActivity(&user, stored)
+		return boltPut(tx, user, &stored)
+	})
+}
+
+func (b *boltUserRepository) Purge(ctx context.Context, id primitive.ObjectID) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltGet(tx, []byte(id.Hex()))
+		if err != nil {
+			return err
+		}
+		if stored.DeletedAt == nil {
+			return errUserNotFound
+		}
+		for _, unique := range boltUniqueKeys(stored) {
+			if err := tx.Bucket(unique.bucket).Delete([]byte(unique.key)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltUsersBucket).Delete([]byte(id.Hex()))
+	})
+}
+
+func (b *boltUserRepository) List(ctx context.Context, query UserQuery) ([]User, int64, error) {
+	users := []User{}
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, data []byte) error {
+			var user User
+			if err := bson.Unmarshal(data, &user); err != nil {
+				return err
+			}
+			if matchesUserQuery(user, query) {
+				users = append(users, user)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortUsers(users, query.Sort)
+	return pageUsers(users, query), int64(len(users)), nil
+}
+
+// Each recorre los usuarios fuera de la transacción de lectura: fn puede
+// escribir en el repositorio, y bbolt no permite abrir una escritura dentro
+// de una lectura.
+func (b *boltUserRepository) Each(ctx context.Context, query UserQuery, fn func(User) error) error {
+	users, _, err := b.List(ctx, query)
+	if err != nil {
+		return err
+	}
+	return eachUser(users, fn)
+}
+
+func (b *boltUserRepository) Stats(ctx context.Context, firstDay, firstWeek time.Time) (UserStats, error) {
+	return countUserStats(ctx, b, firstDay, firstWeek)
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryUserRepository implementa UserRepository en memoria (DB_DRIVER=memory)
+// para demos y pruebas: los usuarios se pierden al reiniciar. Se guardan en
+// BSON para que quien lee no comparta slices ni mapas con lo guardado.
+type memoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID][]byte
+}
+
+func NewMemoryUserRepository() *memoryUserRepository {
+	return &memoryUserRepository{users: map[primitive.ObjectID][]byte{}}
+}
+
+func (m *memoryUserRepository) get(id primitive.ObjectID) (User, bool) {
+	data, ok := m.users[id]
+	if !ok {
+		return User{}, false
+	}
+	var user User
+	if err := bson.Unmarshal(data, &user); err != nil {
+		return User{}, false
+	}
+	return user, true
+}
+
+// put guarda el usuario si no choca con otro en email, código o username.
+// Hay que tener el lock de escritura.
+func (m *memoryUserRepository) put(user User) error {
+	for id := range m.users {
+		if id == user.ID {
+			continue
+		}
+		other, _ := m.get(id)
+		switch {
+		case other.Email == user.Email:
+			return errDuplicateEmail
+		case other.CodeHash == user.CodeHash:
+			return errDuplicateCode
+		case user.Username != "" && other.Username == user.Username:
+			return errDuplicateUsername
+		}
+	}
+
+	data, err := bson.Marshal(user)
+	if err != nil {
+		return err
+	}
+	m.users[user.ID] = data
+	return nil
+}
+
+func (m *memoryUserRepository) Create(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	if _, ok := m.users[user.ID]; ok {
+		return errors.New("ya existe un usuario con ese ID")
+	}
+	user.CreatedAt = documentTime(user.CreatedAt)
+	user.UpdatedAt = documentTime(user.UpdatedAt)
+
+	if err := m.put(*user); err != nil {
+		user.ID = primitive.NilObjectID
+		return err
+	}
+	return nil
+}
+
+// find devuelve el primer usuario visible que cumple match.
+func (m *memoryUserRepository) find(match func(User) bool) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for id := range m.users {
+		user, ok := m.get(id)
+		if ok && user.DeletedAt == nil && user.ErasedAt == nil && match(user) {
+			return user, nil
+		}
+	}
+	return User{}, errUserNotFound
+}
+
+func (m *memoryUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return m.find(func(user User) bool { return user.ID == id })
+}
+
+func (m *memoryUserRepository) FindByEmail(ctx context.Context, email string) (User, error) {
+	return m.find(func(user User) bool { return user.Email == email })
+}
+
+func (m *memoryUserRepository) FindByCode(ctx context.Context, codeHash string) (User, error) {
+	return m.find(func(user User) bool { return user.CodeHash == codeHash })
+}
+
+func (m *memoryUserRepository) FindByUsername(ctx context.Context, username string) (User, error) {
+	return m.find(func(user User) bool { return user.Username != "" && user.Username == username })
+}
+
+func (m *memoryUserRepository) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	return m.find(func(user User) bool { return hasIdentity(user, provider, subject) })
+}
+
+func (m *memoryUserRepository) Update(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.get(user.ID)
+	if !ok || stored.DeletedAt != nil || stored.ErasedAt != nil {
+		return errUserNotFound
+	}
+	if !stored.UpdatedAt.Equal(user.UpdatedAt) {
+		return errUserConflict
+	}
+
+	updated := *user
+	updated.UpdatedAt = documentTime(time.Now())
+	keepActivity(&updated, stored)
+	if err := m.put(updated); err != nil {
+		return err
+	}
+	user.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (m *memoryUserRepository) RecordActivity(ctx context.Context, id primitive.ObjectID, activity UserActivity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.get(id)
+	if !ok {
+		return errUserNotFound
+	}
+	activity.apply(&stored)
+	return m.put(stored)
+}
+
+func (m *memoryUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.get(id)
+	if !ok || stored.DeletedAt != nil || stored.ErasedAt != nil {
+		return errUserNotFound
+	}
+	now := documentTime(time.Now())
+	stored.DeletedAt = &now
+	stored.UpdatedAt = now
+	return m.put(stored)
+}
+
+func (m *memoryUserRepository) Restore(ctx context.Context, id primitive.ObjectID) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.get(id)
+	if !ok || stored.DeletedAt == nil || stored.ErasedAt != nil {
+		return User{}, errUserNotFound
+	}
+	stored.DeletedAt = nil
+	stored.UpdatedAt = documentTime(time.Now())
+	if err := m.put(stored); err != nil {
+		return User{}, err
+	}
+	return stored, nil
+}
+
+func (m *memoryUserRepository) Erase(ctx context.Context, user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.get(user.ID)
+	if !ok || stored.ErasedAt != nil {
+		return errUserNotFound
+	}
+	keepActivity(&user, stored)
+	return m.put(user)
+}
+
+func (m *memoryUserRepository) Purge(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.get(id)
+	if !ok || stored.DeletedAt == nil {
+		return errUserNotFound
+	}
+	delete(m.users, id)
+	return nil
+}
+
+func (m *memoryUserRepository) List(ctx context.Context, query UserQuery) ([]User, int64, error) {
+	m.mu.RLock()
+	users := []User{}
+	for id := range m.users {
+		if user, ok := m.get(id); ok && matchesUserQuery(user, query) {
+			users = append(users, user)
+		}
+	}
+	m.mu.RUnlock()
+
+	sortUsers(users, query.Sort)
+	return pageUsers(users, query), int64(len(users)), nil
+}
+
+// Each recorre una copia: fn puede escribir en el repositorio sin bloquearlo.
+func (m *memoryUserRepository) Each(ctx context.Context, query UserQuery, fn func(User) error) error {
+	users, _, err := m.List(ctx, query)
+	if err != nil {
+		return err
+	}
+	return eachUser(users, fn)
+}
+
+func (m *memoryUserRepository) Stats(ctx context.Context, firstDay, firstWeek time.Time) (UserStats, error) {
+	return countUserStats(ctx, m, firstDay, firstWeek)
+}
@@ -0,0 +1,654 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/store"
+)
+
+// mongoUserRepository implementa UserRepository sobre la colección users.
+type mongoUserRepository struct {
+	users store.Collection
+}
+
+func NewMongoUserRepository(users store.Collection) *mongoUserRepository {
+	return &mongoUserRepository{users: users}
+}
+
+// notDeleted excluye de la consulta las cuentas eliminadas o anonimizadas.
+// Toda búsqueda de usuarios fuera de la administración debe pasar por aquí.
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	filter["erased_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// isDuplicateCode distingue una colisión en el índice único de code_hash de
+// otros duplicados (por ejemplo, el email).
+func isDuplicateCode(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "code_hash_1")
+}
+
+func isIndexNotFound(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && commandErr.Name == "IndexNotFound"
+}
+
+// mongoUserError traduce los errores del driver a los del repositorio.
+func mongoUserError(err error) error {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return errUserNotFound
+	case isDuplicateCode(err):
+		return errDuplicateCode
+	case mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "username_1"):
+		return errDuplicateUsername
+	case mongo.IsDuplicateKeyError(err):
+		return errDuplicateEmail
+	}
+	return err
+}
+
+func (m *mongoUserRepository) Create(ctx context.Context, user *User) error {
+	result, err := m.users.InsertOne(ctx, user)
+	if err != nil {
+		return mongoUserError(err)
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *mongoUserRepository) findOne(ctx context.Context, filter bson.M) (User, error) {
+	var user User
+	if err := m.users.FindOne(ctx, notDeleted(filter)).Decode(&user); err != nil {
+		return User{}, mongoUserError(err)
+	}
+	return user, nil
+}
+
+func (m *mongoUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return m.findOne(ctx, bson.M{"_id": id})
+}
+
+func (m *mongoUserRepository) FindByEmail(ctx context.Context, email string) (User, error) {
+	return m.findOne(ctx, bson.M{"email": email})
+}
+
+func (m *mongoUserRepository) FindByCode(ctx context.Context, codeHash string) (User, error) {
+	return m.findOne(ctx, bson.M{"code_hash": codeHash})
+}
+
+func (m *mongoUserRepository) FindByUsername(ctx context.Context, username string) (User, error) {
+	return m.findOne(ctx, bson.M{"username": username})
+}
+
+func (m *mongoUserRepository) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	return m.findOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	})
+}
+
+// userFields son los campos de User en BSON, para saber cuáles borrar con
+// $unset cuando están vacíos.
+var userFields = func() []string {
+	var fields []string
+	t := reflect.TypeOf(User{})
+	for i := 0; i < t.NumField(); i++ {
+		fields = append(fields, strings.Split(t.Field(i).Tag.Get("bson"), ",")[0])
+	}
+	return fields
+}()
+
+// activityFields solo se escriben con RecordActivity.
+var activityFields = map[string]bool{"last_login_at": true, "last_seen_at": true, "login_count": true}
+
+// mongoUserUpdate escribe los campos de user con $set y borra con $unset
+// los que están vacíos. No usa ReplaceOne para no pisar la actividad que
+// RecordActivity haya guardado entretanto ni los campos que User no conoce.
+func mongoUserUpdate(user User) (bson.M, error) {
+	data, err := bson.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.M
+	if err := bson.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	for _, field := range userFields {
+		if field == "_id" || activityFields[field] {
+			continue
+		}
+		if value, ok := document[field]; ok {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+func (m *mongoUserRepository) Update(ctx context.Context, user *User) error {
+	// Mongo guarda milisegundos: se trunca para que user.UpdatedAt siga
+	// coincidiendo con lo guardado en la siguiente Update.
+	updated := *user
+	updated.UpdatedAt = time.Now().Truncate(time.Millisecond)
+
+	update, err := mongoUserUpdate(updated)
+	if err != nil {
+		return err
+	}
+	result, err := m.users.UpdateOne(ctx,
+		notDeleted(bson.M{"_id": user.ID, "updated_at": user.UpdatedAt}),
+		update,
+	)
+	if err != nil {
+		return mongoUserError(err)
+	}
+	if result.MatchedCount == 0 {
+		count, err := m.users.CountDocuments(ctx, notDeleted(bson.M{"_id": user.ID}))
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errUserNotFound
+		}
+		return errUserConflict
+	}
+	user.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (m *mongoUserRepository) RecordActivity(ctx context.Context, id primitive.ObjectID, activity UserActivity) error {
+	update := bson.M{}
+	if activity.Logins != 0 {
+		update["$inc"] = bson.M{"login_count": activity.Logins}
+	}
+	latest := bson.M{}
+	if activity.LoginAt != nil {
+		latest["last_login_at"] = *activity.LoginAt
+	}
+	if activity.SeenAt != nil {
+		latest["last_seen_at"] = *activity.SeenAt
+	}
+	if len(latest) > 0 {
+		update["$max"] = latest
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	result, err := m.users.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (m *mongoUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	result, err := m.users.UpdateOne(ctx,
+		notDeleted(bson.M{"_id": id}),
+		bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (m *mongoUserRepository) Restore(ctx context.Context, id primitive.ObjectID) (User, error) {
+	var user User
+	err := m.users.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}, "erased_at": bson.M{"$exists": false}},
+		bson.M{
+			"$unset": bson.M{"deleted_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		return User{}, mongoUserError(err)
+	}
+	return user, nil
+}
+
+func (m *mongoUserRepository) Erase(ctx context.Context, user User) error {
+	update, err := mongoUserUpdate(user)
+	if err != nil {
+		return err
+	}
+	result, err := m.users.UpdateOne(ctx, bson.M{"_id": user.ID, "erased_at": bson.M{"$exists": false}}, update)
+	if err != nil {
+		return mongoUserError(err)
+	}
+	if result.MatchedCount == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (m *mongoUserRepository) Purge(ctx context.Context, id primitive.ObjectID) error {
+	result, err := m.users.DeleteOne(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (m *mongoUserRepository) find(ctx context.Context, query UserQuery) (*mongo.Cursor, error) {
+	return m.users.Find(ctx, mongoUserFilter(query), options.Find().
+		SetSort(mongoUserSort(query.Sort)).
+		SetSkip(int64(query.Skip)).
+		SetLimit(int64(query.Limit)))
+}
+
+func (m *mongoUserRepository) List(ctx context.Context, query UserQuery) ([]User, int64, error) {
+	total, err := m.users.CountDocuments(ctx, mongoUserFilter(query))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := m.find(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (m *mongoUserRepository) Each(ctx context.Context, query UserQuery, fn func(User) error) error {
+	cursor, err := m.find(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// mongoUserFilter traduce la consulta a un filtro que usa los índices:
+// email y nombre buscan por prefijo con una regex anclada (distingue
+// mayúsculas).
+func mongoUserFilter(query UserQuery) bson.M {
+	filter := bson.M{}
+	if !query.IncludeDeleted {
+		notDeleted(filter)
+	}
+	if query.Deleted {
+		filter["deleted_at"] = bson.M{"$exists": true}
+	}
+	if query.DeletedBefore != nil {
+		filter["deleted_at"] = bson.M{"$lte": *query.DeletedBefore}
+	}
+	if !query.ID.IsZero() {
+		filter["_id"] = query.ID
+	}
+	if query.CodeHash != "" {
+		filter["code_hash"] = query.CodeHash
+	}
+	if query.Active != nil {
+		filter["deactivated_at"] = bson.M{"$exists": !*query.Active}
+	}
+
+	tags := bson.M{}
+	if len(query.AllTags) > 0 {
+		tags["$all"] = query.AllTags
+	}
+	if len(query.AnyTags) > 0 {
+		tags["$in"] = query.AnyTags
+	}
+	if len(tags) > 0 {
+		filter["tags"] = tags
+	}
+
+	if query.EmailPrefix != "" {
+		filter["email"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.EmailPrefix)}
+	}
+	if query.Email != "" {
+		filter["email"] = query.Email
+	}
+
+	// Cada alternativa va en su $or dentro de un $and para que no se pisen.
+	var alternatives []bson.M
+	if query.NamePrefix != "" {
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(query.NamePrefix)}
+		alternatives = append(alternatives, bson.M{"$or": []bson.M{{"name": prefix}, {"last_name": prefix}}})
+	}
+	if query.ImageKey != "" {
+		alternatives = append(alternatives, bson.M{"$or": []bson.M{
+			{"images.keys": query.ImageKey},
+			{"images.original_key": query.ImageKey},
+		}})
+	}
+	if query.ReminderDue {
+		filter["verified"] = true
+		filter["deactivated_at"] = bson.M{"$exists": false}
+		filter["reminders_opt_out"] = bson.M{"$ne": true}
+		filter["profile_reminder_sent_at"] = bson.M{"$exists": false}
+		alternatives = append(alternatives, bson.M{"$or": []bson.M{{"name": ""}, {"last_name": ""}}})
+	}
+	if len(alternatives) > 0 {
+		filter["$and"] = alternatives
+	}
+
+	if createdAt := mongoTimeRange(query.CreatedAfter, query.CreatedBefore); createdAt != nil {
+		filter["created_at"] = createdAt
+	}
+	// Los usuarios que nunca han usado la API no tienen last_seen_at y no
+	// aparecen con ninguno de los dos filtros.
+	if lastSeen := mongoTimeRange(query.SeenAfter, query.SeenBefore); lastSeen != nil {
+		filter["last_seen_at"] = lastSeen
+	}
+
+	if query.HasImage != nil {
+		if *query.HasImage {
+			filter["image_url"] = bson.M{"$gt": ""}
+		} else {
+			filter["image_url"] = bson.M{"$in": []interface{}{"", nil}}
+		}
+	}
+	return filter
+}
+
+func mongoTimeRange(after, before *time.Time) bson.M {
+	if after == nil && before == nil {
+		return nil
+	}
+	timeRange := bson.M{}
+	if after != nil {
+		timeRange["$gte"] = *after
+	}
+	if before != nil {
+		timeRange["$lt"] = *before
+	}
+	return timeRange
+}
+
+// mongoUserSort desempata por _id salvo en email, que ya es único. Los
+// órdenes tienen índice (ver createAdminIndexes).
+func mongoUserSort(sort string) bson.D {
+	direction := 1
+	if strings.HasPrefix(sort, "-") {
+		direction = -1
+		sort = sort[1:]
+	}
+	if sort == "" {
+		sort = "created_at"
+	}
+	if sort == "email" {
+		return bson.D{{Key: "email", Value: direction}}
+	}
+	return bson.D{{Key: sort, Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// CreateIndexes migra los documentos de versiones anteriores y crea los
+// índices de users. Server.CreateIndexes lo llama junto con los del resto de
+// colecciones (ver userIndexer).
+func (m *mongoUserRepository) CreateIndexes(ctx context.Context, data legacyUserData) error {
+	if err := m.migrateLegacyCodes(ctx, data); err != nil {
+		return err
+	}
+	if err := m.migrateLegacyVerification(ctx); err != nil {
+		return err
+	}
+	if err := m.migrateLegacyAvatars(ctx, data); err != nil {
+		return err
+	}
+
+	_, err := m.users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "code_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{
+			Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities.provider": bson.M{"$exists": true}}),
+		},
+		// Órdenes del listado de administración (ver mongoUserSort).
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "last_login_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "last_seen_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "login_count", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "last_name", Value: 1}}},
+		{Keys: bson.D{{Key: "image_url", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "images.keys", Value: 1}}},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "profile_reminder_sent_at", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	return err
+}
+
+// migrateLegacyCodes reemplaza los códigos guardados en texto plano por su hash.
+// El índice único antiguo sobre code se elimina antes, porque al quitar el
+// campo varios documentos quedarían con code nulo.
+func (m *mongoUserRepository) migrateLegacyCodes(ctx context.Context, data legacyUserData) error {
+	if _, err := m.users.Indexes().DropOne(ctx, "code_1"); err != nil && !isIndexNotFound(err) {
+		return err
+	}
+
+	cursor, err := m.users.Find(ctx, bson.M{"code": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var legacy struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Code string             `bson:"code"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return err
+		}
+
+		_, err := m.users.UpdateOne(ctx, bson.M{"_id": legacy.ID}, bson.M{
+			"$set":   bson.M{"code_hash": data.hashCode(legacy.Code)},
+			"$unset": bson.M{"code": ""},
+		})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		log.Printf("✅ %d códigos migrados a hash", migrated)
+	}
+	return nil
+}
+
+// migrateLegacyVerification marca como verificados a los usuarios creados
+// antes de que existiera la verificación de email, para no bloquearles el login.
+func (m *mongoUserRepository) migrateLegacyVerification(ctx context.Context) error {
+	result, err := m.users.UpdateMany(ctx,
+		bson.M{"verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"verified": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("✅ %d usuarios existentes marcados como verificados", result.ModifiedCount)
+	}
+	return nil
+}
+
+// migrateLegacyAvatars pasa el avatar único de los usuarios anteriores a la
+// galería (images) para que pueda gestionarse como el resto de imágenes.
+func (m *mongoUserRepository) migrateLegacyAvatars(ctx context.Context, data legacyUserData) error {
+	cursor, err := m.users.Find(ctx, bson.M{
+		"image_url": bson.M{"$nin": []interface{}{"", nil}},
+		"images":    bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var legacy struct {
+			ID                primitive.ObjectID `bson:"_id"`
+			ImageURL          string             `bson:"image_url"`
+			ImageFallbackURL  string             `bson:"image_fallback_url"`
+			AvatarOriginalKey string             `bson:"avatar_original_key"`
+			AvatarKeys        []string           `bson:"avatar_keys"`
+			UpdatedAt         time.Time          `bson:"updated_at"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return err
+		}
+
+		image := ProfileImage{
+			ID:          primitive.NewObjectID(),
+			URL:         legacy.ImageURL,
+			FallbackURL: legacy.ImageFallbackURL,
+			OriginalKey: legacy.AvatarOriginalKey,
+			Keys:        legacy.AvatarKeys,
+			CreatedAt:   legacy.UpdatedAt,
+		}
+		if len(image.Keys) == 0 {
+			if key, ok := data.uploadKeyFromURL(legacy.ImageURL); ok {
+				image.Keys = []string{key}
+			}
+		}
+
+		_, err := m.users.UpdateOne(ctx, bson.M{"_id": legacy.ID}, bson.M{
+			"$set":   bson.M{"images": []ProfileImage{image}, "avatar_image_id": image.ID},
+			"$unset": bson.M{"avatar_original_key": "", "avatar_keys": ""},
+		})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		log.Printf("✅ %d avatares migrados a la galería", migrated)
+	}
+	return nil
+}
+
+// Stats agrega en una sola pasada por la colección.
+func (m *mongoUserRepository) Stats(ctx context.Context, firstDay, firstWeek time.Time) (UserStats, error) {
+	missing := func(field string) bson.M {
+		return bson.M{"$eq": bson.A{bson.M{"$type": "$" + field}, "missing"}}
+	}
+	present := func(field string) bson.M {
+		return bson.M{"$ne": bson.A{bson.M{"$type": "$" + field}, "missing"}}
+	}
+	countIf := func(conditions ...bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$and": conditions}, 1, 0}}}
+	}
+	signups := func(since time.Time, format string) bson.A {
+		return bson.A{
+			bson.M{"$match": bson.M{"created_at": bson.M{"$gte": since}}},
+			bson.M{"$group": bson.M{
+				"_id":   bson.M{"$dateToString": bson.M{"format": format, "date": "$created_at"}},
+				"count": bson.M{"$sum": 1},
+			}},
+		}
+	}
+
+	cursor, err := m.users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":         nil,
+					"users":       countIf(missing("deleted_at"), missing("erased_at")),
+					"verified":    countIf(missing("deleted_at"), missing("erased_at"), bson.M{"$eq": bson.A{"$verified", true}}),
+					"deactivated": countIf(missing("deleted_at"), missing("erased_at"), present("deactivated_at")),
+					"deleted":     countIf(present("deleted_at"), missing("erased_at")),
+					"erased":      countIf(present("erased_at")),
+					"images":      bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": bson.A{"$images", bson.A{}}}}},
+				}},
+			},
+			"per_day":  signups(firstDay, "%Y-%m-%d"),
+			"per_week": signups(firstWeek, "%G-W%V"),
+		}}},
+	})
+	if err != nil {
+		return UserStats{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Totals []struct {
+			Users       int64 `bson:"users"`
+			Verified    int64 `bson:"verified"`
+			Deactivated int64 `bson:"deactivated"`
+			Deleted     int64 `bson:"deleted"`
+			Erased      int64 `bson:"erased"`
+			Images      int64 `bson:"images"`
+		} `bson:"totals"`
+		PerDay  []StatsBucket `bson:"per_day"`
+		PerWeek []StatsBucket `bson:"per_week"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return UserStats{}, err
+	}
+	if len(result) == 0 {
+		return UserStats{}, nil
+	}
+
+	stats := UserStats{PerDay: result[0].PerDay, PerWeek: result[0].PerWeek}
+	if totals := result[0].Totals; len(totals) > 0 {
+		stats.Users = totals[0].Users
+		stats.Verified = totals[0].Verified
+		stats.Deactivated = totals[0].Deactivated
+		stats.Deleted = totals[0].Deleted
+		stats.Erased = totals[0].Erased
+		stats.Images = totals[0].Images
+	}
+	return stats, nil
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresUserRepository implementa UserRepository sobre PostgreSQL
+// (DB_DRIVER=postgres). El usuario completo va en la columna document y las
+// demás columnas son copias de los campos que se consultan.
+type postgresUserRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresUserRepository(pool *pgxpool.Pool) *postgresUserRepository {
+	return &postgresUserRepository{pool: pool}
+}
+
+// postgresUserError traduce las violaciones de unicidad a los errores del
+// repositorio por el nombre de la restricción.
+func postgresUserError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errUserNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "users_email_key":
+			return errDuplicateEmail
+		case "users_code_hash_key":
+			return errDuplicateCode
+		case "users_username_key":
+			return errDuplicateUsername
+		}
+	}
+	return err
+}
+
+func nullableString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// postgresUserValues devuelve las columnas del usuario en el orden de
+// postgresUserColumns.
+func postgresUserValues(user User) ([]interface{}, error) {
+	document, err := bson.MarshalExtJSON(user, false, false)
+	if err != nil {
+		return nil, err
+	}
+	tags := user.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return []interface{}{
+		user.ID.Hex(), user.Email, user.CodeHash, nullableString(user.Username),
+		user.Name, user.LastName, user.ImageURL, tags, user.LoginCount,
+		user.CreatedAt, user.UpdatedAt, user.LastLoginAt, user.LastSeenAt,
+		user.DeactivatedAt, user.DeletedAt, user.ErasedAt, string(document),
+	}, nil
+}
+
+const postgresUserColumns = `id, email, code_hash, username, name, last_name, image_url, tags, login_count,
+	created_at, updated_at, last_login_at, last_seen_at, deactivated_at, deleted_at, erased_at, document`
+
+// postgresActive es la condición equivalente a notDeleted.
+const postgresActive = "deleted_at IS NULL AND erased_at IS NULL"
+
+func (p *postgresUserRepository) Create(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.CreatedAt = documentTime(user.CreatedAt)
+	user.UpdatedAt = documentTime(user.UpdatedAt)
+
+	values, err := postgresUserValues(*user)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx, `INSERT INTO users (`+postgresUserColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`, values...)
+	if err != nil {
+		user.ID = primitive.NilObjectID
+		return postgresUserError(err)
+	}
+	return nil
+}
+
+func (p *postgresUserRepository) findOne(ctx context.Context, column string, value interface{}) (User, error) {
+	return p.findWhere(ctx, column+" = $1", value)
+}
+
+func (p *postgresUserRepository) findWhere(ctx context.Context, condition string, args ...interface{}) (User, error) {
+	var document []byte
+	err := p.pool.QueryRow(ctx, "SELECT document FROM users WHERE "+condition+" AND "+postgresActive, args...).Scan(&document)
+	if err != nil {
+		return User{}, postgresUserError(err)
+	}
+	var user User
+	if err := bson.UnmarshalExtJSON(document, false, &user); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (p *postgresUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return p.findOne(ctx, "id", id.Hex())
+}
+
+func (p *postgresUserRepository) FindByEmail(ctx context.Context, email string) (User, error) {
+	return p.findOne(ctx, "email", email)
+}
+
+func (p *postgresUserRepository) FindByCode(ctx context.Context, codeHash string) (User, error) {
+	return p.findOne(ctx, "code_hash", codeHash)
+}
+
+func (p *postgresUserRepository) FindByUsername(ctx context.Context, username string) (User, error) {
+	return p.findOne(ctx, "username", username)
+}
+
+func (p *postgresUserRepository) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	identity, err := json.Marshal([]map[string]string{{"provider": provider, "subject": subject}})
+	if err != nil {
+		return User{}, err
+	}
+	return p.findWhere(ctx, "document->'identities' @> $1::jsonb", string(identity))
+}
+
+// change lee el usuario bloqueando su fila, le aplica fn y lo guarda en la
+// misma transacción. Como el usuario completo va en document, es la única
+// forma de que dos escrituras a la vez no se pisen.
+func (p *postgresUserRepository) change(ctx context.Context, id primitive.ObjectID, fn func(user *User) error) error {
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		var document []byte
+		err := tx.QueryRow(ctx, "SELECT document FROM users WHERE id = $1 FOR UPDATE", id.Hex()).Scan(&document)
+		if err != nil {
+			return postgresUserError(err)
+		}
+		var user User
+		if err := bson.UnmarshalExtJSON(document, false, &user); err != nil {
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+
+		values, err := postgresUserValues(user)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE users SET
+			email = $2, code_hash = $3, username = $4, name = $5, last_name = $6, image_url = $7,
+			tags = $8, login_count = $9, created_at = $10, updated_at = $11, last_login_at = $12,
+			last_seen_at = $13, deactivated_at = $14, deleted_at = $15, erased_at = $16, document = $17
+			WHERE id = $1`, values...)
+		return postgresUserError(err)
+	})
+}
+
+func (p *postgresUserRepository) Update(ctx context.Context, user *User) error {
+	updated := *user
+	updated.UpdatedAt = documentTime(time.Now())
+
+	err := p.change(ctx, user.ID, func(stored *User) error {
+		if stored.DeletedAt != nil || stored.ErasedAt != nil {
+			return errUserNotFound
+		}
+		if !stored.UpdatedAt.Equal(user.UpdatedAt) {
+			return errUserConflict
+		}
+		keepActivity(&updated, *stored)
+		*stored = updated
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	user.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (p *postgresUserRepository) RecordActivity(ctx context.Context, id primitive.ObjectID, activity UserActivity) error {
+	return p.change(ctx, id, func(user *User) error {
+		activity.apply(user)
+		return nil
+	})
+}
+
+func (p *postgresUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return p.change(ctx, id, func(user *User) error {
+		if user.DeletedAt != nil || user.ErasedAt != nil {
+			return errUserNotFound
+		}
+		now := documentTime(time.Now())
+		user.DeletedAt = &now
+		user.UpdatedAt = now
+		return nil
+	})
+}
+
+func (p *postgresUserRepository) Restore(ctx context.Context, id primitive.ObjectID) (User, error) {
+	var restored User
+	err := p.change(ctx, id, func(user *User) error {
+		if user.DeletedAt == nil || user.ErasedAt != nil {
+			return errUserNotFound
+		}
+		user.DeletedAt = nil
+		user.UpdatedAt = documentTime(time.Now())
+		restored = *user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return restored, nil
+}
+
+func (p *postgresUserRepository) Erase(ctx context.Context, user User) error {
+	return p.change(ctx, user.ID, func(stored *User) error {
+		if stored.ErasedAt != nil {
+			return errUserNotFound
+		}
+		keepActivity(&user, *stored)
+		*stored = user
+		return nil
+	})
+}
+
+func (p *postgresUserRepository) Purge(ctx context.Context, id primitive.ObjectID) error {
+	result, err := p.pool.Exec(ctx, "DELETE FROM users WHERE id = $1 AND deleted_at IS NOT NULL", id.Hex())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+// postgresUserSorts traduce los órdenes de userSorts a columnas. Los nulos
+// van primero en orden ascendente, como en MongoDB.
+var postgresUserSorts = map[string]string{
+	"created_at": "created_at", "updated_at": "updated_at", "email": "email",
+	"last_login_at": "last_login_at", "last_seen_at": "last_seen_at", "login_count": "login_count",
+}
+
+func postgresUserOrder(sort string) string {
+	direction := "ASC NULLS FIRST"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC NULLS LAST"
+		sort = sort[1:]
+	}
+	column, ok := postgresUserSorts[sort]
+	if !ok {
+		column = "created_at"
+	}
+	if column == "email" {
+		return "email " + direction
+	}
+	return column + " " + direction + ", id " + direction
+}
+
+// likePrefix escapa value para usarlo como prefijo en LIKE.
+func likePrefix(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value) + "%"
+}
+
+func postgresUserWhere(query UserQuery) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	switch {
+	case query.Deleted:
+		conditions = append(conditions, "deleted_at IS NOT NULL", "erased_at IS NULL")
+	case !query.IncludeDeleted:
+		conditions = append(conditions, postgresActive)
+	}
+	if query.DeletedBefore != nil {
+		conditions = append(conditions, "deleted_at <= "+arg(*query.DeletedBefore))
+	}
+	if !query.ID.IsZero() {
+		conditions = append(conditions, "id = "+arg(query.ID.Hex()))
+	}
+	if query.Email != "" {
+		conditions = append(conditions, "email = "+arg(query.Email))
+	}
+	if query.CodeHash != "" {
+		conditions = append(conditions, "code_hash = "+arg(query.CodeHash))
+	}
+	if query.Active != nil {
+		if *query.Active {
+			conditions = append(conditions, "deactivated_at IS NULL")
+		} else {
+			conditions = append(conditions, "deactivated_at IS NOT NULL")
+		}
+	}
+	if len(query.AllTags) > 0 {
+		conditions = append(conditions, "tags @> "+arg(query.AllTags))
+	}
+	if len(query.AnyTags) > 0 {
+		conditions = append(conditions, "tags && "+arg(query.AnyTags))
+	}
+	if query.EmailPrefix != "" {
+		conditions = append(conditions, "email LIKE "+arg(likePrefix(query.EmailPrefix)))
+	}
+	if query.NamePrefix != "" {
+		prefix := arg(likePrefix(query.NamePrefix))
+		conditions = append(conditions, "(name LIKE "+prefix+" OR last_name LIKE "+prefix+")")
+	}
+	if query.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*query.CreatedAfter))
+	}
+	if query.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*query.CreatedBefore))
+	}
+	if query.SeenAfter != nil {
+		conditions = append(conditions, "last_seen_at >= "+arg(*query.SeenAfter))
+	}
+	if query.SeenBefore != nil {
+		conditions = append(conditions, "last_seen_at < "+arg(*query.SeenBefore))
+	}
+	if query.HasImage != nil {
+		if *query.HasImage {
+			conditions = append(conditions, "image_url <> ''")
+		} else {
+			conditions = append(conditions, "image_url = ''")
+		}
+	}
+	// Los campos que no tienen columna se buscan en document.
+	if query.ImageKey != "" {
+		keys, _ := json.Marshal([]map[string][]string{{"keys": {query.ImageKey}}})
+		original, _ := json.Marshal([]map[string]string{{"original_key": query.ImageKey}})
+		conditions = append(conditions, "(document->'images' @> "+arg(string(keys))+"::jsonb OR document->'images' @> "+arg(string(original))+"::jsonb)")
+	}
+	if query.ReminderDue {
+		conditions = append(conditions,
+			`document @> '{"verified": true}'`, "deactivated_at IS NULL",
+			`NOT document @> '{"reminders_opt_out": true}'`,
+			"document->'profile_reminder_sent_at' IS NULL",
+			"(name = '' OR last_name = '')")
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func (p *postgresUserRepository) List(ctx context.Context, query UserQuery) ([]User, int64, error) {
+	where, args := postgresUserWhere(query)
+
+	var total int64
+	if err := p.pool.QueryRow(ctx, "SELECT count(*) FROM users WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	sql := "SELECT document FROM users WHERE " + where + " ORDER BY " + postgresUserOrder(query.Sort)
+	if query.Limit > 0 {
+		sql += " LIMIT " + strconv.Itoa(query.Limit)
+	}
+	sql += " OFFSET " + strconv.Itoa(query.Skip)
+
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	documents, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]User, 0, len(documents))
+	for _, document := range documents {
+		var user User
+		if err := bson.UnmarshalExtJSON(document, false, &user); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, nil
+}
+
+func (p *postgresUserRepository) Each(ctx context.Context, query UserQuery, fn func(User) error) error {
+	users, _, err := p.List(ctx, query)
+	if err != nil {
+		return err
+	}
+	return eachUser(users, fn)
+}
+
+func (p *postgresUserRepository) Stats(ctx context.Context, firstDay, firstWeek time.Time) (UserStats, error) {
+	return countUserStats(ctx, p, firstDay, firstWeek)
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"backend/internal/store"
+)
+
+// userRepositories devuelve un repositorio vacío de cada driver. El de
+// MongoDB trabaja sobre las colecciones en memoria, con sus índices.
+func userRepositories(t *testing.T) map[string]UserRepository {
+	t.Helper()
+
+	file, err := store.OpenBolt(filepath.Join(t.TempDir(), "userapp.db"))
+	if err != nil {
+		t.Fatalf("abriendo bolt: %v", err)
+	}
+	t.Cleanup(func() { file.Close(context.Background()) })
+	bolt, err := NewBoltUserRepository(file.Bolt())
+	if err != nil {
+		t.Fatalf("preparando bolt: %v", err)
+	}
+
+	mongoRepo := NewMongoUserRepository(store.NewMemory().Users)
+	if err := mongoRepo.CreateIndexes(context.Background(), testServer); err != nil {
+		t.Fatalf("creando índices: %v", err)
+	}
+
+	return map[string]UserRepository{
+		"memory": NewMemoryUserRepository(),
+		"bolt":   bolt,
+		"mongo":  mongoRepo,
+	}
+}
+
+// testUser es un usuario listo para Create. Las fechas son de hace una hora
+// para que cualquier Update cambie updated_at.
+func testUser(email, codeHash string) *User {
+	created := time.Now().Add(-time.Hour)
+	return &User{Email: email, CodeHash: codeHash, CreatedAt: created, UpdatedAt: created}
+}
+
+func TestUserRepositoryFind(t *testing.T) {
+	for name, repo := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := testUser("ana@example.com", testServer.hashCode("ANA"))
+			user.Username = "ana"
+			user.Identities = []ExternalIdentity{{Provider: "github", Subject: "42"}}
+			if err := repo.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if user.ID.IsZero() {
+				t.Fatal("Create no asignó el ID")
+			}
+
+			lookups := map[string]func() (User, error){
+				"FindByID":       func() (User, error) { return repo.FindByID(ctx, user.ID) },
+				"FindByEmail":    func() (User, error) { return repo.FindByEmail(ctx, "ana@example.com") },
+				"FindByCode":     func() (User, error) { return repo.FindByCode(ctx, testServer.hashCode("ANA")) },
+				"FindByUsername": func() (User, error) { return repo.FindByUsername(ctx, "ana") },
+				"FindByIdentity": func() (User, error) { return repo.FindByIdentity(ctx, "github", "42") },
+			}
+			for lookup, find := range lookups {
+				found, err := find()
+				if err != nil {
+					t.Fatalf("%s: %v", lookup, err)
+				}
+				if found.ID != user.ID {
+					t.Errorf("%s devolvió %s, se esperaba %s", lookup, found.ID.Hex(), user.ID.Hex())
+				}
+			}
+
+			if _, err := repo.FindByEmail(ctx, "nadie@example.com"); !errors.Is(err, errUserNotFound) {
+				t.Errorf("FindByEmail de un email desconocido: %v, se esperaba errUserNotFound", err)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryDuplicates(t *testing.T) {
+	for name, repo := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			first := testUser("ana@example.com", testServer.hashCode("ANA"))
+			first.Username = "ana"
+			if err := repo.Create(ctx, first); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			sameEmail := testUser("ana@example.com", testServer.hashCode("OTRO"))
+			if err := repo.Create(ctx, sameEmail); !errors.Is(err, errDuplicateEmail) {
+				t.Errorf("email repetido: %v, se esperaba errDuplicateEmail", err)
+			}
+			sameCode := testUser("luis@example.com", testServer.hashCode("ANA"))
+			if err := repo.Create(ctx, sameCode); !errors.Is(err, errDuplicateCode) {
+				t.Errorf("código repetido: %v, se esperaba errDuplicateCode", err)
+			}
+			sameUsername := testUser("eva@example.com", testServer.hashCode("EVA"))
+			sameUsername.Username = "ana"
+			if err := repo.Create(ctx, sameUsername); !errors.Is(err, errDuplicateUsername) {
+				t.Errorf("username repetido: %v, se esperaba errDuplicateUsername", err)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryUpdate(t *testing.T) {
+	for name, repo := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := testUser("ana@example.com", testServer.hashCode("ANA"))
+			if err := repo.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			first, _ := repo.FindByID(ctx, user.ID)
+			second, _ := repo.FindByID(ctx, user.ID)
+
+			first.Name = "Ana"
+			if err := repo.Update(ctx, &first); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			second.Name = "Otra"
+			if err := repo.Update(ctx, &second); !errors.Is(err, errUserConflict) {
+				t.Fatalf("Update con una copia antigua: %v, se esperaba errUserConflict", err)
+			}
+
+			stored, err := repo.FindByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("FindByID: %v", err)
+			}
+			if stored.Name != "Ana" || !stored.UpdatedAt.Equal(first.UpdatedAt) {
+				t.Errorf("guardado %q con updated_at %v, se esperaba %q con %v", stored.Name, stored.UpdatedAt, "Ana", first.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryRecordActivity(t *testing.T) {
+	for name, repo := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := testUser("ana@example.com", testServer.hashCode("ANA"))
+			if err := repo.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			before, _ := repo.FindByID(ctx, user.ID)
+
+			now := documentTime(time.Now())
+			earlier := now.Add(-time.Minute)
+			if err := repo.RecordActivity(ctx, user.ID, UserActivity{Logins: 1, LoginAt: &now, SeenAt: &now}); err != nil {
+				t.Fatalf("RecordActivity: %v", err)
+			}
+			if err := repo.RecordActivity(ctx, user.ID, UserActivity{Logins: 1, LoginAt: &earlier}); err != nil {
+				t.Fatalf("RecordActivity: %v", err)
+			}
+
+			after, err := repo.FindByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("FindByID: %v", err)
+			}
+			if after.LoginCount != 2 {
+				t.Errorf("login_count = %d, se esperaba 2", after.LoginCount)
+			}
+			if after.LastLoginAt == nil || !after.LastLoginAt.Equal(now) {
+				t.Errorf("last_login_at = %v, se esperaba %v", after.LastLoginAt, now)
+			}
+			if !after.UpdatedAt.Equal(before.UpdatedAt) {
+				t.Errorf("RecordActivity cambió updated_at de %v a %v", before.UpdatedAt, after.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryDeleteRestorePurge(t *testing.T) {
+	for name, repo := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := testUser("ana@example.com", testServer.hashCode("ANA"))
+			if err := repo.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if err := repo.Purge(ctx, user.ID); !errors.Is(err, errUserNotFound) {
+				t.Errorf("Purge de una cuenta activa: %v, se esperaba errUserNotFound", err)
+			}
+			if err := repo.Delete(ctx, user.ID); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := repo.FindByEmail(ctx, user.Email); !errors.Is(err, errUserNotFound) {
+				t.Errorf("FindByEmail de una cuenta eliminada: %v, se esperaba errUserNotFound", err)
+			}
+			if _, total, err := repo.List(ctx, UserQuery{Deleted: true}); err != nil || total != 1 {
+				t.Errorf("List de eliminadas: total %d, error %v; se esperaba 1", total, err)
+			}
+
+			restored, err := repo.Restore(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+			if restored.DeletedAt != nil {
+				t.Error("Restore devolvió la cuenta todavía eliminada")
+			}
+
+			if err := repo.Delete(ctx, user.ID); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := repo.Purge(ctx, user.ID); err != nil {
+				t.Fatalf("Purge: %v", err)
+			}
+			if _, total, err := repo.List(ctx, UserQuery{IncludeDeleted: true}); err != nil || total != 0 {
+				t.Errorf("List tras Purge: total %d, error %v; se esperaba 0", total, err)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryList(t *testing.T) {
+	for name, repo := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			users := []struct {
+				email string
+				tags  []string
+			}{
+				{"carla@example.com", []string{"beta", "staff"}},
+				{"ana@example.com", []string{"beta"}},
+				{"bruno@otro.com", nil},
+			}
+			for i, u := range users {
+				user := testUser(u.email, testServer.hashCode(u.email))
+				user.Tags = u.tags
+				user.CreatedAt = user.CreatedAt.Add(time.Duration(i) * time.Minute)
+				if err := repo.Create(ctx, user); err != nil {
+					t.Fatalf("Create %s: %v", u.email, err)
+				}
+			}
+
+			cases := []struct {
+				name   string
+				query  UserQuery
+				emails []string
+				total  int64
+			}{
+				{"por email", UserQuery{Sort: "email"}, []string{"ana@example.com", "bruno@otro.com", "carla@example.com"}, 3},
+				{"por fecha descendente", UserQuery{Sort: "-created_at"}, []string{"bruno@otro.com", "ana@example.com", "carla@example.com"}, 3},
+				{"paginado", UserQuery{Sort: "email", Skip: 1, Limit: 1}, []string{"bruno@otro.com"}, 3},
+				{"prefijo de email", UserQuery{Sort: "email", EmailPrefix: "b"}, []string{"bruno@otro.com"}, 1},
+				{"todas las etiquetas", UserQuery{Sort: "email", AllTags: []string{"beta", "staff"}}, []string{"carla@example.com"}, 1},
+				{"alguna etiqueta", UserQuery{Sort: "email", AnyTags: []string{"beta"}}, []string{"ana@example.com", "carla@example.com"}, 2},
+			}
+			for _, tc := range cases {
+				found, total, err := repo.List(ctx, tc.query)
+				if err != nil {
+					t.Fatalf("%s: %v", tc.name, err)
+				}
+				var emails []string
+				for _, user := range found {
+					emails = append(emails, user.Email)
+				}
+				if total != tc.total || !slices.Equal(emails, tc.emails) {
+					t.Errorf("%s: %v (total %d), se esperaba %v (total %d)", tc.name, emails, total, tc.emails, tc.total)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/config"
+)
+
+const tokenPurposeSAMLRequest = "saml_request"
+
+// Atributos habituales en los que los IdP envían el email y el nombre.
+var (
+	samlEmailAttributes    = []string{"email", "mail", "emailAddress", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"}
+	samlNameAttributes     = []string{"givenName", "firstName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"}
+	samlLastNameAttributes = []string{"sn", "surname", "lastName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"}
+)
+
+// loadSAML configura el SP a partir de SAML_CERT_FILE, SAML_KEY_FILE y
+// SAML_IDP_METADATA_URL (o SAML_IDP_METADATA_FILE). Sin ellas el SSO queda deshabilitado.
+func (s *Server) loadSAML(cfg config.SAML) {
+	if !cfg.Enabled() {
+		return
+	}
+
+	sp, err := s.newSAMLServiceProvider(cfg)
+	if err != nil {
+		log.Printf("⚠️  SAML deshabilitado: %v", err)
+		return
+	}
+	s.samlSP = sp
+	s.samlTrustedDomains = nil
+	for _, domain := range cfg.TrustedDomains {
+		s.samlTrustedDomains = append(s.samlTrustedDomains, strings.ToLower(domain))
+	}
+	log.Printf("✅ SSO SAML habilitado (entity ID %s)", sp.EntityID)
+}
+
+func (s *Server) newSAMLServiceProvider(cfg config.SAML) (*saml.ServiceProvider, error) {
+	keyPair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("la clave SAML debe ser RSA")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	idpMetadata, err := loadIDPMetadata(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sin versión a propósito: estas URLs (y el entity ID que deriva de ellas)
+	// están dadas de alta en el IdP y cambiarlas rompería la integración.
+	metadataURL, _ := url.Parse(s.publicBaseURL() + "/api/saml/metadata")
+	acsURL, _ := url.Parse(s.publicBaseURL() + "/api/saml/acs")
+
+	entityID := cfg.EntityID
+	if entityID == "" {
+		entityID = metadataURL.String()
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:    entityID,
+		Key:         key,
+		Certificate: cert,
+		MetadataURL: *metadataURL,
+		AcsURL:      *acsURL,
+		IDPMetadata: idpMetadata,
+	}, nil
+}
+
+func loadIDPMetadata(cfg config.SAML) (*saml.EntityDescriptor, error) {
+	if path := cfg.IdPMetadataFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.ParseMetadata(data)
+	}
+
+	metadataURL, err := url.Parse(cfg.IdPMetadataURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return samlsp.FetchMetadata(ctx, http.DefaultClient, *metadataURL)
+}
+
+func (s *Server) samlEnabled(w http.ResponseWriter) bool {
+	if s.samlSP == nil {
+		http.Error(w, "SSO SAML no configurado", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if !s.samlEnabled(w) {
+		return
+	}
+
+	metadata, err := xml.MarshalIndent(s.samlSP.Metadata(), "", "  ")
+	if err != nil {
+		log.Printf("Error generando metadata SAML: %v", err)
+		http.Error(w, "Error generando metadata", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// handleSAMLLogin redirige al IdP. El ID del AuthnRequest se deriva de un
+// token de un solo uso que viaja como RelayState, así el ACS solo acepta
+// respuestas a peticiones emitidas por nosotros y cada una una sola vez.
+func (s *Server) handleSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.samlEnabled(w) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	relayState, err := s.createActionToken(ctx, primitive.NilObjectID, tokenPurposeSAMLRequest, 10*time.Minute)
+	if err != nil {
+		log.Printf("Error creando petición SAML: %v", err)
+		http.Error(w, "Error iniciando login", http.StatusInternalServerError)
+		return
+	}
+
+	req, err := s.samlSP.MakeAuthenticationRequest(
+		s.samlSP.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		log.Printf("Error creando AuthnRequest: %v", err)
+		http.Error(w, "Error iniciando login", http.StatusInternalServerError)
+		return
+	}
+	req.ID = samlRequestID(relayState)
+
+	redirectURL, err := req.Redirect(relayState, s.samlSP)
+	if err != nil {
+		log.Printf("Error firmando AuthnRequest: %v", err)
+		http.Error(w, "Error iniciando login", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func samlRequestID(relayState string) string {
+	return "id-" + relayState
+}
+
+func (s *Server) handleSAMLACS(w http.ResponseWriter, r *http.Request) {
+	if !s.samlEnabled(w) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Respuesta SAML inválida", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	relayState := r.PostForm.Get("RelayState")
+	if _, err := s.consumeActionToken(ctx, tokenPurposeSAMLRequest, relayState); err != nil {
+		http.Error(w, "Respuesta SAML no solicitada o expirada", http.StatusUnauthorized)
+		return
+	}
+
+	assertion, err := s.samlSP.ParseResponse(r, []string{samlRequestID(relayState)})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		log.Printf("Respuesta SAML rechazada: %v", err)
+		http.Error(w, "Respuesta SAML inválida", http.StatusUnauthorized)
+		return
+	}
+
+	email := samlAttribute(assertion, samlEmailAttributes)
+	if email == "" && assertion.Subject != nil && assertion.Subject.NameID != nil &&
+		strings.Contains(assertion.Subject.NameID.Value, "@") {
+		email = assertion.Subject.NameID.Value
+	}
+
+	// El IdP solo es de confianza para sus dominios: con cualquier otro email
+	// un usuario del IdP podría entrar en la cuenta local que lo use. El
+	// usuario se identifica por email, no por el NameID, que puede ser
+	// transitorio.
+	s.completeOAuthLogin(ctx, w, r, oauthProfile{
+		Provider:      "saml",
+		Subject:       strings.ToLower(email),
+		Email:         email,
+		EmailVerified: s.samlTrustedEmail(email),
+		Name:          samlAttribute(assertion, samlNameAttributes),
+		LastName:      samlAttribute(assertion, samlLastNameAttributes),
+	})
+}
+
+func (s *Server) samlTrustedEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at > 0 && slices.Contains(s.samlTrustedDomains, strings.ToLower(email[at+1:]))
+}
+
+func samlAttribute(assertion *saml.Assertion, names []string) string {
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			for _, name := range names {
+				if (attribute.Name == name || attribute.FriendlyName == name) && len(attribute.Values) > 0 {
+					return attribute.Values[0].Value
+				}
+			}
+		}
+	}
+	return ""
+}
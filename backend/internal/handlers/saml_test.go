@@ -0,0 +1,23 @@
+package handlers
+
+import "testing"
+
+func TestSAMLTrustedEmail(t *testing.T) {
+	defer func(domains []string) { testServer.samlTrustedDomains = domains }(testServer.samlTrustedDomains)
+	testServer.samlTrustedDomains = []string{"empresa.com"}
+
+	cases := map[string]bool{
+		"ana@empresa.com":          true,
+		"Ana@EMPRESA.com":          true,
+		"ana@gmail.com":            false,
+		"ana@empresa.com.evil.com": false,
+		"ana@sub.empresa.com":      false,
+		"empresa.com":              false,
+		"":                         false,
+	}
+	for email, want := range cases {
+		if got := testServer.samlTrustedEmail(email); got != want {
+			t.Errorf("samlTrustedEmail(%q) = %v, se esperaba %v", email, got, want)
+		}
+	}
+}
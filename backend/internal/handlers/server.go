@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/rs/cors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/config"
+	"backend/internal/email"
+	"backend/internal/events"
+	"backend/internal/store"
+)
+
+// Server atiende la API HTTP y la gRPC. Lo que cambia según el despliegue
+// (y en los tests) se le pasa a New: la base de datos, el repositorio de
+// usuarios, el proveedor de email y el bus de eventos. El resto del estado
+// se construye a partir de la configuración.
+type Server struct {
+	// database trabaja con la interfaz store.Collection y userRepo con
+	// UserRepository; main elige la implementación según DB_DRIVER.
+	database    *store.DB
+	userRepo    UserRepository
+	emailSender email.Sender
+	eventBus    events.Bus
+
+	// Secciones de la configuración que usan varios archivos; las demás las
+	// guarda el load* de su componente.
+	serverConfig   config.Server
+	apiConfig      config.API
+	usersConfig    config.Users
+	webhooksConfig config.Webhooks
+	authConfig     config.Auth
+	codesConfig    config.Codes
+	emailConfig    config.Email
+	uploadsConfig  config.Uploads
+
+	// liveMu protege los ajustes que Reload cambia con el servidor en
+	// marcha. Quien los lea fuera del arranque debe tomar liveMu.RLock.
+	liveMu sync.RWMutex
+	// activeConfig es la configuración en uso, con las recargas aplicadas.
+	activeConfig config.Config
+	// disposableDomains, adminToken y adminAllowedPrefixes se recargan en
+	// caliente: se leen con liveMu.
+	disposableDomains    map[string]bool
+	adminToken           string
+	adminAllowedPrefixes []netip.Prefix
+	// corsPolicy aplica la configuración CORS; ws.go la usa también para
+	// comprobar el origen al abrir un WebSocket, que no pasa por CORS. Es un
+	// puntero atómico porque se reemplaza al recargar la configuración.
+	corsPolicy atomic.Pointer[cors.Cors]
+
+	jwtSecret        []byte
+	sessionCookieKey []byte
+	signingKeySecret []byte
+	codePepper       []byte
+	tokenKeys        *keyring
+	codeEmailLimiter RateLimiter
+
+	// emailTemplates es el registro de plantillas por nombre. Cada archivo
+	// <nombre>.html es el cuerpo del email y define además un bloque
+	// "subject"; <nombre>.txt, opcional, es la versión en texto plano con los
+	// mismos datos.
+	emailTemplates     map[string]*htmltemplate.Template
+	emailTextTemplates map[string]*texttemplate.Template
+
+	authProviders map[string]AuthProvider
+	// oauthSuccessRedirectURL es OAUTH_SUCCESS_REDIRECT_URL (ver config.OAuth).
+	oauthSuccessRedirectURL string
+	webAuthn                *webauthn.WebAuthn
+	samlSP                  *saml.ServiceProvider
+	// samlTrustedDomains son los dominios de email que administra el IdP
+	// (SAML_TRUSTED_DOMAINS), en minúsculas.
+	samlTrustedDomains []string
+
+	storage Storage
+
+	// middlewares es la cadena del servidor HTTP y apiRateLimiter el límite
+	// por IP de API_RATE_LIMIT, nil si está desactivado. El batch lo aplica
+	// además a cada subpetición.
+	middlewares    middlewareChain
+	apiRateLimiter RateLimiter
+	handler        http.Handler
+
+	// Las redirecciones no se siguen: el endpoint es la URL registrada.
+	webhookClient *http.Client
+	// relayEvents indica que este proceso publica los eventos en cuanto los
+	// guarda. Solo Start lo activa: un comando de la CLI deja los eventos en
+	// el outbox y los publica el relay del servidor, que es quien tiene los
+	// consumidores.
+	relayEvents bool
+	userEvents  *eventHub
+
+	// lastSeenWrites recuerda cuándo esta instancia actualizó last_seen_at de
+	// cada usuario. Con varias instancias alguna escritura se repite, sin más.
+	lastSeenWrites sync.Map
+	// recoveredPanics cuenta los panics atendidos por recoverPanics desde el
+	// arranque; se publica en /admin/stats.
+	recoveredPanics   atomic.Int64
+	adminStatsCache   adminStatsCache
+	storageUsageCache storageUsageCache
+}
+
+// New prepara el servidor con las dependencias recibidas y la configuración
+// cfg. sender es nil si no hay proveedor de email. No crea índices ni
+// arranca los procesos en segundo plano: ver CreateIndexes y Start.
+func New(cfg config.Config, database *store.DB, users UserRepository, sender email.Sender, bus events.Bus) (*Server, error) {
+	s := &Server{
+		database:       database,
+		userRepo:       avatarUsers{users, cfg.Server.PublicBaseURL},
+		eventBus:       bus,
+		serverConfig:   cfg.Server,
+		apiConfig:      cfg.API,
+		usersConfig:    cfg.Users,
+		webhooksConfig: cfg.Webhooks,
+		activeConfig:   cfg,
+		tokenKeys:      &keyring{keys: map[string]*ecdsa.PrivateKey{}},
+
+		emailTemplates:     map[string]*htmltemplate.Template{},
+		emailTextTemplates: map[string]*texttemplate.Template{},
+		authProviders:      map[string]AuthProvider{},
+		webhookClient: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		userEvents: &eventHub{
+			subscribers: map[primitive.ObjectID]map[*eventSubscriber]bool{},
+			history:     map[primitive.ObjectID][]UserEvent{},
+		},
+	}
+
+	s.loadEmailSender(cfg.Email, sender)
+	if err := s.loadEmailTemplates(); err != nil {
+		return nil, fmt.Errorf("plantillas de email inválidas: %w", err)
+	}
+
+	s.loadJWTSecret(cfg.Auth)
+	s.loadSessionCookieKey()
+	s.loadSigningKeySecret()
+	s.loadCodes(cfg.Codes)
+	s.loadAuthProviders(cfg.OAuth)
+	s.loadWebAuthn(cfg.WebAuthn)
+	s.loadSAML(cfg.SAML)
+
+	if err := s.loadDisposableDomains(cfg.Users); err != nil {
+		return nil, fmt.Errorf("lista de dominios desechables inválida: %w", err)
+	}
+
+	if err := s.loadAdminAllowlist(cfg.Admin); err != nil {
+		return nil, fmt.Errorf("allowlist de administración inválida: %w", err)
+	}
+
+	if err := s.loadStorage(cfg.Uploads); err != nil {
+		return nil, fmt.Errorf("configuración de almacenamiento inválida: %w", err)
+	}
+
+	log.Printf("🔐 Modo de login: %s", s.loginMode())
+	log.Printf("🍪 Modo de sesión: %s", s.sessionMode())
+
+	s.handler = s.newHandler(cfg)
+	return s, nil
+}
+
+// ServeHTTP atiende las peticiones con las rutas y los middlewares de
+// newHandler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Start carga las claves de firma y arranca los procesos en segundo plano:
+// rotación de claves, recordatorios, limpiezas, reintentos de webhooks y los
+// consumidores y el relay de eventos. Solo lo llama `backend serve`.
+func (s *Server) Start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.reloadSigningKeys(ctx); err != nil {
+		return fmt.Errorf("error cargando claves de firma: %w", err)
+	}
+
+	go s.runSigningKeyRotation()
+	go s.runProfileReminders()
+	go s.runUploadsCleanup()
+	go s.runUserPurge()
+	go s.runWebhookRetries()
+	if err := s.subscribeEvents(); err != nil {
+		return fmt.Errorf("error suscribiendo los consumidores de eventos: %w", err)
+	}
+	s.relayEvents = true
+	go s.runOutboxRelay()
+	return nil
+}
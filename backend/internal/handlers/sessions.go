@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Cuántos hashes de tokens ya rotados se guardan por sesión para detectar reutilización.
+	maxPreviousRefreshHashes = 50
+
+	// refreshRotationGrace es cuánto se sigue aceptando el último token rotado
+	// sin considerarlo robado. En modo cookie el navegador lanza a la vez
+	// varias peticiones con el access token caducado: la primera rota el
+	// refresh token y las demás llegan con el anterior.
+	refreshRotationGrace = 30 * time.Second
+)
+
+var (
+	errInvalidRefreshToken    = errors.New("refresh token inválido o expirado")
+	errRefreshTokenReused     = errors.New("refresh token reutilizado")
+	errRefreshTokenSuperseded = errors.New("refresh token recién rotado por otra petición")
+)
+
+type Session struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	RefreshHash    string             `json:"-" bson:"refresh_hash"`
+	PreviousHashes []string           `json:"-" bson:"previous_hashes"`
+	RotatedHash    string             `json:"-" bson:"rotated_hash,omitempty"`
+	RotatedAt      *time.Time         `json:"-" bson:"rotated_at,omitempty"`
+	UserAgent      string             `json:"user_agent" bson:"user_agent"`
+	IP             string             `json:"ip" bson:"ip"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt     time.Time          `json:"last_used_at" bson:"last_used_at"`
+	ExpiresAt      time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt      *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Current        bool               `json:"current" bson:"-"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *Server) refreshTokenTTL() time.Duration {
+	return s.authConfig.RefreshTokenTTL
+}
+
+func (s *Server) createSessionIndexes(ctx context.Context) error {
+	_, err := s.database.Sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "refresh_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "previous_hashes", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func (s *Server) createSession(ctx context.Context, r *http.Request, user User) (Session, string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	now := time.Now()
+	session := Session{
+		UserID:         user.ID,
+		RefreshHash:    hash,
+		PreviousHashes: []string{},
+		UserAgent:      r.UserAgent(),
+		IP:             s.clientIP(r),
+		CreatedAt:      now,
+		LastUsedAt:     now,
+		ExpiresAt:      now.Add(s.refreshTokenTTL()),
+	}
+
+	result, err := s.database.Sessions.InsertOne(ctx, session)
+	if err != nil {
+		return Session{}, "", err
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+	s.publishLogin(session)
+	return session, token, nil
+}
+
+var errSessionRevoked = errors.New("sesión revocada")
+
+// checkSessionActive comprueba que la sesión del access token siga abierta,
+// así revocar una sesión (logout, rotación del código, desactivación) también
+// invalida sus access tokens antes de que caduquen. Los tokens de
+// suplantación no tienen sesión y caducan enseguida.
+func (s *Server) checkSessionActive(ctx context.Context, claims *SessionClaims) error {
+	if claims.SessionID == "" {
+		return nil
+	}
+	sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return errSessionRevoked
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return errSessionRevoked
+	}
+
+	count, err := s.database.Sessions.CountDocuments(ctx, bson.M{
+		"_id":        sessionID,
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// revokeUserSessions cierra todas las sesiones abiertas del usuario.
+func (s *Server) revokeUserSessions(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.database.Sessions.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// rotateSession canjea un refresh token por uno nuevo de la misma sesión.
+// Si el token presentado ya había sido rotado, se asume que fue robado y se
+// revoca la sesión completa, salvo que sea el último rotado y no haya pasado
+// refreshRotationGrace: entonces devuelve la sesión con
+// errRefreshTokenSuperseded y no la revoca.
+func (s *Server) rotateSession(ctx context.Context, r *http.Request, refreshToken string) (Session, string, error) {
+	hash := hashToken(refreshToken)
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"refresh_hash": hash,
+		"revoked_at":   bson.M{"$exists": false},
+		"expires_at":   bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_hash": newHash,
+			"last_used_at": now,
+			"user_agent":   r.UserAgent(),
+			"ip":           s.clientIP(r),
+			"expires_at":   now.Add(s.refreshTokenTTL()),
+			"rotated_hash": hash,
+			"rotated_at":   now,
+		},
+		"$push": bson.M{
+			"previous_hashes": bson.M{"$each": []string{hash}, "$slice": -maxPreviousRefreshHashes},
+		},
+	}
+
+	var session Session
+	err = s.database.Sessions.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+	if err == nil {
+		return session, newToken, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return Session{}, "", err
+	}
+
+	err = s.database.Sessions.FindOne(ctx, bson.M{
+		"previous_hashes": hash,
+		"rotated_hash":    hash,
+		"rotated_at":      bson.M{"$gt": now.Add(-refreshRotationGrace)},
+		"revoked_at":      bson.M{"$exists": false},
+		"expires_at":      bson.M{"$gt": now},
+	}).Decode(&session)
+	if err == nil {
+		return session, "", errRefreshTokenSuperseded
+	}
+	if err != mongo.ErrNoDocuments {
+		return Session{}, "", err
+	}
+
+	result, err := s.database.Sessions.UpdateOne(ctx,
+		bson.M{"previous_hashes": hash, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	)
+	if err != nil {
+		return Session{}, "", err
+	}
+	if result.MatchedCount > 0 {
+		return Session{}, "", errRefreshTokenReused
+	}
+	return Session{}, "", errInvalidRefreshToken
+}
+
+// handleRefreshToken acepta el refresh token en el cuerpo o, en modo cookie,
+// en la cookie de sesión.
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.RefreshToken == "" && s.sessionMode() == sessionModeCookie {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if payload, err := s.openSessionCookie(cookie.Value); err == nil {
+				req.RefreshToken = payload.RefreshToken
+			}
+		}
+	}
+
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token requerido", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	session, refreshToken, err := s.rotateSession(ctx, r, req.RefreshToken)
+	if err == errRefreshTokenReused {
+		log.Printf("⚠️  Reutilización de refresh token detectada, sesión revocada")
+		http.Error(w, "Refresh token inválido", http.StatusUnauthorized)
+		return
+	}
+	if err == errInvalidRefreshToken || err == errRefreshTokenSuperseded {
+		http.Error(w, "Refresh token inválido", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotando sesión: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userRepo.FindByID(ctx, session.UserID)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Refresh token inválido", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.tokenResponse(user, session.ID, refreshToken)
+	if err == nil {
+		response, err = s.deliverTokens(w, r, response)
+	}
+	if err != nil {
+		log.Printf("Error creando sesión: %v", err)
+		http.Error(w, "Error creando sesión", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func sessionUserID(r *http.Request) (primitive.ObjectID, bool) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
+
+var sessionList = listOptions{
+	Sorts:       map[string]bool{"created_at": true, "last_used_at": true},
+	DefaultSort: "-last_used_at",
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		return
+	}
+
+	list, invalid := parseListQuery(r.URL.Query(), sessionList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	sessions := []Session{}
+	total, err := findList(ctx, s.database.Sessions, bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, list, &sessions)
+	if err != nil {
+		log.Printf("Error listando sesiones: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.Hex() == claims.SessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, sessions, len(sessions), total))
+}
+
+// handleRevokeSession revoca la sesión del dispositivo: su refresh token y,
+// con checkSessionActive, los access tokens ya emitidos.
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Sesión no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.database.Sessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error revocando sesión: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		http.Error(w, "Sesión no encontrada", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Sesión revocada correctamente",
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GET /api/v1/events envía los mismos eventos que el WebSocket (ver ws.go)
+// como server-sent events, para clientes que no pueden usar WebSockets. Cada
+// evento lleva su ID, así que EventSource reenvía Last-Event-ID al
+// reconectar y se le mandan los eventos que se perdió mientras tanto. Si ese
+// ID ya no está en el historial se envía un evento stream.reset para que el
+// cliente vuelva a pedir el perfil. La autenticación es la del WebSocket.
+
+const (
+	sseKeepAliveInterval = 30 * time.Second
+	sseRetry             = 5 * time.Second
+)
+
+const userEventStreamReset = "stream.reset"
+
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("access_token"); token != "" && bearerToken(r) == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	claims, err := s.requestClaims(w, r)
+	if err == errNoCredentials {
+		http.Error(w, "Token de acceso requerido", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	setRequestUser(r, claims.Subject)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming no soportado", http.StatusInternalServerError)
+		return
+	}
+
+	// Algunos polyfills de EventSource no pueden enviar cabeceras y mandan
+	// el último ID en la query.
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var (
+		missed      []UserEvent
+		found       bool
+		events      <-chan UserEvent
+		unsubscribe func()
+	)
+	if lastEventID != "" {
+		missed, found, events, unsubscribe = s.userEvents.Resume(userID, claims.SessionID, lastEventID)
+	} else {
+		events, unsubscribe = s.userEvents.Subscribe(userID, claims.SessionID)
+	}
+	defer unsubscribe()
+
+	// La conexión queda abierta; los plazos del servidor la cortarían.
+	extendDeadlines(w, 0)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Evita que nginx acumule la respuesta antes de enviarla.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if lastEventID != "" && !found {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", userEventStreamReset)
+	}
+	for _, event := range missed {
+		if err := writeServerEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	var expired <-chan time.Time
+	if claims.ExpiresAt != nil {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if err := writeServerEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			// Los comentarios no llegan al cliente; solo mantienen viva la
+			// conexión a través de proxies.
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-expired:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeServerEvent escribe el evento completo en JSON, igual que el
+// WebSocket, para que los clientes compartan el código que lo interpreta.
+func writeServerEvent(w http.ResponseWriter, event UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error codificando evento %s: %v", event.Type, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
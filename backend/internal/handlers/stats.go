@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GET /api/admin/stats resume el estado de las cuentas, los emails y el
+// almacenamiento. Las cifras salen de pipelines de agregación (o de recorrer
+// los usuarios, según el repositorio) y se guardan en memoria durante
+// adminStatsTTL, para que un panel que refresca cada pocos segundos no
+// recorra los usuarios en cada petición. Recorrer el
+// almacenamiento es mucho más lento, así que se hace en segundo plano y se
+// reutiliza durante storageUsageTTL.
+
+const (
+	adminStatsTTL   = time.Minute
+	storageUsageTTL = 15 * time.Minute
+
+	// Periodos de los registros por día y por semana, y de la tasa de fallos
+	// de los emails.
+	statsDays      = 30
+	statsWeeks     = 12
+	statsEmailDays = 30
+)
+
+type AdminStats struct {
+	Users            int64        `json:"users"`
+	VerifiedUsers    int64        `json:"verified_users"`
+	DeactivatedUsers int64        `json:"deactivated_users"`
+	DeletedUsers     int64        `json:"deleted_users"`
+	ErasedUsers      int64        `json:"erased_users"`
+	VerificationRate float64      `json:"verification_rate"`
+	ActiveSessions   int64        `json:"active_sessions"`
+	APIKeys          int64        `json:"api_keys"`
+	RecoveredPanics  int64        `json:"recovered_panics"`
+	Signups          SignupStats  `json:"signups"`
+	Email            EmailStats   `json:"email"`
+	Storage          StorageStats `json:"storage"`
+	GeneratedAt      time.Time    `json:"generated_at"`
+}
+
+// StatsBucket es el número de registros de un día (2006-01-02) o de una
+// semana ISO (2006-W01).
+type StatsBucket struct {
+	Period string `json:"period" bson:"_id"`
+	Count  int64  `json:"count" bson:"count"`
+}
+
+type SignupStats struct {
+	PerDay  []StatsBucket `json:"per_day"`
+	PerWeek []StatsBucket `json:"per_week"`
+}
+
+// EmailStats cuenta los emails de los últimos WindowDays días. FailureRate
+// es la proporción de los que acabaron en dead letter entre los que se
+// intentaron enviar al proveedor.
+type EmailStats struct {
+	WindowDays  int     `json:"window_days"`
+	Sent        int64   `json:"sent"`
+	Failed      int64   `json:"failed"`
+	Pending     int64   `json:"pending"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// StorageStats describe lo guardado en el almacenamiento. Objects y Bytes
+// solo están si el backend permite listar sus archivos y ya se ha recorrido.
+type StorageStats struct {
+	Backend   string     `json:"backend"`
+	Images    int64      `json:"images"`
+	Objects   *int64     `json:"objects,omitempty"`
+	Bytes     *int64     `json:"bytes,omitempty"`
+	ScannedAt *time.Time `json:"scanned_at,omitempty"`
+}
+
+type adminStatsCache struct {
+	mu      sync.Mutex
+	stats   AdminStats
+	expires time.Time
+}
+
+type storageUsageCache struct {
+	mu         sync.Mutex
+	objects    int64
+	bytes      int64
+	scannedAt  time.Time
+	refreshing bool
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stats, err := s.cachedAdminStats(ctx)
+	if err != nil {
+		log.Printf("Error calculando estadísticas: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	stats.RecoveredPanics = s.recoveredPanics.Load()
+	stats.Storage = s.storageStats(stats.Storage.Images)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// cachedAdminStats devuelve las estadísticas guardadas o las calcula si han
+// caducado. Las peticiones que llegan mientras se calculan esperan al
+// resultado en vez de lanzar otro cálculo.
+func (s *Server) cachedAdminStats(ctx context.Context) (AdminStats, error) {
+	s.adminStatsCache.mu.Lock()
+	defer s.adminStatsCache.mu.Unlock()
+
+	if time.Now().Before(s.adminStatsCache.expires) {
+		return s.adminStatsCache.stats, nil
+	}
+	stats, err := s.computeAdminStats(ctx)
+	if err != nil {
+		return AdminStats{}, err
+	}
+	s.adminStatsCache.stats = stats
+	s.adminStatsCache.expires = time.Now().Add(adminStatsTTL)
+	return stats, nil
+}
+
+func (s *Server) computeAdminStats(ctx context.Context) (AdminStats, error) {
+	now := time.Now().UTC()
+	stats := AdminStats{GeneratedAt: now}
+
+	if err := s.userStats(ctx, now, &stats); err != nil {
+		return AdminStats{}, fmt.Errorf("error agregando usuarios: %w", err)
+	}
+	if err := s.emailStats(ctx, now, &stats.Email); err != nil {
+		return AdminStats{}, fmt.Errorf("error agregando el mail log: %w", err)
+	}
+
+	var err error
+	stats.ActiveSessions, err = s.database.Sessions.CountDocuments(ctx, bson.M{
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return AdminStats{}, fmt.Errorf("error contando sesiones: %w", err)
+	}
+	stats.APIKeys, err = s.database.APIKeys.CountDocuments(ctx, bson.M{"revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		return AdminStats{}, fmt.Errorf("error contando API keys: %w", err)
+	}
+	return stats, nil
+}
+
+// UserStats son las cifras de usuarios que devuelve UserRepository.Stats. Los
+// registros incluyen las cuentas eliminadas después.
+type UserStats struct {
+	Users       int64
+	Verified    int64
+	Deactivated int64
+	Deleted     int64
+	Erased      int64
+	Images      int64
+	PerDay      []StatsBucket
+	PerWeek     []StatsBucket
+}
+
+// userStats cuenta las cuentas por estado y los registros por día y semana.
+func (s *Server) userStats(ctx context.Context, now time.Time, stats *AdminStats) error {
+	firstDay := startOfDay(now).AddDate(0, 0, -(statsDays - 1))
+	firstWeek := startOfISOWeek(now).AddDate(0, 0, -7*(statsWeeks-1))
+
+	users, err := s.userRepo.Stats(ctx, firstDay, firstWeek)
+	if err != nil {
+		return err
+	}
+	stats.Users = users.Users
+	stats.VerifiedUsers = users.Verified
+	stats.DeactivatedUsers = users.Deactivated
+	stats.DeletedUsers = users.Deleted
+	stats.ErasedUsers = users.Erased
+	stats.Storage.Images = users.Images
+	stats.VerificationRate = ratio(stats.VerifiedUsers, stats.Users)
+
+	// Los periodos sin registros no vienen en users; se rellenan con cero
+	// para que la serie no tenga huecos.
+	stats.Signups.PerDay = fillBuckets(users.PerDay, statsDays, func(i int) string {
+		return firstDay.AddDate(0, 0, i).Format("2006-01-02")
+	})
+	stats.Signups.PerWeek = fillBuckets(users.PerWeek, statsWeeks, func(i int) string {
+		return isoWeekPeriod(firstWeek.AddDate(0, 0, 7*i))
+	})
+	return nil
+}
+
+func isoWeekPeriod(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// countUserStats calcula UserStats recorriendo todos los usuarios, para los
+// repositorios que no agregan en la base.
+func countUserStats(ctx context.Context, repo UserRepository, firstDay, firstWeek time.Time) (UserStats, error) {
+	var stats UserStats
+	perDay, perWeek := map[string]int64{}, map[string]int64{}
+	err := repo.Each(ctx, UserQuery{IncludeDeleted: true}, func(user User) error {
+		switch {
+		case user.ErasedAt != nil:
+			stats.Erased++
+		case user.DeletedAt != nil:
+			stats.Deleted++
+		default:
+			stats.Users++
+			if user.Verified {
+				stats.Verified++
+			}
+			if user.DeactivatedAt != nil {
+				stats.Deactivated++
+			}
+		}
+		stats.Images += int64(len(user.Images))
+
+		created := user.CreatedAt.UTC()
+		if !created.Before(firstDay) {
+			perDay[created.Format("2006-01-02")]++
+		}
+		if !created.Before(firstWeek) {
+			perWeek[isoWeekPeriod(created)]++
+		}
+		return nil
+	})
+	if err != nil {
+		return UserStats{}, err
+	}
+
+	for period, count := range perDay {
+		stats.PerDay = append(stats.PerDay, StatsBucket{Period: period, Count: count})
+	}
+	for period, count := range perWeek {
+		stats.PerWeek = append(stats.PerWeek, StatsBucket{Period: period, Count: count})
+	}
+	return stats, nil
+}
+
+func (s *Server) emailStats(ctx context.Context, now time.Time, stats *EmailStats) error {
+	stats.WindowDays = statsEmailDays
+
+	cursor, err := s.database.MailLog.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": now.AddDate(0, 0, -statsEmailDays)}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var statuses []StatsBucket
+	if err := cursor.All(ctx, &statuses); err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		switch status.Period {
+		case mailStatusSent:
+			stats.Sent = status.Count
+		case mailStatusDead:
+			stats.Failed = status.Count
+		case mailStatusPending:
+			stats.Pending = status.Count
+		}
+	}
+	stats.FailureRate = ratio(stats.Failed, stats.Sent+stats.Failed)
+	return nil
+}
+
+// storageStats completa las estadísticas del almacenamiento con el último
+// recorrido y lanza otro en segundo plano si ha caducado.
+func (s *Server) storageStats(images int64) StorageStats {
+	stats := StorageStats{Backend: s.storage.Name(), Images: images}
+	if _, ok := s.storage.(storageLister); !ok {
+		return stats
+	}
+
+	s.storageUsageCache.mu.Lock()
+	defer s.storageUsageCache.mu.Unlock()
+
+	if time.Since(s.storageUsageCache.scannedAt) > storageUsageTTL && !s.storageUsageCache.refreshing {
+		s.storageUsageCache.refreshing = true
+		go s.refreshStorageUsage()
+	}
+	if !s.storageUsageCache.scannedAt.IsZero() {
+		objects, bytes, scannedAt := s.storageUsageCache.objects, s.storageUsageCache.bytes, s.storageUsageCache.scannedAt
+		stats.Objects, stats.Bytes, stats.ScannedAt = &objects, &bytes, &scannedAt
+	}
+	return stats
+}
+
+func (s *Server) refreshStorageUsage() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var objects, bytes int64
+	err := s.storage.(storageLister).List(ctx, func(object StoredObject) error {
+		objects++
+		bytes += object.Size
+		return nil
+	})
+
+	s.storageUsageCache.mu.Lock()
+	defer s.storageUsageCache.mu.Unlock()
+	s.storageUsageCache.refreshing = false
+	if err != nil {
+		log.Printf("⚠️  Error recorriendo el almacenamiento para las estadísticas: %v", err)
+		return
+	}
+	s.storageUsageCache.objects = objects
+	s.storageUsageCache.bytes = bytes
+	s.storageUsageCache.scannedAt = time.Now().UTC()
+}
+
+// fillBuckets devuelve los n periodos de period(0) a period(n-1), con la
+// cuenta de buckets o cero.
+func fillBuckets(buckets []StatsBucket, n int, period func(i int) string) []StatsBucket {
+	counts := make(map[string]int64, len(buckets))
+	for _, bucket := range buckets {
+		counts[bucket.Period] = bucket.Count
+	}
+	filled := make([]StatsBucket, n)
+	for i := range filled {
+		filled[i] = StatsBucket{Period: period(i), Count: counts[period(i)]}
+	}
+	return filled
+}
+
+// ratio redondea a cuatro decimales; sin total es cero.
+func ratio(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*10000) / 10000
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// startOfISOWeek es el lunes de la semana de t.
+func startOfISOWeek(t time.Time) time.Time {
+	day := startOfDay(t)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
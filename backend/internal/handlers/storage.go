@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backend/internal/config"
+)
+
+// Storage guarda los archivos subidos por los usuarios (avatares) y genera
+// su URL pública. Se elige con STORAGE_BACKEND en loadStorage.
+type Storage interface {
+	Name() string
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// Prefijo de las claves que no deben servirse públicamente (originales de avatares).
+const privateStoragePrefix = "originals/"
+
+// Prefijo de los archivos nombrados por el hash de su contenido. Como nunca
+// cambian, se sirven con caché indefinida.
+const contentAddressedPrefix = "images/"
+
+// contentKey nombra un archivo por el SHA-256 de su contenido.
+func contentKey(data []byte, ext string) string {
+	return contentAddressedPrefix + sha256Hex(data) + ext
+}
+
+// storageCacheControl es la cabecera Cache-Control con la que se sirve cada archivo.
+func storageCacheControl(key string) string {
+	if strings.HasPrefix(key, contentAddressedPrefix) {
+		return "public, max-age=31536000, immutable"
+	}
+	return ""
+}
+
+// URL con la que se guardaban las imágenes antes de que fuera configurable.
+const legacyUploadsURL = "http://localhost:8080/uploads/"
+
+// storageChecker lo implementan los backends que pueden verificar su
+// configuración al arrancar (bucket existente, credenciales válidas).
+type storageChecker interface {
+	Check(ctx context.Context) error
+}
+
+// StoredObject describe un archivo del almacenamiento al listarlo.
+type StoredObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// storageLister lo implementan los backends que pueden recorrer todos sus
+// archivos; lo usa la limpieza de huérfanos (cleanup.go).
+type storageLister interface {
+	List(ctx context.Context, fn func(StoredObject) error) error
+}
+
+// storagePresigner lo implementan los backends que permiten que el cliente
+// suba el archivo directamente con una URL firmada (S3 y compatibles, como
+// GCS con claves HMAC), sin pasar por el servidor.
+type storagePresigner interface {
+	PresignPut(key, contentType string, size int64, ttl time.Duration) (string, http.Header, error)
+}
+
+// loadStorage lee STORAGE_BACKEND: "local" (por defecto, carpeta UPLOADS_DIR),
+// "s3" (cualquier servicio compatible: AWS, MinIO, R2...) o "azure".
+func (s *Server) loadStorage(cfg config.Uploads) error {
+	s.uploadsConfig = cfg
+
+	var err error
+	switch cfg.Backend {
+	case "s3":
+		s.storage, err = newS3Storage(cfg.S3)
+	case "azure":
+		s.storage, err = newAzureStorage(cfg.Azure)
+	default:
+		s.storage, err = s.newLocalStorage(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if checker, ok := s.storage.(storageChecker); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := checker.Check(ctx); err != nil {
+			return fmt.Errorf("%s: %v", s.storage.Name(), err)
+		}
+	}
+
+	log.Printf("✅ Almacenamiento de archivos: %s", s.storage.Name())
+	return nil
+}
+
+// uploadURL es la URL pública de un archivo. UPLOADS_CDN_URL, si está
+// configurada, sustituye a la del backend para servir las imágenes desde un CDN.
+func (s *Server) uploadURL(key string) string {
+	if cdn := s.uploadsConfig.CDNURL; cdn != "" {
+		return cdn + "/" + key
+	}
+	return s.storage.URL(key)
+}
+
+// uploadKeyFromURL recupera la clave de un archivo a partir de una URL
+// guardada con cualquiera de las bases conocidas (CDN, backend o la antigua
+// URL fija de localhost).
+func (s *Server) uploadKeyFromURL(url string, extraPrefixes ...string) (string, bool) {
+	prefixes := append([]string{s.uploadURL(""), s.storage.URL(""), legacyUploadsURL}, extraPrefixes...)
+	for _, prefix := range prefixes {
+		prefix = strings.TrimRight(prefix, "/") + "/"
+		if strings.HasPrefix(url, prefix) {
+			return strings.TrimPrefix(url, prefix), true
+		}
+	}
+	return "", false
+}
+
+type localStorage struct {
+	dir       string
+	publicURL string
+	// maxAge es el tiempo que el navegador puede reutilizar un archivo sin
+	// revalidarlo (UPLOADS_MAX_AGE, 1h por defecto). Los archivos con nombre
+	// por contenido usan siempre caché indefinida.
+	maxAge time.Duration
+}
+
+func (s *Server) newLocalStorage(cfg config.Uploads) (*localStorage, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	publicURL := cfg.PublicURL
+	if publicURL == "" {
+		publicURL = s.publicBaseURL() + "/uploads"
+	}
+	return &localStorage{dir: cfg.Dir, publicURL: publicURL, maxAge: cfg.MaxAge}, nil
+}
+
+func (s *localStorage) Name() string {
+	return "local (" + s.dir + ")"
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) URL(key string) string {
+	return s.publicURL + "/" + key
+}
+
+func (s *localStorage) List(ctx context.Context, fn func(StoredObject) error) error {
+	return filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(StoredObject{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// Handler sirve los archivos en /uploads/, salvo los privados. Solo tiene
+// sentido con almacenamiento local. Envía ETag y Last-Modified y responde
+// 304 a las peticiones condicionales (http.ServeContent).
+func (s *localStorage) Handler() http.Handler {
+	return http.StripPrefix("/uploads/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if key == "" || strings.HasPrefix(key, privateStoragePrefix) {
+			http.NotFound(w, r)
+			return
+		}
+
+		file, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		cacheControl := storageCacheControl(key)
+		if cacheControl == "" {
+			cacheControl = fmt.Sprintf("public, max-age=%d", int(s.maxAge.Seconds()))
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	}))
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/internal/config"
+)
+
+const azureStorageAPIVersion = "2021-08-06"
+
+// azureStorage sube los archivos como block blobs con la API REST de Azure
+// Blob Storage. Se autentica con la clave de la cuenta (connection string)
+// o, si no hay clave, con la identidad administrada de la instancia.
+type azureStorage struct {
+	account   string
+	key       []byte
+	endpoint  string
+	container string
+	publicURL string
+	// Identidad administrada (ver managedIdentityToken).
+	clientID         string
+	identityEndpoint string
+	identityHeader   string
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// newAzureStorage lee AZURE_STORAGE_CONNECTION_STRING o, para identidad
+// administrada, AZURE_STORAGE_ACCOUNT. AZURE_STORAGE_CONTAINER es obligatoria.
+func newAzureStorage(cfg config.Azure) (*azureStorage, error) {
+	s := &azureStorage{
+		container: cfg.Container,
+		publicURL: cfg.PublicURL,
+
+		clientID:         cfg.ClientID,
+		identityEndpoint: cfg.IdentityEndpoint,
+		identityHeader:   cfg.IdentityHeader,
+	}
+
+	if cfg.ConnectionString != "" {
+		if err := s.parseConnectionString(cfg.ConnectionString); err != nil {
+			return nil, err
+		}
+	} else {
+		s.account = cfg.Account
+	}
+
+	if s.endpoint == "" {
+		s.endpoint = "https://" + s.account + ".blob.core.windows.net"
+	}
+	if s.publicURL == "" {
+		s.publicURL = s.endpoint + "/" + s.container
+	}
+	return s, nil
+}
+
+func (s *azureStorage) parseConnectionString(connectionString string) error {
+	values := map[string]string{}
+	for _, part := range strings.Split(connectionString, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if ok {
+			values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	s.account = values["AccountName"]
+	if s.account == "" || values["AccountKey"] == "" {
+		return errors.New("AZURE_STORAGE_CONNECTION_STRING debe incluir AccountName y AccountKey")
+	}
+	key, err := base64.StdEncoding.DecodeString(values["AccountKey"])
+	if err != nil {
+		return fmt.Errorf("AccountKey inválida: %v", err)
+	}
+	s.key = key
+
+	s.endpoint = strings.TrimRight(values["BlobEndpoint"], "/")
+	if s.endpoint == "" {
+		protocol := values["DefaultEndpointsProtocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := values["EndpointSuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		s.endpoint = protocol + "://" + s.account + ".blob." + suffix
+	}
+	return nil
+}
+
+func (s *azureStorage) Name() string {
+	return "Azure Blob (" + s.account + "/" + s.container + ")"
+}
+
+func (s *azureStorage) URL(key string) string {
+	return s.publicURL + "/" + key
+}
+
+func (s *azureStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	resp, err := s.do(ctx, "PUT", key, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error de Azure Blob: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (s *azureStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, "GET", key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error de Azure Blob: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+func (s *azureStorage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error de Azure Blob: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// List recorre el contenedor con List Blobs, paginando con NextMarker.
+func (s *azureStorage) List(ctx context.Context, fn func(StoredObject) error) error {
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := s.do(ctx, "GET", "?"+query.Encode(), nil, "")
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error de Azure Blob: status %d, response: %s", resp.StatusCode, string(body))
+		}
+
+		var result struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					ContentLength int64  `xml:"Content-Length"`
+					LastModified  string `xml:"Last-Modified"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return fmt.Errorf("respuesta de Azure Blob inválida: %v", err)
+		}
+
+		for _, blob := range result.Blobs {
+			modTime, _ := http.ParseTime(blob.Properties.LastModified)
+			object := StoredObject{Key: blob.Name, Size: blob.Properties.ContentLength, ModTime: modTime}
+			if err := fn(object); err != nil {
+				return err
+			}
+		}
+		if result.NextMarker == "" {
+			return nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (s *azureStorage) do(ctx context.Context, method, key string, data []byte, contentType string) (*http.Response, error) {
+	key, query, _ := strings.Cut(key, "?")
+	target := s.endpoint + "/" + s.container
+	if key != "" {
+		target += "/" + key
+	}
+	blobURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint de Azure inválido: %v", err)
+	}
+	blobURL.RawQuery = query
+
+	req, err := http.NewRequestWithContext(ctx, method, blobURL.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creando petición: %v", err)
+	}
+	req.ContentLength = int64(len(data))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method == "PUT" {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		if cacheControl := storageCacheControl(key); cacheControl != "" {
+			req.Header.Set("x-ms-blob-cache-control", cacheControl)
+		}
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureStorageAPIVersion)
+
+	if len(s.key) > 0 {
+		s.signSharedKey(req)
+	} else {
+		token, err := s.managedIdentityToken(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error obteniendo token de identidad administrada: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error enviando petición: %v", err)
+	}
+	return resp, nil
+}
+
+// signSharedKey añade la cabecera Authorization con el esquema Shared Key.
+func (s *azureStorage) signSharedKey(req *http.Request) {
+	var msHeaders []string
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower+":"+strings.Join(values, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		"", // Content-Encoding
+		"", // Content-Language
+		contentLength,
+		"", // Content-MD5
+		req.Header.Get("Content-Type"),
+		"", // Date (se usa x-ms-date)
+		"", // If-Modified-Since
+		"", // If-Match
+		"", // If-None-Match
+		"", // If-Unmodified-Since
+		"", // Range
+		strings.Join(msHeaders, "\n"),
+		"/" + s.account + req.URL.EscapedPath(),
+	}, "\n")
+
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		stringToSign += "\n" + strings.ToLower(name) + ":" + strings.Join(query[name], ",")
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "SharedKey "+s.account+":"+signature)
+}
+
+// managedIdentityToken pide un token para Azure Storage a la identidad
+// administrada: el endpoint de App Service (IDENTITY_ENDPOINT) si existe o,
+// si no, el IMDS de la VM. AZURE_CLIENT_ID elige una identidad asignada por el usuario.
+func (s *azureStorage) managedIdentityToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.tokenExpiry) > 5*time.Minute {
+		return s.token, nil
+	}
+
+	query := url.Values{"resource": {"https://storage.azure.com/"}}
+	if clientID := s.clientID; clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	endpoint := s.identityEndpoint
+	if endpoint != "" {
+		query.Set("api-version", "2019-08-01")
+	} else {
+		endpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+		query.Set("api-version", "2018-02-01")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if header := s.identityHeader; header != "" {
+		req.Header.Set("X-IDENTITY-HEADER", header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	expiresOn, err := result.ExpiresOn.Int64()
+	if err != nil {
+		expiresOn = time.Now().Add(time.Hour).Unix()
+	}
+
+	s.token = result.AccessToken
+	s.tokenExpiry = time.Unix(expiresOn, 0)
+	return s.token, nil
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/config"
+)
+
+// s3Storage sube los archivos con la API REST de S3 firmada con SigV4.
+type s3Storage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	publicURL string
+	pathStyle bool
+}
+
+// newS3Storage usa AWS por defecto. Para MinIO (u otro servicio compatible)
+// basta con S3_ENDPOINT, por ejemplo:
+//
+//	STORAGE_BACKEND=s3
+//	S3_ENDPOINT=http://localhost:9000
+//	S3_BUCKET=avatars
+//	S3_ACCESS_KEY_ID=minioadmin
+//	S3_SECRET_ACCESS_KEY=minioadmin
+//
+// Con un endpoint propio se usan URLs estilo path (endpoint/bucket/key), que
+// MinIO acepta sin configurar DNS; S3_FORCE_PATH_STYLE=true|false lo fuerza.
+func newS3Storage(cfg config.S3) (*s3Storage, error) {
+	s := &s3Storage{
+		endpoint:  cfg.Endpoint,
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKeyID,
+		secretKey: cfg.SecretAccessKey,
+		publicURL: cfg.PublicURL,
+	}
+	s.pathStyle = s.endpoint != ""
+	if s.endpoint == "" {
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if cfg.ForcePathStyle != nil {
+		s.pathStyle = *cfg.ForcePathStyle
+	}
+
+	bucketURL, err := s.bucketURL()
+	if err != nil {
+		return nil, err
+	}
+	if s.publicURL == "" {
+		s.publicURL = bucketURL.String()
+	}
+	return s, nil
+}
+
+// bucketURL devuelve endpoint/bucket (estilo path) o bucket.endpoint (virtual-hosted).
+func (s *s3Storage) bucketURL() (*url.URL, error) {
+	endpoint, err := url.Parse(s.endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT inválido: %s", s.endpoint)
+	}
+	if s.pathStyle {
+		endpoint.Path = strings.TrimRight(endpoint.Path, "/") + "/" + s.bucket
+	} else {
+		endpoint.Host = s.bucket + "." + endpoint.Host
+	}
+	return endpoint, nil
+}
+
+func (s *s3Storage) Name() string {
+	return "S3 (" + s.bucket + ")"
+}
+
+func (s *s3Storage) URL(key string) string {
+	return s.publicURL + "/" + key
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	resp, err := s.do(ctx, "PUT", "/"+key, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error de S3: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, "GET", "/"+key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error de S3: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", "/"+key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error de S3: status %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Check comprueba al arrancar que el bucket existe y las credenciales sirven.
+func (s *s3Storage) Check(ctx context.Context) error {
+	resp, err := s.do(ctx, "HEAD", "/", nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("el bucket %s no existe", s.bucket)
+	case http.StatusForbidden:
+		return fmt.Errorf("sin permiso para acceder al bucket %s", s.bucket)
+	default:
+		return fmt.Errorf("error de S3: status %d", resp.StatusCode)
+	}
+}
+
+// List recorre el bucket con ListObjectsV2, de mil en mil objetos.
+func (s *s3Storage) List(ctx context.Context, fn func(StoredObject) error) error {
+	continuation := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+
+		// SigV4 exige %20 para los espacios en la query firmada.
+		resp, err := s.do(ctx, "GET", "/?"+strings.ReplaceAll(query.Encode(), "+", "%20"), nil, "")
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error de S3: status %d, response: %s", resp.StatusCode, string(body))
+		}
+
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return fmt.Errorf("respuesta de S3 inválida: %v", err)
+		}
+
+		for _, object := range result.Contents {
+			if err := fn(StoredObject{Key: object.Key, Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		continuation = result.NextContinuationToken
+	}
+}
+
+func (s *s3Storage) do(ctx context.Context, method, path string, data []byte, contentType string) (*http.Response, error) {
+	target, err := s.bucketURL()
+	if err != nil {
+		return nil, err
+	}
+	path, query, _ := strings.Cut(path, "?")
+	target.Path = strings.TrimRight(target.Path, "/") + path
+	target.RawQuery = query
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creando petición: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if cacheControl := storageCacheControl(strings.TrimPrefix(path, "/")); method == "PUT" && cacheControl != "" {
+		req.Header.Set("Cache-Control", cacheControl)
+	}
+	s.sign(req, data, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error enviando petición: %v", err)
+	}
+	return resp, nil
+}
+
+// sign añade la cabecera Authorization de AWS Signature Version 4.
+func (s *s3Storage) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		canonicalHeaders = "content-type:" + contentType + "\n" + canonicalHeaders
+		signedHeaders = "content-type;" + signedHeaders
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.scope(now), signedHeaders, s.signature(now, canonicalRequest)))
+}
+
+func (s *s3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *s3Storage) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		s.scope(now),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// PresignPut genera una URL firmada para que el cliente suba el objeto
+// directamente. Content-Type y Content-Length forman parte de la firma, así
+// que el cliente debe enviar exactamente las cabeceras devueltas.
+func (s *s3Storage) PresignPut(key, contentType string, size int64, ttl time.Duration) (string, http.Header, error) {
+	target, err := s.bucketURL()
+	if err != nil {
+		return "", nil, err
+	}
+	target.Path = strings.TrimRight(target.Path, "/") + "/" + key
+
+	now := time.Now().UTC()
+	signedHeaders := "content-length;content-type;host"
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {signedHeaders},
+	}
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{
+		"PUT",
+		target.EscapedPath(),
+		canonicalQuery,
+		"content-length:" + strconv.FormatInt(size, 10) + "\n" +
+			"content-type:" + contentType + "\n" +
+			"host:" + target.Host + "\n",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	target.RawQuery = canonicalQuery + "&X-Amz-Signature=" + s.signature(now, canonicalRequest)
+
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Length", strconv.FormatInt(size, 10))
+	return target.String(), headers, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Los plazos del servidor (ver newHTTPServer) cuentan desde que llega la
+// petición: HTTP_READ_TIMEOUT limita lo que tarda en llegar el cuerpo y
+// HTTP_WRITE_TIMEOUT hasta cuándo se puede responder; pasado ese plazo la
+// respuesta se pierde aunque el handler termine. Los handlers que necesitan
+// más los amplían con extendDeadlines. El WebSocket no lo necesita:
+// gorilla/websocket quita los plazos al tomar la conexión.
+
+// extendDeadlines amplía los plazos de lectura y escritura de la petición a d
+// desde ahora; con d = 0 los quita, para conexiones que se mantienen abiertas.
+func extendDeadlines(w http.ResponseWriter, d time.Duration) {
+	var deadline time.Time
+	if d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	controller := http.NewResponseController(w)
+	// Los ResponseWriter de prueba no admiten plazos; no es un error.
+	controller.SetReadDeadline(deadline)
+	controller.SetWriteDeadline(deadline)
+}
+
+func (s *Server) uploadTimeout() time.Duration {
+	return s.serverConfig.Timeouts.Upload
+}
+
+// detach devuelve un contexto con los valores de ctx que no se cancela con
+// él, con su propio plazo. Es para escrituras que no deben quedarse a medias
+// si el cliente se desconecta, o que acompañan a un cambio ya hecho.
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
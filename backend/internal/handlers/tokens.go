@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const tokenPurposeMagicLogin = "magic_login"
+
+var errInvalidActionToken = errors.New("token inválido o expirado")
+
+// ActionToken es un token de un solo uso enviado por email (magic links,
+// confirmaciones, etc.). Solo se guarda su hash.
+type ActionToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+func (s *Server) createActionTokenIndexes(ctx context.Context) error {
+	_, err := s.database.ActionTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func (s *Server) createActionToken(ctx context.Context, userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = s.database.ActionTokens.InsertOne(ctx, ActionToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeActionToken elimina el token al validarlo, de modo que solo pueda usarse una vez.
+// El índice TTL puede tardar en borrar los expirados, así que también se filtra por fecha.
+func (s *Server) consumeActionToken(ctx context.Context, purpose, token string) (ActionToken, error) {
+	var stored ActionToken
+	err := s.database.ActionTokens.FindOneAndDelete(ctx, bson.M{
+		"token_hash": hashToken(token),
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return ActionToken{}, errInvalidActionToken
+	}
+	if err != nil {
+		return ActionToken{}, err
+	}
+	return stored, nil
+}
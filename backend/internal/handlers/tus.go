@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Subidas reanudables con el protocolo tus (https://tus.io), pensadas para
+// móviles con conexiones inestables. Los trozos se guardan en MongoDB para
+// que la subida pueda continuar en cualquier instancia; al completarse, la
+// imagen se procesa igual que en PUT /api/user/{code} y pasa a ser el avatar.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+
+	// Tamaño máximo aceptado en cada PATCH; el cliente continúa desde el
+	// Upload-Offset devuelto. Mantiene los trozos muy por debajo del límite
+	// de 16MB de un documento de MongoDB.
+	tusMaxChunk = 4 << 20
+
+	tusUploadTTL = 24 * time.Hour
+)
+
+type TusUpload struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `bson:"user_id"`
+	Length      int64              `bson:"length"`
+	Offset      int64              `bson:"offset"`
+	ContentType string             `bson:"content_type"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	ExpiresAt   time.Time          `bson:"expires_at"`
+}
+
+type tusChunk struct {
+	UploadID  primitive.ObjectID `bson:"upload_id"`
+	Offset    int64              `bson:"offset"`
+	Data      []byte             `bson:"data"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+func (s *Server) createTusIndexes(ctx context.Context) error {
+	_, err := s.database.Uploads.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.database.UploadChunks.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "upload_id", Value: 1}, {Key: "offset", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// checkTusResumable responde 412 si el cliente habla otra versión del protocolo.
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Versión de tus no soportada", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodifica Upload-Metadata ("clave base64,clave base64").
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil {
+			metadata[key] = string(value)
+		}
+	}
+	return metadata
+}
+
+func (s *Server) deleteTusUpload(ctx context.Context, uploadID primitive.ObjectID) {
+	if _, err := s.database.UploadChunks.DeleteMany(ctx, bson.M{"upload_id": uploadID}); err != nil {
+		log.Printf("Error borrando trozos de subida: %v", err)
+	}
+	if _, err := s.database.Uploads.DeleteOne(ctx, bson.M{"_id": uploadID}); err != nil {
+		log.Printf("Error borrando subida: %v", err)
+	}
+}
+
+// loadTusUpload busca la subida de la URL, que debe pertenecer al usuario.
+func (s *Server) loadTusUpload(ctx context.Context, w http.ResponseWriter, r *http.Request) (TusUpload, User, bool) {
+	uploadID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Subida no encontrada", http.StatusNotFound)
+		return TusUpload{}, User{}, false
+	}
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return TusUpload{}, User{}, false
+	}
+
+	var upload TusUpload
+	err = s.database.Uploads.FindOne(ctx, bson.M{
+		"_id":        uploadID,
+		"user_id":    user.ID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&upload)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Subida no encontrada", http.StatusNotFound)
+		return TusUpload{}, User{}, false
+	}
+	if err != nil {
+		log.Printf("Error obteniendo subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return TusUpload{}, User{}, false
+	}
+	return upload, user, true
+}
+
+func (s *Server) handleTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.maxUploadSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length requerido", http.StatusBadRequest)
+		return
+	}
+	if limit := s.maxUploadSize(); length > limit {
+		writeUploadTooLarge(w, limit)
+		return
+	}
+
+	contentType := parseTusMetadata(r.Header.Get("Upload-Metadata"))["filetype"]
+	if _, ok := allowedImageTypes[contentType]; contentType != "" && !ok {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_image", errUnsupportedImage.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	upload := TusUpload{
+		UserID:      user.ID,
+		Length:      length,
+		ContentType: contentType,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(tusUploadTTL),
+	}
+	result, err := s.database.Uploads.InsertOne(ctx, upload)
+	if err != nil {
+		log.Printf("Error creando subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	uploadID := result.InsertedID.(primitive.ObjectID)
+
+	w.Header().Set("Location", s.publicBaseURL()+strings.TrimRight(r.URL.Path, "/")+"/"+uploadID.Hex())
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	upload, _, ok := s.loadTusUpload(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTusPatch añade un trozo a la subida. Si la conexión se corta a mitad
+// se guarda lo recibido, para que el cliente reanude desde ahí.
+func (s *Server) handleTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type debe ser application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset requerido", http.StatusBadRequest)
+		return
+	}
+
+	lookupCtx, cancelLookup := context.WithTimeout(r.Context(), 10*time.Second)
+	upload, user, ok := s.loadTusUpload(lookupCtx, w, r)
+	cancelLookup()
+	if !ok {
+		return
+	}
+	if offset != upload.Offset {
+		http.Error(w, "Upload-Offset no coincide con el de la subida", http.StatusConflict)
+		return
+	}
+
+	extendDeadlines(w, s.uploadTimeout())
+	limit := upload.Length - upload.Offset
+	if limit > tusMaxChunk {
+		limit = tusMaxChunk
+	}
+	data, readErr := io.ReadAll(io.LimitReader(r.Body, limit))
+	if len(data) == 0 {
+		if readErr != nil {
+			http.Error(w, "Error leyendo el trozo", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	_, err = s.database.UploadChunks.ReplaceOne(ctx,
+		bson.M{"upload_id": upload.ID, "offset": upload.Offset},
+		tusChunk{UploadID: upload.ID, Offset: upload.Offset, Data: data, ExpiresAt: upload.ExpiresAt},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Error guardando trozo de subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	newOffset := upload.Offset + int64(len(data))
+	result, err := s.database.Uploads.UpdateOne(ctx,
+		bson.M{"_id": upload.ID, "offset": upload.Offset},
+		bson.M{"$set": bson.M{"offset": newOffset}},
+	)
+	if err != nil {
+		log.Printf("Error actualizando subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "La subida avanzó en otra petición", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if newOffset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.finishTusUpload(ctx, w, user, upload)
+}
+
+// finishTusUpload junta los trozos y procesa la imagen como nuevo avatar.
+func (s *Server) finishTusUpload(ctx context.Context, w http.ResponseWriter, user User, upload TusUpload) {
+	defer s.deleteTusUpload(context.Background(), upload.ID)
+
+	cursor, err := s.database.UploadChunks.Find(ctx,
+		bson.M{"upload_id": upload.ID},
+		options.Find().SetSort(bson.D{{Key: "offset", Value: 1}}),
+	)
+	if err != nil {
+		log.Printf("Error leyendo trozos de subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	var chunks []tusChunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		log.Printf("Error leyendo trozos de subida: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	var data bytes.Buffer
+	for _, chunk := range chunks {
+		if chunk.Offset != int64(data.Len()) {
+			break
+		}
+		data.Write(chunk.Data)
+	}
+	if int64(data.Len()) != upload.Length {
+		log.Printf("Subida %s incompleta: %d de %d bytes", upload.ID.Hex(), data.Len(), upload.Length)
+		http.Error(w, "Subida incompleta", http.StatusInternalServerError)
+		return
+	}
+
+	uploaded, ok := s.storeUploadedImage(ctx, w, user.ID, data.Bytes(), upload.ContentType, nil, "")
+	if !ok {
+		return
+	}
+
+	images, replaced := replaceAvatarImage(user, uploaded)
+	if _, ok := s.updateGallery(ctx, w, user, images, &uploaded, "Imagen actualizada correctamente"); ok {
+		s.deleteProfileImageObjects(ctx, replaced...)
+	}
+}
+
+func (s *Server) handleTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	upload, _, ok := s.loadTusUpload(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	s.deleteTusUpload(ctx, upload.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
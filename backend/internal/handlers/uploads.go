@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Margen para los campos de texto y las cabeceras multipart, además del archivo.
+const multipartOverhead = 64 << 10
+
+// Tipos de imagen aceptados para el avatar y la extensión con la que se guardan.
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+var (
+	errUnsupportedImage  = errors.New("formato de imagen no permitido (solo JPEG, PNG o WebP)")
+	errImageTypeMismatch = errors.New("el Content-Type declarado no coincide con el contenido del archivo")
+)
+
+// detectImageType identifica la imagen por sus bytes iniciales, no por el
+// nombre del archivo. Si el cliente declaró un Content-Type debe coincidir
+// con el detectado. Devuelve el tipo y la extensión con la que guardarla.
+func detectImageType(data []byte, declared string) (string, string, error) {
+	detected := http.DetectContentType(data)
+	ext, ok := allowedImageTypes[detected]
+	if !ok {
+		return "", "", errUnsupportedImage
+	}
+
+	if declared != "" && declared != "application/octet-stream" {
+		mediaType, _, err := mime.ParseMediaType(declared)
+		if err != nil || mediaType != detected {
+			return "", "", errImageTypeMismatch
+		}
+	}
+	return detected, ext, nil
+}
+
+// maxUploadSize lee MAX_UPLOAD_SIZE en bytes o con sufijo KB/MB ("5MB").
+// Por defecto 10MB.
+func (s *Server) maxUploadSize() int64 {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	return s.uploadsConfig.MaxSize
+}
+
+func writeUploadTooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     "upload_too_large",
+		"message":   fmt.Sprintf("La imagen supera el tamaño máximo de %s", formatBytes(limit)),
+		"max_bytes": limit,
+	})
+}
+
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", size>>20)
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", size>>10)
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
+
+// userUpdate son los datos de PUT /api/user/{code}, ya sea multipart o JSON.
+// Fields son los campos del perfil recibidos (ver profileFields); un valor
+// nil borra el campo.
+type userUpdate struct {
+	Fields    map[string]*string
+	Image     []byte
+	ImageType string
+	Crop      *image.Rectangle
+}
+
+// readUserUpdate lee la petición según su Content-Type. Si falla ya ha
+// respondido al cliente.
+func (s *Server) readUserUpdate(w http.ResponseWriter, r *http.Request) (userUpdate, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		return s.readUserUpdateJSON(w, r)
+	}
+
+	extendDeadlines(w, s.uploadTimeout())
+	limit := s.maxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+
+	err := r.ParseMultipartForm(limit)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeUploadTooLarge(w, limit)
+		return userUpdate{}, false
+	}
+	if err != nil {
+		http.Error(w, "Error parseando formulario", http.StatusBadRequest)
+		return userUpdate{}, false
+	}
+
+	// name y last_name se reemplazan siempre, como hasta ahora; el resto de
+	// campos solo si vienen en el formulario (vacíos los borran).
+	name := r.FormValue("name")
+	lastName := r.FormValue("last_name")
+	update := userUpdate{Fields: map[string]*string{"name": &name, "last_name": &lastName}}
+	for field := range profileFields {
+		values, ok := r.MultipartForm.Value[field]
+		if !ok || field == "name" || field == "last_name" {
+			continue
+		}
+		if values[0] == "" {
+			update.Fields[field] = nil
+		} else {
+			update.Fields[field] = &values[0]
+		}
+	}
+
+	update.Crop, err = parseCrop(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_crop", err.Error())
+		return userUpdate{}, false
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return update, true
+	}
+	defer file.Close()
+
+	if header.Size > limit {
+		writeUploadTooLarge(w, limit)
+		return userUpdate{}, false
+	}
+
+	update.Image, err = io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Error leyendo imagen", http.StatusBadRequest)
+		return userUpdate{}, false
+	}
+	update.ImageType = header.Header.Get("Content-Type")
+	return update, true
+}
+
+// readUserUpdateJSON lee el cuerpo JSON alternativo al formulario, para
+// clientes que no pueden enviar multipart con facilidad. Los campos del
+// perfil siguen la semántica de PATCH; image va en base64, sola o como data
+// URL ("data:image/png;base64,..."), y crop es opcional.
+func (s *Server) readUserUpdateJSON(w http.ResponseWriter, r *http.Request) (userUpdate, bool) {
+	extendDeadlines(w, s.uploadTimeout())
+	limit := s.maxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(int(limit)))+multipartOverhead)
+
+	var body map[string]json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeUploadTooLarge(w, limit)
+		return userUpdate{}, false
+	}
+	if err != nil || body == nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return userUpdate{}, false
+	}
+
+	// image y crop son los únicos campos que no son del perfil.
+	var req struct {
+		Image string
+		Crop  *CropRequest
+	}
+	if raw, ok := body["image"]; ok {
+		delete(body, "image")
+		if err := json.Unmarshal(raw, &req.Image); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_image", "image debe ser un texto en base64")
+			return userUpdate{}, false
+		}
+	}
+	if raw, ok := body["crop"]; ok {
+		delete(body, "crop")
+		if err := json.Unmarshal(raw, &req.Crop); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_crop", errInvalidCrop.Error())
+			return userUpdate{}, false
+		}
+	}
+
+	fields, invalid := profileValuesFromJSON(body)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return userUpdate{}, false
+	}
+
+	update := userUpdate{Fields: fields}
+	update.Crop, err = req.Crop.Rect()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_crop", err.Error())
+		return userUpdate{}, false
+	}
+
+	if req.Image == "" {
+		return update, true
+	}
+
+	encoded := req.Image
+	if strings.HasPrefix(encoded, "data:") {
+		header, payload, ok := strings.Cut(encoded, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			writeJSONError(w, http.StatusBadRequest, "invalid_image", "image debe ser una data URL en base64")
+			return userUpdate{}, false
+		}
+		update.ImageType = strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+		encoded = payload
+	}
+
+	update.Image, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_image", "image no es base64 válido")
+		return userUpdate{}, false
+	}
+	if int64(len(update.Image)) > limit {
+		writeUploadTooLarge(w, limit)
+		return userUpdate{}, false
+	}
+	return update, true
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type RewriteURLsRequest struct {
+	From []string `json:"from"`
+}
+
+// rewriteImageURLs recalcula las URLs de una imagen con la base pública
+// actual. Si tiene claves guardadas se usan; si no, se deduce la clave de la URL.
+func (s *Server) rewriteImageURLs(image *ProfileImage, from []string) {
+	if len(image.Keys) == 0 {
+		if key, ok := s.uploadKeyFromURL(image.URL, from...); ok {
+			image.Keys = []string{key}
+		}
+		if key, ok := s.uploadKeyFromURL(image.FallbackURL, from...); ok && image.FallbackURL != image.URL {
+			image.Keys = append(image.Keys, key)
+		}
+	}
+
+	for _, key := range image.Keys {
+		switch {
+		case strings.HasSuffix(key, ".webp"):
+			image.URL = s.uploadURL(key)
+		case strings.HasSuffix(key, ".jpg"):
+			image.FallbackURL = s.uploadURL(key)
+			if !hasWebPKey(image.Keys) {
+				image.URL = s.uploadURL(key)
+			}
+		default:
+			image.URL = s.uploadURL(key)
+		}
+	}
+}
+
+// rewriteUserImageURLs reescribe las URLs de todas las imágenes del usuario
+// y las del avatar. Devuelve si alguna URL cambió.
+func (s *Server) rewriteUserImageURLs(user *User, from []string) bool {
+	changed := false
+	var avatar *ProfileImage
+	for i := range user.Images {
+		before := user.Images[i]
+		s.rewriteImageURLs(&user.Images[i], from)
+		if user.Images[i].URL != before.URL || user.Images[i].FallbackURL != before.FallbackURL {
+			changed = true
+		}
+		if user.AvatarImageID != nil && user.Images[i].ID == *user.AvatarImageID {
+			avatar = &user.Images[i]
+		}
+	}
+	if changed && avatar != nil {
+		setAvatar(user, avatar)
+	}
+	return changed
+}
+
+func hasWebPKey(keys []string) bool {
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".webp") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdminRewriteUploadURLs reescribe las URLs de imágenes guardadas en los
+// usuarios tras cambiar PUBLIC_BASE_URL, UPLOADS_CDN_URL o el backend de
+// almacenamiento. Además de las bases conocidas acepta otras en "from".
+// Con ?dry_run=true solo cuenta los usuarios que cambiarían.
+func (s *Server) handleAdminRewriteUploadURLs(w http.ResponseWriter, r *http.Request) {
+	var req RewriteURLsRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	extendDeadlines(w, 5*time.Minute)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	// Cada usuario se vuelve a leer al guardarlo (changeUser), por si cambió
+	// mientras se recorría el listado.
+	scanned, rewritten := 0, 0
+	err := s.userRepo.Each(ctx, UserQuery{}, func(user User) error {
+		if len(user.Images) == 0 {
+			return nil
+		}
+		scanned++
+
+		if !s.rewriteUserImageURLs(&user, req.From) {
+			return nil
+		}
+		rewritten++
+		if dryRun {
+			return nil
+		}
+
+		_, err := s.changeUser(ctx, user.ID, func(user *User) error {
+			s.rewriteUserImageURLs(user, req.From)
+			return nil
+		})
+		if err != nil && !errors.Is(err, errUserNotFound) {
+			return fmt.Errorf("usuario %s: %w", user.ID.Hex(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reescribiendo URLs: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun && rewritten > 0 {
+		log.Printf("✅ URLs de imágenes reescritas en %d usuarios", rewritten)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"scanned":   scanned,
+		"rewritten": rewritten,
+		"dry_run":   dryRun,
+		"base_url":  strings.TrimSuffix(s.uploadURL(""), "/"),
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// El nombre de usuario es opcional y sirve para las URLs públicas del
+// perfil, que así no exponen el código de acceso. Se guarda en minúsculas.
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{2,29}$`)
+
+// reservedUsernames no pueden usarse porque chocan con rutas o pueden
+// hacerse pasar por cuentas del sistema.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true,
+	"support": true, "help": true, "security": true, "staff": true,
+	"api": true, "auth": true, "login": true, "logout": true, "register": true,
+	"me": true, "user": true, "users": true, "settings": true, "uploads": true,
+	"null": true, "undefined": true, "userapp": true,
+}
+
+func parseUsernameField(value string) (interface{}, error) {
+	username := strings.ToLower(strings.TrimSpace(value))
+	if !usernamePattern.MatchString(username) {
+		return nil, errors.New("entre 3 y 30 caracteres: letras, números y guion bajo, empezando por letra o número")
+	}
+	if reservedUsernames[username] {
+		return nil, errors.New("nombre de usuario reservado")
+	}
+	return username, nil
+}
+
+// isDuplicateUsername distingue una colisión en el índice de username de
+// otros duplicados.
+func isDuplicateUsername(err error) bool {
+	return errors.Is(err, errDuplicateUsername)
+}
+
+func writeUsernameTaken(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusConflict, "username_taken", "Ese nombre de usuario ya está en uso")
+}
+
+// handleGetUserByUsername devuelve el perfil público (ver publicProfile). No
+// requiere sesión, pero si quien pregunta es el propio usuario recibe el
+// documento completo. Las cuentas desactivadas no se muestran.
+func (s *Server) handleGetUserByUsername(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userRepo.FindByUsername(ctx, strings.ToLower(mux.Vars(r)["name"]))
+	if err == nil && user.DeactivatedAt != nil {
+		err = errUserNotFound
+	}
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if claims, err := s.requestClaims(w, r); err == nil && claims.Act == nil && claims.Subject == user.ID.Hex() {
+		json.NewEncoder(w).Encode(user)
+		return
+	}
+	json.NewEncoder(w).Encode(s.publicProfile(user))
+}
@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"backend/internal/config"
+	"backend/internal/email"
+)
+
+type User struct {
+	ID            primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	Email         string                `json:"email" bson:"email"`
+	CodeHash      string                `json:"-" bson:"code_hash"`
+	CodeExpiresAt time.Time             `json:"code_expires_at" bson:"code_expires_at"`
+	Name          string                `json:"name" bson:"name"`
+	LastName      string                `json:"last_name" bson:"last_name"`
+	Username      string                `json:"username,omitempty" bson:"username,omitempty"`
+	ImageURL      string                `json:"image_url" bson:"image_url"`
+	Verified      bool                  `json:"verified" bson:"verified"`
+	VerifiedAt    *time.Time            `json:"verified_at,omitempty" bson:"verified_at,omitempty"`
+	Identities    []ExternalIdentity    `json:"-" bson:"identities,omitempty"`
+	Passkeys      []webauthn.Credential `json:"-" bson:"passkeys,omitempty"`
+	Role          string                `json:"role,omitempty" bson:"role,omitempty"`
+	Tags          []string              `json:"tags,omitempty" bson:"tags,omitempty"`
+	AdminNotes    []AdminNote           `json:"-" bson:"admin_notes,omitempty"`
+
+	ImageFallbackURL string              `json:"image_fallback_url,omitempty" bson:"image_fallback_url,omitempty"`
+	Images           []ProfileImage      `json:"images,omitempty" bson:"images,omitempty"`
+	AvatarImageID    *primitive.ObjectID `json:"avatar_image_id,omitempty" bson:"avatar_image_id,omitempty"`
+
+	Phone    string `json:"phone,omitempty" bson:"phone,omitempty"`
+	Bio      string `json:"bio,omitempty" bson:"bio,omitempty"`
+	Birthday string `json:"birthday,omitempty" bson:"birthday,omitempty"`
+	Website  string `json:"website,omitempty" bson:"website,omitempty"`
+	Pronouns string `json:"pronouns,omitempty" bson:"pronouns,omitempty"`
+
+	Preferences    *UserPreferences  `json:"-" bson:"preferences,omitempty"`
+	Visibility     map[string]string `json:"-" bson:"visibility,omitempty"`
+	ProfileVersion int               `json:"-" bson:"profile_version,omitempty"`
+
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" bson:"last_login_at,omitempty"`
+	LoginCount  int        `json:"login_count" bson:"login_count,omitempty"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty" bson:"last_seen_at,omitempty"`
+
+	RemindersOptOut       bool       `json:"reminders_opt_out" bson:"reminders_opt_out,omitempty"`
+	ProfileReminderSentAt *time.Time `json:"-" bson:"profile_reminder_sent_at,omitempty"`
+
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" bson:"updated_at"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" bson:"deactivated_at,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	ErasedAt      *time.Time `json:"erased_at,omitempty" bson:"erased_at,omitempty"`
+
+	// avatarBaseURL es la URL pública del servidor que lo ha leído, para el
+	// avatar generado (ver avatarUsers).
+	avatarBaseURL string
+}
+
+type RegisterRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type LoginRequest struct {
+	Code  string `json:"code" validate:"max=64"`
+	Email string `json:"email" validate:"max=254"`
+	OTP   string `json:"otp" validate:"digits,max=6"`
+}
+
+func newCORSPolicy(cfg config.CORS) *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins: cfg.AllowedOrigins,
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"Location", "ETag", "API-Version", "Deprecation", "Sunset", "Link", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires"},
+		// Necesario para que el navegador envíe la cookie con SESSION_MODE=cookie.
+		AllowCredentials: cfg.AllowCredentials,
+	})
+}
+
+// newHandler monta las rutas y los middlewares del servidor HTTP.
+func (s *Server) newHandler(cfg config.Config) http.Handler {
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+
+	r.HandleFunc("/.well-known/jwks.json", s.handleJWKS).Methods("GET")
+
+	s.mountAPIDocs(r)
+	s.mountAPI(r, cfg)
+
+	if local, ok := s.storage.(*localStorage); ok {
+		r.PathPrefix("/uploads/").Handler(local.Handler())
+	}
+
+	s.corsPolicy.Store(newCORSPolicy(cfg.CORS))
+	s.useDefaultMiddlewares(cfg)
+	return s.middlewares.Handler(r)
+}
+
+// publicBaseURL es la URL desde la que los clientes alcanzan este servidor,
+// usada para construir los enlaces que se envían por email.
+func (s *Server) publicBaseURL() string {
+	return s.serverConfig.PublicBaseURL
+}
+
+// CreateIndexes crea los índices de todas las colecciones y migra los
+// documentos de versiones anteriores.
+func (s *Server) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if indexer, ok := s.userRepo.(userIndexer); ok {
+		if err := indexer.CreateIndexes(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	if err := s.createAuditIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createVersionIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createSessionIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createOTPIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createActionTokenIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createAPIKeyIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createWebAuthnIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createSigningKeyIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createEmailEventIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createMailLogIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createTusIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createRateLimitIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createWebhookIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createEventCountIndexes(ctx); err != nil {
+		return err
+	}
+
+	if err := s.createOutboxIndexes(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("✅ Índices creados")
+	return nil
+}
+
+func (s *Server) sendEmail(toEmail, code string) error {
+	data := map[string]interface{}{
+		"Code": code,
+	}
+
+	var attachments []email.Attachment
+	if qr, src, err := s.accessCodeQRAttachment(code); err != nil {
+		log.Printf("⚠️  Error generando QR del código: %v", err)
+	} else {
+		data["QRImage"] = src
+		attachments = append(attachments, qr)
+	}
+
+	return s.sendTemplateEmail(toEmail, emailTemplateAccessCode, data,
+		fmt.Sprintf("🔑 CÓDIGO DE ACCESO: %s", code), attachments...)
+}
+
+var errDisposableEmail = errors.New("No se permiten direcciones de email temporales o desechables")
+
+// registerUser crea el usuario y le envía el código de acceso y el enlace de
+// verificación. Los devuelve en claro para el modo desarrollo, donde no hay
+// proveedor de email. Lo comparten la API HTTP y la gRPC.
+func (s *Server) registerUser(ctx context.Context, email string) (user User, code, verifyLink string, err error) {
+	if s.isDisposableEmail(email) {
+		return User{}, "", "", errDisposableEmail
+	}
+
+	if err := s.purgeDeletedUserByEmail(ctx, email); err != nil {
+		return User{}, "", "", fmt.Errorf("error liberando email de cuenta eliminada: %w", err)
+	}
+
+	_, err = s.userRepo.FindByEmail(ctx, email)
+	if err == nil {
+		return User{}, "", "", errDuplicateEmail
+	}
+	if !errors.Is(err, errUserNotFound) {
+		return User{}, "", "", fmt.Errorf("error verificando email: %w", err)
+	}
+
+	user = User{
+		Email:         email,
+		CodeExpiresAt: time.Now().Add(s.codeTTL()),
+		Name:          "",
+		LastName:      "",
+		ImageURL:      "",
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	code, err = s.insertUserWithCode(ctx, &user)
+	if err != nil {
+		return User{}, "", "", err
+	}
+
+	log.Printf("✅ Usuario creado con ID: %v", user.ID.Hex())
+
+	if err := s.sendEmail(email, code); err != nil {
+		log.Printf("❌ Error enviando email: %v", err)
+	} else {
+		log.Printf("✅ Código enviado a %s", email)
+	}
+
+	verifyLink, err = s.startEmailVerification(ctx, user.ID, email)
+	if err != nil {
+		log.Printf("❌ Error enviando verificación de email: %v", err)
+	}
+	return user, code, verifyLink, nil
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, code, verifyLink, err := s.registerUser(ctx, req.Email)
+	if err == errDisposableEmail {
+		writeJSONError(w, http.StatusUnprocessableEntity, "disposable_email", err.Error())
+		return
+	}
+	if errors.Is(err, errDuplicateEmail) {
+		http.Error(w, "El email ya está registrado", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error registrando usuario: %v", err)
+		http.Error(w, "Error guardando usuario", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Usuario registrado correctamente. Confirma tu email y revisa tu correo para obtener el código de acceso.",
+	}
+
+	if s.showDevCodes() {
+		response["dev_code"] = code
+		response["dev_verify_url"] = verifyLink
+		response["dev_note"] = "Sin proveedor de email - código mostrado solo para desarrollo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// loginError es un login rechazado, con el estado HTTP y el mensaje para el
+// cliente. Si Field no está vacío, falta ese campo de la petición.
+type loginError struct {
+	Status  int
+	Message string
+	Field   string
+}
+
+func (e *loginError) Error() string {
+	return e.Message
+}
+
+// authenticateLogin valida el código de acceso, o el código de un solo uso
+// si se envía otp, y comprueba que la cuenta pueda iniciar sesión. Los
+// rechazos son *loginError; cualquier otro error es un fallo interno.
+func (s *Server) authenticateLogin(ctx context.Context, req LoginRequest) (User, error) {
+	var user User
+	var err error
+	if req.OTP != "" {
+		if !s.loginModeEnabled(loginModeOTP) {
+			return User{}, &loginError{Status: http.StatusBadRequest, Message: "Login con código de un solo uso deshabilitado"}
+		}
+		if req.Email == "" {
+			return User{}, &loginError{Status: http.StatusUnprocessableEntity, Message: "requerido", Field: "email"}
+		}
+		user, err = s.consumeLoginOTP(ctx, req.Email, req.OTP)
+	} else {
+		if !s.loginModeEnabled(loginModeCode) {
+			return User{}, &loginError{Status: http.StatusBadRequest, Message: "Login con código permanente deshabilitado, solicita un código de un solo uso"}
+		}
+		if req.Code == "" {
+			return User{}, &loginError{Status: http.StatusUnprocessableEntity, Message: "requerido", Field: "code"}
+		}
+		user, err = s.userRepo.FindByCode(ctx, s.hashCode(req.Code))
+		if err == nil && codeExpired(user) {
+			return User{}, &loginError{Status: http.StatusUnauthorized, Message: "Código expirado, solicita uno nuevo"}
+		}
+	}
+	if err == mongo.ErrNoDocuments || errors.Is(err, errUserNotFound) || err == errInvalidOTP {
+		return User{}, &loginError{Status: http.StatusUnauthorized, Message: "Código inválido"}
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if !user.Verified {
+		return User{}, &loginError{Status: http.StatusForbidden, Message: "Debes confirmar tu email antes de iniciar sesión"}
+	}
+	if user.DeactivatedAt != nil {
+		return User{}, &loginError{Status: http.StatusForbidden, Message: accountDeactivatedMessage}
+	}
+	return user, nil
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.authenticateLogin(ctx, req)
+	var rejected *loginError
+	if errors.As(err, &rejected) {
+		if rejected.Field != "" {
+			writeInvalidFields(w, map[string]string{rejected.Field: rejected.Message})
+		} else {
+			http.Error(w, rejected.Message, rejected.Status)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := s.sessionResponse(ctx, w, r, user)
+	if err != nil {
+		log.Printf("Error creando sesión: %v", err)
+		http.Error(w, "Error creando sesión", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Login exitoso",
+		"user":    user,
+	}
+	for key, value := range session {
+		response[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok || writeUserNotModified(w, r, user) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.readUserUpdate(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	before, ok := s.findRequestUser(ctx, w, r)
+	if !ok || !checkUserIfMatch(w, r, before) {
+		return
+	}
+
+	user := before
+	if invalid := setProfileFields(&user, req.Fields); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	var replaced []ProfileImage
+	if req.Image != nil {
+		uploaded, ok := s.storeUploadedImage(ctx, w, user.ID, req.Image, req.ImageType, req.Crop, "")
+		if !ok {
+			return
+		}
+
+		user.Images, replaced = replaceAvatarImage(user, uploaded)
+		setAvatar(&user, &uploaded)
+	}
+	user.ProfileVersion++
+
+	if !s.saveUser(ctx, w, &user) {
+		return
+	}
+
+	s.recordProfileChange(ctx, r, before, user)
+	s.deleteProfileImageObjects(ctx, replaced...)
+
+	w.Header().Set("ETag", userETag(user))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Usuario actualizado correctamente",
+		"user":    user,
+	})
+}
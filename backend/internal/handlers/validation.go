@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validación de las peticiones con la etiqueta validate de cada campo,
+// separando las reglas por comas:
+//
+//	required   no puede estar vacío
+//	email      dirección de email sin nombre ("a@b.com")
+//	min=N      mínimo de N caracteres
+//	max=N      máximo de N caracteres
+//	digits     solo dígitos
+//	oneof=a b  uno de los valores indicados
+//
+// Salvo required, las reglas no se aplican a campos vacíos. Los errores se
+// devuelven por campo (con su nombre JSON) con writeInvalidFields.
+
+// decodeRequest lee el JSON del body en dst y lo valida. Si falla ya ha
+// respondido: 400 si el JSON está mal formado y 422 con el error de cada
+// campo si alguno tiene un tipo equivocado o no cumple sus reglas.
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if writeTypeError(w, err) {
+		return false
+	}
+	if err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return false
+	}
+
+	if invalid := validateStruct(dst); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return false
+	}
+	return true
+}
+
+// writeTypeError responde 422 si err indica que un campo del JSON tiene un
+// tipo distinto del esperado.
+func writeTypeError(w http.ResponseWriter, err error) bool {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return false
+	}
+	writeInvalidFields(w, map[string]string{typeErr.Field: jsonTypeMessage(typeErr.Type)})
+	return true
+}
+
+func jsonTypeMessage(expected reflect.Type) string {
+	switch expected.Kind() {
+	case reflect.String:
+		return "debe ser un texto"
+	case reflect.Bool:
+		return "debe ser true o false"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "debe ser un número"
+	case reflect.Slice:
+		return "debe ser una lista"
+	default:
+		return "tipo inválido"
+	}
+}
+
+// validateStruct aplica las reglas de los campos de texto de v (un struct o
+// un puntero a struct) y devuelve un error por campo inválido.
+func validateStruct(v interface{}) map[string]string {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	invalid := map[string]string{}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" || field.Type.Kind() != reflect.String {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		if message := validateValue(value.Field(i).String(), rules); message != "" {
+			invalid[name] = message
+		}
+	}
+	return invalid
+}
+
+func validateValue(value, rules string) string {
+	for _, rule := range strings.Split(rules, ",") {
+		rule, param, _ := strings.Cut(rule, "=")
+		if value == "" {
+			if rule == "required" {
+				return "requerido"
+			}
+			continue
+		}
+
+		switch rule {
+		case "required":
+		case "email":
+			address, err := mail.ParseAddress(value)
+			if err != nil || address.Address != value {
+				return "debe ser un email válido"
+			}
+		case "min", "max":
+			limit, _ := strconv.Atoi(param)
+			length := utf8.RuneCountInString(value)
+			if rule == "min" && length < limit {
+				return fmt.Sprintf("mínimo %d caracteres", limit)
+			}
+			if rule == "max" && length > limit {
+				return fmt.Sprintf("máximo %d caracteres", limit)
+			}
+		case "digits":
+			if strings.Trim(value, "0123456789") != "" {
+				return "solo puede contener dígitos"
+			}
+		case "oneof":
+			options := strings.Fields(param)
+			found := false
+			for _, option := range options {
+				found = found || value == option
+			}
+			if !found {
+				return "debe ser uno de: " + strings.Join(options, ", ")
+			}
+		default:
+			panic("regla de validación desconocida: " + rule)
+		}
+	}
+	return ""
+}
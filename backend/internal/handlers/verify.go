@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const tokenPurposeVerifyEmail = "verify_email"
+
+func (s *Server) verifyEmailTTL() time.Duration {
+	return s.authConfig.VerifyEmailTTL
+}
+
+func (s *Server) sendVerificationEmail(toEmail, link string) error {
+	return s.sendTemplateEmail(toEmail, emailTemplateVerifyEmail, map[string]interface{}{
+		"Link": link,
+	}, "✉️  ENLACE DE VERIFICACIÓN: "+link)
+}
+
+// startEmailVerification crea el token de verificación y envía el enlace.
+// Devuelve el enlace para poder mostrarlo en desarrollo.
+func (s *Server) startEmailVerification(ctx context.Context, userID primitive.ObjectID, email string) (string, error) {
+	token, err := s.createActionToken(ctx, userID, tokenPurposeVerifyEmail, s.verifyEmailTTL())
+	if err != nil {
+		return "", err
+	}
+
+	link := s.publicBaseURL() + apiPath("/verify/"+token)
+	if err := s.sendVerificationEmail(email, link); err != nil {
+		return link, err
+	}
+	return link, nil
+}
+
+var errAlreadyVerified = errors.New("el email ya estaba verificado")
+
+// markEmailVerified no hace nada si la cuenta ya estaba verificada o ya no
+// existe.
+func (s *Server) markEmailVerified(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.changeUser(ctx, userID, func(user *User) error {
+		if user.Verified {
+			return errAlreadyVerified
+		}
+		now := time.Now()
+		user.Verified = true
+		user.VerifiedAt = &now
+		return nil
+	})
+	if errors.Is(err, errAlreadyVerified) || errors.Is(err, errUserNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeActionToken(ctx, tokenPurposeVerifyEmail, mux.Vars(r)["token"])
+	if err == errInvalidActionToken {
+		http.Error(w, "Enlace de verificación inválido o expirado", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error validando verificación: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.markEmailVerified(ctx, stored.UserID); err != nil {
+		log.Printf("Error verificando usuario: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("✅ Email verificado para el usuario %s", stored.UserID.Hex())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email verificado correctamente. Ya puedes iniciar sesión.",
+	})
+}
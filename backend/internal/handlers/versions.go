@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Historial del perfil: antes de cada cambio se guarda una copia de los
+// campos del perfil, numerada con profile_version, para poder deshacer
+// sobrescrituras accidentales. Las imágenes no se versionan porque los
+// archivos reemplazados se borran del almacenamiento, ni el nombre de
+// usuario, que otra cuenta puede haber ocupado desde entonces.
+
+type ProfileVersion struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"-" bson:"user_id"`
+	Version   int                `json:"version" bson:"version"`
+	Fields    map[string]string  `json:"fields" bson:"fields"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// versionedProfileFields son los campos que se guardan y restauran.
+var versionedProfileFields = []string{"name", "last_name", "phone", "bio", "birthday", "website", "pronouns"}
+
+// profileVersionsKept lee PROFILE_VERSIONS: cuántas versiones se conservan
+// por usuario (10 por defecto).
+func (s *Server) profileVersionsKept() int {
+	return s.usersConfig.ProfileVersions
+}
+
+func (s *Server) createVersionIndexes(ctx context.Context) error {
+	_, err := s.database.ProfileVersions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// recordProfileChange registra un cambio de perfil en la auditoría y guarda
+// la versión anterior. before debe ser el documento leído antes de una
+// actualización que incrementó profile_version.
+func (s *Server) recordProfileChange(ctx context.Context, r *http.Request, before, after User) {
+	changes := profileChanges(before, after)
+	if len(changes) == 0 {
+		return
+	}
+	ctx, cancel := detach(ctx, 5*time.Second)
+	defer cancel()
+	s.recordProfileAudit(ctx, r, before, after)
+	s.publishProfileUpdated(r, after, changes)
+	s.publishEvent(ctx, eventUserUpdated, after.ID, userEventData{User: after, Fields: changedFields(changes)})
+
+	snapshot := profileSnapshot(before)
+	fields := make(map[string]string, len(versionedProfileFields))
+	for _, field := range versionedProfileFields {
+		fields[field] = snapshot[field]
+	}
+
+	_, err := s.database.ProfileVersions.InsertOne(ctx, ProfileVersion{
+		UserID:    before.ID,
+		Version:   before.ProfileVersion,
+		Fields:    fields,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️  Error guardando versión del perfil: %v", err)
+		return
+	}
+
+	_, err = s.database.ProfileVersions.DeleteMany(ctx, bson.M{
+		"user_id": before.ID,
+		"version": bson.M{"$lte": before.ProfileVersion - s.profileVersionsKept()},
+	})
+	if err != nil {
+		log.Printf("⚠️  Error borrando versiones antiguas del perfil: %v", err)
+	}
+}
+
+var profileVersionList = listOptions{
+	Sorts:       map[string]bool{"version": true},
+	DefaultSort: "-version",
+}
+
+func (s *Server) handleListProfileVersions(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), profileVersionList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	versions := []ProfileVersion{}
+	total, err := findList(ctx, s.database.ProfileVersions, bson.M{"user_id": user.ID}, list, &versions)
+	if err != nil {
+		log.Printf("Error listando versiones: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	response := listResponse(list, versions, len(versions), total)
+	response["current_version"] = user.ProfileVersion
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleRevertProfile restaura los campos del perfil de una versión
+// anterior. La reversión es a su vez un cambio, así que también se puede deshacer.
+func (s *Server) handleRevertProfile(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "Versión no encontrada", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	current, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	var stored ProfileVersion
+	err = s.database.ProfileVersions.FindOne(ctx, bson.M{"user_id": current.ID, "version": version}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Versión no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo versión: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	values := map[string]*string{}
+	for _, field := range versionedProfileFields {
+		if value := stored.Fields[field]; value != "" {
+			values[field] = &value
+		} else {
+			values[field] = nil
+		}
+	}
+	user := current
+	if invalid := setProfileFields(&user, values); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+	user.ProfileVersion++
+
+	if !s.saveUser(ctx, w, &user) {
+		return
+	}
+
+	s.recordProfileChange(ctx, r, current, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Perfil restaurado a la versión " + strconv.Itoa(version),
+		"user":    user,
+	})
+}
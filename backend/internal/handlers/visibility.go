@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+)
+
+// defaultVisibility son los campos del perfil que el usuario puede mostrar u
+// ocultar en su perfil público, con su valor por defecto. El email nunca es
+// público y el nombre de usuario siempre lo es.
+var defaultVisibility = map[string]string{
+	"name":      visibilityPublic,
+	"last_name": visibilityPublic,
+	"image_url": visibilityPublic,
+	"bio":       visibilityPublic,
+	"website":   visibilityPublic,
+	"pronouns":  visibilityPublic,
+	"phone":     visibilityPrivate,
+	"birthday":  visibilityPrivate,
+}
+
+// userVisibility completa con los valores por defecto lo que el usuario no
+// ha configurado.
+func userVisibility(user User) map[string]string {
+	visibility := make(map[string]string, len(defaultVisibility))
+	for field, value := range defaultVisibility {
+		visibility[field] = value
+		if configured, ok := user.Visibility[field]; ok {
+			visibility[field] = configured
+		}
+	}
+	return visibility
+}
+
+// publicProfile es lo que ve de un usuario cualquiera que conozca su nombre
+// de usuario.
+func (s *Server) publicProfile(user User) map[string]interface{} {
+	snapshot := profileSnapshot(user)
+	profile := map[string]interface{}{"username": user.Username}
+	for field, value := range userVisibility(user) {
+		if value == visibilityPublic {
+			profile[field] = snapshot[field]
+		}
+	}
+	if image, ok := profile["image_url"]; ok && image == "" {
+		profile["image_url"] = s.defaultAvatarURL(user)
+	}
+	return profile
+}
+
+func (s *Server) handleGetVisibility(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := s.findRequestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userVisibility(user))
+}
+
+// handleUpdateVisibility cambia solo los campos enviados, por ejemplo
+// {"phone": "public"}.
+func (s *Server) handleUpdateVisibility(w http.ResponseWriter, r *http.Request) {
+	var req map[string]string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, multipartOverhead)).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	invalid := map[string]string{}
+	for field, value := range req {
+		if _, ok := defaultVisibility[field]; !ok {
+			invalid[field] = "campo desconocido o sin visibilidad configurable"
+			continue
+		}
+		if value != visibilityPublic && value != visibilityPrivate {
+			invalid[field] = "debe ser public o private"
+			continue
+		}
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.changeRouteUser(ctx, r, func(user *User) error {
+		if user.Visibility == nil {
+			user.Visibility = map[string]string{}
+		}
+		for field, value := range req {
+			user.Visibility[field] = value
+		}
+		return nil
+	})
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error guardando visibilidad: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Visibilidad actualizada correctamente",
+		"visibility": userVisibility(user),
+	})
+}
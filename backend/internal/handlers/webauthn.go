@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/config"
+)
+
+const (
+	webauthnCeremonyRegister = "register"
+	webauthnCeremonyLogin    = "login"
+)
+
+var errWebAuthnSessionNotFound = errors.New("ceremonia WebAuthn no encontrada o expirada")
+
+// webauthnUser adapta User a la interfaz que espera la librería.
+type webauthnUser struct {
+	user User
+}
+
+func (u webauthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u webauthnUser) WebAuthnDisplayName() string {
+	if name := strings.TrimSpace(u.user.Name + " " + u.user.LastName); name != "" {
+		return name
+	}
+	return u.user.Email
+}
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.user.Passkeys
+}
+
+// WebAuthnSession guarda el challenge entre el inicio y el final de una ceremonia.
+type WebAuthnSession struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Ceremony  string             `bson:"ceremony"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty"`
+	Data      []byte             `bson:"data"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+type WebAuthnLoginRequest struct {
+	Email string `json:"email"`
+}
+
+func (s *Server) loadWebAuthn(cfg config.WebAuthn) {
+	var err error
+	s.webAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: "UserApp",
+		RPOrigins:     cfg.RPOrigins,
+	})
+	if err != nil {
+		log.Printf("⚠️  WebAuthn deshabilitado: %v", err)
+		s.webAuthn = nil
+		return
+	}
+	log.Printf("✅ WebAuthn habilitado para %s", cfg.RPID)
+}
+
+func (s *Server) createWebAuthnIndexes(ctx context.Context) error {
+	_, err := s.database.WebAuthnSessions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *Server) saveWebAuthnSession(ctx context.Context, ceremony string, userID primitive.ObjectID, data *webauthn.SessionData) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := data.Expires
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(5 * time.Minute)
+	}
+
+	result, err := s.database.WebAuthnSessions.InsertOne(ctx, WebAuthnSession{
+		Ceremony:  ceremony,
+		UserID:    userID,
+		Data:      raw,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// takeWebAuthnSession recupera y elimina la sesión: cada challenge se usa una vez.
+func (s *Server) takeWebAuthnSession(ctx context.Context, ceremony, id string) (WebAuthnSession, webauthn.SessionData, error) {
+	sessionID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return WebAuthnSession{}, webauthn.SessionData{}, errWebAuthnSessionNotFound
+	}
+
+	var stored WebAuthnSession
+	err = s.database.WebAuthnSessions.FindOneAndDelete(ctx, bson.M{
+		"_id":        sessionID,
+		"ceremony":   ceremony,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return WebAuthnSession{}, webauthn.SessionData{}, errWebAuthnSessionNotFound
+	}
+	if err != nil {
+		return WebAuthnSession{}, webauthn.SessionData{}, err
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(stored.Data, &data); err != nil {
+		return WebAuthnSession{}, webauthn.SessionData{}, err
+	}
+	return stored, data, nil
+}
+
+func (s *Server) webAuthnEnabled(w http.ResponseWriter) bool {
+	if s.webAuthn == nil {
+		http.Error(w, "WebAuthn no configurado", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if rejectImpersonation(w, r) {
+		return
+	}
+
+	if !s.webAuthnEnabled(w) {
+		return
+	}
+
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	exclusions := webauthn.Credentials(user.Passkeys).CredentialDescriptors()
+	creation, data, err := s.webAuthn.BeginRegistration(webauthnUser{user}, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		log.Printf("Error iniciando registro WebAuthn: %v", err)
+		http.Error(w, "Error iniciando registro de passkey", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := s.saveWebAuthnSession(ctx, webauthnCeremonyRegister, user.ID, data)
+	if err != nil {
+		log.Printf("Error guardando sesión WebAuthn: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"options":    creation,
+	})
+}
+
+func (s *Server) handleWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if !s.webAuthnEnabled(w) {
+		return
+	}
+
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, data, err := s.takeWebAuthnSession(ctx, webauthnCeremonyRegister, r.URL.Query().Get("session_id"))
+	if err == errWebAuthnSessionNotFound || (err == nil && stored.UserID != userID) {
+		http.Error(w, "Ceremonia de registro inválida o expirada", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error leyendo sesión WebAuthn: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	credential, err := s.webAuthn.FinishRegistration(webauthnUser{user}, data, r)
+	if err != nil {
+		log.Printf("Registro de passkey rechazado: %v", err)
+		http.Error(w, "No se pudo verificar la passkey", http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.changeUser(ctx, user.ID, func(user *User) error {
+		user.Passkeys = append(user.Passkeys, *credential)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error guardando passkey: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("✅ Passkey registrada para el usuario %s", user.ID.Hex())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Passkey registrada correctamente",
+	})
+}
+
+// handleWebAuthnLoginBegin inicia el login. Con email se limita a las passkeys
+// de ese usuario; sin email se usa el flujo de credenciales descubribles.
+func (s *Server) handleWebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if !s.webAuthnEnabled(w) {
+		return
+	}
+
+	var req WebAuthnLoginRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var user User
+	if req.Email != "" {
+		var err error
+		user, err = s.userRepo.FindByEmail(ctx, req.Email)
+		if errors.Is(err, errUserNotFound) || (err == nil && len(user.Passkeys) == 0) {
+			http.Error(w, "No hay passkeys registradas para este email", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error buscando usuario: %v", err)
+			http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var assertion interface{}
+	var data *webauthn.SessionData
+	var err error
+	if req.Email != "" {
+		assertion, data, err = s.webAuthn.BeginLogin(webauthnUser{user})
+	} else {
+		assertion, data, err = s.webAuthn.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		log.Printf("Error iniciando login WebAuthn: %v", err)
+		http.Error(w, "Error iniciando login con passkey", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := s.saveWebAuthnSession(ctx, webauthnCeremonyLogin, user.ID, data)
+	if err != nil {
+		log.Printf("Error guardando sesión WebAuthn: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"options":    assertion,
+	})
+}
+
+func (s *Server) handleWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if !s.webAuthnEnabled(w) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, data, err := s.takeWebAuthnSession(ctx, webauthnCeremonyLogin, r.URL.Query().Get("session_id"))
+	if err == errWebAuthnSessionNotFound {
+		http.Error(w, "Ceremonia de login inválida o expirada", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error leyendo sesión WebAuthn: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	var user User
+	var credential *webauthn.Credential
+	if !stored.UserID.IsZero() {
+		user, err = s.userRepo.FindByID(ctx, stored.UserID)
+		if err == nil {
+			credential, err = s.webAuthn.FinishLogin(webauthnUser{user}, data, r)
+		}
+	} else {
+		credential, err = s.webAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			if len(userHandle) != len(primitive.NilObjectID) {
+				return nil, errors.New("user handle inválido")
+			}
+			var userID primitive.ObjectID
+			copy(userID[:], userHandle)
+			found, err := s.userRepo.FindByID(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			user = found
+			return webauthnUser{user}, nil
+		}, data, r)
+	}
+	if err != nil {
+		log.Printf("Login con passkey rechazado: %v", err)
+		http.Error(w, "No se pudo verificar la passkey", http.StatusUnauthorized)
+		return
+	}
+
+	if !user.Verified {
+		http.Error(w, "Debes confirmar tu email antes de iniciar sesión", http.StatusForbidden)
+		return
+	}
+	if !accountActive(w, user) {
+		return
+	}
+
+	// Se guarda el contador de firmas actualizado para detectar autenticadores clonados.
+	_, err = s.changeUser(ctx, user.ID, func(user *User) error {
+		for i := range user.Passkeys {
+			if bytes.Equal(user.Passkeys[i].ID, credential.ID) {
+				user.Passkeys[i].Authenticator = credential.Authenticator
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error actualizando passkey: %v", err)
+	}
+
+	session, err := s.sessionResponse(ctx, w, r, user)
+	if err != nil {
+		log.Printf("Error creando sesión: %v", err)
+		http.Error(w, "Error creando sesión", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Login exitoso",
+		"user":    user,
+	}
+	for key, value := range session {
+		response[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
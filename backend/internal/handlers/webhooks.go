@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Margen aceptado entre el timestamp firmado del webhook y la hora local.
+const webhookTolerance = 5 * time.Minute
+
+var errInvalidWebhookSignature = errors.New("firma de webhook inválida")
+
+// EmailEvent es un evento de entrega recibido del proveedor (email.delivered,
+// email.bounced, email.opened, ...).
+type EmailEvent struct {
+	ID         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	WebhookID  string             `json:"-" bson:"webhook_id"`
+	MessageID  string             `json:"message_id" bson:"message_id"`
+	Type       string             `json:"type" bson:"type"`
+	To         []string           `json:"to" bson:"to"`
+	Subject    string             `json:"subject,omitempty" bson:"subject,omitempty"`
+	OccurredAt time.Time          `json:"occurred_at" bson:"occurred_at"`
+	ReceivedAt time.Time          `json:"received_at" bson:"received_at"`
+}
+
+type resendWebhookPayload struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		EmailID string   `json:"email_id"`
+		To      []string `json:"to"`
+		Subject string   `json:"subject"`
+	} `json:"data"`
+}
+
+func (s *Server) createEmailEventIndexes(ctx context.Context) error {
+	_, err := s.database.EmailEvents.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "webhook_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "occurred_at", Value: 1}}},
+		{Keys: bson.D{{Key: "to", Value: 1}, {Key: "occurred_at", Value: -1}}},
+	})
+	return err
+}
+
+// verifyResendSignature valida la firma Svix que Resend añade a cada webhook
+// (svix-id, svix-timestamp y svix-signature) con RESEND_WEBHOOK_SECRET.
+func (s *Server) verifyResendSignature(r *http.Request, body []byte) error {
+	secret := strings.TrimPrefix(s.emailConfig.ResendWebhookSecret, "whsec_")
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return errInvalidWebhookSignature
+	}
+
+	id := r.Header.Get("svix-id")
+	timestamp := r.Header.Get("svix-timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if id == "" || err != nil {
+		return errInvalidWebhookSignature
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return errInvalidWebhookSignature
+	}
+
+	expected := webhookSignature(key, id, timestamp, body)
+
+	// La cabecera puede traer varias firmas ("v1,<firma> v1,<firma>") durante
+	// la rotación del secreto.
+	for _, candidate := range strings.Fields(r.Header.Get("svix-signature")) {
+		version, signature, ok := strings.Cut(candidate, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return errInvalidWebhookSignature
+}
+
+// webhookSignature es la firma v1 de Svix: HMAC-SHA256 de "id.timestamp.body".
+// Los webhooks salientes se firman igual (ver outbound_webhooks.go), así que
+// los integradores pueden verificarlos con las librerías de Svix.
+func webhookSignature(key []byte, id, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (s *Server) handleResendWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.emailConfig.ResendWebhookSecret == "" {
+		http.Error(w, "Webhooks de Resend no configurados", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Cuerpo inválido", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.verifyResendSignature(r, body); err != nil {
+		http.Error(w, "Firma inválida", http.StatusUnauthorized)
+		return
+	}
+
+	var payload resendWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Data.EmailID == "" {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	occurredAt := payload.CreatedAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, err = s.database.EmailEvents.InsertOne(ctx, EmailEvent{
+		WebhookID:  r.Header.Get("svix-id"),
+		MessageID:  payload.Data.EmailID,
+		Type:       payload.Type,
+		To:         payload.Data.To,
+		Subject:    payload.Data.Subject,
+		OccurredAt: occurredAt,
+		ReceivedAt: time.Now(),
+	})
+	// Resend reintenta los webhooks; un svix-id repetido ya está guardado.
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Error guardando evento de email: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if payload.Type == "email.bounced" || payload.Type == "email.complained" {
+		log.Printf("⚠️  Email %s: %s (%s)", payload.Data.EmailID, payload.Type, strings.Join(payload.Data.To, ", "))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// emailStatus resume los eventos de un mensaje en su estado más reciente.
+func emailStatus(events []EmailEvent) string {
+	if len(events) == 0 {
+		return "unknown"
+	}
+	return strings.TrimPrefix(events[len(events)-1].Type, "email.")
+}
+
+// handleAdminEmailStatus devuelve los eventos de entrega de un mensaje.
+func (s *Server) handleAdminEmailStatus(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.database.EmailEvents.Find(ctx, bson.M{"message_id": messageID},
+		options.Find().SetSort(bson.D{{Key: "occurred_at", Value: 1}}))
+	if err != nil {
+		log.Printf("Error listando eventos de email: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	events := []EmailEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		log.Printf("Error leyendo eventos de email: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	if len(events) == 0 {
+		http.Error(w, "No hay eventos para este mensaje", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": messageID,
+		"status":     emailStatus(events),
+		"events":     events,
+	})
+}
+
+var emailEventList = listOptions{
+	Sorts:       map[string]bool{"occurred_at": true},
+	DefaultSort: "-occurred_at",
+	Filters:     []string{"to", "type"},
+}
+
+// handleAdminListEmailEvents lista los últimos eventos, opcionalmente de un
+// destinatario (?to=) o de un tipo (?type=).
+func (s *Server) handleAdminListEmailEvents(w http.ResponseWriter, r *http.Request) {
+	list, invalid := parseListQuery(r.URL.Query(), emailEventList)
+	if len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	events := []EmailEvent{}
+	total, err := findList(ctx, s.database.EmailEvents, bson.M{}, list, &events)
+	if err != nil {
+		log.Printf("Error listando eventos de email: %v", err)
+		http.Error(w, "Error de base de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listResponse(list, events, len(events), total))
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GET /api/v1/ws abre un WebSocket por el que se envían al usuario sus
+// eventos (ver events.go), uno por mensaje en JSON. Los navegadores no
+// pueden enviar Authorization al abrir un WebSocket, así que además de la
+// cabecera y de la cookie de sesión se acepta ?access_token=. La conexión
+// se cierra cuando expira el token; el cliente debe reconectar con uno nuevo.
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+// checkWebSocketOrigin aplica la política CORS al abrir el WebSocket.
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	return r.Header.Get("Origin") == "" || s.corsPolicy.Load().OriginAllowed(r)
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("access_token"); token != "" && bearerToken(r) == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	claims, err := s.requestClaims(w, r)
+	if err == errNoCredentials {
+		http.Error(w, "Token de acceso requerido", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+		return
+	}
+	setRequestUser(r, claims.Subject)
+
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade ya ha respondido con el error.
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe := s.userEvents.Subscribe(userID, claims.SessionID)
+	defer unsubscribe()
+
+	// El cliente no envía nada: solo se lee para procesar pongs y el cierre.
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	var expired <-chan time.Time
+	if claims.ExpiresAt != nil {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case event := <-events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-expired:
+			message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Token expirado")
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+			return
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+)
+
+// OpenBolt abre (o crea) el archivo de BoltDB de path y carga en memoria
+// las colecciones guardadas en él (DB_DRIVER=bolt). Las consultas se
+// resuelven en memoria como con NewMemory y cada cambio se escribe en el
+// archivo, así los datos sobreviven a los reinicios sin servidor de base
+// de datos. Los índices no se guardan: createIndexes los vuelve a crear.
+func OpenBolt(path string) (*DB, error) {
+	file, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	var loadErr error
+	db := newDB(func(name string) Collection {
+		persist := boltPersister{db: file, bucket: []byte(boltBucketPrefix + name)}
+		c := newMemoryCollection()
+		c.persist = persist
+		if loadErr == nil {
+			if c.docs, loadErr = loadBoltDocs(file, persist.bucket); loadErr != nil {
+				loadErr = fmt.Errorf("cargando %s: %w", name, loadErr)
+			}
+		}
+		return c
+	})
+	if loadErr != nil {
+		file.Close()
+		return nil, loadErr
+	}
+	db.bolt = file
+	return db, nil
+}
+
+// Bolt devuelve el archivo abierto por OpenBolt, o nil, para que el
+// repositorio de usuarios lo comparta: BoltDB no deja abrir el mismo
+// archivo dos veces.
+func (db *DB) Bolt() *bbolt.DB {
+	return db.bolt
+}
+
+// boltBucketPrefix separa los buckets de las colecciones de los del
+// repositorio de usuarios, que comparte el archivo.
+const boltBucketPrefix = "collection:"
+
+func loadBoltDocs(file *bbolt.DB, bucket []byte) ([]bson.M, error) {
+	var docs []bson.M
+	err := file.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(_, raw []byte) error {
+			dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+			if err != nil {
+				return err
+			}
+			dec.DefaultDocumentM()
+			var doc bson.M
+			if err := dec.Decode(&doc); err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+			return nil
+		})
+	})
+	return docs, err
+}
+
+// boltPersister guarda cada documento en BSON con su _id como clave.
+type boltPersister struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func (p boltPersister) save(put, remove []bson.M) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(p.bucket)
+		for _, doc := range remove {
+			key, err := bson.Marshal(bson.M{"_id": doc["_id"]})
+			if err != nil {
+				return err
+			}
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		for _, doc := range put {
+			key, err := bson.Marshal(bson.M{"_id": doc["_id"]})
+			if err != nil {
+				return err
+			}
+			raw, err := bson.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
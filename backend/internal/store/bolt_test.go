@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBoltPersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "userapp.db")
+
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	seed(t, db.Sessions,
+		testDoc{Name: "ana", Age: 30, Seen: time.Now()},
+		testDoc{Name: "bruno", Age: 25},
+		testDoc{Name: "carla", Age: 41},
+	)
+	if _, err := db.Sessions.UpdateOne(ctx, bson.M{"name": "ana"}, bson.M{"$inc": bson.M{"age": 1}}); err != nil {
+		t.Fatalf("UpdateOne: %v", err)
+	}
+	if _, err := db.Sessions.DeleteOne(ctx, bson.M{"name": "bruno"}); err != nil {
+		t.Fatalf("DeleteOne: %v", err)
+	}
+	if err := db.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	db, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt tras cerrar: %v", err)
+	}
+	defer db.Close(ctx)
+
+	if got := names(t, db.Sessions, bson.M{}); !slices.Equal(got, []string{"ana", "carla"}) {
+		t.Errorf("tras reabrir: %v, se esperaba [ana carla]", got)
+	}
+	var doc testDoc
+	if err := db.Sessions.FindOne(ctx, bson.M{"name": "ana"}).Decode(&doc); err != nil || doc.Age != 31 {
+		t.Errorf("ana tras reabrir: edad %d, %v; se esperaba 31", doc.Age, err)
+	}
+	if count, _ := db.OTPs.CountDocuments(ctx, bson.M{}); count != 0 {
+		t.Errorf("otra colección tiene %d documentos, se esperaba 0", count)
+	}
+}
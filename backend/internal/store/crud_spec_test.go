@@ -0,0 +1,429 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Los casos de testdata/crud comparan las colecciones en memoria con
+// MongoDB. Casi todos son de la especificación CRUD de los drivers, con el
+// resultado que da un servidor real; backend.json son casos propios con los
+// operadores que usa el backend. Con MONGODB_TEST_URI los mismos casos se
+// ejecutan también contra ese servidor, que es lo que valida backend.json.
+// Bolt usa las mismas colecciones en memoria y se prueba igual. Con
+// POSTGRES_TEST_URL también se prueba NewPostgres, en un esquema propio que
+// se borra al terminar.
+
+type crudFile struct {
+	Data  []bson.M   `bson:"data"`
+	Tests []crudTest `bson:"tests"`
+}
+
+type crudTest struct {
+	Description string        `bson:"description"`
+	Operation   crudOperation `bson:"operation"`
+	Outcome     bson.M        `bson:"outcome"`
+}
+
+type crudOperation struct {
+	Name      string `bson:"name"`
+	Arguments bson.M `bson:"arguments"`
+}
+
+func TestCRUDSpec(t *testing.T) {
+	files, err := filepath.Glob("testdata/crud/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no hay casos en testdata/crud: %v", err)
+	}
+
+	targets := map[string]func(t *testing.T) Collection{
+		"memory": func(*testing.T) Collection { return NewMemory().Users },
+		"bolt": func(t *testing.T) Collection {
+			db, err := OpenBolt(filepath.Join(t.TempDir(), "userapp.db"))
+			if err != nil {
+				t.Fatalf("OpenBolt: %v", err)
+			}
+			t.Cleanup(func() { db.Bolt().Close() })
+			return db.Users
+		},
+	}
+	if uri := os.Getenv("MONGODB_TEST_URI"); uri != "" {
+		db := connectTestMongo(t, uri)
+		targets["mongo"] = func(t *testing.T) Collection {
+			coll := db.Collection("crud_spec")
+			if err := coll.Drop(context.Background()); err != nil {
+				t.Fatalf("Drop: %v", err)
+			}
+			return mongoCollection{coll}
+		}
+	}
+
+	if url := os.Getenv("POSTGRES_TEST_URL"); url != "" {
+		pool := connectTestPostgres(t, url)
+		targets["postgres"] = func(t *testing.T) Collection {
+			if _, err := pool.Exec(context.Background(), "DELETE FROM documents"); err != nil {
+				t.Fatalf("DELETE: %v", err)
+			}
+			return NewPostgres(pool).Users
+		}
+	}
+
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var file crudFile
+		if err := bson.UnmarshalExtJSON(raw, false, &file); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, test := range file.Tests {
+			for target, open := range targets {
+				name := strings.TrimSuffix(filepath.Base(path), ".json") + "/" + test.Description + "/" + target
+				t.Run(name, func(t *testing.T) {
+					runCRUDTest(t, open(t), file.Data, test)
+				})
+			}
+		}
+	}
+}
+
+func connectTestMongo(t *testing.T, uri string) *mongo.Database {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("conectando a MONGODB_TEST_URI: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("conectando a MONGODB_TEST_URI: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client.Database("store_crud_test")
+}
+
+// connectTestPostgres crea un esquema con las migraciones de documents y
+// devuelve un pool que lo usa.
+func connectTestPostgres(t *testing.T, url string) *pgxpool.Pool {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("POSTGRES_TEST_URL: %v", err)
+	}
+	schema := fmt.Sprintf("store_crud_test_%d", os.Getpid())
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("conectando a POSTGRES_TEST_URL: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		pool.Close()
+	})
+
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("CREATE SCHEMA: %v", err)
+	}
+	for _, name := range []string{"0003_create_documents.sql", "0004_document_queries.sql"} {
+		migration, err := os.ReadFile(filepath.Join("..", "..", "migrations", "postgres", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(migration)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	return pool
+}
+
+func runCRUDTest(t *testing.T, c Collection, data []bson.M, test crudTest) {
+	ctx := context.Background()
+	for _, doc := range data {
+		if _, err := c.InsertOne(ctx, doc); err != nil {
+			t.Fatalf("InsertOne: %v", err)
+		}
+	}
+
+	args, err := toDocument(test.Operation.Arguments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome, err := toDocument(test.Outcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := runCRUDOperation(ctx, c, test.Operation.Name, args)
+	if truthy(outcome["error"]) {
+		if err == nil {
+			t.Errorf("se esperaba un error")
+		}
+	} else if err != nil {
+		t.Fatalf("%s: %v", test.Operation.Name, err)
+	}
+
+	if want, ok := outcome["result"]; ok && !matchesOutcome(want, result) {
+		t.Errorf("resultado = %v, se esperaba %v", result, want)
+	}
+	if collection, ok := outcome["collection"].(bson.M); ok {
+		got := allDocs(t, c)
+		if want := collection["data"]; !equal(bson.A(got), want) {
+			t.Errorf("colección = %v, se esperaba %v", got, want)
+		}
+	}
+}
+
+// matchesOutcome compara el resultado de una operación con el esperado. En
+// los resultados de escritura solo cuentan los campos que indica el caso.
+func matchesOutcome(want, got interface{}) bool {
+	wantDoc, ok := want.(bson.M)
+	gotDoc, isResult := got.(crudResult)
+	if !ok || !isResult {
+		return equal(got, want)
+	}
+	for key, value := range wantDoc {
+		if !equal(gotDoc[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// crudResult es el resultado de una escritura, con los nombres de campo de
+// la especificación.
+type crudResult bson.M
+
+func allDocs(t *testing.T, c Collection) bson.A {
+	t.Helper()
+	cursor, err := c.Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	var docs []bson.M
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	out := bson.A{}
+	for _, doc := range docs {
+		value, err := toValue(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, value)
+	}
+	return out
+}
+
+func runCRUDOperation(ctx context.Context, c Collection, name string, args bson.M) (interface{}, error) {
+	filter := args["filter"]
+	if filter == nil {
+		filter = bson.M{}
+	}
+	upsert := truthy(args["upsert"])
+	var arrayFilters *options.ArrayFilters
+	if list, ok := args["arrayFilters"].(bson.A); ok {
+		arrayFilters = &options.ArrayFilters{Filters: list}
+	}
+
+	switch name {
+	case "find":
+		opt := options.Find()
+		if sort, ok := args["sort"]; ok {
+			opt.SetSort(sort)
+		}
+		if skip, ok := toFloat(args["skip"]); ok {
+			opt.SetSkip(int64(skip))
+		}
+		if limit, ok := toFloat(args["limit"]); ok {
+			opt.SetLimit(int64(limit))
+		}
+		if projection, ok := args["projection"]; ok {
+			opt.SetProjection(projection)
+		}
+		cursor, err := c.Find(ctx, filter, opt)
+		if err != nil {
+			return nil, err
+		}
+		return cursorDocs(ctx, cursor)
+	case "aggregate":
+		cursor, err := c.Aggregate(ctx, args["pipeline"])
+		if err != nil {
+			return nil, err
+		}
+		return cursorDocs(ctx, cursor)
+	case "count", "countDocuments", "estimatedDocumentCount":
+		opt := options.Count()
+		if skip, ok := toFloat(args["skip"]); ok {
+			opt.SetSkip(int64(skip))
+		}
+		if limit, ok := toFloat(args["limit"]); ok {
+			opt.SetLimit(int64(limit))
+		}
+		return c.CountDocuments(ctx, filter, opt)
+	case "insertOne":
+		res, err := c.InsertOne(ctx, args["document"])
+		if err != nil {
+			return nil, err
+		}
+		return crudResult{"insertedId": res.InsertedID}, nil
+	case "updateOne", "updateMany":
+		opt := options.Update().SetUpsert(upsert)
+		if arrayFilters != nil {
+			opt.SetArrayFilters(*arrayFilters)
+		}
+		update := c.UpdateOne
+		if name == "updateMany" {
+			update = c.UpdateMany
+		}
+		res, err := update(ctx, filter, args["update"], opt)
+		if err != nil {
+			return nil, err
+		}
+		return updateResult(res), nil
+	case "replaceOne":
+		res, err := c.ReplaceOne(ctx, filter, args["replacement"], options.Replace().SetUpsert(upsert))
+		if err != nil {
+			return nil, err
+		}
+		return updateResult(res), nil
+	case "deleteOne", "deleteMany":
+		remove := c.DeleteOne
+		if name == "deleteMany" {
+			remove = c.DeleteMany
+		}
+		res, err := remove(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		return crudResult{"deletedCount": res.DeletedCount}, nil
+	case "findOneAndUpdate":
+		opt := options.FindOneAndUpdate().SetUpsert(upsert)
+		if sort, ok := args["sort"]; ok {
+			opt.SetSort(sort)
+		}
+		if projection, ok := args["projection"]; ok {
+			opt.SetProjection(projection)
+		}
+		if args["returnDocument"] == "After" {
+			opt.SetReturnDocument(options.After)
+		}
+		if arrayFilters != nil {
+			opt.SetArrayFilters(*arrayFilters)
+		}
+		return singleDoc(c.FindOneAndUpdate(ctx, filter, args["update"], opt))
+	case "findOneAndDelete":
+		opt := options.FindOneAndDelete()
+		if sort, ok := args["sort"]; ok {
+			opt.SetSort(sort)
+		}
+		if projection, ok := args["projection"]; ok {
+			opt.SetProjection(projection)
+		}
+		return singleDoc(c.FindOneAndDelete(ctx, filter, opt))
+	case "bulkWrite":
+		return bulkWrite(ctx, c, args)
+	}
+	return nil, fmt.Errorf("operación %s no soportada", name)
+}
+
+// bulkWrite ejecuta las peticiones una a una. Las ordenadas paran en el
+// primer error; las demás siguen y devuelven el error al final.
+func bulkWrite(ctx context.Context, c Collection, args bson.M) (interface{}, error) {
+	ordered := true
+	if opts, ok := args["options"].(bson.M); ok {
+		if value, ok := opts["ordered"].(bool); ok {
+			ordered = value
+		}
+	}
+	requests, _ := args["requests"].(bson.A)
+
+	total := crudResult{
+		"deletedCount": int64(0), "insertedCount": int64(0), "matchedCount": int64(0),
+		"modifiedCount": int64(0), "upsertedCount": int64(0),
+		"insertedIds": bson.M{}, "upsertedIds": bson.M{},
+	}
+	var failed error
+	for i, raw := range requests {
+		request, _ := raw.(bson.M)
+		name, _ := request["name"].(string)
+		arguments, _ := request["arguments"].(bson.M)
+		result, err := runCRUDOperation(ctx, c, name, arguments)
+		if err != nil {
+			failed = errors.Join(failed, err)
+			if ordered {
+				break
+			}
+			continue
+		}
+		res, _ := result.(crudResult)
+		index := strconv.Itoa(i)
+		for key, value := range res {
+			switch key {
+			case "insertedId":
+				total["insertedCount"] = total["insertedCount"].(int64) + 1
+				total["insertedIds"].(bson.M)[index] = value
+			case "upsertedId":
+				if value != nil {
+					total["upsertedIds"].(bson.M)[index] = value
+				}
+			default:
+				total[key] = total[key].(int64) + value.(int64)
+			}
+		}
+	}
+	return total, failed
+}
+
+func updateResult(res *mongo.UpdateResult) crudResult {
+	result := crudResult{
+		"matchedCount":  res.MatchedCount,
+		"modifiedCount": res.ModifiedCount,
+		"upsertedCount": res.UpsertedCount,
+	}
+	if res.UpsertedID != nil {
+		result["upsertedId"] = res.UpsertedID
+	}
+	return result
+}
+
+func singleDoc(res *mongo.SingleResult) (interface{}, error) {
+	var doc bson.M
+	err := res.Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toValue(doc)
+}
+
+func cursorDocs(ctx context.Context, cursor *mongo.Cursor) (interface{}, error) {
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := bson.A{}
+	for _, doc := range docs {
+		value, err := toValue(doc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, nil
+}
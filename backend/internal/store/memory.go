@@ -0,0 +1,673 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMemory devuelve colecciones guardadas en memoria, para el modo demo y
+// las pruebas. Entienden los filtros, actualizaciones e índices (únicos,
+// parciales y TTL) que usa el backend; lo que no entienden devuelve error
+// en lugar de ignorarse. TestCRUDSpec las compara con los resultados de
+// MongoDB (ver testdata/crud).
+func NewMemory() *DB {
+	return newDB(func(string) Collection { return newMemoryCollection() })
+}
+
+type memoryCollection struct {
+	mu      sync.Mutex
+	docs    []bson.M
+	indexes []memoryIndex
+	// persist, si no es nil, guarda cada cambio antes de aplicarlo en
+	// memoria (ver OpenBolt).
+	persist persister
+}
+
+// persister escribe los documentos nuevos o modificados (put) y quita los
+// borrados (remove) en una sola operación.
+type persister interface {
+	save(put, remove []bson.M) error
+}
+
+type memoryIndex struct {
+	name    string
+	keys    bson.D
+	unique  bool
+	sparse  bool
+	partial bson.M
+	ttl     *int32
+}
+
+func newMemoryCollection() *memoryCollection {
+	return &memoryCollection{
+		indexes: []memoryIndex{{name: "_id_", keys: bson.D{{Key: "_id", Value: int32(1)}}, unique: true}},
+	}
+}
+
+// lock toma el mutex de la colección y borra los documentos caducados por
+// los índices TTL, como haría el monitor de MongoDB.
+func (c *memoryCollection) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if err := c.expire(time.Now()); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (c *memoryCollection) expire(now time.Time) error {
+	for _, index := range c.indexes {
+		if index.ttl == nil {
+			continue
+		}
+		limit := primitive.NewDateTimeFromTime(now.Add(-time.Duration(*index.ttl) * time.Second))
+		field := strings.Split(index.keys[0].Key, ".")
+		var expired []int
+		for i, doc := range c.docs {
+			for _, value := range candidates(resolve(doc, field)) {
+				if date, ok := value.(primitive.DateTime); ok && date <= limit {
+					expired = append(expired, i)
+					break
+				}
+			}
+		}
+		if err := c.remove(expired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save guarda los documentos con persist, si la colección lo tiene.
+func (c *memoryCollection) save(put, remove []bson.M) error {
+	if c.persist == nil {
+		return nil
+	}
+	return c.persist.save(put, remove)
+}
+
+// remove borra los documentos de las posiciones indicadas, en orden
+// creciente.
+func (c *memoryCollection) remove(positions []int) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	docs := make([]bson.M, len(positions))
+	for i, position := range positions {
+		docs[i] = c.docs[position]
+	}
+	if err := c.save(nil, docs); err != nil {
+		return err
+	}
+	for i := len(positions) - 1; i >= 0; i-- {
+		c.docs = slices.Delete(c.docs, positions[i], positions[i]+1)
+	}
+	return nil
+}
+
+// filterDocs devuelve las posiciones de los documentos que cumplen filter,
+// en el orden indicado por sort.
+func (c *memoryCollection) filterDocs(filter interface{}, sort interface{}) ([]int, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var found []int
+	for i, doc := range c.docs {
+		ok, err := matches(doc, query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, i)
+		}
+	}
+	if sort != nil {
+		keys, err := toOrdered(sort)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortStableFunc(found, func(a, b int) int {
+			return compareByKeys(c.docs[a], c.docs[b], keys)
+		})
+	}
+	return found, nil
+}
+
+func (c *memoryCollection) InsertOne(ctx context.Context, document interface{}, _ ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if err := c.checkUnique(doc, -1); err != nil {
+		return nil, err
+	}
+	if err := c.save([]bson.M{doc}, nil); err != nil {
+		return nil, err
+	}
+	c.docs = append(c.docs, doc)
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	opt := options.MergeFindOneOptions(opts...)
+	if err := c.lock(ctx); err != nil {
+		return singleResult(nil, err)
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, opt.Sort)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	found = page(found, opt.Skip, nil)
+	if len(found) == 0 {
+		return singleResult(nil, nil)
+	}
+	return singleResult(project(c.docs[found[0]], opt.Projection))
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	opt := options.MergeFindOptions(opts...)
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, opt.Sort)
+	if err != nil {
+		return nil, err
+	}
+	found = page(found, opt.Skip, opt.Limit)
+	docs := make([]interface{}, len(found))
+	for i, position := range found {
+		if docs[i], err = project(c.docs[position], opt.Projection); err != nil {
+			return nil, err
+		}
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (c *memoryCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	opt := options.MergeFindOneAndUpdateOptions(opts...)
+	if err := c.lock(ctx); err != nil {
+		return singleResult(nil, err)
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, opt.Sort)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	after := opt.ReturnDocument != nil && *opt.ReturnDocument == options.After
+	if len(found) == 0 {
+		if opt.Upsert == nil || !*opt.Upsert {
+			return singleResult(nil, nil)
+		}
+		doc, err := c.upsert(filter, update, opt.ArrayFilters, false)
+		if err != nil || !after {
+			return singleResult(nil, err)
+		}
+		return singleResult(project(doc, opt.Projection))
+	}
+
+	before := c.docs[found[0]]
+	doc, _, err := c.updateAt(found[0], update, opt.ArrayFilters)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	if after {
+		return singleResult(project(doc, opt.Projection))
+	}
+	return singleResult(project(before, opt.Projection))
+}
+
+func (c *memoryCollection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	opt := options.MergeFindOneAndDeleteOptions(opts...)
+	if err := c.lock(ctx); err != nil {
+		return singleResult(nil, err)
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, opt.Sort)
+	if err != nil || len(found) == 0 {
+		return singleResult(nil, err)
+	}
+	doc, err := project(c.docs[found[0]], opt.Projection)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	if err := c.remove(found[:1]); err != nil {
+		return singleResult(nil, err)
+	}
+	return singleResult(doc, nil)
+}
+
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(ctx, filter, update, false, options.MergeUpdateOptions(opts...))
+}
+
+func (c *memoryCollection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(ctx, filter, update, true, options.MergeUpdateOptions(opts...))
+}
+
+func (c *memoryCollection) update(ctx context.Context, filter, update interface{}, many bool, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		if opt.Upsert == nil || !*opt.Upsert {
+			return &mongo.UpdateResult{}, nil
+		}
+		doc, err := c.upsert(filter, update, opt.ArrayFilters, false)
+		if err != nil {
+			return nil, err
+		}
+		return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: doc["_id"]}, nil
+	}
+	if !many {
+		found = found[:1]
+	}
+
+	result := &mongo.UpdateResult{MatchedCount: int64(len(found))}
+	for _, position := range found {
+		_, changed, err := c.updateAt(position, update, opt.ArrayFilters)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			result.ModifiedCount++
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryCollection) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	opt := options.MergeReplaceOptions(opts...)
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		if opt.Upsert == nil || !*opt.Upsert {
+			return &mongo.UpdateResult{}, nil
+		}
+		doc, err := c.upsert(filter, replacement, nil, true)
+		if err != nil {
+			return nil, err
+		}
+		return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: doc["_id"]}, nil
+	}
+
+	doc, err := toDocument(replacement)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReplacement(doc); err != nil {
+		return nil, err
+	}
+	old := c.docs[found[0]]
+	doc["_id"] = old["_id"]
+	if err := c.checkUnique(doc, found[0]); err != nil {
+		return nil, err
+	}
+	if err := c.save([]bson.M{doc}, nil); err != nil {
+		return nil, err
+	}
+	c.docs[found[0]] = doc
+
+	result := &mongo.UpdateResult{MatchedCount: 1}
+	if !equal(old, doc) {
+		result.ModifiedCount = 1
+	}
+	return result, nil
+}
+
+func (c *memoryCollection) DeleteOne(ctx context.Context, filter interface{}, _ ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, false)
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter interface{}, _ ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, true)
+}
+
+func (c *memoryCollection) delete(ctx context.Context, filter interface{}, many bool) (*mongo.DeleteResult, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !many && len(found) > 1 {
+		found = found[:1]
+	}
+	if err := c.remove(found); err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(found))}, nil
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	opt := options.MergeCountOptions(opts...)
+	if err := c.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer c.mu.Unlock()
+
+	found, err := c.filterDocs(filter, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(page(found, opt.Skip, opt.Limit))), nil
+}
+
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline interface{}, _ ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	stages, err := toArray(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	docs := make([]bson.M, len(c.docs))
+	copy(docs, c.docs)
+	c.mu.Unlock()
+
+	docs, err = aggregate(docs, stages)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+// updateAt aplica update al documento de la posición indicada. Los cambios
+// se hacen sobre una copia, así un error o una clave duplicada no dejan el
+// documento a medias.
+func (c *memoryCollection) updateAt(position int, update interface{}, arrayFilters *options.ArrayFilters) (bson.M, bool, error) {
+	old := c.docs[position]
+	doc := copyValue(old).(bson.M)
+	if err := applyUpdate(doc, update, arrayFilters, false); err != nil {
+		return nil, false, err
+	}
+	if !equal(doc["_id"], old["_id"]) {
+		return nil, false, fmt.Errorf("no se puede modificar el campo inmutable _id")
+	}
+	if err := c.checkUnique(doc, position); err != nil {
+		return nil, false, err
+	}
+	changed := !equal(old, doc)
+	if changed {
+		if err := c.save([]bson.M{doc}, nil); err != nil {
+			return nil, false, err
+		}
+	}
+	c.docs[position] = doc
+	return doc, changed, nil
+}
+
+// upsert inserta el documento que describen las igualdades de filter con
+// update aplicado, o la sustitución si replace es true.
+func (c *memoryCollection) upsert(filter, update interface{}, arrayFilters *options.ArrayFilters, replace bool) (bson.M, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if replace {
+		if doc, err = toDocument(update); err != nil {
+			return nil, err
+		}
+		if err := checkReplacement(doc); err != nil {
+			return nil, err
+		}
+		if id, ok := equalityValue(query["_id"]); ok {
+			doc["_id"] = id
+		}
+	} else {
+		doc = bson.M{}
+		if err := seedUpsert(doc, query); err != nil {
+			return nil, err
+		}
+		if err := applyUpdate(doc, update, arrayFilters, true); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	if err := c.checkUnique(doc, -1); err != nil {
+		return nil, err
+	}
+	if err := c.save([]bson.M{doc}, nil); err != nil {
+		return nil, err
+	}
+	c.docs = append(c.docs, doc)
+	return doc, nil
+}
+
+// checkUnique comprueba que doc no repite la clave de ningún índice único
+// con otro documento. skip es la posición del propio documento, o -1.
+func (c *memoryCollection) checkUnique(doc bson.M, skip int) error {
+	for _, index := range c.indexes {
+		if !index.unique || !index.covers(doc) {
+			continue
+		}
+		key := index.key(doc)
+		for i, other := range c.docs {
+			if i != skip && index.covers(other) && equal(key, index.key(other)) {
+				return duplicateKeyError(index.name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// covers indica si el documento entra en el índice: los parciales solo
+// indexan los que cumplen el filtro y los dispersos los que tienen el campo.
+func (index memoryIndex) covers(doc bson.M) bool {
+	if index.partial != nil {
+		if ok, _ := matches(doc, index.partial); !ok {
+			return false
+		}
+	}
+	if index.sparse {
+		for _, key := range index.keys {
+			if len(resolve(doc, strings.Split(key.Key, "."))) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (index memoryIndex) key(doc bson.M) bson.A {
+	key := make(bson.A, len(index.keys))
+	for i, field := range index.keys {
+		if values := resolve(doc, strings.Split(field.Key, ".")); len(values) > 0 {
+			key[i] = values[0]
+		}
+	}
+	return key
+}
+
+func duplicateKeyError(index string, key bson.A) error {
+	return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+		Code:    11000,
+		Message: fmt.Sprintf("E11000 duplicate key error index: %s dup key: %v", index, key),
+	}}}
+}
+
+func (c *memoryCollection) Indexes() IndexView {
+	return memoryIndexView{c}
+}
+
+type memoryIndexView struct {
+	c *memoryCollection
+}
+
+func (v memoryIndexView) CreateOne(ctx context.Context, model mongo.IndexModel, _ ...*options.CreateIndexesOptions) (string, error) {
+	index, err := newMemoryIndex(model)
+	if err != nil {
+		return "", err
+	}
+
+	c := v.c
+	if err := c.lock(ctx); err != nil {
+		return "", err
+	}
+	defer c.mu.Unlock()
+
+	for _, existing := range c.indexes {
+		if existing.name == index.name {
+			return index.name, nil
+		}
+	}
+	if index.unique {
+		for i, doc := range c.docs {
+			if !index.covers(doc) {
+				continue
+			}
+			for _, other := range c.docs[i+1:] {
+				if index.covers(other) && equal(index.key(doc), index.key(other)) {
+					return "", duplicateKeyError(index.name, index.key(doc))
+				}
+			}
+		}
+	}
+	c.indexes = append(c.indexes, index)
+	return index.name, nil
+}
+
+// newMemoryIndex lee los campos y opciones del índice, con el nombre que le
+// daría MongoDB si no lo indica.
+func newMemoryIndex(model mongo.IndexModel) (memoryIndex, error) {
+	keys, err := toOrdered(model.Keys)
+	if err != nil {
+		return memoryIndex{}, err
+	}
+	if len(keys) == 0 {
+		return memoryIndex{}, fmt.Errorf("el índice no tiene campos")
+	}
+
+	index := memoryIndex{keys: keys}
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+	index.name = strings.Join(parts, "_")
+	if opt := model.Options; opt != nil {
+		if opt.Name != nil {
+			index.name = *opt.Name
+		}
+		index.unique = opt.Unique != nil && *opt.Unique
+		index.sparse = opt.Sparse != nil && *opt.Sparse
+		index.ttl = opt.ExpireAfterSeconds
+		if opt.PartialFilterExpression != nil {
+			if index.partial, err = toDocument(opt.PartialFilterExpression); err != nil {
+				return memoryIndex{}, err
+			}
+		}
+	}
+	return index, nil
+}
+
+func (v memoryIndexView) CreateMany(ctx context.Context, models []mongo.IndexModel, opts ...*options.CreateIndexesOptions) ([]string, error) {
+	names := make([]string, 0, len(models))
+	for _, model := range models {
+		name, err := v.CreateOne(ctx, model, opts...)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (v memoryIndexView) DropOne(ctx context.Context, name string, _ ...*options.DropIndexesOptions) (bson.Raw, error) {
+	c := v.c
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	for i, index := range c.indexes {
+		if index.name == name && name != "_id_" {
+			c.indexes = slices.Delete(c.indexes, i, i+1)
+			return bson.Marshal(bson.M{"ok": 1})
+		}
+	}
+	return nil, mongo.CommandError{Code: 27, Name: "IndexNotFound", Message: "index not found with name [" + name + "]"}
+}
+
+// singleResult construye el resultado de FindOne y compañía: sin documento
+// ni error es mongo.ErrNoDocuments, como en el driver.
+func singleResult(doc bson.M, err error) *mongo.SingleResult {
+	if err == nil && doc == nil {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+	return mongo.NewSingleResultFromDocument(doc, nil, nil)
+}
+
+// page aplica skip y limit a las posiciones encontradas. Un límite negativo
+// se trata como su valor absoluto, como hace MongoDB en Find.
+func page(found []int, skip, limit *int64) []int {
+	if skip != nil && *skip > 0 {
+		if *skip >= int64(len(found)) {
+			return nil
+		}
+		found = found[*skip:]
+	}
+	if limit != nil && *limit != 0 {
+		n := *limit
+		if n < 0 {
+			n = -n
+		}
+		if n < int64(len(found)) {
+			found = found[:n]
+		}
+	}
+	return found
+}
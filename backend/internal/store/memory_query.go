@@ -0,0 +1,1031 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Las colecciones en memoria trabajan con los documentos tal y como los
+// guardaría MongoDB: se pasan a BSON y se leen como bson.M y bson.A, así
+// time.Time queda como primitive.DateTime y los structs respetan sus tags.
+
+// toValue pasa v por BSON y lo devuelve con documentos bson.M y arrays bson.A.
+func toValue(v interface{}) (interface{}, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: v}})
+	if err != nil {
+		return nil, err
+	}
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	dec.DefaultDocumentM()
+	var doc bson.M
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+func toDocument(v interface{}) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	value, err := toValue(v)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := value.(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("se esperaba un documento y se recibió %T", v)
+	}
+	return doc, nil
+}
+
+func toArray(v interface{}) (bson.A, error) {
+	value, err := toValue(v)
+	if err != nil {
+		return nil, err
+	}
+	array, ok := value.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("se esperaba un array y se recibió %T", v)
+	}
+	return array, nil
+}
+
+// toOrdered devuelve los campos de un documento en su orden, para los de
+// orden e índices.
+func toOrdered(v interface{}) (bson.D, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		out := make(bson.M, len(t))
+		for key, value := range t {
+			out[key] = copyValue(value)
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(t))
+		for i, value := range t {
+			out[i] = copyValue(value)
+		}
+		return out
+	}
+	return v
+}
+
+// resolve devuelve los valores del campo path dentro de v. Como en MongoDB,
+// un array en mitad del camino se recorre elemento a elemento, y un número
+// también puede indicar una posición del array.
+func resolve(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+		return resolve(child, path[1:])
+	case bson.A:
+		var out []interface{}
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(t) {
+			out = resolve(t[i], path[1:])
+		}
+		for _, elem := range t {
+			if doc, ok := elem.(bson.M); ok {
+				out = append(out, resolve(doc, path)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// candidates añade a los valores los elementos de los que son arrays: una
+// condición sobre un campo array se cumple si la cumple algún elemento.
+func candidates(values []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		out = append(out, value)
+		if array, ok := value.(bson.A); ok {
+			out = append(out, array...)
+		}
+	}
+	return out
+}
+
+func matches(doc bson.M, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("operador %s no soportado en memoria", key)
+			}
+			ok, err = matchField(resolve(doc, strings.Split(key, ".")), cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	clauses, ok := cond.(bson.A)
+	if !ok || len(clauses) == 0 {
+		return false, fmt.Errorf("%s necesita un array de filtros", op)
+	}
+	for _, clause := range clauses {
+		filter, ok := clause.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%s necesita un array de filtros", op)
+		}
+		ok, err := matches(doc, filter)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !ok:
+			return false, nil
+		case op == "$or" && ok:
+			return true, nil
+		case op == "$nor" && ok:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+// operators devuelve cond como documento de operadores ({"$gt": 1}) si lo es.
+func operators(cond interface{}) (bson.M, bool) {
+	doc, ok := cond.(bson.M)
+	if !ok || len(doc) == 0 {
+		return nil, false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func matchField(values []interface{}, cond interface{}) (bool, error) {
+	ops, ok := operators(cond)
+	if !ok {
+		return matchEqual(values, cond), nil
+	}
+	for op, arg := range ops {
+		ok, err := matchOperator(values, op, arg, ops)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchEqual(values []interface{}, want interface{}) bool {
+	if want == nil && len(values) == 0 {
+		return true
+	}
+	if re, ok := want.(primitive.Regex); ok {
+		return matchRegex(values, re.Pattern, re.Options)
+	}
+	for _, value := range candidates(values) {
+		if equal(value, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(values []interface{}, op string, arg interface{}, ops bson.M) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEqual(values, arg), nil
+	case "$ne":
+		return !matchEqual(values, arg), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, value := range candidates(values) {
+			cmp, ok := compare(value, arg)
+			if !ok {
+				continue
+			}
+			if op == "$gt" && cmp > 0 || op == "$gte" && cmp >= 0 || op == "$lt" && cmp < 0 || op == "$lte" && cmp <= 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s necesita un array", op)
+		}
+		found := slices.ContainsFunc(list, func(want interface{}) bool { return matchEqual(values, want) })
+		return found == (op == "$in"), nil
+	case "$all":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$all necesita un array")
+		}
+		if len(list) == 0 {
+			return false, nil
+		}
+		for _, want := range list {
+			if !matchEqual(values, want) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$exists":
+		return truthy(arg) == (len(values) > 0), nil
+	case "$size":
+		size, ok := toFloat(arg)
+		if !ok {
+			return false, fmt.Errorf("$size necesita un número")
+		}
+		for _, value := range values {
+			if array, ok := value.(bson.A); ok && float64(len(array)) == size {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$elemMatch":
+		cond, ok := arg.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("$elemMatch necesita un documento")
+		}
+		for _, value := range values {
+			array, _ := value.(bson.A)
+			for _, elem := range array {
+				ok, err := matchElem(elem, cond)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "$regex":
+		pattern, options := "", ""
+		switch t := arg.(type) {
+		case string:
+			pattern = t
+		case primitive.Regex:
+			pattern, options = t.Pattern, t.Options
+		default:
+			return false, fmt.Errorf("$regex necesita un texto")
+		}
+		if extra, ok := ops["$options"].(string); ok {
+			options += extra
+		}
+		return matchRegex(values, pattern, options), nil
+	case "$options":
+		return true, nil
+	case "$not":
+		ok, err := matchField(values, arg)
+		return !ok, err
+	}
+	return false, fmt.Errorf("operador %s no soportado en memoria", op)
+}
+
+func matchElem(elem interface{}, cond bson.M) (bool, error) {
+	if _, ok := operators(cond); ok {
+		return matchField([]interface{}{elem}, cond)
+	}
+	doc, ok := elem.(bson.M)
+	if !ok {
+		return false, nil
+	}
+	return matches(doc, cond)
+}
+
+func matchRegex(values []interface{}, pattern, options string) bool {
+	flags := ""
+	for _, option := range options {
+		if strings.ContainsRune("ims", option) {
+			flags += string(option)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	for _, value := range candidates(values) {
+		if s, ok := value.(string); ok && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	}
+	if n, ok := toFloat(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	switch t := a.(type) {
+	case bson.M:
+		other, ok := b.(bson.M)
+		if !ok || len(t) != len(other) {
+			return false
+		}
+		for key, value := range t {
+			if otherValue, ok := other[key]; !ok || !equal(value, otherValue) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		other, ok := b.(bson.A)
+		return ok && slices.EqualFunc(t, other, equal)
+	case primitive.Binary:
+		other, ok := b.(primitive.Binary)
+		return ok && t.Subtype == other.Subtype && bytes.Equal(t.Data, other.Data)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare compara dos valores del mismo tipo BSON; ok es false si no se
+// pueden comparar, y entonces $gt y compañía no los seleccionan.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch t := a.(type) {
+	case string:
+		other, ok := b.(string)
+		return strings.Compare(t, other), ok
+	case primitive.DateTime:
+		other, ok := b.(primitive.DateTime)
+		return cmpInt(int64(t), int64(other)), ok
+	case primitive.ObjectID:
+		other, ok := b.(primitive.ObjectID)
+		return bytes.Compare(t[:], other[:]), ok
+	case bool:
+		other, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case t == other:
+			return 0, true
+		case !t:
+			return -1, true
+		}
+		return 1, true
+	case nil:
+		return 0, b == nil
+	}
+	return 0, false
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// typeOrder es el orden en que MongoDB ordena valores de distinto tipo.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case bson.A:
+		return 4
+	case primitive.Binary:
+		return 5
+	case primitive.ObjectID:
+		return 6
+	case bool:
+		return 7
+	case primitive.DateTime:
+		return 8
+	}
+	return 9
+}
+
+func sortCompare(a, b interface{}) int {
+	if cmp, ok := compare(a, b); ok {
+		return cmp
+	}
+	return typeOrder(a) - typeOrder(b)
+}
+
+func compareByKeys(a, b bson.M, keys bson.D) int {
+	for _, key := range keys {
+		path := strings.Split(key.Key, ".")
+		cmp := sortCompare(first(resolve(a, path)), first(resolve(b, path)))
+		if direction, _ := toFloat(key.Value); direction < 0 {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func first(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// project aplica una proyección de campos de primer nivel ({"x": 1} o
+// {"x": 0}). _id se incluye salvo que se excluya expresamente.
+func project(doc bson.M, projection interface{}) (bson.M, error) {
+	if projection == nil {
+		return doc, nil
+	}
+	fields, err := toDocument(projection)
+	if err != nil {
+		return nil, err
+	}
+	include, exclude := false, false
+	for field, value := range fields {
+		if strings.Contains(field, ".") || strings.HasPrefix(field, "$") {
+			return nil, fmt.Errorf("proyección de %s no soportada en memoria", field)
+		}
+		if _, ok := value.(bson.M); ok {
+			return nil, fmt.Errorf("proyección de %s no soportada en memoria", field)
+		}
+		if field != "_id" {
+			include = include || truthy(value)
+			exclude = exclude || !truthy(value)
+		}
+	}
+	if include && exclude {
+		return nil, fmt.Errorf("la proyección no puede mezclar inclusiones y exclusiones")
+	}
+
+	out := bson.M{}
+	for field, value := range doc {
+		keep, ok := fields[field]
+		if ok && truthy(keep) || !ok && (!include || field == "_id") {
+			out[field] = value
+		}
+	}
+	return out, nil
+}
+
+// equalityValue devuelve el valor de una condición de igualdad del filtro,
+// que es lo que MongoDB copia en el documento al hacer un upsert.
+func equalityValue(cond interface{}) (interface{}, bool) {
+	if cond == nil {
+		return nil, false
+	}
+	if ops, ok := operators(cond); ok {
+		value, ok := ops["$eq"]
+		return value, ok && len(ops) == 1
+	}
+	if _, ok := cond.(primitive.Regex); ok {
+		return nil, false
+	}
+	return cond, true
+}
+
+func seedUpsert(doc bson.M, filter bson.M) error {
+	for key, cond := range filter {
+		if key == "$and" {
+			clauses, _ := cond.(bson.A)
+			for _, clause := range clauses {
+				if sub, ok := clause.(bson.M); ok {
+					if err := seedUpsert(doc, sub); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+		if value, ok := equalityValue(cond); ok {
+			if err := setPath(doc, key, copyValue(value), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkReplacement(doc bson.M) error {
+	for key := range doc {
+		if strings.HasPrefix(key, "$") {
+			return fmt.Errorf("el documento de sustitución no puede tener operadores (%s)", key)
+		}
+	}
+	return nil
+}
+
+// applyUpdate aplica los operadores de update a doc. inserting indica que
+// el documento se está creando por un upsert, para $setOnInsert.
+func applyUpdate(doc bson.M, update interface{}, arrayFilters *options.ArrayFilters, inserting bool) error {
+	value, err := toValue(update)
+	if err != nil {
+		return err
+	}
+	ops, ok := value.(bson.M)
+	if !ok {
+		return fmt.Errorf("las actualizaciones con pipeline no están soportadas en memoria")
+	}
+	if _, ok := operators(ops); !ok {
+		return fmt.Errorf("la actualización necesita operadores como $set")
+	}
+
+	filters := map[string]bson.M{}
+	if arrayFilters != nil {
+		for _, raw := range arrayFilters.Filters {
+			filter, err := toDocument(raw)
+			if err != nil {
+				return err
+			}
+			for key := range filter {
+				name, _, _ := strings.Cut(key, ".")
+				filters[name] = filter
+			}
+		}
+	}
+
+	for op, arg := range ops {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s necesita un documento", op)
+		}
+		for path, value := range fields {
+			if err := applyOperator(doc, op, path, value, filters, inserting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyOperator(doc bson.M, op, path string, value interface{}, filters map[string]bson.M, inserting bool) error {
+	switch op {
+	case "$set":
+		return setPath(doc, path, copyValue(value), filters)
+	case "$setOnInsert":
+		if !inserting {
+			return nil
+		}
+		return setPath(doc, path, copyValue(value), filters)
+	case "$unset":
+		return modifyPath(doc, path, filters, func(interface{}, bool) (interface{}, bool, error) {
+			return nil, true, nil
+		})
+	case "$inc":
+		return modifyPath(doc, path, filters, func(old interface{}, exists bool) (interface{}, bool, error) {
+			if !exists || old == nil {
+				return value, false, nil
+			}
+			sum, err := add(old, value)
+			return sum, false, err
+		})
+	case "$max", "$min":
+		return modifyPath(doc, path, filters, func(old interface{}, exists bool) (interface{}, bool, error) {
+			if !exists {
+				return value, false, nil
+			}
+			cmp := sortCompare(value, old)
+			if op == "$max" && cmp > 0 || op == "$min" && cmp < 0 {
+				return value, false, nil
+			}
+			return old, false, nil
+		})
+	case "$push", "$addToSet":
+		items, slice := bson.A{value}, interface{}(nil)
+		if spec, ok := value.(bson.M); ok {
+			if each, ok := spec["$each"].(bson.A); ok {
+				items, slice = each, spec["$slice"]
+			}
+		}
+		return modifyPath(doc, path, filters, func(old interface{}, exists bool) (interface{}, bool, error) {
+			var array bson.A
+			if exists && old != nil {
+				var ok bool
+				if array, ok = old.(bson.A); !ok {
+					return nil, false, fmt.Errorf("%s necesita que %s sea un array", op, path)
+				}
+			}
+			for _, item := range items {
+				if op == "$addToSet" && slices.ContainsFunc(array, func(v interface{}) bool { return equal(v, item) }) {
+					continue
+				}
+				array = append(array, copyValue(item))
+			}
+			if n, ok := toFloat(slice); ok {
+				switch {
+				case n >= 0 && int(n) < len(array):
+					array = array[:int(n)]
+				case n < 0 && int(-n) < len(array):
+					array = array[len(array)+int(n):]
+				}
+			}
+			return array, false, nil
+		})
+	case "$pull":
+		return modifyPath(doc, path, filters, func(old interface{}, exists bool) (interface{}, bool, error) {
+			array, ok := old.(bson.A)
+			if !exists || !ok {
+				return old, !exists, nil
+			}
+			kept := bson.A{}
+			for _, elem := range array {
+				var pull bool
+				var err error
+				if cond, ok := value.(bson.M); ok {
+					pull, err = matchElem(elem, cond)
+				} else {
+					pull = equal(elem, value)
+				}
+				if err != nil {
+					return nil, false, err
+				}
+				if !pull {
+					kept = append(kept, elem)
+				}
+			}
+			return kept, false, nil
+		})
+	}
+	return fmt.Errorf("operador %s no soportado en memoria", op)
+}
+
+func add(a, b interface{}) (interface{}, error) {
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	if !okA || !okB {
+		return nil, fmt.Errorf("$inc solo se aplica a números")
+	}
+	_, floatA := a.(float64)
+	_, floatB := b.(float64)
+	if floatA || floatB {
+		return x + y, nil
+	}
+	sum := int64(x) + int64(y)
+	_, longA := a.(int64)
+	_, longB := b.(int64)
+	if !longA && !longB && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+		return int32(sum), nil
+	}
+	return sum, nil
+}
+
+func setPath(doc bson.M, path string, value interface{}, filters map[string]bson.M) error {
+	return modifyPath(doc, path, filters, func(interface{}, bool) (interface{}, bool, error) {
+		return value, false, nil
+	})
+}
+
+// modifier recibe el valor actual de un campo (y si existe) y devuelve el
+// nuevo, o remove a true para quitarlo.
+type modifier func(old interface{}, exists bool) (value interface{}, remove bool, err error)
+
+// modifyPath aplica fn al campo path de doc, creando los documentos
+// intermedios que falten. Entiende los posicionales $[] y $[nombre].
+func modifyPath(doc bson.M, path string, filters map[string]bson.M, fn modifier) error {
+	_, _, err := modifyIn(doc, true, strings.Split(path, "."), filters, fn)
+	return err
+}
+
+func modifyIn(node interface{}, exists bool, parts []string, filters map[string]bson.M, fn modifier) (interface{}, bool, error) {
+	if len(parts) == 0 {
+		return fn(node, exists)
+	}
+	key := parts[0]
+
+	switch t := node.(type) {
+	case bson.M:
+		child, ok := t[key]
+		value, remove, err := modifyIn(child, ok, parts[1:], filters, fn)
+		if err != nil {
+			return nil, false, err
+		}
+		if remove {
+			delete(t, key)
+		} else {
+			t[key] = value
+		}
+		return t, false, nil
+	case bson.A:
+		if strings.HasPrefix(key, "$[") && strings.HasSuffix(key, "]") {
+			name := key[2 : len(key)-1]
+			filter, ok := filters[name]
+			if name != "" && !ok {
+				return nil, false, fmt.Errorf("no hay arrayFilters para %s", key)
+			}
+			for i, elem := range t {
+				if name != "" {
+					if ok, err := matches(bson.M{name: elem}, filter); err != nil || !ok {
+						if err != nil {
+							return nil, false, err
+						}
+						continue
+					}
+				}
+				value, remove, err := modifyIn(elem, true, parts[1:], filters, fn)
+				if err != nil {
+					return nil, false, err
+				}
+				if remove {
+					value = nil
+				}
+				t[i] = value
+			}
+			return t, false, nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 {
+			return nil, false, fmt.Errorf("no se puede usar el campo %q dentro de un array", key)
+		}
+		if i >= len(t) {
+			value, remove, err := modifyIn(nil, false, parts[1:], filters, fn)
+			if err != nil || remove {
+				return t, false, err
+			}
+			for len(t) < i {
+				t = append(t, nil)
+			}
+			return append(t, value), false, nil
+		}
+		value, remove, err := modifyIn(t[i], true, parts[1:], filters, fn)
+		if err != nil {
+			return nil, false, err
+		}
+		if remove {
+			value = nil
+		}
+		t[i] = value
+		return t, false, nil
+	case nil:
+		if strings.HasPrefix(key, "$") {
+			return nil, false, fmt.Errorf("el posicional %s necesita un array", key)
+		}
+		created := bson.M{}
+		if _, _, err := modifyIn(created, true, parts, filters, fn); err != nil {
+			return nil, false, err
+		}
+		if !exists && len(created) == 0 {
+			return nil, true, nil
+		}
+		return created, false, nil
+	}
+	return nil, false, fmt.Errorf("no se puede crear el campo %q dentro de un valor %T", key, node)
+}
+
+// aggregate ejecuta las etapas de pipeline que usa el backend: $match,
+// $group, $sort, $skip, $limit y $count.
+func aggregate(docs []bson.M, pipeline bson.A) ([]bson.M, error) {
+	for _, raw := range pipeline {
+		stage, ok := raw.(bson.M)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("cada etapa del pipeline debe tener un solo operador")
+		}
+		for name, arg := range stage {
+			var err error
+			switch name {
+			case "$match":
+				filter, _ := arg.(bson.M)
+				kept := docs[:0:0]
+				for _, doc := range docs {
+					ok, err := matches(doc, filter)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						kept = append(kept, doc)
+					}
+				}
+				docs = kept
+			case "$group":
+				spec, _ := arg.(bson.M)
+				docs, err = group(docs, spec)
+			case "$sort":
+				var keys bson.D
+				if keys, err = toOrdered(arg); err == nil {
+					docs = slices.Clone(docs)
+					slices.SortStableFunc(docs, func(a, b bson.M) int { return compareByKeys(a, b, keys) })
+				}
+			case "$skip", "$limit":
+				n, ok := toFloat(arg)
+				if !ok || n < 0 {
+					return nil, fmt.Errorf("%s necesita un número positivo", name)
+				}
+				if name == "$skip" {
+					docs = docs[min(int(n), len(docs)):]
+				} else {
+					docs = docs[:min(int(n), len(docs))]
+				}
+			case "$count":
+				field, _ := arg.(string)
+				docs = []bson.M{{field: int32(len(docs))}}
+			default:
+				return nil, fmt.Errorf("etapa %s no soportada en memoria", name)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+func group(docs []bson.M, spec bson.M) ([]bson.M, error) {
+	idExpr, ok := spec["_id"]
+	if !ok {
+		return nil, fmt.Errorf("$group necesita _id")
+	}
+
+	var groups []bson.M
+	var members [][]bson.M
+	for _, doc := range docs {
+		id, err := evaluate(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		position := slices.IndexFunc(groups, func(g bson.M) bool { return equal(g["_id"], id) })
+		if position < 0 {
+			position = len(groups)
+			groups = append(groups, bson.M{"_id": id})
+			members = append(members, nil)
+		}
+		members[position] = append(members[position], doc)
+	}
+
+	for i, out := range groups {
+		for field, raw := range spec {
+			if field == "_id" {
+				continue
+			}
+			acc, ok := operators(raw)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("el campo %s de $group necesita un acumulador", field)
+			}
+			for op, expr := range acc {
+				value, err := accumulate(members[i], op, expr)
+				if err != nil {
+					return nil, err
+				}
+				out[field] = value
+			}
+		}
+	}
+	return groups, nil
+}
+
+func accumulate(docs []bson.M, op string, expr interface{}) (interface{}, error) {
+	var result interface{}
+	for i, doc := range docs {
+		value, err := evaluate(doc, expr)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "$sum":
+			if _, ok := toFloat(value); !ok {
+				value = int32(0)
+			}
+			if result == nil {
+				result = int32(0)
+			}
+			if result, err = add(result, value); err != nil {
+				return nil, err
+			}
+		case "$first":
+			if i == 0 {
+				result = value
+			}
+		case "$last":
+			result = value
+		case "$max", "$min":
+			if value == nil {
+				continue
+			}
+			cmp := sortCompare(value, result)
+			if result == nil || op == "$max" && cmp > 0 || op == "$min" && cmp < 0 {
+				result = value
+			}
+		case "$push":
+			list, _ := result.(bson.A)
+			result = append(list, value)
+		default:
+			return nil, fmt.Errorf("acumulador %s no soportado en memoria", op)
+		}
+	}
+	if result == nil {
+		switch op {
+		case "$sum":
+			result = int32(0)
+		case "$push":
+			result = bson.A{}
+		}
+	}
+	return result, nil
+}
+
+// evaluate resuelve una expresión de agregación sencilla: "$campo", un
+// documento de expresiones o una constante.
+func evaluate(doc bson.M, expr interface{}) (interface{}, error) {
+	switch t := expr.(type) {
+	case string:
+		if strings.HasPrefix(t, "$") {
+			return first(resolve(doc, strings.Split(t[1:], "."))), nil
+		}
+	case bson.M:
+		if _, ok := operators(t); ok {
+			return nil, fmt.Errorf("expresiones con operadores no soportadas en memoria")
+		}
+		out := bson.M{}
+		for key, value := range t {
+			v, err := evaluate(doc, value)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = v
+		}
+		return out, nil
+	}
+	return expr, nil
+}
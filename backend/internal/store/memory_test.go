@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type testDoc struct {
+	Name  string    `bson:"name"`
+	Age   int       `bson:"age"`
+	Tags  []string  `bson:"tags,omitempty"`
+	Seen  time.Time `bson:"seen"`
+	Hits  []int     `bson:"hits,omitempty"`
+	Owner *struct {
+		Email string `bson:"email"`
+	} `bson:"owner,omitempty"`
+}
+
+func seed(t *testing.T, c Collection, docs ...interface{}) {
+	t.Helper()
+	for _, doc := range docs {
+		if _, err := c.InsertOne(context.Background(), doc); err != nil {
+			t.Fatalf("InsertOne: %v", err)
+		}
+	}
+}
+
+func names(t *testing.T, c Collection, filter interface{}, opts ...*options.FindOptions) []string {
+	t.Helper()
+	cursor, err := c.Find(context.Background(), filter, opts...)
+	if err != nil {
+		t.Fatalf("Find %v: %v", filter, err)
+	}
+	var docs []testDoc
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	out := []string{}
+	for _, doc := range docs {
+		out = append(out, doc.Name)
+	}
+	return out
+}
+
+func TestMemoryFind(t *testing.T) {
+	c := NewMemory().Users
+	now := time.Now()
+	seed(t, c,
+		testDoc{Name: "ana", Age: 30, Tags: []string{"beta", "staff"}, Seen: now},
+		testDoc{Name: "bruno", Age: 25, Tags: []string{"beta"}, Seen: now.Add(-time.Hour)},
+		bson.M{"name": "carla", "age": 41, "owner": bson.M{"email": "c@example.com"}},
+	)
+
+	byName := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cases := []struct {
+		filter interface{}
+		want   []string
+	}{
+		{bson.M{}, []string{"ana", "bruno", "carla"}},
+		{bson.M{"age": bson.M{"$gt": 26}}, []string{"ana", "carla"}},
+		{bson.M{"age": bson.M{"$gte": 25, "$lt": 30}}, []string{"bruno"}},
+		{bson.M{"tags": "staff"}, []string{"ana"}},
+		{bson.M{"tags": bson.M{"$all": []string{"beta", "staff"}}}, []string{"ana"}},
+		{bson.M{"tags": bson.M{"$in": []string{"staff", "otro"}}}, []string{"ana"}},
+		{bson.M{"tags": bson.M{"$exists": false}}, []string{"carla"}},
+		{bson.M{"owner.email": "c@example.com"}, []string{"carla"}},
+		{bson.M{"seen": bson.M{"$lt": now.Add(-time.Minute)}}, []string{"bruno"}},
+		{bson.M{"name": bson.M{"$regex": "^b"}}, []string{"bruno"}},
+		{bson.M{"$or": []bson.M{{"name": "ana"}, {"age": 41}}}, []string{"ana", "carla"}},
+		{bson.M{"name": bson.M{"$nin": []interface{}{"ana", nil}}}, []string{"bruno", "carla"}},
+	}
+	for _, tc := range cases {
+		if got := names(t, c, tc.filter, byName); !slices.Equal(got, tc.want) {
+			t.Errorf("Find %v = %v, se esperaba %v", tc.filter, got, tc.want)
+		}
+	}
+
+	paged := options.Find().SetSort(bson.D{{Key: "age", Value: -1}}).SetSkip(1).SetLimit(1)
+	if got := names(t, c, bson.M{}, paged); !slices.Equal(got, []string{"ana"}) {
+		t.Errorf("Find paginado = %v, se esperaba [ana]", got)
+	}
+
+	count, err := c.CountDocuments(context.Background(), bson.M{"tags": "beta"})
+	if err != nil || count != 2 {
+		t.Errorf("CountDocuments = %d, %v; se esperaba 2", count, err)
+	}
+
+	err = c.FindOne(context.Background(), bson.M{"name": "nadie"}).Err()
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("FindOne sin resultados: %v, se esperaba mongo.ErrNoDocuments", err)
+	}
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Users
+	seed(t, c, testDoc{Name: "ana", Age: 30, Hits: []int{1, 2}})
+
+	var doc testDoc
+	err := c.FindOneAndUpdate(ctx, bson.M{"name": "ana"},
+		bson.M{
+			"$inc":  bson.M{"age": 1},
+			"$push": bson.M{"hits": bson.M{"$each": []int{3, 4}, "$slice": -3}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&doc)
+	if err != nil {
+		t.Fatalf("FindOneAndUpdate: %v", err)
+	}
+	if doc.Age != 31 || !slices.Equal(doc.Hits, []int{2, 3, 4}) {
+		t.Errorf("tras actualizar: edad %d, hits %v; se esperaba 31 y [2 3 4]", doc.Age, doc.Hits)
+	}
+
+	result, err := c.UpdateOne(ctx, bson.M{"name": "bruno"},
+		bson.M{"$set": bson.M{"age": 20}, "$setOnInsert": bson.M{"tags": []string{"nuevo"}}},
+		options.Update().SetUpsert(true))
+	if err != nil || result.UpsertedCount != 1 {
+		t.Fatalf("upsert: %+v, %v", result, err)
+	}
+	if err := c.FindOne(ctx, bson.M{"name": "bruno"}).Decode(&doc); err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if doc.Age != 20 || !slices.Equal(doc.Tags, []string{"nuevo"}) {
+		t.Errorf("documento del upsert: %+v", doc)
+	}
+
+	result, err = c.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"tags": ""}})
+	if err != nil || result.MatchedCount != 2 || result.ModifiedCount != 1 {
+		t.Errorf("UpdateMany: %+v, %v; se esperaban 2 encontrados y 1 modificado", result, err)
+	}
+
+	_, err = c.UpdateOne(ctx, bson.M{}, mongo.Pipeline{{{Key: "$set", Value: bson.M{"age": 1}}}})
+	if err == nil {
+		t.Error("las actualizaciones con pipeline deberían devolver error")
+	}
+}
+
+func TestMemoryArrayFilters(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().EmailEvents
+	seed(t, c, bson.M{"to": []string{"ana@example.com", "otro@example.com"}})
+
+	_, err := c.UpdateMany(ctx, bson.M{"to": "ana@example.com"},
+		bson.M{"$set": bson.M{"to.$[recipient]": "anonimo"}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"recipient": "ana@example.com"}},
+		}))
+	if err != nil {
+		t.Fatalf("UpdateMany: %v", err)
+	}
+
+	var doc struct {
+		To []string `bson:"to"`
+	}
+	if err := c.FindOne(ctx, bson.M{}).Decode(&doc); err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if !slices.Equal(doc.To, []string{"anonimo", "otro@example.com"}) {
+		t.Errorf("to = %v", doc.To)
+	}
+}
+
+func TestMemoryUniqueIndex(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Users
+	_, err := c.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"token": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateMany: %v", err)
+	}
+
+	seed(t, c, bson.M{"name": "ana"}, bson.M{"name": "bruno"})
+	if _, err := c.InsertOne(ctx, bson.M{"name": "ana"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("InsertOne repetido: %v, se esperaba un error de clave duplicada", err)
+	}
+	if _, err := c.UpdateOne(ctx, bson.M{"name": "bruno"}, bson.M{"$set": bson.M{"name": "ana"}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("UpdateOne repetido: %v, se esperaba un error de clave duplicada", err)
+	}
+	if count, _ := c.CountDocuments(ctx, bson.M{"name": "bruno"}); count != 1 {
+		t.Error("la actualización rechazada modificó el documento")
+	}
+
+	if _, err := c.Indexes().DropOne(ctx, "name_1"); err != nil {
+		t.Fatalf("DropOne: %v", err)
+	}
+	seed(t, c, bson.M{"name": "ana"})
+
+	var commandErr mongo.CommandError
+	if _, err := c.Indexes().DropOne(ctx, "name_1"); !errors.As(err, &commandErr) || commandErr.Name != "IndexNotFound" {
+		t.Errorf("DropOne de un índice que no existe: %v", err)
+	}
+}
+
+func TestMemoryTTLIndex(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Sessions
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		t.Fatalf("CreateOne: %v", err)
+	}
+
+	seed(t, c,
+		bson.M{"name": "caducada", "expires_at": time.Now().Add(-time.Minute)},
+		bson.M{"name": "vigente", "expires_at": time.Now().Add(time.Hour)},
+	)
+	if got := names(t, c, bson.M{}); !slices.Equal(got, []string{"vigente"}) {
+		t.Errorf("tras caducar: %v, se esperaba [vigente]", got)
+	}
+}
+
+func TestMemoryAggregate(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().MailLog
+	now := time.Now()
+	seed(t, c,
+		bson.M{"status": "sent", "created_at": now},
+		bson.M{"status": "sent", "created_at": now},
+		bson.M{"status": "dead", "created_at": now},
+		bson.M{"status": "sent", "created_at": now.AddDate(0, 0, -60)},
+	)
+
+	cursor, err := c.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": now.AddDate(0, 0, -30)}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	var groups []struct {
+		Status string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Status != "dead" || groups[0].Count != 1 || groups[1].Status != "sent" || groups[1].Count != 2 {
+		t.Errorf("grupos = %+v, se esperaba dead:1 y sent:2", groups)
+	}
+}
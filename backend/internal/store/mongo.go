@@ -0,0 +1,99 @@
+// Package store expone las colecciones que usa el backend. Se guardan en
+// MongoDB, en memoria para demos y pruebas (ver NewMemory) o en un archivo
+// local para desarrollar (ver OpenBolt).
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/config"
+)
+
+type DB struct {
+	client            *mongo.Client
+	bolt              *bbolt.DB
+	Users             Collection
+	Sessions          Collection
+	OTPs              Collection
+	ActionTokens      Collection
+	APIKeys           Collection
+	WebAuthnSessions  Collection
+	SigningKeys       Collection
+	EmailEvents       Collection
+	MailLog           Collection
+	Uploads           Collection
+	UploadChunks      Collection
+	Erasures          Collection
+	Audit             Collection
+	ProfileVersions   Collection
+	Groups            Collection
+	RateLimits        Collection
+	WebhookEndpoints  Collection
+	WebhookDeliveries Collection
+	EventCounts       Collection
+	Outbox            Collection
+}
+
+// newDB asigna a cada campo de DB la colección que devuelve open.
+func newDB(open func(name string) Collection) *DB {
+	return &DB{
+		Users:             open("users"),
+		Sessions:          open("sessions"),
+		OTPs:              open("login_otps"),
+		ActionTokens:      open("action_tokens"),
+		APIKeys:           open("api_keys"),
+		WebAuthnSessions:  open("webauthn_sessions"),
+		SigningKeys:       open("signing_keys"),
+		EmailEvents:       open("email_events"),
+		MailLog:           open("mail_log"),
+		Uploads:           open("tus_uploads"),
+		UploadChunks:      open("tus_upload_chunks"),
+		Erasures:          open("erasures"),
+		Audit:             open("audit"),
+		ProfileVersions:   open("profile_versions"),
+		Groups:            open("groups"),
+		RateLimits:        open("rate_limits"),
+		WebhookEndpoints:  open("webhook_endpoints"),
+		WebhookDeliveries: open("webhook_deliveries"),
+		EventCounts:       open("event_counts"),
+		Outbox:            open("outbox"),
+	}
+}
+
+// Connect conecta con MongoDB y comprueba la conexión antes de devolverla.
+func Connect(ctx context.Context, cfg config.Mongo) (*DB, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI))
+	if err != nil {
+		return nil, err
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := client.Ping(pingCtx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	database := client.Database(cfg.Database)
+	db := newDB(func(name string) Collection {
+		return mongoCollection{database.Collection(name)}
+	})
+	db.client = client
+	return db, nil
+}
+
+func (db *DB) Close(ctx context.Context) error {
+	switch {
+	case db.client != nil:
+		return db.client.Disconnect(ctx)
+	case db.bolt != nil:
+		return db.bolt.Close()
+	}
+	return nil
+}
@@ -0,0 +1,587 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewPostgres guarda las colecciones en la tabla documents de PostgreSQL
+// (DB_DRIVER=postgres), que crean las migraciones 0003 y 0004 del backend.
+// Los filtros se traducen a SQL sobre la columna JSONB (ver postgresQuery):
+// las lecturas no bloquean nada, y las escrituras solo bloquean con FOR
+// UPDATE los documentos que pueden cumplir el filtro. Lo que no se puede
+// traducir se resuelve en memoria, como NewMemory, sobre los documentos que
+// ha seleccionado PostgreSQL. Los índices de MongoDB se crean como índices
+// de expresión de PostgreSQL, así que los únicos se cumplen entre
+// instancias.
+func NewPostgres(pool *pgxpool.Pool) *DB {
+	return newDB(func(name string) Collection {
+		return &postgresCollection{pool: pool, name: name, indexes: newMemoryCollection().indexes}
+	})
+}
+
+type postgresCollection struct {
+	pool *pgxpool.Pool
+	name string
+
+	// indexes son los índices creados con Indexes(), para traducir los
+	// errores de clave duplicada y borrar los documentos caducados.
+	// createIndexes los vuelve a crear al arrancar cada instancia.
+	mu      sync.Mutex
+	indexes []memoryIndex
+	expired time.Time
+}
+
+// postgresDB es lo que comparten *pgxpool.Pool y pgx.Tx.
+type postgresDB interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// query traduce filter a SQL, limitado a la colección.
+func (c *postgresCollection) query(filter interface{}) (postgresQuery, error) {
+	doc, err := toDocument(filter)
+	if err != nil {
+		return postgresQuery{}, err
+	}
+	b := &sqlBuilder{}
+	where, exact := b.filter(doc)
+	return postgresQuery{
+		where: "collection = " + quoteLiteral(c.name) + " AND " + where,
+		args:  b.args,
+		exact: exact,
+	}, nil
+}
+
+// load lee los documentos de q en el orden indicado. Con lock los bloquea
+// hasta el final de la transacción de db. Devuelve también los id de la
+// tabla, para que postgresPersister sepa qué documentos ya existen.
+func (c *postgresCollection) load(ctx context.Context, db postgresDB, q postgresQuery, order string, skip, limit *int64, lock bool) ([]bson.M, map[string]bool, error) {
+	sql := "SELECT id, document FROM documents WHERE " + q.where + " ORDER BY " + order
+	if limit != nil && *limit != 0 {
+		n := *limit
+		if n < 0 {
+			n = -n
+		}
+		sql += " LIMIT " + strconv.FormatInt(n, 10)
+	}
+	if skip != nil && *skip > 0 {
+		sql += " OFFSET " + strconv.FormatInt(*skip, 10)
+	}
+	if lock {
+		sql += " FOR UPDATE"
+	}
+
+	rows, err := db.Query(ctx, sql, q.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var docs []bson.M
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, nil, err
+		}
+		doc, err := decodePostgresDoc(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, doc)
+		ids[id] = true
+	}
+	return docs, ids, rows.Err()
+}
+
+func decodePostgresDoc(raw []byte) (bson.M, error) {
+	vr, err := bsonrw.NewExtJSONValueReader(bytes.NewReader(raw), true)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := bson.NewDecoder(vr)
+	if err != nil {
+		return nil, err
+	}
+	dec.DefaultDocumentM()
+	var doc bson.M
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// memory devuelve una colección en memoria con los documentos leídos, que
+// escribe los cambios con db. No tiene índices: los únicos los comprueba
+// PostgreSQL al escribir.
+func (c *postgresCollection) memory(ctx context.Context, db postgresDB, docs []bson.M, ids map[string]bool) *memoryCollection {
+	if ids == nil {
+		ids = map[string]bool{}
+	}
+	return &memoryCollection{
+		docs:    docs,
+		persist: &postgresPersister{ctx: ctx, db: db, c: c, ids: ids},
+	}
+}
+
+// read lee los documentos que pueden cumplir filter, sin bloquearlos. Si
+// la consulta es exacta ya vienen ordenados y paginados, y basta con
+// filtrar con bson.M{} en memoria; si no, vienen todos y hay que volver a
+// aplicar filter, sort, skip y limit.
+func (c *postgresCollection) read(ctx context.Context, filter, sort interface{}, skip, limit *int64) (*memoryCollection, bool, error) {
+	if err := c.expire(ctx); err != nil {
+		return nil, false, err
+	}
+	q, err := c.query(filter)
+	if err != nil {
+		return nil, false, err
+	}
+	order := "seq"
+	if q.exact {
+		if order, err = orderSQL(sort); err != nil {
+			return nil, false, err
+		}
+	} else {
+		skip, limit = nil, nil
+	}
+	docs, ids, err := c.load(ctx, c.pool, q, order, skip, limit, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.memory(ctx, c.pool, docs, ids), q.exact, nil
+}
+
+// write ejecuta fn en una transacción sobre los documentos que pueden
+// cumplir filter, bloqueados con FOR UPDATE. Si la operación solo cambia un
+// documento (one) y la consulta es exacta, solo se lee el primero según
+// sort. Si fn devuelve error no se guarda ningún cambio.
+func (c *postgresCollection) write(ctx context.Context, filter, sort interface{}, one bool, fn func(m *memoryCollection) error) error {
+	if err := c.expire(ctx); err != nil {
+		return err
+	}
+	q, err := c.query(filter)
+	if err != nil {
+		return err
+	}
+	order := "seq"
+	var limit *int64
+	if q.exact && one {
+		if order, err = orderSQL(sort); err != nil {
+			return err
+		}
+		limit = new(int64)
+		*limit = 1
+	}
+	return pgx.BeginFunc(ctx, c.pool, func(tx pgx.Tx) error {
+		docs, ids, err := c.load(ctx, tx, q, order, nil, limit, true)
+		if err != nil {
+			return err
+		}
+		return fn(c.memory(ctx, tx, docs, ids))
+	})
+}
+
+// expire borra los documentos caducados por los índices TTL. Como el
+// monitor de MongoDB, pasa como mucho una vez por minuto, así que un
+// documento puede seguir apareciendo hasta un minuto después de caducar.
+func (c *postgresCollection) expire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if time.Since(c.expired) < time.Minute {
+		c.mu.Unlock()
+		return nil
+	}
+	c.expired = time.Now()
+	var ttls []memoryIndex
+	for _, index := range c.indexes {
+		if index.ttl != nil {
+			ttls = append(ttls, index)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, index := range ttls {
+		limit := time.Now().Add(-time.Duration(*index.ttl) * time.Second).UnixMilli()
+		_, err := c.pool.Exec(ctx, "DELETE FROM documents WHERE collection = "+quoteLiteral(c.name)+
+			" AND "+ttlSQL(index)+" <= $1::text::numeric", strconv.FormatInt(limit, 10))
+		if err != nil {
+			c.mu.Lock()
+			c.expired = time.Time{}
+			c.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// ttlSQL es la fecha de caducidad de un documento, la más antigua del
+// campo del índice TTL.
+func ttlSQL(index memoryIndex) string {
+	return "document_min(document, " + pathSQL(strings.Split(index.keys[0].Key, ".")) + ", 8)"
+}
+
+// postgresPersister guarda cada documento en Extended JSON canónico, el
+// mismo formato que la columna document de users. ids son los documentos
+// que ya están en la tabla: se actualizan, y los demás se insertan.
+type postgresPersister struct {
+	ctx context.Context
+	db  postgresDB
+	c   *postgresCollection
+	ids map[string]bool
+}
+
+func (p *postgresPersister) save(put, remove []bson.M) error {
+	for _, doc := range remove {
+		id, err := bson.MarshalExtJSON(bson.M{"_id": doc["_id"]}, true, false)
+		if err != nil {
+			return err
+		}
+		if _, err := p.db.Exec(p.ctx, "DELETE FROM documents WHERE collection = $1 AND id = $2", p.c.name, string(id)); err != nil {
+			return err
+		}
+		delete(p.ids, string(id))
+	}
+	for _, doc := range put {
+		id, err := bson.MarshalExtJSON(bson.M{"_id": doc["_id"]}, true, false)
+		if err != nil {
+			return err
+		}
+		document, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return err
+		}
+		if p.ids[string(id)] {
+			_, err = p.db.Exec(p.ctx, "UPDATE documents SET document = $3 WHERE collection = $1 AND id = $2",
+				p.c.name, string(id), string(document))
+		} else {
+			_, err = p.db.Exec(p.ctx, "INSERT INTO documents (collection, id, document) VALUES ($1, $2, $3)",
+				p.c.name, string(id), string(document))
+		}
+		if err != nil {
+			return p.c.writeError(err, doc)
+		}
+		p.ids[string(id)] = true
+	}
+	return nil
+}
+
+// writeError convierte las violaciones de los índices únicos en el error
+// de clave duplicada de MongoDB.
+func (c *postgresCollection) writeError(err error, doc bson.M) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, index := range c.indexes {
+		if pgErr.ConstraintName == "documents_pkey" && index.name == "_id_" ||
+			pgErr.ConstraintName == postgresIndexName(c.name, index.name) {
+			return duplicateKeyError(index.name, index.key(doc))
+		}
+	}
+	return duplicateKeyError(pgErr.ConstraintName, nil)
+}
+
+// singleErr es el error con el que se decide si guardar los cambios de una
+// operación que devuelve *mongo.SingleResult: no encontrar nada no lo es.
+func singleErr(result *mongo.SingleResult) error {
+	if err := result.Err(); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+func (c *postgresCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	if err := c.expire(ctx); err != nil {
+		return nil, err
+	}
+	return c.memory(ctx, c.pool, nil, nil).InsertOne(ctx, document, opts...)
+}
+
+func (c *postgresCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	opt := options.MergeFindOneOptions(opts...)
+	limit := int64(1)
+	m, exact, err := c.read(ctx, filter, opt.Sort, opt.Skip, &limit)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	if exact {
+		return m.FindOne(ctx, bson.M{}, &options.FindOneOptions{Projection: opt.Projection})
+	}
+	return m.FindOne(ctx, filter, opts...)
+}
+
+func (c *postgresCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	opt := options.MergeFindOptions(opts...)
+	m, exact, err := c.read(ctx, filter, opt.Sort, opt.Skip, opt.Limit)
+	if err != nil {
+		return nil, err
+	}
+	if exact {
+		return m.Find(ctx, bson.M{}, &options.FindOptions{Projection: opt.Projection})
+	}
+	return m.Find(ctx, filter, opts...)
+}
+
+func (c *postgresCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) (result *mongo.SingleResult) {
+	opt := options.MergeFindOneAndUpdateOptions(opts...)
+	err := c.write(ctx, filter, opt.Sort, true, func(m *memoryCollection) error {
+		result = m.FindOneAndUpdate(ctx, filter, update, opts...)
+		return singleErr(result)
+	})
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	return result
+}
+
+func (c *postgresCollection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) (result *mongo.SingleResult) {
+	opt := options.MergeFindOneAndDeleteOptions(opts...)
+	err := c.write(ctx, filter, opt.Sort, true, func(m *memoryCollection) error {
+		result = m.FindOneAndDelete(ctx, filter, opts...)
+		return singleErr(result)
+	})
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	return result
+}
+
+func (c *postgresCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	err = c.write(ctx, filter, nil, true, func(m *memoryCollection) error {
+		result, err = m.UpdateOne(ctx, filter, update, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *postgresCollection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	err = c.write(ctx, filter, nil, false, func(m *memoryCollection) error {
+		result, err = m.UpdateMany(ctx, filter, update, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *postgresCollection) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
+	err = c.write(ctx, filter, nil, true, func(m *memoryCollection) error {
+		result, err = m.ReplaceOne(ctx, filter, replacement, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *postgresCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	err = c.write(ctx, filter, nil, true, func(m *memoryCollection) error {
+		result, err = m.DeleteOne(ctx, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *postgresCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	err = c.write(ctx, filter, nil, false, func(m *memoryCollection) error {
+		result, err = m.DeleteMany(ctx, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *postgresCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	if err := c.expire(ctx); err != nil {
+		return 0, err
+	}
+	q, err := c.query(filter)
+	if err != nil {
+		return 0, err
+	}
+	if !q.exact {
+		docs, _, err := c.load(ctx, c.pool, q, "seq", nil, nil, false)
+		if err != nil {
+			return 0, err
+		}
+		return c.memory(ctx, c.pool, docs, nil).CountDocuments(ctx, filter, opts...)
+	}
+
+	opt := options.MergeCountOptions(opts...)
+	sql := "SELECT 1 FROM documents WHERE " + q.where
+	if opt.Limit != nil && *opt.Limit != 0 {
+		n := *opt.Limit
+		if n < 0 {
+			n = -n
+		}
+		sql += " LIMIT " + strconv.FormatInt(n, 10)
+	}
+	if opt.Skip != nil && *opt.Skip > 0 {
+		sql += " OFFSET " + strconv.FormatInt(*opt.Skip, 10)
+	}
+	var count int64
+	err = c.pool.QueryRow(ctx, "SELECT count(*) FROM ("+sql+") AS found", q.args...).Scan(&count)
+	return count, err
+}
+
+// Aggregate filtra en PostgreSQL con el $match inicial, si lo hay, y
+// ejecuta el pipeline en memoria.
+func (c *postgresCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	stages, err := toArray(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var filter interface{} = bson.M{}
+	if len(stages) > 0 {
+		if stage, ok := stages[0].(bson.M); ok && len(stage) == 1 && stage["$match"] != nil {
+			filter = stage["$match"]
+		}
+	}
+	m, _, err := c.read(ctx, filter, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return m.Aggregate(ctx, stages, opts...)
+}
+
+func (c *postgresCollection) Indexes() IndexView {
+	return postgresIndexView{c}
+}
+
+// postgresIndexView crea cada índice de MongoDB como un índice de
+// expresión de documents limitado a la colección (ver postgresIndexName):
+// los únicos sobre document_key, los TTL sobre la fecha de caducidad y el
+// resto sobre las expresiones de orden.
+type postgresIndexView struct {
+	c *postgresCollection
+}
+
+func (v postgresIndexView) CreateOne(ctx context.Context, model mongo.IndexModel, _ ...*options.CreateIndexesOptions) (string, error) {
+	index, err := newMemoryIndex(model)
+	if err != nil {
+		return "", err
+	}
+	c := v.c
+	sql, err := c.indexSQL(index)
+	if err != nil {
+		return "", err
+	}
+	// CREATE INDEX IF NOT EXISTS no es seguro si dos instancias lo
+	// ejecutan a la vez.
+	err = pgx.BeginFunc(ctx, c.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('documents_indexes'))"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, sql)
+		return err
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return "", duplicateKeyError(index.name, nil)
+		}
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.indexes {
+		if existing.name == index.name {
+			return index.name, nil
+		}
+	}
+	c.indexes = append(c.indexes, index)
+	// El índice TTL nuevo se aplica en la siguiente operación.
+	c.expired = time.Time{}
+	return index.name, nil
+}
+
+// indexSQL es el CREATE INDEX de index.
+func (c *postgresCollection) indexSQL(index memoryIndex) (string, error) {
+	where := []string{"collection = " + quoteLiteral(c.name)}
+	var columns, present []string
+	for _, key := range index.keys {
+		path := pathSQL(strings.Split(key.Key, "."))
+		present = append(present, "document_first(document, "+path+") IS NOT NULL")
+		switch {
+		case index.unique:
+			columns = append(columns, "(document_key(document_first(document, "+path+")))")
+		case index.ttl != nil:
+			columns = append(columns, "("+ttlSQL(index)+")")
+		default:
+			direction := ""
+			if d, _ := toFloat(key.Value); d < 0 {
+				direction = " DESC"
+			}
+			for _, expr := range sortExprs(key.Key) {
+				columns = append(columns, "("+expr+")"+direction)
+			}
+		}
+	}
+	if index.sparse {
+		where = append(where, joinSQL(present, " OR "))
+	}
+	if index.partial != nil {
+		b := &sqlBuilder{literal: true}
+		partial, exact := b.filter(index.partial)
+		if !exact {
+			return "", errors.New("el filtro parcial del índice " + index.name + " no se puede traducir a PostgreSQL")
+		}
+		where = append(where, partial)
+	}
+
+	unique := ""
+	if index.unique {
+		unique = "UNIQUE "
+	}
+	return "CREATE " + unique + "INDEX IF NOT EXISTS " + postgresIndexName(c.name, index.name) +
+		" ON documents (" + strings.Join(columns, ", ") + ") WHERE " + joinSQL(where, " AND "), nil
+}
+
+func (v postgresIndexView) CreateMany(ctx context.Context, models []mongo.IndexModel, opts ...*options.CreateIndexesOptions) ([]string, error) {
+	names := make([]string, 0, len(models))
+	for _, model := range models {
+		name, err := v.CreateOne(ctx, model, opts...)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (v postgresIndexView) DropOne(ctx context.Context, name string, _ ...*options.DropIndexesOptions) (bson.Raw, error) {
+	c := v.c
+	notFound := mongo.CommandError{Code: 27, Name: "IndexNotFound", Message: "index not found with name [" + name + "]"}
+	if name == "_id_" {
+		return nil, notFound
+	}
+	if _, err := c.pool.Exec(ctx, "DROP INDEX "+postgresIndexName(c.name, name)); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "42704" {
+			return nil, notFound
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.indexes = slices.DeleteFunc(c.indexes, func(index memoryIndex) bool { return index.name == name })
+	c.mu.Unlock()
+	return bson.Marshal(bson.M{"ok": 1})
+}
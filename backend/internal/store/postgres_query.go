@@ -0,0 +1,499 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresQuery es un filtro de MongoDB traducido a SQL sobre la tabla
+// documents (ver la migración 0004). where selecciona los documentos que
+// pueden cumplir el filtro; si exact es true son justo los que lo cumplen y
+// el orden, skip, limit y el recuento también se resuelven en PostgreSQL. Si
+// no, los documentos seleccionados se vuelven a filtrar con matches, como en
+// memoria.
+type postgresQuery struct {
+	where string
+	args  []interface{}
+	exact bool
+}
+
+// sqlBuilder traduce filtros a SQL. Cada condición que no se puede traducir
+// se queda en TRUE (y la consulta deja de ser exacta), sin parámetros: si
+// no, PostgreSQL no sabría de qué tipo son.
+type sqlBuilder struct {
+	args []interface{}
+	// literal escribe los valores en el propio SQL y no admite subconsultas,
+	// para los filtros parciales de los índices.
+	literal bool
+}
+
+// maxPathDepth limita las variantes de @> de una igualdad, que son 2^n para
+// un campo con n niveles.
+const maxPathDepth = 4
+
+func (b *sqlBuilder) param(value string) string {
+	if b.literal {
+		return quoteLiteral(value)
+	}
+	b.args = append(b.args, value)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// discard quita los parámetros añadidos desde mark cuando la condición
+// que los usaba se ha cambiado por TRUE.
+func (b *sqlBuilder) discard(mark int, sql string) {
+	if sql == "TRUE" {
+		b.args = b.args[:mark]
+	}
+}
+
+func (b *sqlBuilder) filter(filter bson.M) (string, bool) {
+	var conds []string
+	exact := true
+	for _, key := range sortedKeys(filter) {
+		mark := len(b.args)
+		var sql string
+		var ok bool
+		switch {
+		case key == "$and" || key == "$or" || key == "$nor":
+			sql, ok = b.logical(key, filter[key])
+		case strings.HasPrefix(key, "$"):
+			sql, ok = "TRUE", false
+		default:
+			sql, ok = b.field(key, filter[key])
+		}
+		b.discard(mark, sql)
+		conds = append(conds, sql)
+		exact = exact && ok
+	}
+	return joinSQL(conds, " AND "), exact
+}
+
+func (b *sqlBuilder) logical(op string, cond interface{}) (string, bool) {
+	clauses, ok := cond.(bson.A)
+	if !ok || len(clauses) == 0 {
+		return "TRUE", false
+	}
+	mark := len(b.args)
+	var parts []string
+	exact := true
+	for _, clause := range clauses {
+		filter, ok := clause.(bson.M)
+		if !ok {
+			b.args = b.args[:mark]
+			return "TRUE", false
+		}
+		sql, ok := b.filter(filter)
+		if sql == "TRUE" && op != "$and" {
+			// Una cláusula que siempre se cumple hace que $or sea TRUE y
+			// que $nor solo se pueda saber en memoria.
+			b.args = b.args[:mark]
+			return "TRUE", ok && op == "$or"
+		}
+		parts = append(parts, sql)
+		exact = exact && ok
+	}
+	switch op {
+	case "$and":
+		return joinSQL(parts, " AND "), exact
+	case "$or":
+		return joinSQL(parts, " OR "), exact
+	}
+	if !exact {
+		b.args = b.args[:mark]
+		return "TRUE", false
+	}
+	return negateSQL(joinSQL(parts, " OR ")), true
+}
+
+func (b *sqlBuilder) field(path string, cond interface{}) (string, bool) {
+	ops, ok := operators(cond)
+	if !ok {
+		return b.equal(path, cond)
+	}
+	var conds []string
+	exact := true
+	for _, op := range sortedKeys(ops) {
+		arg := ops[op]
+		mark := len(b.args)
+		var sql string
+		var ok bool
+		switch op {
+		case "$eq":
+			sql, ok = b.equal(path, arg)
+		case "$ne":
+			sql, ok = b.negate(mark, b.equal, path, arg)
+		case "$in":
+			sql, ok = b.in(path, arg)
+		case "$nin":
+			sql, ok = b.negate(mark, b.in, path, arg)
+		case "$all":
+			sql, ok = b.all(path, arg)
+		case "$exists":
+			sql, ok = b.exists(path, truthy(arg))
+		case "$gt", "$gte", "$lt", "$lte":
+			sql, ok = b.compare(path, op, arg)
+		case "$not":
+			sql, ok = b.negate(mark, b.field, path, arg)
+		default:
+			sql, ok = "TRUE", false
+		}
+		b.discard(mark, sql)
+		conds = append(conds, sql)
+		exact = exact && ok
+	}
+	return joinSQL(conds, " AND "), exact
+}
+
+// negate niega la condición de translate, que solo se puede hacer si es
+// exacta.
+func (b *sqlBuilder) negate(mark int, translate func(string, interface{}) (string, bool), path string, arg interface{}) (string, bool) {
+	sql, ok := translate(path, arg)
+	if !ok {
+		b.args = b.args[:mark]
+		return "TRUE", false
+	}
+	return negateSQL(sql), true
+}
+
+// equal busca el valor con @>, que usa el índice GIN de document. Como un
+// campo puede ser un array en cualquier nivel del camino, cada nivel tiene
+// dos variantes: {"a": x} y {"a": [x]}. Un número se busca en los tres tipos
+// numéricos, que MongoDB considera iguales. Con documentos y arrays @>
+// también acepta los que tienen campos o elementos de más, así que la
+// consulta no es exacta.
+func (b *sqlBuilder) equal(path string, want interface{}) (string, bool) {
+	if want == nil {
+		return "TRUE", false
+	}
+	if _, ok := want.(primitive.Regex); ok {
+		return "TRUE", false
+	}
+	forms, ok := numberForms(want)
+	if !ok {
+		return "TRUE", false
+	}
+
+	parts := strings.Split(path, ".")
+	if len(parts) > maxPathDepth || hasArrayIndex(parts[1:]) {
+		return "document ? " + b.param(parts[0]), false
+	}
+	exact := true
+	switch want.(type) {
+	case bson.M, bson.A, bson.D:
+		exact = false
+	}
+
+	// Los valores se preparan antes de añadir parámetros, para no dejar
+	// ninguno suelto si alguno falla.
+	var values []string
+	if path == "_id" && exact {
+		for _, form := range forms {
+			id, err := bson.MarshalExtJSON(bson.M{"_id": form}, true, false)
+			if err != nil {
+				return "TRUE", false
+			}
+			values = append(values, string(id))
+		}
+		ids := make([]string, len(values))
+		for i, id := range values {
+			ids[i] = b.param(id)
+		}
+		return "id IN (" + strings.Join(ids, ", ") + ")", true
+	}
+
+	for _, form := range forms {
+		for _, doc := range nestings(parts, form) {
+			raw, err := bson.MarshalExtJSON(doc, true, false)
+			if err != nil {
+				return "TRUE", false
+			}
+			values = append(values, string(raw))
+		}
+	}
+	conds := make([]string, len(values))
+	for i, value := range values {
+		conds[i] = "document @> " + b.param(value) + "::jsonb"
+	}
+	return joinSQL(conds, " OR "), exact
+}
+
+func (b *sqlBuilder) in(path string, arg interface{}) (string, bool) {
+	list, ok := arg.(bson.A)
+	if !ok {
+		return "TRUE", false
+	}
+	var conds []string
+	exact := true
+	for _, want := range list {
+		sql, ok := b.equal(path, want)
+		if sql == "TRUE" {
+			return "TRUE", false
+		}
+		conds = append(conds, sql)
+		exact = exact && ok
+	}
+	if len(conds) == 0 {
+		return "FALSE", true
+	}
+	return joinSQL(conds, " OR "), exact
+}
+
+func (b *sqlBuilder) all(path string, arg interface{}) (string, bool) {
+	list, ok := arg.(bson.A)
+	if !ok {
+		return "TRUE", false
+	}
+	if len(list) == 0 {
+		return "FALSE", true
+	}
+	var conds []string
+	exact := true
+	for _, want := range list {
+		sql, ok := b.equal(path, want)
+		conds = append(conds, sql)
+		exact = exact && ok
+	}
+	return joinSQL(conds, " AND "), exact
+}
+
+func (b *sqlBuilder) exists(path string, want bool) (string, bool) {
+	parts := strings.Split(path, ".")
+	var sql string
+	switch {
+	case len(parts) == 1:
+		sql = "document ? " + b.param(path)
+	case b.literal:
+		return "TRUE", false
+	default:
+		sql = "EXISTS (SELECT 1 FROM document_resolve(document, " + pathSQL(parts) + "))"
+	}
+	if !want {
+		return negateSQL(sql), true
+	}
+	return sql, true
+}
+
+// compare traduce los rangos con document_max y document_min, que solo
+// tienen en cuenta los valores del mismo tipo que el del filtro, como
+// compare en memoria.
+func (b *sqlBuilder) compare(path, op string, arg interface{}) (string, bool) {
+	var rank int
+	var value string
+	text := false
+	switch t := arg.(type) {
+	case int32:
+		rank, value = 1, strconv.FormatInt(int64(t), 10)
+	case int64:
+		rank, value = 1, strconv.FormatInt(t, 10)
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return "TRUE", false
+		}
+		rank, value = 1, strconv.FormatFloat(t, 'f', -1, 64)
+	case string:
+		rank, value, text = 2, t, true
+	case primitive.ObjectID:
+		rank, value, text = 6, t.Hex(), true
+	case bool:
+		rank, value = 7, "0"
+		if t {
+			value = "1"
+		}
+	case primitive.DateTime:
+		rank, value = 8, strconv.FormatInt(int64(t), 10)
+	default:
+		return "TRUE", false
+	}
+
+	fn := "document_max"
+	if op == "$lt" || op == "$lte" {
+		fn = "document_min"
+	}
+	operator := map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[op]
+	if text {
+		expr := fn + "_text(document, " + pathSQL(strings.Split(path, ".")) + ", " + strconv.Itoa(rank) + ")"
+		return expr + " " + operator + " " + b.param(value) + ` COLLATE "C"`, true
+	}
+	expr := fn + "(document, " + pathSQL(strings.Split(path, ".")) + ", " + strconv.Itoa(rank) + ")"
+	return expr + " " + operator + " " + b.param(value) + "::text::numeric", true
+}
+
+// numberForms devuelve las representaciones de want que MongoDB considera
+// iguales. ok es false para NaN, que no es igual a nada.
+func numberForms(want interface{}) ([]interface{}, bool) {
+	f, isNumber := toFloat(want)
+	if !isNumber {
+		return []interface{}{want}, true
+	}
+	if math.IsNaN(f) {
+		return nil, false
+	}
+	forms := []interface{}{want}
+	if _, ok := want.(float64); !ok {
+		forms = append(forms, f)
+	} else if f == 0 {
+		forms = append(forms, -f)
+	}
+	if f == math.Trunc(f) && math.Abs(f) <= 1<<53 {
+		if _, ok := want.(int32); !ok && f >= math.MinInt32 && f <= math.MaxInt32 {
+			forms = append(forms, int32(f))
+		}
+		if _, ok := want.(int64); !ok {
+			forms = append(forms, int64(f))
+		}
+	}
+	return forms, true
+}
+
+// nestings devuelve los documentos que @> tiene que buscar para que el
+// campo parts valga value, con cada nivel como documento o dentro de un
+// array.
+func nestings(parts []string, value interface{}) []interface{} {
+	variants := []interface{}{value, bson.A{value}}
+	for i := len(parts) - 1; i >= 0; i-- {
+		var next []interface{}
+		for _, inner := range variants {
+			doc := bson.M{parts[i]: inner}
+			next = append(next, doc)
+			if i > 0 {
+				next = append(next, bson.A{doc})
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+var arrayIndex = regexp.MustCompile(`^[0-9]+$`)
+
+// hasArrayIndex dice si algún segmento del camino puede ser una posición
+// de un array, que @> no sabe buscar.
+func hasArrayIndex(parts []string) bool {
+	for _, part := range parts {
+		if arrayIndex.MatchString(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// orderSQL traduce sort a ORDER BY con las mismas reglas que compareByKeys:
+// primero el tipo y luego el valor. seq desempata con el orden de inserción,
+// como la ordenación estable en memoria.
+func orderSQL(sort interface{}) (string, error) {
+	if sort == nil {
+		return "seq", nil
+	}
+	keys, err := toOrdered(sort)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, key := range keys {
+		direction := ""
+		if d, _ := toFloat(key.Value); d < 0 {
+			direction = " DESC"
+		}
+		for _, expr := range sortExprs(key.Key) {
+			parts = append(parts, expr+direction)
+		}
+	}
+	return strings.Join(append(parts, "seq"), ", "), nil
+}
+
+// sortExprs son las expresiones por las que se ordena un campo, las mismas
+// que usan los índices para que PostgreSQL pueda aprovecharlos.
+func sortExprs(path string) []string {
+	value := "document_first(document, " + pathSQL(strings.Split(path, ".")) + ")"
+	return []string{
+		"document_rank(" + value + ")",
+		"document_number(" + value + ")",
+		"document_text(" + value + `) COLLATE "C"`,
+	}
+}
+
+func pathSQL(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = quoteLiteral(part)
+	}
+	return "ARRAY[" + strings.Join(quoted, ", ") + "]::text[]"
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// postgresIndexName es el nombre del índice de PostgreSQL para el índice
+// name de la colección. Los nombres de índice son únicos en todo el esquema
+// y de 63 bytes como mucho, así que llevan la colección y un hash.
+func postgresIndexName(collection, name string) string {
+	sum := sha256.Sum256([]byte(collection + "\x00" + name))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	clean := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, "documents_"+collection+"_"+name)
+	if len(clean) > 63-len(suffix) {
+		clean = clean[:63-len(suffix)]
+	}
+	return clean + suffix
+}
+
+// joinSQL une las condiciones con AND u OR, sin las que no cambian el
+// resultado (TRUE en AND y FALSE en OR).
+func joinSQL(conds []string, op string) string {
+	neutral := "TRUE"
+	if op == " OR " {
+		neutral = "FALSE"
+	}
+	var parts []string
+	for _, cond := range conds {
+		if cond != neutral {
+			parts = append(parts, cond)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return neutral
+	case 1:
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, op) + ")"
+}
+
+// negateSQL niega una condición exacta. COALESCE hace que NULL (por
+// ejemplo, un rango sobre un campo que no existe) cuente como falso antes
+// de negarlo.
+func negateSQL(sql string) string {
+	switch sql {
+	case "TRUE":
+		return "FALSE"
+	case "FALSE":
+		return "TRUE"
+	}
+	return "NOT COALESCE(" + sql + ", false)"
+}
+
+func sortedKeys(doc bson.M) []string {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+func TestPostgresFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	tests := []struct {
+		filter bson.M
+		exact  bool
+	}{
+		{bson.M{}, true},
+		{bson.M{"_id": id}, true},
+		{bson.M{"_id": bson.M{"$in": bson.A{id, primitive.NewObjectID()}}}, true},
+		{bson.M{"user_id": id, "expires_at": bson.M{"$gt": now}}, true},
+		{bson.M{"status": "pending", "next_attempt_at": bson.M{"$lte": now}}, true},
+		{bson.M{"identities.provider": "google", "age": bson.M{"$gte": 18, "$lt": 65.5}}, true},
+		{bson.M{"revoked_at": bson.M{"$exists": false}}, true},
+		{bson.M{"owner.email": bson.M{"$exists": true}}, true},
+		{bson.M{"tags": bson.M{"$all": bson.A{"beta", "staff"}}}, true},
+		{bson.M{"name": bson.M{"$nin": bson.A{"ana", "bruno"}}}, true},
+		{bson.M{"$or": bson.A{bson.M{"a": 1}, bson.M{"b": bson.M{"$ne": "x"}}}}, true},
+		{bson.M{"$nor": bson.A{bson.M{"a": true}}}, true},
+		{bson.M{"name": bson.M{"$in": bson.A{}}}, true},
+
+		{bson.M{"name": primitive.Regex{Pattern: "^a"}}, false},
+		{bson.M{"deleted_at": nil}, false},
+		{bson.M{"owner": bson.M{"email": "c@example.com"}}, false},
+		{bson.M{"tags": bson.M{"$size": 2}}, false},
+		{bson.M{"hits.0": 1}, false},
+		{bson.M{"name": "ana", "$or": bson.A{bson.M{"a": 1}, bson.M{"b": nil}}}, false},
+		{bson.M{"$nor": bson.A{bson.M{"a": nil}}}, false},
+		{bson.M{"name": bson.M{"$not": bson.M{"$regex": "^a"}}}, false},
+	}
+	for _, tt := range tests {
+		filter, err := toDocument(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &sqlBuilder{}
+		where, exact := b.filter(filter)
+		if exact != tt.exact {
+			t.Errorf("%v: exact = %v, se esperaba %v (%s)", tt.filter, exact, tt.exact, where)
+		}
+		// Cada parámetro tiene que aparecer en el SQL: PostgreSQL rechaza
+		// los que no se usan porque no sabe de qué tipo son.
+		used := map[string]bool{}
+		for _, p := range placeholder.FindAllString(where, -1) {
+			used[p] = true
+		}
+		if len(used) != len(b.args) {
+			t.Errorf("%v: %d parámetros y %d usados en %s", tt.filter, len(b.args), len(used), where)
+		}
+	}
+}
+
+func TestPostgresIndexSQL(t *testing.T) {
+	c := &postgresCollection{name: "event_counts"}
+	sql, err := c.indexSQL(memoryIndex{
+		name:    "day_1_type_1",
+		keys:    bson.D{{Key: "day", Value: 1}, {Key: "type", Value: 1}},
+		unique:  true,
+		partial: bson.M{"type": bson.M{"$exists": true}},
+	})
+	if err != nil {
+		t.Fatalf("indexSQL: %v", err)
+	}
+	if !strings.HasPrefix(sql, "CREATE UNIQUE INDEX IF NOT EXISTS documents_event_counts_day_1_type_1_") || placeholder.MatchString(sql) {
+		t.Errorf("indexSQL = %s", sql)
+	}
+
+	if _, err := c.indexSQL(memoryIndex{
+		name:    "name_1",
+		keys:    bson.D{{Key: "name", Value: 1}},
+		partial: bson.M{"name": primitive.Regex{Pattern: "^a"}},
+	}); err == nil {
+		t.Error("se esperaba un error con un filtro parcial que no se puede traducir")
+	}
+
+	long := postgresIndexName("webhook_deliveries", strings.Repeat("endpoint_id_1_", 10))
+	if len(long) > 63 || long == postgresIndexName("webhook_deliveries", strings.Repeat("endpoint_id_1_", 11)) {
+		t.Errorf("postgresIndexName = %s", long)
+	}
+}
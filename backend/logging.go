@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"backend/internal/config"
+)
+
+// Los logs son estructurados (slog), en texto o JSON según LOG_FORMAT. Los
+// log.Printf de siempre pasan por el mismo handler: el nivel se deduce del
+// emoji con el que empiezan (❌ error, ⚠️ aviso).
+
+// setupLogging instala el logger por defecto y redirige el paquete log.
+func setupLogging(cfg config.Log) {
+	options := &slog.HandlerOptions{Level: cfg.Level}
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(legacyLogWriter{logger})
+}
+
+// legacyLogWriter convierte cada línea de log.Printf en una entrada de slog.
+type legacyLogWriter struct {
+	logger *slog.Logger
+}
+
+func (l legacyLogWriter) Write(p []byte) (int, error) {
+	message := string(bytes.TrimRight(p, "\n"))
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(message, "❌"):
+		level = slog.LevelError
+	case strings.HasPrefix(message, "⚠️"):
+		level = slog.LevelWarn
+	}
+	l.logger.Log(context.Background(), level, message)
+	return len(p), nil
+}
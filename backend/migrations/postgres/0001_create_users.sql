@@ -0,0 +1,36 @@
+-- Usuarios. document guarda el usuario completo en Extended JSON (el mismo
+-- formato que en MongoDB); las columnas duplican los campos por los que se
+-- busca, se ordena o que deben ser únicos.
+CREATE TABLE users (
+    id             TEXT PRIMARY KEY,
+    email          TEXT NOT NULL,
+    code_hash      TEXT NOT NULL,
+    username       TEXT,
+    name           TEXT NOT NULL DEFAULT '',
+    last_name      TEXT NOT NULL DEFAULT '',
+    image_url      TEXT NOT NULL DEFAULT '',
+    tags           TEXT[] NOT NULL DEFAULT '{}',
+    login_count    INTEGER NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL,
+    last_login_at  TIMESTAMPTZ,
+    last_seen_at   TIMESTAMPTZ,
+    deactivated_at TIMESTAMPTZ,
+    deleted_at     TIMESTAMPTZ,
+    erased_at      TIMESTAMPTZ,
+    document       JSONB NOT NULL,
+
+    CONSTRAINT users_email_key UNIQUE (email),
+    CONSTRAINT users_code_hash_key UNIQUE (code_hash),
+    CONSTRAINT users_username_key UNIQUE (username)
+);
+
+CREATE INDEX users_created_at_idx ON users (created_at, id);
+CREATE INDEX users_updated_at_idx ON users (updated_at, id);
+CREATE INDEX users_last_login_at_idx ON users (last_login_at, id);
+CREATE INDEX users_last_seen_at_idx ON users (last_seen_at, id);
+CREATE INDEX users_login_count_idx ON users (login_count, id);
+CREATE INDEX users_name_idx ON users (name text_pattern_ops);
+CREATE INDEX users_last_name_idx ON users (last_name text_pattern_ops);
+CREATE INDEX users_email_prefix_idx ON users (email text_pattern_ops);
+CREATE INDEX users_tags_idx ON users USING GIN (tags);
@@ -0,0 +1,6 @@
+-- Búsquedas por campos que solo están en document: la cuenta vinculada a un
+-- proveedor externo (FindByIdentity) y los usuarios que usan un archivo
+-- (UserQuery.ImageKey).
+CREATE INDEX users_identities_idx ON users USING GIN ((document->'identities') jsonb_path_ops);
+CREATE INDEX users_images_idx ON users USING GIN ((document->'images') jsonb_path_ops);
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Resto de colecciones (sesiones, tokens, auditoría...) con DB_DRIVER=postgres.
+-- document guarda el documento en Extended JSON, como users; id es el _id en
+-- Extended JSON. Las consultas se resuelven en la aplicación (ver
+-- store.NewPostgres).
+CREATE TABLE documents (
+    collection TEXT NOT NULL,
+    id         TEXT NOT NULL,
+    document   JSONB NOT NULL,
+
+    PRIMARY KEY (collection, id)
+);
@@ -0,0 +1,137 @@
+-- Consultas sobre documents en PostgreSQL (ver store.NewPostgres). Los
+-- filtros de MongoDB se traducen a SQL: las igualdades usan @> sobre el
+-- índice GIN y los rangos y el orden, las funciones de abajo, que siguen las
+-- reglas de MongoDB con los valores en Extended JSON canónico.
+
+-- seq conserva el orden de inserción, el orden natural de MongoDB.
+ALTER TABLE documents ADD COLUMN seq BIGSERIAL;
+
+CREATE INDEX documents_collection_seq_idx ON documents (collection, seq);
+CREATE INDEX documents_document_idx ON documents USING GIN (document);
+
+-- document_resolve devuelve los valores del campo path. Como en MongoDB, un
+-- array en mitad del camino se recorre elemento a elemento, y un número
+-- también puede indicar una posición del array.
+CREATE FUNCTION document_resolve(v jsonb, path text[]) RETURNS SETOF jsonb
+LANGUAGE plpgsql IMMUTABLE PARALLEL SAFE AS $$
+DECLARE
+    elem jsonb;
+BEGIN
+    IF v IS NULL THEN
+        RETURN;
+    END IF;
+    IF cardinality(path) = 0 THEN
+        RETURN NEXT v;
+        RETURN;
+    END IF;
+    IF jsonb_typeof(v) = 'object' THEN
+        IF v ? path[1] THEN
+            RETURN QUERY SELECT * FROM document_resolve(v -> path[1], path[2:]);
+        END IF;
+    ELSIF jsonb_typeof(v) = 'array' THEN
+        IF path[1] ~ '^[0-9]{1,9}$' THEN
+            RETURN QUERY SELECT * FROM document_resolve(v -> path[1]::integer, path[2:]);
+        END IF;
+        FOR elem IN SELECT jsonb_array_elements(v) LOOP
+            IF jsonb_typeof(elem) = 'object' THEN
+                RETURN QUERY SELECT * FROM document_resolve(elem, path);
+            END IF;
+        END LOOP;
+    END IF;
+END
+$$;
+
+-- document_values añade los elementos de los valores que son arrays: una
+-- condición sobre un campo array se cumple si la cumple algún elemento.
+CREATE FUNCTION document_values(doc jsonb, path text[]) RETURNS SETOF jsonb
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT c
+    FROM document_resolve(doc, path) r,
+        LATERAL (
+            SELECT r AS c
+            UNION ALL
+            SELECT e FROM jsonb_array_elements(CASE WHEN jsonb_typeof(r) = 'array' THEN r ELSE '[]' END) e
+        ) v
+$$;
+
+-- document_first es el valor por el que se ordena y se indexa un campo.
+CREATE FUNCTION document_first(doc jsonb, path text[]) RETURNS jsonb
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT r FROM document_resolve(doc, path) r LIMIT 1
+$$;
+
+-- document_rank es el orden de MongoDB entre valores de distinto tipo: null,
+-- números, textos, documentos, arrays, binarios, ObjectId, booleanos, fechas
+-- y el resto.
+CREATE FUNCTION document_rank(v jsonb) RETURNS integer
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT CASE
+        WHEN v IS NULL OR jsonb_typeof(v) = 'null' THEN 0
+        WHEN jsonb_typeof(v) = 'number' THEN 1
+        WHEN jsonb_typeof(v) = 'string' THEN 2
+        WHEN jsonb_typeof(v) = 'array' THEN 4
+        WHEN jsonb_typeof(v) = 'boolean' THEN 7
+        WHEN v ?| ARRAY['$numberInt', '$numberLong', '$numberDouble'] THEN 1
+        WHEN v ? '$binary' THEN 5
+        WHEN v ? '$oid' THEN 6
+        WHEN v ? '$date' THEN 8
+        WHEN v ?| ARRAY['$numberDecimal', '$timestamp', '$regularExpression', '$code', '$symbol',
+            '$dbPointer', '$minKey', '$maxKey', '$undefined'] THEN 9
+        ELSE 3
+    END
+$$;
+
+-- document_number compara números, booleanos y fechas (en milisegundos).
+CREATE FUNCTION document_number(v jsonb) RETURNS numeric
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT CASE document_rank(v)
+        WHEN 1 THEN coalesce(v ->> '$numberInt', v ->> '$numberLong', v ->> '$numberDouble', v #>> '{}')::numeric
+        WHEN 7 THEN CASE WHEN v = 'true' THEN 1 ELSE 0 END
+        WHEN 8 THEN (v -> '$date' ->> '$numberLong')::numeric
+    END
+$$;
+
+-- document_text compara textos y ObjectId (en hexadecimal), con COLLATE "C"
+-- para que sea byte a byte como en MongoDB.
+CREATE FUNCTION document_text(v jsonb) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT CASE document_rank(v)
+        WHEN 2 THEN v #>> '{}'
+        WHEN 6 THEN v ->> '$oid'
+    END
+$$;
+
+-- document_max y document_min devuelven el mayor y el menor de los valores
+-- del tipo rank: {"$gt": x} se cumple si el mayor es > x y {"$lt": x} si el
+-- menor es < x.
+CREATE FUNCTION document_max(doc jsonb, path text[], rank integer) RETURNS numeric
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT max(document_number(v)) FROM document_values(doc, path) v WHERE document_rank(v) = rank
+$$;
+
+CREATE FUNCTION document_min(doc jsonb, path text[], rank integer) RETURNS numeric
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT min(document_number(v)) FROM document_values(doc, path) v WHERE document_rank(v) = rank
+$$;
+
+CREATE FUNCTION document_max_text(doc jsonb, path text[], rank integer) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT max(document_text(v) COLLATE "C") FROM document_values(doc, path) v WHERE document_rank(v) = rank
+$$;
+
+CREATE FUNCTION document_min_text(doc jsonb, path text[], rank integer) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT min(document_text(v) COLLATE "C") FROM document_values(doc, path) v WHERE document_rank(v) = rank
+$$;
+
+-- document_key es la clave de los índices únicos: los números se comparan
+-- por su valor, sea cual sea su tipo, y un campo que falta cuenta como null.
+CREATE FUNCTION document_key(v jsonb) RETURNS jsonb
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT CASE
+        WHEN v IS NULL THEN 'null'::jsonb
+        WHEN document_rank(v) = 1 AND document_number(v) NOT IN ('NaN', 'Infinity', '-Infinity')
+            THEN to_jsonb(document_number(v))
+        ELSE v
+    END
+$$;
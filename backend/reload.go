@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+
+	"backend/internal/config"
+	"backend/internal/handlers"
+)
+
+// dotEnvKeys son las variables que vienen del .env. Las del entorno del
+// proceso tienen prioridad, así que la recarga solo cambia estas.
+var dotEnvKeys map[string]bool
+
+// loadDotEnv carga el .env en el entorno sin pisar las variables del
+// proceso y, al recargar, borra las que se hayan quitado del archivo.
+func loadDotEnv() error {
+	values, err := godotenv.Read()
+	if err != nil {
+		return err
+	}
+
+	for key := range dotEnvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	keys := map[string]bool{}
+	for key, value := range values {
+		if _, fromProcess := os.LookupEnv(key); fromProcess && !dotEnvKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		keys[key] = true
+	}
+	dotEnvKeys = keys
+	return nil
+}
+
+// watchReloadSignal recarga la configuración con cada SIGHUP (ver
+// handlers.Server.Reload).
+func watchReloadSignal(srv *handlers.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadConfig(srv)
+	}
+}
+
+// reloadConfig vuelve a leer el .env y el entorno. Si la configuración nueva
+// no es válida se mantiene la anterior entera.
+func reloadConfig(srv *handlers.Server) {
+	log.Println("🔄 Recargando configuración")
+	if err := loadDotEnv(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("❌ Recarga cancelada, error leyendo .env: %v", err)
+		return
+	}
+	next, err := config.Load()
+	if err != nil {
+		log.Printf("❌ Recarga cancelada, configuración inválida: %v", err)
+		return
+	}
+	srv.Reload(next)
+}
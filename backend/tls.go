@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"backend/internal/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serveHTTP sirve handler en cfg.Port, con HTTPS si cfg.TLS lo configura
+// (ver config.TLS). Solo vuelve si el servidor falla.
+func serveHTTP(cfg config.Config, handler http.Handler) error {
+	server := newHTTPServer(":"+cfg.Port, handler, cfg.Server.Timeouts)
+	if !cfg.TLS.Enabled() {
+		return server.ListenAndServe()
+	}
+
+	// Sin autocert, el puerto HTTP solo redirige.
+	httpHandler := redirectToHTTPS(cfg.Port)
+
+	if len(cfg.TLS.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertCache),
+			Email:      cfg.TLS.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		httpHandler = manager.HTTPHandler(httpHandler)
+		log.Printf("🔒 Certificados de Let's Encrypt para %v (caché en %s)", cfg.TLS.AutocertDomains, cfg.TLS.AutocertCache)
+	} else {
+		// Se carga antes de escuchar para que un certificado inválido impida
+		// arrancar con un error claro.
+		if _, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			return fmt.Errorf("error cargando TLS_CERT/TLS_KEY: %v", err)
+		}
+		log.Printf("🔒 HTTPS con el certificado %s", cfg.TLS.CertFile)
+	}
+
+	if cfg.TLS.HTTPPort != "" {
+		go func() {
+			log.Printf("↪️  HTTP en puerto %s redirige a HTTPS", cfg.TLS.HTTPPort)
+			log.Fatal(newHTTPServer(":"+cfg.TLS.HTTPPort, httpHandler, cfg.Server.Timeouts).ListenAndServe())
+		}()
+	}
+	return server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
+
+// newHTTPServer aplica los plazos de config.Timeouts. Los handlers que
+// necesitan más (streaming, subidas) los amplían con extendDeadlines.
+func newHTTPServer(addr string, handler http.Handler, timeouts config.Timeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+}
+
+// redirectToHTTPS redirige al mismo host en el puerto HTTPS, que se omite si
+// es el estándar.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
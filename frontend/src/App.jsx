@@ -10,19 +10,65 @@ function App() {
 
   useEffect(() => {
     const savedCode = localStorage.getItem('userCode');
-    if (savedCode) {
+    const savedToken = localStorage.getItem('accessToken');
+    if (savedCode && savedToken) {
       setUserCode(savedCode);
       setCurrentView('profile');
       fetchUserProfile(savedCode);
     }
   }, []);
 
+  const refreshSession = async () => {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) {
+      return false;
+    }
+
+    const response = await fetch(`${API_BASE_URL}/api/v1/token/refresh`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    });
+    if (!response.ok) {
+      return false;
+    }
+
+    const data = await response.json();
+    localStorage.setItem('accessToken', data.access_token);
+    localStorage.setItem('refreshToken', data.refresh_token);
+    return true;
+  };
+
+  const authFetch = async (url, options = {}) => {
+    const withAuth = () => ({
+      ...options,
+      headers: {
+        ...options.headers,
+        Authorization: `Bearer ${localStorage.getItem('accessToken')}`,
+      },
+    });
+
+    const response = await fetch(url, withAuth());
+    if (response.status === 401 && (await refreshSession())) {
+      return fetch(url, withAuth());
+    }
+    return response;
+  };
+
   const fetchUserProfile = async (code) => {
     try {
-      const response = await fetch(`${API_BASE_URL}/api/user/${code}`);
+      const response = await authFetch(`${API_BASE_URL}/api/v1/user/${code}`);
       if (response.ok) {
         const userData = await response.json();
         setUser(userData);
+      } else if (response.status === 401) {
+        localStorage.removeItem('userCode');
+        localStorage.removeItem('accessToken');
+        localStorage.removeItem('refreshToken');
+        setUserCode('');
+        setCurrentView('login');
       }
     } catch (error) {
       console.error('Error fetching user profile:', error);
@@ -40,7 +86,7 @@ function App() {
       setMessage('');
 
       try {
-        const response = await fetch(`${API_BASE_URL}/api/register`, {
+        const response = await fetch(`${API_BASE_URL}/api/v1/register`, {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
@@ -56,7 +102,7 @@ function App() {
             setCurrentView('login');
           }, 2000);
         } else {
-          setMessage(data.error || 'Error en el registro');
+          setMessage(data.message || data.error || 'Error en el registro');
         }
       } catch (error) {
         setMessage('Error de conexión');
@@ -105,7 +151,7 @@ function App() {
       setMessage('');
 
       try {
-        const response = await fetch(`${API_BASE_URL}/api/login`, {
+        const response = await fetch(`${API_BASE_URL}/api/v1/login`, {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
@@ -118,6 +164,8 @@ function App() {
         if (response.ok) {
           setUserCode(code);
           localStorage.setItem('userCode', code);
+          localStorage.setItem('accessToken', data.access_token);
+          localStorage.setItem('refreshToken', data.refresh_token);
           setUser(data.user);
           setCurrentView('profile');
         } else {
@@ -192,7 +240,7 @@ function App() {
       }
 
       try {
-        const response = await fetch(`${API_BASE_URL}/api/user/${userCode}`, {
+        const response = await authFetch(`${API_BASE_URL}/api/v1/user/${userCode}`, {
           method: 'PUT',
           body: formData,
         });
@@ -203,7 +251,7 @@ function App() {
           setUser(data.user);
           setMessage('Perfil actualizado correctamente');
         } else {
-          setMessage(data.error || 'Error al actualizar');
+          setMessage(data.message || data.error || 'Error al actualizar');
         }
       } catch (error) {
         setMessage('Error de conexión');
@@ -214,6 +262,8 @@ function App() {
 
     const handleLogout = () => {
       localStorage.removeItem('userCode');
+      localStorage.removeItem('accessToken');
+      localStorage.removeItem('refreshToken');
       setUserCode('');
       setUser(null);
       setCurrentView('register');
@@ -233,7 +283,12 @@ function App() {
           <form onSubmit={handleUpdateProfile}>
             <div className="profile-image-section">
               <div className="image-preview">
-                {imagePreview ? (
+                {imagePreview && imagePreview === user?.image_url && user?.image_fallback_url ? (
+                  <picture>
+                    <source srcSet={user.image_url} type="image/webp" />
+                    <img src={user.image_fallback_url} alt="Perfil" />
+                  </picture>
+                ) : imagePreview ? (
                   <img src={imagePreview} alt="Perfil" />
                 ) : (
                   <div className="no-image">Sin imagen</div>